@@ -1,16 +1,25 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/aung-arata/youtube-clone/backend/internal/auth"
 	"github.com/aung-arata/youtube-clone/backend/internal/database"
 	"github.com/aung-arata/youtube-clone/backend/internal/docs"
 	"github.com/aung-arata/youtube-clone/backend/internal/handlers"
 	"github.com/aung-arata/youtube-clone/backend/internal/middleware"
+	"github.com/aung-arata/youtube-clone/backend/internal/moderation"
+	"github.com/aung-arata/youtube-clone/backend/internal/notify"
 	"github.com/aung-arata/youtube-clone/backend/internal/storage"
+	"github.com/aung-arata/youtube-clone/backend/internal/webpush"
+	"github.com/aung-arata/youtube-clone/backend/internal/websocket"
 	"github.com/gorilla/mux"
 )
 
@@ -22,12 +31,116 @@ func main() {
 	}
 	defer db.Close()
 
+	// Reject access tokens whose session was revoked
+	auth.UseSessionChecker(auth.NewSessionCache(auth.NewSQLSessionChecker(db), auth.SessionCheckTTL))
+
+	// Accept API keys on routes that name a scope
+	auth.UseAPIKeyVerifier(auth.NewSQLAPIKeyVerifier(db))
+
 	// Initialize file storage
 	fileStorage, err := storage.NewFileStorage("")
 	if err != nil {
 		log.Fatal("Failed to initialize file storage:", err)
 	}
 
+	// Hubs on all instances share notifications through Postgres, unless
+	// WS_BROADCAST_BACKEND=local for a single instance
+	var broadcaster websocket.Broadcaster
+	if os.Getenv("WS_BROADCAST_BACKEND") == "local" {
+		broadcaster = websocket.NewLocalBroadcaster()
+	} else {
+		broadcaster, err = websocket.NewPostgresBroadcaster(db, database.ConnString())
+		if err != nil {
+			log.Fatal("Failed to start notification broadcaster:", err)
+		}
+	}
+	defer broadcaster.Close()
+
+	// Start the websocket hub for real-time notifications
+	hub := websocket.NewHubWithBroadcaster(broadcaster, websocket.NewSQLStore(db))
+	go hub.Run()
+
+	// Comma-separated list of origins allowed to open websockets
+	if origins := os.Getenv("WS_ALLOWED_ORIGINS"); origins != "" {
+		websocket.SetAllowedOrigins(strings.Split(origins, ","))
+	}
+
+	// Comma-separated addresses or CIDR ranges of proxies in front of this
+	// server, whose X-Forwarded-For is believed when recording client IPs
+	if proxies := os.Getenv("TRUSTED_PROXIES"); proxies != "" {
+		if err := auth.SetTrustedProxies(strings.Split(proxies, ",")); err != nil {
+			log.Fatal("Invalid TRUSTED_PROXIES:", err)
+		}
+	}
+
+	// Email notifications, digests and account emails are sent only when
+	// SMTP_ADDR is set
+	var notificationSenders []notify.Sender
+	var accountMailer handlers.AccountMailer
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		from := os.Getenv("SMTP_FROM")
+		if from == "" {
+			from = "YouTube Clone <notifications@localhost>"
+		}
+		// Links in emails point at the web app
+		baseURL := os.Getenv("APP_BASE_URL")
+		if baseURL == "" {
+			baseURL = "http://localhost:3000"
+		}
+
+		mailer := notify.NewSMTPMailer(notify.SMTPConfig{
+			Addr:     addr,
+			From:     from,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		})
+		accountMailer = notify.NewAccountMailer(mailer, baseURL)
+		outbox := notify.NewEmailOutbox(db, mailer)
+		recipients := notify.NewSQLRecipients(db)
+
+		go outbox.Run(context.Background(), 30*time.Second)
+		go notify.NewDigestScheduler(db, outbox, recipients, baseURL).Run(context.Background(), time.Hour)
+		notificationSenders = append(notificationSenders, notify.NewEmailSender(outbox, recipients, baseURL))
+	}
+
+	// Web push is sent only when a VAPID key pair is configured
+	var vapidKeys *webpush.VAPIDKeys
+	if privateKey := os.Getenv("VAPID_PRIVATE_KEY"); privateKey != "" {
+		vapidKeys, err = webpush.ParseVAPIDKeys(os.Getenv("VAPID_PUBLIC_KEY"), privateKey, os.Getenv("VAPID_SUBJECT"))
+		if err != nil {
+			log.Fatal("Invalid VAPID configuration:", err)
+		}
+		notificationSenders = append(notificationSenders, notify.NewPushSender(db, webpush.NewClient(vapidKeys)))
+	}
+
+	// Everything that notifies users goes through one dispatcher
+	dispatcher := notify.NewDispatcher(db, hub, notificationSenders...)
+
+	// Read notifications are deleted after NOTIFICATION_RETENTION_DAYS, 90 by
+	// default; 0 keeps them forever
+	retentionDays := 90
+	if v := os.Getenv("NOTIFICATION_RETENTION_DAYS"); v != "" {
+		retentionDays, err = strconv.Atoi(v)
+		if err != nil || retentionDays < 0 {
+			log.Fatal("Invalid NOTIFICATION_RETENTION_DAYS:", v)
+		}
+	}
+	if retentionDays > 0 {
+		retention := notify.NewRetentionJob(db, time.Duration(retentionDays)*24*time.Hour)
+		go retention.Run(context.Background(), time.Hour)
+	}
+
+	// Users can also sign in with the OpenID Connect providers in
+	// OIDC_PROVIDERS
+	var oidcProviders []*auth.OIDCProvider
+	for _, config := range auth.OIDCConfigsFromEnv() {
+		provider, err := auth.NewOIDCProvider(config)
+		if err != nil {
+			log.Fatal("Invalid OIDC configuration:", err)
+		}
+		oidcProviders = append(oidcProviders, provider)
+	}
+
 	// Create router
 	r := mux.NewRouter()
 
@@ -38,90 +151,202 @@ func main() {
 	api := r.PathPrefix("/api").Subrouter()
 	
 	// Auth routes (public)
-	authHandler := handlers.NewAuthHandler(db)
+	authHandler := handlers.NewAuthHandler(db, accountMailer)
 	api.HandleFunc("/auth/signup", authHandler.Signup).Methods("POST")
 	api.HandleFunc("/auth/login", authHandler.Login).Methods("POST")
 	api.HandleFunc("/auth/refresh", authHandler.RefreshToken).Methods("POST")
+	api.HandleFunc("/auth/logout", authHandler.Logout).Methods("POST")
+	api.HandleFunc("/auth/login/2fa", authHandler.LoginTwoFactor).Methods("POST")
+	api.HandleFunc("/auth/verify-email", authHandler.VerifyEmail).Methods("POST")
+	api.HandleFunc("/auth/forgot-password", authHandler.ForgotPassword).Methods("POST")
+	api.HandleFunc("/auth/reset-password", authHandler.ResetPassword).Methods("POST")
+
+	oidcHandler := handlers.NewOIDCHandler(db, oidcProviders)
+	api.HandleFunc("/auth/oidc/providers", oidcHandler.ListProviders).Methods("GET")
+	api.HandleFunc("/auth/oidc/{provider}/authorize", oidcHandler.Authorize).Methods("GET")
+	api.HandleFunc("/auth/oidc/{provider}/callback", oidcHandler.Callback).Methods("POST")
+	r.HandleFunc("/.well-known/jwks.json", authHandler.JWKS).Methods("GET")
 	
 	// Protected auth route
 	protectedAuth := api.PathPrefix("/auth").Subrouter()
 	protectedAuth.Use(middleware.AuthMiddleware)
-	protectedAuth.HandleFunc("/me", authHandler.GetCurrentUser).Methods("GET")
+	protectedAuth.Handle("/me", middleware.RequireScope(auth.ScopeProfileRead, http.HandlerFunc(authHandler.GetCurrentUser))).Methods("GET")
+	protectedAuth.HandleFunc("/logout-all", authHandler.LogoutAll).Methods("POST")
+	protectedAuth.HandleFunc("/sessions", authHandler.ListSessions).Methods("GET")
+	protectedAuth.HandleFunc("/sessions/{id}", authHandler.RevokeSession).Methods("DELETE")
+	protectedAuth.HandleFunc("/verify-email/resend", authHandler.ResendVerificationEmail).Methods("POST")
+	protectedAuth.HandleFunc("/2fa/setup", authHandler.SetupTwoFactor).Methods("POST")
+	protectedAuth.HandleFunc("/2fa/confirm", authHandler.ConfirmTwoFactor).Methods("POST")
+	protectedAuth.HandleFunc("/2fa/disable", authHandler.DisableTwoFactor).Methods("POST")
+	protectedAuth.HandleFunc("/2fa/recovery-codes", authHandler.RegenerateRecoveryCodes).Methods("POST")
+
+	// API keys can't manage API keys
+	apiKeyHandler := handlers.NewAPIKeyHandler(db)
+	protectedAuth.HandleFunc("/api-keys", apiKeyHandler.CreateAPIKey).Methods("POST")
+	protectedAuth.HandleFunc("/api-keys", apiKeyHandler.ListAPIKeys).Methods("GET")
+	protectedAuth.HandleFunc("/api-keys/{id:[0-9]+}", apiKeyHandler.RevokeAPIKey).Methods("DELETE")
 	
 	// Upload routes (protected)
-	uploadHandler := handlers.NewUploadHandler(db, fileStorage)
+	uploadHandler := handlers.NewUploadHandler(db, fileStorage, dispatcher)
 	protectedUpload := api.PathPrefix("/upload").Subrouter()
 	protectedUpload.Use(middleware.AuthMiddleware)
-	protectedUpload.HandleFunc("/video", uploadHandler.UploadVideo).Methods("POST")
-	protectedUpload.HandleFunc("/video/delete", uploadHandler.DeleteVideo).Methods("DELETE")
+	protectedUpload.Handle("/video", middleware.RequireScope(auth.ScopeVideosWrite, middleware.RequirePermission(auth.PermUploadVideos, middleware.RequireVerifiedEmail(http.HandlerFunc(uploadHandler.UploadVideo))))).Methods("POST")
+	protectedUpload.Handle("/video/delete", middleware.RequireScope(auth.ScopeVideosWrite, middleware.RequirePermission(auth.PermUploadVideos, http.HandlerFunc(uploadHandler.DeleteVideo)))).Methods("DELETE")
 	
 	// Video routes
-	videoHandler := handlers.NewVideoHandler(db)
+	videoHandler := handlers.NewVideoHandler(db, dispatcher)
 	api.HandleFunc("/videos", videoHandler.GetVideos).Methods("GET")
 	api.HandleFunc("/videos/categories", videoHandler.GetCategories).Methods("GET")
 	api.HandleFunc("/videos/trending", videoHandler.GetTrendingVideos).Methods("GET")
 	api.HandleFunc("/videos/popular", videoHandler.GetPopularVideos).Methods("GET")
 	api.HandleFunc("/videos/{id}", videoHandler.GetVideo).Methods("GET")
 	api.HandleFunc("/videos/{id}/recommendations", videoHandler.GetRecommendations).Methods("GET")
-	api.HandleFunc("/videos/{id}/analytics", videoHandler.GetVideoAnalytics).Methods("GET")
-	api.HandleFunc("/videos", videoHandler.CreateVideo).Methods("POST")
+	api.Handle("/videos/{id}/analytics", middleware.Protect(auth.PermInteract, http.HandlerFunc(videoHandler.GetVideoAnalytics))).Methods("GET")
+	api.Handle("/videos/{id}", middleware.RequireScope(auth.ScopeVideosWrite, middleware.Protect(auth.PermUploadVideos, http.HandlerFunc(videoHandler.UpdateVideo)))).Methods("PUT")
+	api.Handle("/videos", middleware.RequireScope(auth.ScopeVideosWrite, middleware.Protect(auth.PermUploadVideos, middleware.RequireVerifiedEmail(http.HandlerFunc(videoHandler.CreateVideo))))).Methods("POST")
+	// Views are counted for everyone, logged in or not
 	api.HandleFunc("/videos/{id}/views", videoHandler.IncrementViews).Methods("POST")
-	api.HandleFunc("/videos/{id}/like", videoHandler.LikeVideo).Methods("POST")
-	api.HandleFunc("/videos/{id}/dislike", videoHandler.DislikeVideo).Methods("POST")
+	api.Handle("/videos/{id}/like", middleware.Protect(auth.PermInteract, http.HandlerFunc(videoHandler.LikeVideo))).Methods("POST")
+	api.Handle("/videos/{id}/dislike", middleware.Protect(auth.PermInteract, http.HandlerFunc(videoHandler.DislikeVideo))).Methods("POST")
 	
 	// Comment routes
-	commentHandler := handlers.NewCommentHandler(db)
+	// An external spam/toxicity classifier, if configured, runs after the built-in rules
+	var classifiers []moderation.Classifier
+	if url := os.Getenv("COMMENT_CLASSIFIER_URL"); url != "" {
+		classifiers = append(classifiers, moderation.NewHTTPClassifier("external", url))
+	}
+	commentHandler := handlers.NewCommentHandler(db, dispatcher, classifiers...)
 	api.HandleFunc("/videos/{videoId}/comments", commentHandler.GetComments).Methods("GET")
-	api.HandleFunc("/videos/{videoId}/comments", commentHandler.CreateComment).Methods("POST")
+	api.Handle("/videos/{videoId}/comments", middleware.RequireScope(auth.ScopeCommentsWrite, middleware.Protect(auth.PermInteract, http.HandlerFunc(commentHandler.CreateComment)))).Methods("POST")
 	api.HandleFunc("/comments/{id}", commentHandler.GetComment).Methods("GET")
-	api.HandleFunc("/comments/{id}", commentHandler.UpdateComment).Methods("PUT")
-	api.HandleFunc("/comments/{id}", commentHandler.DeleteComment).Methods("DELETE")
+	api.HandleFunc("/comments/{id}/replies", commentHandler.GetReplies).Methods("GET")
+
+	// Protected comment routes
+	protectedComments := api.PathPrefix("/comments").Subrouter()
+	protectedComments.Use(middleware.AuthMiddleware)
+	protectedComments.Handle("/{id}", middleware.RequireScope(auth.ScopeCommentsWrite, middleware.RequirePermission(auth.PermInteract, http.HandlerFunc(commentHandler.UpdateComment)))).Methods("PUT")
+	protectedComments.Handle("/{id}", middleware.RequireScope(auth.ScopeCommentsWrite, middleware.RequirePermission(auth.PermInteract, http.HandlerFunc(commentHandler.DeleteComment)))).Methods("DELETE")
+	protectedComments.Handle("/{id}/like", middleware.RequireScope(auth.ScopeCommentsWrite, middleware.RequirePermission(auth.PermInteract, http.HandlerFunc(commentHandler.LikeComment)))).Methods("POST")
+	protectedComments.Handle("/{id}/like", middleware.RequireScope(auth.ScopeCommentsWrite, middleware.RequirePermission(auth.PermInteract, http.HandlerFunc(commentHandler.UnlikeComment)))).Methods("DELETE")
+	protectedComments.Handle("/{id}/moderation", middleware.RequirePermission(auth.PermManageChannels, http.HandlerFunc(commentHandler.ModerateComment))).Methods("PUT")
 
 	// User routes
 	userHandler := handlers.NewUserHandler(db)
-	api.HandleFunc("/users", userHandler.CreateUser).Methods("POST")
+	api.Handle("/users", middleware.Protect(auth.PermManageUsers, http.HandlerFunc(userHandler.CreateUser))).Methods("POST")
 	api.HandleFunc("/users/{id}", userHandler.GetUser).Methods("GET")
-	api.HandleFunc("/users/{id}", userHandler.UpdateUser).Methods("PUT")
+	api.Handle("/users/{id}", middleware.Protect(auth.PermInteract, middleware.RequireSelf("id", http.HandlerFunc(userHandler.UpdateUser)))).Methods("PUT")
 
 	// Watch history routes
 	historyHandler := handlers.NewHistoryHandler(db)
-	api.HandleFunc("/users/{userId}/history", historyHandler.AddToHistory).Methods("POST")
+	api.Handle("/users/{userId}/history", middleware.Protect(auth.PermInteract, middleware.RequireSelf("userId", http.HandlerFunc(historyHandler.AddToHistory)))).Methods("POST")
 	api.HandleFunc("/users/{userId}/history", historyHandler.GetHistory).Methods("GET")
 	
 	// Plan routes
 	planHandler := handlers.NewPlanHandler(db)
 	api.HandleFunc("/plans", planHandler.GetPlans).Methods("GET")
 	api.HandleFunc("/plans/{id}", planHandler.GetPlan).Methods("GET")
-	api.HandleFunc("/plans", planHandler.CreatePlan).Methods("POST")
-	api.HandleFunc("/plans/{id}", planHandler.UpdatePlan).Methods("PUT")
-	api.HandleFunc("/plans/{id}", planHandler.DeletePlan).Methods("DELETE")
+	api.Handle("/plans", middleware.Protect(auth.PermManagePlans, http.HandlerFunc(planHandler.CreatePlan))).Methods("POST")
+	api.Handle("/plans/{id}", middleware.Protect(auth.PermManagePlans, http.HandlerFunc(planHandler.UpdatePlan))).Methods("PUT")
+	api.Handle("/plans/{id}", middleware.Protect(auth.PermManagePlans, http.HandlerFunc(planHandler.DeletePlan))).Methods("DELETE")
 	api.HandleFunc("/users/{userId}/plan", planHandler.GetUserPlan).Methods("GET")
-	api.HandleFunc("/users/{userId}/plan", planHandler.UpdateUserPlan).Methods("PUT")
+	api.Handle("/users/{userId}/plan", middleware.Protect(auth.PermInteract, middleware.RequireSelf("userId", http.HandlerFunc(planHandler.UpdateUserPlan)))).Methods("PUT")
 
 	// Subscription routes
 	subscriptionHandler := handlers.NewSubscriptionHandler(db)
 	api.HandleFunc("/users/{userId}/subscriptions", subscriptionHandler.GetUserSubscriptions).Methods("GET")
-	api.HandleFunc("/users/{userId}/subscriptions", subscriptionHandler.Subscribe).Methods("POST")
-	api.HandleFunc("/users/{userId}/subscriptions/{channelName}", subscriptionHandler.CheckSubscription).Methods("GET")
-	api.HandleFunc("/users/{userId}/subscriptions/{channelName}", subscriptionHandler.Unsubscribe).Methods("DELETE")
+	api.Handle("/users/{userId}/subscriptions", middleware.Protect(auth.PermInteract, middleware.RequireSelf("userId", http.HandlerFunc(subscriptionHandler.Subscribe)))).Methods("POST")
+	api.HandleFunc("/users/{userId}/subscriptions/feed", subscriptionHandler.GetSubscriptionFeed).Methods("GET")
+	api.HandleFunc("/users/{userId}/subscriptions/unseen", subscriptionHandler.GetUnseenCounts).Methods("GET")
+	api.Handle("/users/{userId}/subscriptions/{channelId:[0-9]+}/visit", middleware.Protect(auth.PermInteract, middleware.RequireSelf("userId", http.HandlerFunc(subscriptionHandler.MarkChannelVisited)))).Methods("POST")
+	api.Handle("/users/{userId}/subscriptions/{channelId:[0-9]+}/notifications", middleware.Protect(auth.PermInteract, middleware.RequireSelf("userId", http.HandlerFunc(subscriptionHandler.UpdateNotificationLevel)))).Methods("PUT")
+	api.HandleFunc("/users/{userId}/subscriptions/{channelId:[0-9]+}", subscriptionHandler.CheckSubscription).Methods("GET")
+	api.Handle("/users/{userId}/subscriptions/{channelId:[0-9]+}", middleware.Protect(auth.PermInteract, middleware.RequireSelf("userId", http.HandlerFunc(subscriptionHandler.Unsubscribe)))).Methods("DELETE")
+
+	// Channel routes
+	channelHandler := handlers.NewChannelHandler(db)
+	api.HandleFunc("/channels/{id:[0-9]+}", channelHandler.GetChannel).Methods("GET")
+	api.HandleFunc("/channels/{id:[0-9]+}/page", channelHandler.GetChannelPage).Methods("GET")
+	api.HandleFunc("/channels/handle/{handle}", channelHandler.GetChannelByHandle).Methods("GET")
+	api.HandleFunc("/users/{userId}/channels", channelHandler.GetUserChannels).Methods("GET")
+
+	// Protected channel routes
+	protectedChannels := api.PathPrefix("/channels").Subrouter()
+	protectedChannels.Use(middleware.AuthMiddleware)
+	protectedChannels.Handle("", middleware.RequirePermission(auth.PermManageChannels, http.HandlerFunc(channelHandler.CreateChannel))).Methods("POST")
+	protectedChannels.Handle("/{id:[0-9]+}", middleware.RequirePermission(auth.PermManageChannels, http.HandlerFunc(channelHandler.UpdateChannel))).Methods("PUT")
+	protectedChannels.Handle("/{id:[0-9]+}", middleware.RequirePermission(auth.PermManageChannels, http.HandlerFunc(channelHandler.DeleteChannel))).Methods("DELETE")
+	protectedChannels.HandleFunc("/{id:[0-9]+}/blocked-words", channelHandler.GetBlockedWords).Methods("GET")
+	protectedChannels.Handle("/{id:[0-9]+}/blocked-words", middleware.RequirePermission(auth.PermManageChannels, http.HandlerFunc(channelHandler.UpdateBlockedWords))).Methods("PUT")
+	protectedChannels.HandleFunc("/{id:[0-9]+}/comments/held", commentHandler.GetHeldComments).Methods("GET")
 
-	// Playlist routes
+	// Channel teams; owners and managers invite others to help run a channel
+	teamHandler := handlers.NewChannelTeamHandler(db, dispatcher)
+	protectedChannels.HandleFunc("/{id:[0-9]+}/members", teamHandler.ListMembers).Methods("GET")
+	protectedChannels.Handle("/{id:[0-9]+}/members/{userId:[0-9]+}", middleware.RequirePermission(auth.PermManageChannels, http.HandlerFunc(teamHandler.UpdateMemberRole))).Methods("PUT")
+	protectedChannels.Handle("/{id:[0-9]+}/members/{userId:[0-9]+}", middleware.RequirePermission(auth.PermInteract, http.HandlerFunc(teamHandler.RemoveMember))).Methods("DELETE")
+	protectedChannels.Handle("/{id:[0-9]+}/invitations", middleware.RequirePermission(auth.PermManageChannels, http.HandlerFunc(teamHandler.InviteMember))).Methods("POST")
+	protectedChannels.HandleFunc("/{id:[0-9]+}/invitations", teamHandler.ListChannelInvitations).Methods("GET")
+	protectedChannels.Handle("/{id:[0-9]+}/invitations/{invitationId:[0-9]+}", middleware.RequirePermission(auth.PermManageChannels, http.HandlerFunc(teamHandler.CancelInvitation))).Methods("DELETE")
+	api.Handle("/channel-invitations", middleware.AuthMiddleware(http.HandlerFunc(teamHandler.GetMyInvitations))).Methods("GET")
+	api.Handle("/channel-invitations/{id:[0-9]+}/accept", middleware.Protect(auth.PermInteract, http.HandlerFunc(teamHandler.AcceptInvitation))).Methods("POST")
+	api.Handle("/channel-invitations/{id:[0-9]+}", middleware.Protect(auth.PermInteract, http.HandlerFunc(teamHandler.DeclineInvitation))).Methods("DELETE")
+
+	// Playlist routes; changes need their owner's token or a playlists:write
+	// API key
 	playlistHandler := handlers.NewPlaylistHandler(db)
+	playlistWrite := func(h http.HandlerFunc) http.Handler {
+		return middleware.RequireScope(auth.ScopePlaylistsWrite, middleware.Protect(auth.PermInteract, h))
+	}
 	api.HandleFunc("/users/{userId}/playlists", playlistHandler.GetUserPlaylists).Methods("GET")
-	api.HandleFunc("/users/{userId}/playlists", playlistHandler.CreatePlaylist).Methods("POST")
+	api.Handle("/users/{userId}/playlists", playlistWrite(playlistHandler.CreatePlaylist)).Methods("POST")
 	api.HandleFunc("/playlists/{id}", playlistHandler.GetPlaylist).Methods("GET")
-	api.HandleFunc("/playlists/{id}", playlistHandler.UpdatePlaylist).Methods("PUT")
-	api.HandleFunc("/playlists/{id}", playlistHandler.DeletePlaylist).Methods("DELETE")
-	api.HandleFunc("/playlists/{id}/videos", playlistHandler.AddVideoToPlaylist).Methods("POST")
-	api.HandleFunc("/playlists/{id}/videos/{videoId}", playlistHandler.RemoveVideoFromPlaylist).Methods("DELETE")
+	api.Handle("/playlists/{id}", playlistWrite(playlistHandler.UpdatePlaylist)).Methods("PUT")
+	api.Handle("/playlists/{id}", playlistWrite(playlistHandler.DeletePlaylist)).Methods("DELETE")
+	api.Handle("/playlists/{id}/videos", playlistWrite(playlistHandler.AddVideoToPlaylist)).Methods("POST")
+	api.Handle("/playlists/{id}/videos/{videoId}", playlistWrite(playlistHandler.RemoveVideoFromPlaylist)).Methods("DELETE")
 	
 	// Notification routes
-	notificationHandler := handlers.NewNotificationHandler(db)
+	notificationHandler := handlers.NewNotificationHandler(db, dispatcher)
 	api.HandleFunc("/users/{userId}/notifications", notificationHandler.GetUserNotifications).Methods("GET")
 	api.HandleFunc("/users/{userId}/notifications/unread-count", notificationHandler.GetUnreadCount).Methods("GET")
-	api.HandleFunc("/users/{userId}/notifications/mark-all-read", notificationHandler.MarkAllAsRead).Methods("POST")
-	api.HandleFunc("/notifications", notificationHandler.CreateNotification).Methods("POST")
-	api.HandleFunc("/notifications/{id}/mark-read", notificationHandler.MarkAsRead).Methods("POST")
+	api.Handle("/users/{userId}/notifications/mark-all-read", middleware.Protect(auth.PermInteract, middleware.RequireSelf("userId", http.HandlerFunc(notificationHandler.MarkAllAsRead)))).Methods("POST")
+	api.Handle("/notifications", middleware.Protect(auth.PermSendNotifications, http.HandlerFunc(notificationHandler.CreateNotification))).Methods("POST")
+	api.Handle("/notifications/{id}/mark-read", middleware.Protect(auth.PermInteract, http.HandlerFunc(notificationHandler.MarkAsRead))).Methods("POST")
+	api.Handle("/notifications/{id}", middleware.Protect(auth.PermInteract, http.HandlerFunc(notificationHandler.DeleteNotification))).Methods("DELETE")
+	api.Handle("/notifications/{id}/archive", middleware.Protect(auth.PermInteract, http.HandlerFunc(notificationHandler.ArchiveNotification))).Methods("POST")
+	api.Handle("/notifications/{id}/unarchive", middleware.Protect(auth.PermInteract, http.HandlerFunc(notificationHandler.UnarchiveNotification))).Methods("POST")
+	api.Handle("/users/{userId}/notifications/bulk-delete", middleware.Protect(auth.PermInteract, http.HandlerFunc(notificationHandler.BulkDeleteNotifications))).Methods("POST")
+
+	// Real-time notifications; the websocket handshake authenticates itself
+	api.HandleFunc("/ws", hub.ServeWS).Methods("GET")
+
+	// Muted users (protected)
+	api.Handle("/users/{userId}/mutes", middleware.AuthMiddleware(http.HandlerFunc(notificationHandler.GetMutedUsers))).Methods("GET")
+	api.Handle("/users/{userId}/mutes", middleware.Protect(auth.PermInteract, http.HandlerFunc(notificationHandler.MuteUser))).Methods("POST")
+	api.Handle("/users/{userId}/mutes/{mutedUserId:[0-9]+}", middleware.Protect(auth.PermInteract, http.HandlerFunc(notificationHandler.UnmuteUser))).Methods("DELETE")
+
+	// Notification preferences (protected)
+	api.Handle("/users/{userId}/notification-preferences", middleware.AuthMiddleware(http.HandlerFunc(notificationHandler.GetPreferences))).Methods("GET")
+	api.Handle("/users/{userId}/notification-preferences", middleware.Protect(auth.PermInteract, http.HandlerFunc(notificationHandler.UpdatePreferences))).Methods("PUT")
+
+	// Web push registration
+	pushHandler := handlers.NewPushHandler(db, vapidKeys)
+	api.HandleFunc("/push/vapid-public-key", pushHandler.GetVAPIDPublicKey).Methods("GET")
+	api.Handle("/users/{userId}/push-subscriptions", middleware.Protect(auth.PermInteract, http.HandlerFunc(pushHandler.RegisterSubscription))).Methods("POST")
+	api.Handle("/users/{userId}/push-subscriptions", middleware.Protect(auth.PermInteract, http.HandlerFunc(pushHandler.UnregisterSubscription))).Methods("DELETE")
+
+	// Staff routes (protected, each needs a staff permission)
+	moderationHandler := handlers.NewModerationHandler(db, dispatcher)
+	roleHandler := handlers.NewRoleHandler(db)
+	admin := api.PathPrefix("/admin").Subrouter()
+	admin.Use(middleware.AuthMiddleware)
+	admin.Handle("/comments/review", middleware.RequirePermission(auth.PermModerateComments, http.HandlerFunc(moderationHandler.GetReviewQueue))).Methods("GET")
+	admin.Handle("/comments/{id:[0-9]+}/review", middleware.RequirePermission(auth.PermModerateComments, http.HandlerFunc(moderationHandler.ReviewComment))).Methods("PUT")
+	admin.Handle("/comment-filter", middleware.RequirePermission(auth.PermManageSettings, http.HandlerFunc(moderationHandler.GetFilterSettings))).Methods("GET")
+	admin.Handle("/comment-filter", middleware.RequirePermission(auth.PermManageSettings, http.HandlerFunc(moderationHandler.UpdateFilterSettings))).Methods("PUT")
+	admin.Handle("/websocket/stats", middleware.RequirePermission(auth.PermManageSettings, http.HandlerFunc(hub.StatsHandler))).Methods("GET")
+	admin.Handle("/roles", middleware.RequirePermission(auth.PermManageUsers, http.HandlerFunc(roleHandler.ListRoles))).Methods("GET")
+	admin.Handle("/users/{id:[0-9]+}/role", middleware.RequirePermission(auth.PermManageUsers, http.HandlerFunc(roleHandler.UpdateUserRole))).Methods("PUT")
 
 	// API Documentation routes (Swagger/OpenAPI)
 	api.HandleFunc("/docs", docs.SwaggerUIHandler).Methods("GET")
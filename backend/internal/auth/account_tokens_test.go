@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestConsumeAccountToken(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE account_tokens SET used_at = CURRENT_TIMESTAMP").
+		WithArgs(HashRefreshToken("token"), PurposeVerifyEmail).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "email"}).AddRow(7, "a@example.com"))
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	defer tx.Rollback()
+
+	userID, email, err := ConsumeAccountToken(context.Background(), tx, "token", PurposeVerifyEmail)
+	if err != nil {
+		t.Fatalf("ConsumeAccountToken failed: %v", err)
+	}
+	if userID != 7 || email != "a@example.com" {
+		t.Errorf("Expected user 7 and a@example.com, got %d and %q", userID, email)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestConsumeAccountToken_Invalid(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	// Used, expired and unknown tokens, as well as ones for another purpose,
+	// match no row
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE account_tokens SET used_at = CURRENT_TIMESTAMP").
+		WithArgs(HashRefreshToken("token"), PurposePasswordReset).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "email"}))
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, _, err := ConsumeAccountToken(context.Background(), tx, "token", PurposePasswordReset); !errors.Is(err, ErrInvalidAccountToken) {
+		t.Errorf("Expected ErrInvalidAccountToken, got %v", err)
+	}
+}
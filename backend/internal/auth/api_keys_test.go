@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+func TestCreateAPIKey(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	mock.ExpectQuery("INSERT INTO api_keys").
+		WithArgs(7, "ci", sqlmock.AnyArg(), sqlmock.AnyArg(), pq.Array([]string{ScopeVideosWrite}), DefaultAPIKeyTTL.Seconds()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "expires_at"}).AddRow(1, now, now.Add(DefaultAPIKeyTTL)))
+
+	apiKey, key, err := CreateAPIKey(context.Background(), db, 7, "ci", []string{ScopeVideosWrite}, DefaultAPIKeyTTL)
+	if err != nil {
+		t.Fatalf("CreateAPIKey failed: %v", err)
+	}
+	if !IsAPIKey(key) || !strings.HasPrefix(key, apiKey.Prefix) || len(apiKey.Prefix) != len(APIKeyPrefix)+6 {
+		t.Errorf("Unexpected key %q with prefix %q", key, apiKey.Prefix)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSQLAPIKeyVerifier(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	verifier := NewSQLAPIKeyVerifier(db)
+	rows := []string{"id", "user_id", "username", "role", "email_verified", "scopes"}
+
+	mock.ExpectQuery("FROM api_keys k JOIN users u").
+		WithArgs(HashAPIKey("yt_good")).
+		WillReturnRows(sqlmock.NewRows(rows).AddRow(3, 7, "alice", "user", true, "{videos:write,comments:write}"))
+	mock.ExpectExec("UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP").
+		WithArgs(3, apiKeyUseInterval.Seconds()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	p, err := verifier.VerifyAPIKey(context.Background(), "yt_good")
+	if err != nil {
+		t.Fatalf("VerifyAPIKey failed: %v", err)
+	}
+	if p.UserID != 7 || !p.HasScope(ScopeCommentsWrite) || p.HasScope(ScopePlaylistsWrite) {
+		t.Errorf("Unexpected principal %+v", p)
+	}
+
+	// Revoked and expired keys aren't found
+	mock.ExpectQuery("FROM api_keys k JOIN users u").
+		WithArgs(HashAPIKey("yt_revoked")).
+		WillReturnRows(sqlmock.NewRows(rows))
+	if _, err := verifier.VerifyAPIKey(context.Background(), "yt_revoked"); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("Expected ErrInvalidAPIKey, got %v", err)
+	}
+
+	// Access tokens aren't looked up at all
+	if _, err := verifier.VerifyAPIKey(context.Background(), "eyJhbGciOi"); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("Expected ErrInvalidAPIKey, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestRemoteAPIKeyVerifier(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		var req struct {
+			Key string `json:"key"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Key != "yt_good" {
+			http.Error(w, "Invalid or expired API key", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(APIKeyPrincipal{KeyID: 3, UserID: 7, Username: "alice", Scopes: []string{ScopeVideosWrite}})
+	}))
+	defer server.Close()
+
+	verifier := NewRemoteAPIKeyVerifier(server.URL)
+	now := time.Now()
+	verifier.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		p, err := verifier.VerifyAPIKey(context.Background(), "yt_good")
+		if err != nil || p.UserID != 7 || !p.HasScope(ScopeVideosWrite) {
+			t.Fatalf("Unexpected principal %+v (%v)", p, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Expected one check while cached, got %d", calls)
+	}
+
+	now = now.Add(APIKeyCheckTTL)
+	verifier.VerifyAPIKey(context.Background(), "yt_good")
+	if calls != 2 {
+		t.Errorf("Expected the key to be checked again after the TTL, got %d checks", calls)
+	}
+
+	if _, err := verifier.VerifyAPIKey(context.Background(), "yt_bad"); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("Expected ErrInvalidAPIKey, got %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	if err := SetTrustedProxies([]string{"10.0.0.5", "172.28.0.0/16"}); err != nil {
+		t.Fatalf("SetTrustedProxies failed: %v", err)
+	}
+	defer SetTrustedProxies(nil)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		want       string
+	}{
+		{"direct", "203.0.113.7:5000", "", "203.0.113.7"},
+		{"spoofed header from a client", "203.0.113.7:5000", "198.51.100.1", "203.0.113.7"},
+		{"through a trusted proxy", "10.0.0.5:5000", "203.0.113.7", "203.0.113.7"},
+		{"spoofed entry before the proxy's", "10.0.0.5:5000", "198.51.100.1, 203.0.113.7", "203.0.113.7"},
+		{"through two trusted proxies", "10.0.0.5:5000", "203.0.113.7, 172.28.0.3", "203.0.113.7"},
+		{"trusted proxy without a header", "10.0.0.5:5000", "", "10.0.0.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/auth/login", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+
+			if got := ClientIP(req); got != tt.want {
+				t.Errorf("ClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetTrustedProxies_Invalid(t *testing.T) {
+	defer SetTrustedProxies(nil)
+
+	for _, proxy := range []string{"gateway", "10.0.0.0/33"} {
+		if err := SetTrustedProxies([]string{proxy}); err == nil {
+			t.Errorf("Expected an error for %q", proxy)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestGenerateAndValidateToken(t *testing.T) {
+	token, err := GenerateToken(TokenSubject{UserID: 7, Username: "alice", Role: "user", SessionID: "session-1"})
+	if err != nil {
+		t.Fatalf("GenerateToken failed: %v", err)
+	}
+
+	claims, err := ValidateToken(token)
+	if err != nil {
+		t.Fatalf("ValidateToken failed: %v", err)
+	}
+	if claims.UserID != 7 || claims.Issuer != issuer || claims.NotBefore == nil {
+		t.Errorf("Unexpected claims %+v", claims)
+	}
+}
+
+func signClaims(t *testing.T, method jwt.SigningMethod, key interface{}, claims jwt.Claims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = keys.signer.id
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+	return s
+}
+
+func TestValidateToken_Rejects(t *testing.T) {
+	now := time.Now()
+	valid := func() jwt.RegisteredClaims {
+		return jwt.RegisteredClaims{
+			Issuer:    issuer,
+			Audience:  jwt.ClaimStrings{audience},
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+			NotBefore: jwt.NewNumericDate(now),
+		}
+	}
+
+	wrongIssuer := valid()
+	wrongIssuer.Issuer = "someone-else"
+	wrongAudience := valid()
+	wrongAudience.Audience = jwt.ClaimStrings{"another-api"}
+	noNotBefore := valid()
+	noNotBefore.NotBefore = nil
+	notYetValid := valid()
+	notYetValid.NotBefore = jwt.NewNumericDate(now.Add(time.Hour))
+	noExpiry := valid()
+	noExpiry.ExpiresAt = nil
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"Wrong issuer", signClaims(t, keys.signer.method, keys.signer.key, &Claims{RegisteredClaims: wrongIssuer})},
+		{"Wrong audience", signClaims(t, keys.signer.method, keys.signer.key, &Claims{RegisteredClaims: wrongAudience})},
+		{"No not-before", signClaims(t, keys.signer.method, keys.signer.key, &Claims{RegisteredClaims: noNotBefore})},
+		{"Not yet valid", signClaims(t, keys.signer.method, keys.signer.key, &Claims{RegisteredClaims: notYetValid})},
+		{"No expiry", signClaims(t, keys.signer.method, keys.signer.key, &Claims{RegisteredClaims: noExpiry})},
+		{"HMAC with the old shared secret", signClaims(t, jwt.SigningMethodHS256,
+			[]byte("youtube-clone-secret-key-change-in-production"), &Claims{RegisteredClaims: valid()})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ValidateToken(tt.token); err == nil {
+				t.Error("Expected the token to be rejected")
+			}
+		})
+	}
+}
+
+func TestKeyID_RFC8037(t *testing.T) {
+	// Example key and thumbprint from RFC 8037, appendix A.3
+	x, _ := base64.RawURLEncoding.DecodeString("11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo")
+
+	kid, err := KeyID(ed25519.PublicKey(x))
+	if err != nil {
+		t.Fatalf("KeyID failed: %v", err)
+	}
+	if kid != "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k" {
+		t.Errorf("Unexpected thumbprint %s", kid)
+	}
+}
+
+func TestJWK_RoundTrip(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate Ed25519 key: %v", err)
+	}
+
+	for _, pub := range []interface{}{&rsaKey.PublicKey, edPub} {
+		jwk, err := NewJWK(pub)
+		if err != nil {
+			t.Fatalf("NewJWK failed: %v", err)
+		}
+		decoded, err := jwk.PublicKey()
+		if err != nil {
+			t.Fatalf("PublicKey failed: %v", err)
+		}
+		kid, _ := KeyID(decoded)
+		if kid != jwk.Kid {
+			t.Errorf("Expected the decoded %s key to keep kid %s, got %s", jwk.Kty, jwk.Kid, kid)
+		}
+	}
+}
+
+func TestJWKSFetcher(t *testing.T) {
+	first, _, _ := ed25519.GenerateKey(rand.Reader)
+	second, _, _ := ed25519.GenerateKey(rand.Reader)
+	firstKid, _ := KeyID(first)
+	secondKid, _ := KeyID(second)
+
+	published := []interface{}{first}
+	var fetches int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		set := JWKS{}
+		for _, pub := range published {
+			jwk, _ := NewJWK(pub)
+			set.Keys = append(set.Keys, jwk)
+		}
+		json.NewEncoder(w).Encode(set)
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	fetcher := NewJWKSFetcher(server.URL)
+	fetcher.now = func() time.Time { return now }
+
+	if _, err := fetcher.publicKey(firstKid); err != nil {
+		t.Fatalf("Expected the first key, got %v", err)
+	}
+	if _, err := fetcher.publicKey(firstKid); err != nil || atomic.LoadInt32(&fetches) != 1 {
+		t.Errorf("Expected the cached key without another fetch, got %v after %d fetches", err, fetches)
+	}
+
+	// The signer rotates, but a burst of unknown kids doesn't refetch
+	published = []interface{}{second, first}
+	if _, err := fetcher.publicKey(secondKid); !errors.Is(err, ErrUnknownKey) || atomic.LoadInt32(&fetches) != 1 {
+		t.Errorf("Expected ErrUnknownKey without a fetch, got %v after %d fetches", err, fetches)
+	}
+
+	now = now.Add(jwksMinRefresh)
+	if _, err := fetcher.publicKey(secondKid); err != nil || atomic.LoadInt32(&fetches) != 2 {
+		t.Errorf("Expected the rotated key after a fetch, got %v after %d fetches", err, fetches)
+	}
+}
+
+func TestJWKSFetcher_KnownKeysDuringRefresh(t *testing.T) {
+	first, _, _ := ed25519.GenerateKey(rand.Reader)
+	second, _, _ := ed25519.GenerateKey(rand.Reader)
+	firstKid, _ := KeyID(first)
+	secondKid, _ := KeyID(second)
+
+	published := []interface{}{first}
+	release := make(chan struct{})
+	var fetches int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&fetches, 1) > 1 {
+			<-release
+		}
+		set := JWKS{}
+		for _, pub := range published {
+			jwk, _ := NewJWK(pub)
+			set.Keys = append(set.Keys, jwk)
+		}
+		json.NewEncoder(w).Encode(set)
+	}))
+	defer server.Close()
+
+	start := time.Now()
+	fetcher := NewJWKSFetcher(server.URL)
+	fetcher.now = func() time.Time { return start }
+	if _, err := fetcher.publicKey(firstKid); err != nil {
+		t.Fatalf("Expected the first key, got %v", err)
+	}
+
+	// The cache expires and an unknown kid starts a fetch that hangs
+	published = []interface{}{second, first}
+	fetcher.now = func() time.Time { return start.Add(jwksCacheTTL) }
+	rotated := make(chan error, 2)
+	go func() {
+		_, err := fetcher.publicKey(secondKid)
+		rotated <- err
+	}()
+	for atomic.LoadInt32(&fetches) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	go func() {
+		_, err := fetcher.publicKey(secondKid)
+		rotated <- err
+	}()
+
+	known := make(chan error, 1)
+	go func() {
+		_, err := fetcher.publicKey(firstKid)
+		known <- err
+	}()
+	select {
+	case err := <-known:
+		if err != nil {
+			t.Errorf("Expected the known key during the fetch, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Looking up a known key waited for the fetch")
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-rotated; err != nil {
+			t.Errorf("Expected the rotated key once the fetch finished, got %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Errorf("Expected concurrent lookups to share one fetch, got %d fetches", n)
+	}
+}
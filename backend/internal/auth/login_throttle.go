@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// Failed logins slow down further attempts on the same account and from the
+// same IP address. After a few free failures each one doubles the wait, until
+// enough of them lock the account or address out for LockoutDuration.
+const (
+	// LoginFailureWindow is how far back failed logins count
+	LoginFailureWindow = time.Hour
+
+	// LockoutDuration is how long a locked out account or IP has to wait
+	LockoutDuration = 15 * time.Minute
+
+	// MaxLoginFailures locks an account out. A successful login starts the
+	// count over.
+	MaxLoginFailures = 10
+
+	// freeLoginFailures are allowed on an account before backing off
+	freeLoginFailures = 3
+
+	// An address may try many accounts, such as users behind one NAT, so it
+	// gets more leeway
+	freeIPLoginFailures = 20
+	maxIPLoginFailures  = 100
+)
+
+// LoginStatus is how an account and IP address stand before a login attempt
+type LoginStatus struct {
+	// Failures counts the account's recent failed logins
+	Failures int
+
+	// RetryAfter is how long to wait before the next attempt is allowed
+	RetryAfter time.Duration
+}
+
+// NormalizeEmail returns the form failed logins are tracked under, so that
+// changing case doesn't get around the limits
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// loginBackoff returns how long to wait after the last of failures
+func loginBackoff(failures, free, max int) time.Duration {
+	if failures >= max {
+		return LockoutDuration
+	}
+	if failures < free {
+		return 0
+	}
+	// Shifting further would overflow, and wait longer than a lockout anyway
+	if failures-free >= 10 {
+		return LockoutDuration
+	}
+	if d := time.Second << (failures - free); d < LockoutDuration {
+		return d
+	}
+	return LockoutDuration
+}
+
+// CheckLogin tells whether a login for email from ip may go ahead. It doesn't
+// matter whether an account uses the address, so that the limits don't reveal
+// who has one.
+func CheckLogin(ctx context.Context, db *sql.DB, email, ip string) (LoginStatus, error) {
+	var status LoginStatus
+	var elapsed float64
+	err := db.QueryRowContext(ctx, `
+		SELECT COUNT(*), COALESCE(EXTRACT(EPOCH FROM CURRENT_TIMESTAMP - MAX(created_at)), 0)
+		FROM login_attempts
+		WHERE email = $1 AND succeeded = FALSE
+		  AND created_at > CURRENT_TIMESTAMP - make_interval(secs => $2)
+		  AND created_at > COALESCE(
+		      (SELECT MAX(created_at) FROM login_attempts WHERE email = $1 AND succeeded = TRUE),
+		      '-infinity')
+	`, NormalizeEmail(email), LoginFailureWindow.Seconds()).Scan(&status.Failures, &elapsed)
+	if err != nil {
+		return LoginStatus{}, err
+	}
+	status.RetryAfter = remaining(loginBackoff(status.Failures, freeLoginFailures, MaxLoginFailures), elapsed)
+
+	if ip == "" {
+		return status, nil
+	}
+
+	var ipFailures int
+	err = db.QueryRowContext(ctx, `
+		SELECT COUNT(*), COALESCE(EXTRACT(EPOCH FROM CURRENT_TIMESTAMP - MAX(created_at)), 0)
+		FROM login_attempts
+		WHERE ip_address = $1 AND succeeded = FALSE
+		  AND created_at > CURRENT_TIMESTAMP - make_interval(secs => $2)
+	`, ip, LoginFailureWindow.Seconds()).Scan(&ipFailures, &elapsed)
+	if err != nil {
+		return LoginStatus{}, err
+	}
+	if wait := remaining(loginBackoff(ipFailures, freeIPLoginFailures, maxIPLoginFailures), elapsed); wait > status.RetryAfter {
+		status.RetryAfter = wait
+	}
+	return status, nil
+}
+
+// remaining returns what is left of wait after elapsed seconds
+func remaining(wait time.Duration, elapsed float64) time.Duration {
+	left := wait - time.Duration(elapsed*float64(time.Second))
+	if left < 0 {
+		return 0
+	}
+	return left
+}
+
+// RecordLoginAttempt adds a login to the audit trail that CheckLogin counts.
+// userID is 0 when no account uses the email address.
+func RecordLoginAttempt(ctx context.Context, db *sql.DB, email string, userID int, client Client, succeeded bool) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO login_attempts (email, user_id, ip_address, user_agent, succeeded)
+		VALUES ($1, $2, $3, $4, $5)
+	`, NormalizeEmail(email), sql.NullInt64{Int64: int64(userID), Valid: userID != 0},
+		truncate(client.IP, 45), truncate(client.UserAgent, 512), succeeded)
+	return err
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestLoginBackoff(t *testing.T) {
+	cases := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{freeLoginFailures - 1, 0},
+		{freeLoginFailures, time.Second},
+		{freeLoginFailures + 3, 8 * time.Second},
+		{MaxLoginFailures - 1, 64 * time.Second},
+		{MaxLoginFailures, LockoutDuration},
+	}
+	for _, c := range cases {
+		if got := loginBackoff(c.failures, freeLoginFailures, MaxLoginFailures); got != c.want {
+			t.Errorf("loginBackoff(%d) = %v, want %v", c.failures, got, c.want)
+		}
+	}
+
+	// Long runs of failures from one address don't overflow
+	if got := loginBackoff(maxIPLoginFailures-1, freeIPLoginFailures, maxIPLoginFailures); got != LockoutDuration {
+		t.Errorf("Expected a lockout, got %v", got)
+	}
+}
+
+func TestCheckLogin(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	// The account is locked out and the last failure was 5 minutes ago
+	mock.ExpectQuery("FROM login_attempts WHERE email = \\$1").
+		WithArgs("alice@example.com", LoginFailureWindow.Seconds()).
+		WillReturnRows(sqlmock.NewRows([]string{"count", "elapsed"}).AddRow(MaxLoginFailures, 300.0))
+	mock.ExpectQuery("FROM login_attempts WHERE ip_address = \\$1").
+		WithArgs("192.0.2.1", LoginFailureWindow.Seconds()).
+		WillReturnRows(sqlmock.NewRows([]string{"count", "elapsed"}).AddRow(MaxLoginFailures, 300.0))
+
+	status, err := CheckLogin(context.Background(), db, " Alice@Example.com", "192.0.2.1")
+	if err != nil {
+		t.Fatalf("CheckLogin failed: %v", err)
+	}
+	if status.Failures != MaxLoginFailures {
+		t.Errorf("Expected %d failures, got %d", MaxLoginFailures, status.Failures)
+	}
+	if status.RetryAfter != LockoutDuration-5*time.Minute {
+		t.Errorf("Expected to wait %v, got %v", LockoutDuration-5*time.Minute, status.RetryAfter)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
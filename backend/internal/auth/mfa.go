@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	// RecoveryCodeCount is how many recovery codes a user gets at a time
+	RecoveryCodeCount = 10
+
+	// MFAChallengeTTL is how long a user has to enter their code after
+	// their password was accepted
+	MFAChallengeTTL = 5 * time.Minute
+
+	// maxMFAAttempts is how many codes may be tried against one challenge
+	maxMFAAttempts = 5
+
+	// recoveryAlphabet has 32 characters, leaving out ones that are easy
+	// to confuse (0, i, l, o)
+	recoveryAlphabet = "abcdefghjkmnpqrstuvwxyz123456789"
+)
+
+var (
+	// ErrInvalidMFAChallenge is returned for unknown, expired or used up
+	// login challenges
+	ErrInvalidMFAChallenge = errors.New("invalid or expired two-factor challenge")
+
+	// ErrInvalidMFACode is returned for wrong, reused or malformed codes
+	ErrInvalidMFACode = errors.New("invalid two-factor code")
+)
+
+// GenerateRecoveryCodes returns RecoveryCodeCount new codes such as
+// "k3v9x-2qj7m"
+func GenerateRecoveryCodes() ([]string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		for j := range b {
+			b[j] = recoveryAlphabet[b[j]&31]
+		}
+		codes[i] = string(b[:5]) + "-" + string(b[5:])
+	}
+	return codes, nil
+}
+
+// HashRecoveryCode returns the form a recovery code is stored in. Case,
+// spaces and dashes don't matter when a code is entered.
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	return HashRefreshToken(code)
+}
+
+// ReplaceRecoveryCodes generates new recovery codes for a user and stores
+// their hashes, invalidating the old ones. The codes are only ever returned
+// here.
+func ReplaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID int) ([]string, error) {
+	codes, err := GenerateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, $2)
+		`, userID, HashRecoveryCode(code)); err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// VerifySecondFactor accepts a current TOTP code or an unused recovery code
+// of a user with two-factor authentication enabled. Each code works once.
+func VerifySecondFactor(ctx context.Context, db *sql.DB, userID int, code string) error {
+	code = strings.TrimSpace(code)
+	if len(strings.ReplaceAll(code, " ", "")) == totpDigits {
+		return verifyTOTP(ctx, db, userID, code)
+	}
+
+	result, err := db.ExecContext(ctx, `
+		UPDATE mfa_recovery_codes SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`, userID, HashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+func verifyTOTP(ctx context.Context, db *sql.DB, userID int, code string) error {
+	var secret string
+	err := db.QueryRowContext(ctx, `
+		SELECT totp_secret FROM users WHERE id = $1 AND totp_enabled = TRUE
+	`, userID).Scan(&secret)
+	if err == sql.ErrNoRows {
+		return ErrInvalidMFACode
+	} else if err != nil {
+		return err
+	}
+
+	step, ok := ValidateTOTP(secret, code, time.Now())
+	if !ok {
+		return ErrInvalidMFACode
+	}
+
+	// Record the step, so that a code seen over someone's shoulder can't be
+	// used again
+	result, err := db.ExecContext(ctx, `
+		UPDATE users SET totp_last_step = $2
+		WHERE id = $1 AND (totp_last_step IS NULL OR totp_last_step < $2)
+	`, userID, step)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+// CreateMFAChallenge starts the second step of a login whose password was
+// accepted. The returned token is exchanged, together with a code, for the
+// login's tokens.
+func CreateMFAChallenge(ctx context.Context, db *sql.DB, userID int) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	// Expired challenges are cleared out as the user logs in again
+	if _, err := db.ExecContext(ctx, `
+		DELETE FROM mfa_challenges WHERE user_id = $1 AND expires_at <= CURRENT_TIMESTAMP
+	`, userID); err != nil {
+		return "", err
+	}
+
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO mfa_challenges (user_id, token_hash, expires_at)
+		VALUES ($1, $2, CURRENT_TIMESTAMP + make_interval(secs => $3))
+	`, userID, HashRefreshToken(token), MFAChallengeTTL.Seconds())
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// MFAChallengeUser returns the user a login challenge belongs to, without
+// counting an attempt against it
+func MFAChallengeUser(ctx context.Context, db *sql.DB, token string) (int, error) {
+	var userID int
+	err := db.QueryRowContext(ctx, `
+		SELECT user_id FROM mfa_challenges
+		WHERE token_hash = $1 AND attempts < $2 AND expires_at > CURRENT_TIMESTAMP
+	`, HashRefreshToken(token), maxMFAAttempts).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrInvalidMFAChallenge
+	}
+	return userID, err
+}
+
+// CompleteMFAChallenge checks a code against a login challenge and returns
+// the user it belongs to. A challenge can be tried a few times and is used
+// up once a code is accepted.
+func CompleteMFAChallenge(ctx context.Context, db *sql.DB, token, code string) (int, error) {
+	// Count the attempt before checking the code, so that parallel guesses
+	// can't get around the limit
+	var userID int
+	err := db.QueryRowContext(ctx, `
+		UPDATE mfa_challenges SET attempts = attempts + 1
+		WHERE token_hash = $1 AND attempts < $2 AND expires_at > CURRENT_TIMESTAMP
+		RETURNING user_id
+	`, HashRefreshToken(token), maxMFAAttempts).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrInvalidMFAChallenge
+	} else if err != nil {
+		return 0, err
+	}
+
+	if err := VerifySecondFactor(ctx, db, userID, code); err != nil {
+		return 0, err
+	}
+
+	if _, err := db.ExecContext(ctx, `DELETE FROM mfa_challenges WHERE token_hash = $1`, HashRefreshToken(token)); err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes()
+	if err != nil {
+		t.Fatalf("GenerateRecoveryCodes failed: %v", err)
+	}
+	if len(codes) != RecoveryCodeCount {
+		t.Fatalf("Expected %d codes, got %d", RecoveryCodeCount, len(codes))
+	}
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' || seen[code] {
+			t.Errorf("Unexpected or repeated code %q", code)
+		}
+		seen[code] = true
+	}
+
+	// Codes may be typed in any case, with or without the dash
+	if HashRecoveryCode(codes[0]) != HashRecoveryCode(strings.ToUpper(strings.Replace(codes[0], "-", " ", 1))) {
+		t.Error("Expected the hash to ignore case and separators")
+	}
+}
+
+func TestCompleteMFAChallenge_RecoveryCode(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("UPDATE mfa_challenges SET attempts = attempts \\+ 1").
+		WithArgs(HashRefreshToken("challenge"), maxMFAAttempts).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(7))
+	mock.ExpectExec("UPDATE mfa_recovery_codes SET used_at = CURRENT_TIMESTAMP").
+		WithArgs(7, HashRecoveryCode("abcde-fghjk")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM mfa_challenges").
+		WithArgs(HashRefreshToken("challenge")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	userID, err := CompleteMFAChallenge(context.Background(), db, "challenge", "abcde-fghjk")
+	if err != nil || userID != 7 {
+		t.Errorf("Expected user 7, got %d (%v)", userID, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestCompleteMFAChallenge_UsedRecoveryCode(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("UPDATE mfa_challenges SET attempts = attempts \\+ 1").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(7))
+	mock.ExpectExec("UPDATE mfa_recovery_codes SET used_at = CURRENT_TIMESTAMP").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	_, err = CompleteMFAChallenge(context.Background(), db, "challenge", "abcde-fghjk")
+	if !errors.Is(err, ErrInvalidMFACode) {
+		t.Errorf("Expected ErrInvalidMFACode, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestCompleteMFAChallenge_Exhausted(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	// Expired, unknown or out of attempts
+	mock.ExpectQuery("UPDATE mfa_challenges SET attempts = attempts \\+ 1").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+
+	_, err = CompleteMFAChallenge(context.Background(), db, "challenge", "123456")
+	if !errors.Is(err, ErrInvalidMFAChallenge) {
+		t.Errorf("Expected ErrInvalidMFAChallenge, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
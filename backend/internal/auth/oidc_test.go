@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockOIDCProvider is a minimal OpenID Connect provider. Its token endpoint
+// accepts code "good-code" with the verifier whose challenge it was told to
+// expect.
+type mockOIDCProvider struct {
+	*httptest.Server
+	key       ed25519.PrivateKey
+	kid       string
+	challenge string
+	claims    jwt.MapClaims
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	t.Helper()
+
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	jwk, err := NewJWK(pub)
+	if err != nil {
+		t.Fatalf("NewJWK failed: %v", err)
+	}
+
+	m := &mockOIDCProvider{key: key, kid: jwk.Kid}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.URL,
+			"authorization_endpoint": m.URL + "/authorize",
+			"token_endpoint":         m.URL + "/token",
+			"jwks_uri":               m.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(JWKS{Keys: []JWK{jwk}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("code") != "good-code" || PKCEChallenge(r.Form.Get("code_verifier")) != m.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": m.sign(t, m.claims)})
+	})
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+func (m *mockOIDCProvider) sign(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	token.Header["kid"] = m.kid
+	signed, err := token.SignedString(m.key)
+	if err != nil {
+		t.Fatalf("Failed to sign ID token: %v", err)
+	}
+	return signed
+}
+
+func (m *mockOIDCProvider) idClaims(nonce string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":            m.URL,
+		"sub":            "user-123",
+		"aud":            "client",
+		"exp":            now.Add(time.Hour).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          nonce,
+		"email":          "alice@example.com",
+		"email_verified": true,
+	}
+}
+
+func newTestOIDCProvider(t *testing.T, m *mockOIDCProvider) *OIDCProvider {
+	t.Helper()
+	p, err := NewOIDCProvider(OIDCConfig{
+		Name:        "mock",
+		Issuer:      m.URL,
+		ClientID:    "client",
+		RedirectURL: "http://localhost:3000/auth/oidc/mock/callback",
+	})
+	if err != nil {
+		t.Fatalf("NewOIDCProvider failed: %v", err)
+	}
+	return p
+}
+
+func TestOIDCProvider_CodeFlow(t *testing.T) {
+	m := newMockOIDCProvider(t)
+	p := newTestOIDCProvider(t, m)
+
+	authURL, err := p.AuthorizationURL(context.Background(), "state", "nonce", "verifier")
+	if err != nil {
+		t.Fatalf("AuthorizationURL failed: %v", err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil || !strings.HasPrefix(authURL, m.URL+"/authorize?") {
+		t.Fatalf("Unexpected authorization URL %q", authURL)
+	}
+	q := u.Query()
+	if q.Get("state") != "state" || q.Get("nonce") != "nonce" || q.Get("client_id") != "client" ||
+		q.Get("code_challenge_method") != "S256" || q.Get("scope") != "openid email profile" {
+		t.Errorf("Unexpected authorization parameters %v", q)
+	}
+
+	// The provider remembers the challenge and checks the verifier against it
+	m.challenge = q.Get("code_challenge")
+	m.claims = m.idClaims("nonce")
+
+	claims, err := p.Exchange(context.Background(), "good-code", "verifier", "nonce")
+	if err != nil {
+		t.Fatalf("Exchange failed: %v", err)
+	}
+	if claims.Subject != "user-123" || claims.Email != "alice@example.com" || !claims.EmailVerified {
+		t.Errorf("Unexpected claims %+v", claims)
+	}
+
+	if _, err := p.Exchange(context.Background(), "good-code", "other-verifier", "nonce"); err == nil {
+		t.Error("Expected a wrong PKCE verifier to be rejected")
+	}
+}
+
+func TestOIDCProvider_VerifyIDToken(t *testing.T) {
+	m := newMockOIDCProvider(t)
+	p := newTestOIDCProvider(t, m)
+
+	cases := map[string]func(jwt.MapClaims){
+		"wrong nonce":    func(c jwt.MapClaims) { c["nonce"] = "other" },
+		"wrong audience": func(c jwt.MapClaims) { c["aud"] = "someone-else" },
+		"wrong issuer":   func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" },
+		"expired":        func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"no subject":     func(c jwt.MapClaims) { delete(c, "sub") },
+		"other party":    func(c jwt.MapClaims) { c["aud"] = []string{"client", "other"}; c["azp"] = "other" },
+	}
+	for name, mutate := range cases {
+		t.Run(name, func(t *testing.T) {
+			claims := m.idClaims("nonce")
+			mutate(claims)
+			if _, err := p.VerifyIDToken(context.Background(), m.sign(t, claims), "nonce"); !errors.Is(err, ErrInvalidIDToken) {
+				t.Errorf("Expected ErrInvalidIDToken, got %v", err)
+			}
+		})
+	}
+
+	// Tokens signed by anyone else don't verify
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	forged := jwt.NewWithClaims(jwt.SigningMethodEdDSA, m.idClaims("nonce"))
+	forged.Header["kid"] = m.kid
+	raw, _ := forged.SignedString(otherKey)
+	if _, err := p.VerifyIDToken(context.Background(), raw, "nonce"); !errors.Is(err, ErrInvalidIDToken) {
+		t.Errorf("Expected a forged token to be rejected, got %v", err)
+	}
+}
+
+func TestOIDCProvider_IssuerMismatch(t *testing.T) {
+	m := newMockOIDCProvider(t)
+	p, _ := NewOIDCProvider(OIDCConfig{
+		Name:        "mock",
+		Issuer:      m.URL + "/",
+		ClientID:    "client",
+		RedirectURL: "http://localhost:3000/auth/oidc/mock/callback",
+	})
+
+	if _, err := p.AuthorizationURL(context.Background(), "state", "nonce", "verifier"); err == nil {
+		t.Error("Expected discovery to reject a different issuer")
+	}
+}
+
+func TestPKCEChallenge(t *testing.T) {
+	// RFC 7636 appendix B
+	if got := PKCEChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"); got != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Errorf("Unexpected challenge %q", got)
+	}
+}
@@ -1,9 +1,16 @@
 package auth
 
 import (
+	"sync"
+
 	"golang.org/x/crypto/bcrypt"
 )
 
+var (
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+)
+
 // HashPassword hashes a password using bcrypt
 func HashPassword(password string) (string, error) {
 	hashedBytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
@@ -13,7 +20,23 @@ func HashPassword(password string) (string, error) {
 	return string(hashedBytes), nil
 }
 
-// ComparePasswords compares a hashed password with a plain text password
+// ComparePasswords compares a hashed password with a plain text password.
+// Accounts created through an identity provider have no password, and no
+// password matches.
 func ComparePasswords(hashedPassword, password string) error {
+	if hashedPassword == "" {
+		CompareDummyPassword(password)
+		return bcrypt.ErrMismatchedHashAndPassword
+	}
 	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
 }
+
+// CompareDummyPassword takes as long as ComparePasswords, for logins to
+// addresses no account uses. Answering those faster would reveal which
+// addresses have accounts.
+func CompareDummyPassword(password string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+	})
+	bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+}
@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// RefreshTokenTTL is how long a refresh token can be exchanged before the
+// user has to log in again
+const RefreshTokenTTL = 30 * 24 * time.Hour
+
+var (
+	// ErrInvalidRefreshToken is returned for refresh tokens that are unknown,
+	// expired or revoked
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+
+	// ErrRefreshTokenReused is returned when a token that was already
+	// rotated is presented again. Its whole family is revoked, since either
+	// the user or an attacker holds a stolen copy.
+	ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+)
+
+// TokenPair is what a client receives when it logs in or refreshes
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"` // seconds until the access token expires
+}
+
+// HashRefreshToken returns the form a refresh token is stored in. Tokens are
+// random, so a fast hash is enough.
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// IssueTokens logs a user in: it starts a session, whose ID doubles as the
+// refresh token family, and signs an access token for it
+func IssueTokens(ctx context.Context, db *sql.DB, sub TokenSubject, client Client) (TokenPair, error) {
+	sessionID, err := randomToken(16)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	sub.SessionID = sessionID
+
+	refresh, err := randomToken(32)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO sessions (id, user_id, user_agent, device, ip_address, mfa, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP + make_interval(secs => $7))
+	`, sub.SessionID, sub.UserID, truncate(client.UserAgent, 512), DeviceName(client.UserAgent), truncate(client.IP, 45),
+		sub.MFA, RefreshTokenTTL.Seconds()); err != nil {
+		return TokenPair{}, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO refresh_tokens (user_id, token_hash, family_id, expires_at)
+		VALUES ($1, $2, $3, CURRENT_TIMESTAMP + make_interval(secs => $4))
+	`, sub.UserID, HashRefreshToken(refresh), sub.SessionID, RefreshTokenTTL.Seconds()); err != nil {
+		return TokenPair{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return TokenPair{}, err
+	}
+
+	return newTokenPair(sub, refresh)
+}
+
+func newTokenPair(sub TokenSubject, refresh string) (TokenPair, error) {
+	access, err := GenerateToken(sub)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	return TokenPair{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		ExpiresIn:    int(AccessTokenTTL.Seconds()),
+	}, nil
+}
+
+// RotateRefreshToken exchanges a refresh token for a new pair and records
+// the session as used from client. The old token stops working; presenting
+// it again revokes the session.
+func RotateRefreshToken(ctx context.Context, db *sql.DB, token string, client Client) (TokenPair, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT rt.id, rt.user_id, rt.family_id, rt.replaced_by IS NOT NULL,
+		       rt.revoked_at IS NULL AND s.revoked_at IS NULL AND rt.expires_at > CURRENT_TIMESTAMP,
+		       s.mfa, u.username, u.role, u.email_verified_at IS NOT NULL
+		FROM refresh_tokens rt
+		INNER JOIN sessions s ON s.id = rt.family_id
+		INNER JOIN users u ON u.id = rt.user_id
+		WHERE rt.token_hash = $1
+		FOR UPDATE OF rt
+	`
+
+	var id int
+	var sub TokenSubject
+	var rotated, active bool
+	err = tx.QueryRowContext(ctx, query, HashRefreshToken(token)).
+		Scan(&id, &sub.UserID, &sub.SessionID, &rotated, &active, &sub.MFA, &sub.Username, &sub.Role, &sub.EmailVerified)
+	if err == sql.ErrNoRows {
+		return TokenPair{}, ErrInvalidRefreshToken
+	} else if err != nil {
+		return TokenPair{}, err
+	}
+
+	if rotated {
+		if err := revokeSession(ctx, tx, sub.SessionID); err != nil {
+			return TokenPair{}, err
+		}
+		if err := tx.Commit(); err != nil {
+			return TokenPair{}, err
+		}
+		ForgetSession(sub.SessionID)
+		return TokenPair{}, ErrRefreshTokenReused
+	}
+	if !active {
+		return TokenPair{}, ErrInvalidRefreshToken
+	}
+
+	refresh, err := randomToken(32)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	// The new token keeps the session's original expiry so that rotating
+	// can't extend a login forever
+	var newID int
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO refresh_tokens (user_id, token_hash, family_id, expires_at)
+		SELECT user_id, $2, family_id, expires_at FROM refresh_tokens WHERE id = $1
+		RETURNING id
+	`, id, HashRefreshToken(refresh)).Scan(&newID)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP, replaced_by = $2 WHERE id = $1
+	`, id, newID); err != nil {
+		return TokenPair{}, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE sessions SET last_used_at = CURRENT_TIMESTAMP, ip_address = $2 WHERE id = $1
+	`, sub.SessionID, truncate(client.IP, 45)); err != nil {
+		return TokenPair{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return TokenPair{}, err
+	}
+
+	return newTokenPair(sub, refresh)
+}
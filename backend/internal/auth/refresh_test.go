@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var refreshColumns = []string{"id", "user_id", "family_id", "rotated", "active", "mfa", "username", "role", "email_verified"}
+
+func TestRotateRefreshToken(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM refresh_tokens rt (.+) FOR UPDATE OF rt").
+		WithArgs(HashRefreshToken("old-token")).
+		WillReturnRows(sqlmock.NewRows(refreshColumns).AddRow(3, 1, "fam", false, true, true, "alice", "user", true))
+	mock.ExpectQuery("INSERT INTO refresh_tokens (.+) SELECT user_id").
+		WithArgs(3, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	mock.ExpectExec("UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP, replaced_by = \\$2").
+		WithArgs(3, 4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE sessions SET last_used_at = CURRENT_TIMESTAMP, ip_address = \\$2").
+		WithArgs("fam", "203.0.113.7").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	pair, err := RotateRefreshToken(context.Background(), db, "old-token", Client{IP: "203.0.113.7"})
+	if err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	if pair.RefreshToken == "" || pair.RefreshToken == "old-token" {
+		t.Errorf("Expected a new refresh token, got %q", pair.RefreshToken)
+	}
+
+	claims, err := ValidateToken(pair.AccessToken)
+	if err != nil || claims.UserID != 1 || claims.Username != "alice" || claims.SessionID != "fam" || !claims.MFA {
+		t.Errorf("Expected an access token for alice's session, got %+v (%v)", claims, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestRotateRefreshToken_ReuseRevokesSession(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM refresh_tokens rt").
+		WithArgs(HashRefreshToken("rotated-token")).
+		WillReturnRows(sqlmock.NewRows(refreshColumns).AddRow(3, 1, "fam", true, false, false, "alice", "user", true))
+	mock.ExpectExec("UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP WHERE id = \\$1").
+		WithArgs("fam").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP (.+) family_id = \\$1").
+		WithArgs("fam").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	_, err = RotateRefreshToken(context.Background(), db, "rotated-token", Client{})
+	if !errors.Is(err, ErrRefreshTokenReused) {
+		t.Errorf("Expected ErrRefreshTokenReused, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestRotateRefreshToken_Revoked(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	// Logged out, so revoked but never rotated
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM refresh_tokens rt").
+		WillReturnRows(sqlmock.NewRows(refreshColumns).AddRow(3, 1, "fam", false, false, false, "alice", "user", true))
+	mock.ExpectRollback()
+
+	_, err = RotateRefreshToken(context.Background(), db, "logged-out-token", Client{})
+	if !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("Expected ErrInvalidRefreshToken, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
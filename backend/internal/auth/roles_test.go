@@ -0,0 +1,44 @@
+package auth
+
+import "testing"
+
+func TestHasPermission(t *testing.T) {
+	tests := []struct {
+		role string
+		perm Permission
+		want bool
+	}{
+		{RoleViewer, PermInteract, true},
+		{RoleViewer, PermUploadVideos, false},
+		{RoleCreator, PermManageChannels, true},
+		{RoleCreator, PermModerateComments, false},
+		{RoleModerator, PermUploadVideos, true},
+		{RoleModerator, PermModerateComments, true},
+		{RoleModerator, PermManagePlans, false},
+		{RoleAdmin, PermManageUsers, true},
+		{RoleAdmin, PermInteract, true},
+		// Tokens issued before roles were split up
+		{"user", PermUploadVideos, true},
+		{"user", PermModerateComments, false},
+		{"", PermInteract, false},
+		{"superuser", PermInteract, false},
+	}
+	for _, tt := range tests {
+		if got := HasPermission(tt.role, tt.perm); got != tt.want {
+			t.Errorf("HasPermission(%q, %q) = %v, want %v", tt.role, tt.perm, got, tt.want)
+		}
+	}
+}
+
+func TestPermissionRequiresMFA(t *testing.T) {
+	for _, perm := range []Permission{PermInteract, PermUploadVideos, PermManageChannels} {
+		if PermissionRequiresMFA(perm) {
+			t.Errorf("Expected %q not to require two-factor authentication", perm)
+		}
+	}
+	for _, perm := range []Permission{PermModerateComments, PermManageSettings, PermManageUsers} {
+		if !PermissionRequiresMFA(perm) {
+			t.Errorf("Expected %q to require two-factor authentication", perm)
+		}
+	}
+}
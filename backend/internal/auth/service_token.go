@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// ServiceTokenHeader carries the token services present to each other's
+// internal endpoints
+const ServiceTokenHeader = "X-Service-Token"
+
+// serviceToken is shared by all services of a deployment
+var serviceToken string
+
+// UseServiceToken sets the token sent to other services' internal endpoints
+// and required by this one's. Without one, internal endpoints refuse every
+// request.
+func UseServiceToken(token string) {
+	serviceToken = token
+}
+
+// SetServiceToken adds the service token to a request for another service's
+// internal endpoint
+func SetServiceToken(req *http.Request) {
+	if serviceToken != "" {
+		req.Header.Set(ServiceTokenHeader, serviceToken)
+	}
+}
+
+// ValidServiceToken reports whether a request carries the service token
+func ValidServiceToken(r *http.Request) bool {
+	if serviceToken == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get(ServiceTokenHeader)), []byte(serviceToken)) == 1
+}
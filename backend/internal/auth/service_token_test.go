@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestValidServiceToken(t *testing.T) {
+	defer UseServiceToken("")
+
+	req := httptest.NewRequest("GET", "/internal/sessions/abc", nil)
+	req.Header.Set(ServiceTokenHeader, "")
+
+	// Without a configured token, even an empty header doesn't match
+	UseServiceToken("")
+	if ValidServiceToken(req) {
+		t.Error("Expected no request to be accepted without a service token")
+	}
+
+	UseServiceToken("s3cret")
+	if ValidServiceToken(req) {
+		t.Error("Expected a request without the token to be refused")
+	}
+	req.Header.Set(ServiceTokenHeader, "guess")
+	if ValidServiceToken(req) {
+		t.Error("Expected a wrong token to be refused")
+	}
+
+	SetServiceToken(req)
+	if !ValidServiceToken(req) {
+		t.Error("Expected a request with the token to be accepted")
+	}
+}
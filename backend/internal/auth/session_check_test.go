@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type fakeChecker struct {
+	active map[string]bool
+	calls  int
+}
+
+func (f *fakeChecker) SessionActive(ctx context.Context, sessionID string) (bool, error) {
+	f.calls++
+	return f.active[sessionID], nil
+}
+
+func TestSessionCache(t *testing.T) {
+	checker := &fakeChecker{active: map[string]bool{"s1": true}}
+	cache := NewSessionCache(checker, time.Minute)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if active, _ := cache.SessionActive(context.Background(), "s1"); !active {
+			t.Fatal("Expected s1 to be active")
+		}
+	}
+	if checker.calls != 1 {
+		t.Errorf("Expected one lookup while cached, got %d", checker.calls)
+	}
+
+	// Revoked elsewhere: still cached until the TTL passes
+	checker.active["s1"] = false
+	if active, _ := cache.SessionActive(context.Background(), "s1"); !active {
+		t.Error("Expected the cached answer before the TTL passes")
+	}
+	now = now.Add(time.Minute)
+	if active, _ := cache.SessionActive(context.Background(), "s1"); active {
+		t.Error("Expected s1 to be revoked after the TTL")
+	}
+
+	// Revoked here: forgotten at once
+	checker.active["s2"] = true
+	cache.SessionActive(context.Background(), "s2")
+	checker.active["s2"] = false
+	cache.Forget("s2")
+	if active, _ := cache.SessionActive(context.Background(), "s2"); active {
+		t.Error("Expected a forgotten session to be looked up again")
+	}
+}
+
+func TestCheckSession(t *testing.T) {
+	defer UseSessionChecker(nil)
+
+	if err := CheckSession(context.Background(), &Claims{}); err != nil {
+		t.Errorf("Expected every token to pass without a checker, got %v", err)
+	}
+
+	UseSessionChecker(&fakeChecker{active: map[string]bool{"live": true}})
+	tests := []struct {
+		name      string
+		sessionID string
+		want      error
+	}{
+		{"Active session", "live", nil},
+		{"Revoked session", "dead", ErrSessionRevoked},
+		{"Token without a session", "", ErrSessionRevoked},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckSession(context.Background(), &Claims{SessionID: tt.sessionID})
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestRemoteSessionChecker(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/internal/sessions/live":
+			w.Write([]byte(`{"active":true}`))
+		case "/internal/sessions/down":
+			http.Error(w, "Database error", http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	checker := NewRemoteSessionChecker(server.URL + "/internal/sessions")
+	if active, err := checker.SessionActive(context.Background(), "live"); err != nil || !active {
+		t.Errorf("Expected live to be active, got %v (%v)", active, err)
+	}
+	if active, err := checker.SessionActive(context.Background(), "gone"); err != nil || active {
+		t.Errorf("Expected an unknown session to be inactive, got %v (%v)", active, err)
+	}
+	if _, err := checker.SessionActive(context.Background(), "down"); err == nil {
+		t.Error("Expected an error when the user service fails")
+	}
+}
@@ -0,0 +1,224 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Client describes where a login comes from
+type Client struct {
+	UserAgent string
+	IP        string
+}
+
+// Session is one login of a user, on one device. It lasts until it is
+// revoked or its refresh tokens expire.
+type Session struct {
+	ID         string    `json:"id"`
+	Device     string    `json:"device"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
+
+// DeviceName gives a short description of a user agent, such as "Firefox on
+// Windows", for people to recognise their sessions by
+func DeviceName(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+
+	browser := "Unknown browser"
+	for _, b := range []struct{ token, name string }{
+		// Order matters: Edge and Opera also claim to be Chrome, and
+		// Chrome claims to be Safari
+		{"edg/", "Edge"},
+		{"opr/", "Opera"},
+		{"firefox/", "Firefox"},
+		{"chrome/", "Chrome"},
+		{"crios/", "Chrome"},
+		{"safari/", "Safari"},
+		{"curl/", "curl"},
+		{"go-http-client", "Go client"},
+	} {
+		if strings.Contains(ua, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	for _, o := range []struct{ token, name string }{
+		{"android", "Android"},
+		{"iphone", "iOS"},
+		{"ipad", "iOS"},
+		{"windows", "Windows"},
+		{"mac os x", "macOS"},
+		{"cros", "ChromeOS"},
+		{"linux", "Linux"},
+	} {
+		if strings.Contains(ua, o.token) {
+			return browser + " on " + o.name
+		}
+	}
+	return browser
+}
+
+// revokeSession revokes a session and all of its refresh tokens
+func revokeSession(ctx context.Context, db execer, sessionID string) error {
+	if _, err := db.ExecContext(ctx, `
+		UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND revoked_at IS NULL
+	`, sessionID); err != nil {
+		return err
+	}
+	return revokeSessionTokens(ctx, db, sessionID)
+}
+
+func revokeSessionTokens(ctx context.Context, db execer, sessionID string) error {
+	_, err := db.ExecContext(ctx, `
+		UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP
+		WHERE family_id = $1 AND revoked_at IS NULL
+	`, sessionID)
+	return err
+}
+
+// RevokeRefreshToken logs out the session a refresh token belongs to.
+// Unknown tokens are ignored.
+func RevokeRefreshToken(ctx context.Context, db *sql.DB, token string) error {
+	var sessionID string
+	err := db.QueryRowContext(ctx, `SELECT family_id FROM refresh_tokens WHERE token_hash = $1`, HashRefreshToken(token)).
+		Scan(&sessionID)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if err := revokeSession(ctx, db, sessionID); err != nil {
+		return err
+	}
+	ForgetSession(sessionID)
+	return nil
+}
+
+// RevokeSession revokes one of a user's sessions. It returns false if the
+// user has no such active session.
+func RevokeSession(ctx context.Context, db *sql.DB, userID int, sessionID string) (bool, error) {
+	result, err := db.ExecContext(ctx, `
+		UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`, sessionID, userID)
+	if err != nil {
+		return false, err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return false, nil
+	}
+
+	if err := revokeSessionTokens(ctx, db, sessionID); err != nil {
+		return false, err
+	}
+	ForgetSession(sessionID)
+	return true, nil
+}
+
+// RevokeAllSessions logs a user out everywhere and returns how many sessions
+// were revoked
+func RevokeAllSessions(ctx context.Context, db *sql.DB, userID int) (int, error) {
+	rows, err := db.QueryContext(ctx, `
+		UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND revoked_at IS NULL
+		RETURNING id
+	`, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if len(ids) > 0 {
+		if _, err := db.ExecContext(ctx, `
+			UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP
+			WHERE family_id = ANY($1) AND revoked_at IS NULL
+		`, pq.Array(ids)); err != nil {
+			return 0, err
+		}
+	}
+	for _, id := range ids {
+		ForgetSession(id)
+	}
+	return len(ids), nil
+}
+
+// ListSessions returns a user's active sessions, most recently used first
+func ListSessions(ctx context.Context, db *sql.DB, userID int) ([]Session, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, device, user_agent, ip_address, created_at, last_used_at, expires_at
+		FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+		ORDER BY last_used_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var s Session
+		if err := rows.Scan(&s.ID, &s.Device, &s.UserAgent, &s.IPAddress, &s.CreatedAt, &s.LastUsedAt, &s.ExpiresAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// SQLSessionChecker looks sessions up in the sessions table
+type SQLSessionChecker struct {
+	db *sql.DB
+}
+
+// NewSQLSessionChecker creates a checker backed by db
+func NewSQLSessionChecker(db *sql.DB) *SQLSessionChecker {
+	return &SQLSessionChecker{db: db}
+}
+
+// SessionActive implements SessionChecker
+func (c *SQLSessionChecker) SessionActive(ctx context.Context, sessionID string) (bool, error) {
+	var active bool
+	err := c.db.QueryRowContext(ctx, `
+		SELECT revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP FROM sessions WHERE id = $1
+	`, sessionID).Scan(&active)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return active, err
+}
@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDeviceName(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		want      string
+	}{
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.0.0", "Edge on Windows"},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Safari/605.1.15", "Safari on macOS"},
+		{"Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0", "Firefox on Linux"},
+		{"Mozilla/5.0 (Linux; Android 14) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36", "Chrome on Android"},
+		{"curl/8.4.0", "curl"},
+		{"", "Unknown browser"},
+	}
+	for _, tt := range tests {
+		if got := DeviceName(tt.userAgent); got != tt.want {
+			t.Errorf("DeviceName(%q) = %q, want %q", tt.userAgent, got, tt.want)
+		}
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	checker := &fakeChecker{active: map[string]bool{"s1": true}}
+	cache := NewSessionCache(checker, time.Minute)
+	UseSessionChecker(cache)
+	defer UseSessionChecker(nil)
+	cache.SessionActive(context.Background(), "s1")
+
+	mock.ExpectExec("UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP (.+) user_id = \\$2").
+		WithArgs("s1", 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP").
+		WithArgs("s1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	checker.active["s1"] = false
+	revoked, err := RevokeSession(context.Background(), db, 7, "s1")
+	if err != nil || !revoked {
+		t.Fatalf("Expected the session to be revoked, got %v (%v)", revoked, err)
+	}
+	if active, _ := cache.SessionActive(context.Background(), "s1"); active {
+		t.Error("Expected the revoked session to be dropped from the cache")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestRevokeSession_OtherUser(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec("UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP").
+		WithArgs("s1", 8).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	revoked, err := RevokeSession(context.Background(), db, 8, "s1")
+	if err != nil || revoked {
+		t.Errorf("Expected another user's session to be left alone, got %v (%v)", revoked, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateTOTP_RFC6238(t *testing.T) {
+	// SHA-1 test vectors from RFC 6238, appendix B, cut to six digits
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		step, ok := ValidateTOTP(secret, tt.code, time.Unix(tt.unix, 0))
+		if !ok || step != tt.unix/totpPeriod {
+			t.Errorf("Expected %s to be valid at %d, got step %d (%v)", tt.code, tt.unix, step, ok)
+		}
+	}
+}
+
+func TestValidateTOTP_Window(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("GenerateTOTPSecret failed: %v", err)
+	}
+	key, _ := totpEncoding.DecodeString(secret)
+	now := time.Unix(1700000000, 0)
+	step := now.Unix() / totpPeriod
+
+	if _, ok := ValidateTOTP(secret, totpCode(key, step-1), now); !ok {
+		t.Error("Expected the previous code to be accepted")
+	}
+	if _, ok := ValidateTOTP(secret, totpCode(key, step-2), now); ok {
+		t.Error("Expected a code from a minute ago to be rejected")
+	}
+	if _, ok := ValidateTOTP(secret, "12345", now); ok {
+		t.Error("Expected a short code to be rejected")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("alice@example.com", "JBSWY3DPEHPK3PXP")
+	if !strings.HasPrefix(uri, "otpauth://totp/YouTube%20Clone:alice@example.com?") ||
+		!strings.Contains(uri, "secret=JBSWY3DPEHPK3PXP") || !strings.Contains(uri, "issuer=YouTube+Clone") {
+		t.Errorf("Unexpected URI %s", uri)
+	}
+}
@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aung-arata/youtube-clone/backend/internal/auth"
+	"github.com/aung-arata/youtube-clone/backend/internal/middleware"
+)
+
+// accountEmailTimeout bounds creating a token and sending it by email
+const accountEmailTimeout = 30 * time.Second
+
+// VerifyEmailRequest carries the token from a verification link
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+// ForgotPasswordRequest represents the request body to ask for a reset link
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+// ResetPasswordRequest carries the token from a reset link and the new
+// password
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// sendVerificationEmail emails a user a link to verify their address. It
+// runs after the response is written, so failures are only logged.
+func (h *AuthHandler) sendVerificationEmail(userID int, username, email string) {
+	ctx, cancel := context.WithTimeout(context.Background(), accountEmailTimeout)
+	defer cancel()
+
+	token, err := auth.CreateAccountToken(ctx, h.db, userID, auth.PurposeVerifyEmail, email, auth.EmailVerificationTTL)
+	if err != nil {
+		log.Printf("Failed to create email verification token for user %d: %v", userID, err)
+		return
+	}
+	if err := h.mailer.SendEmailVerification(ctx, username, email, token, auth.EmailVerificationTTL); err != nil {
+		log.Printf("Failed to send verification email to user %d: %v", userID, err)
+	}
+}
+
+// VerifyEmail marks the address a verification link was sent to as verified.
+// The user's next token refresh carries the change.
+func (h *AuthHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req VerifyEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Token == "" {
+		http.Error(w, "Token is required", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	userID, email, err := auth.ConsumeAccountToken(r.Context(), tx, req.Token, auth.PurposeVerifyEmail)
+	if errors.Is(err, auth.ErrInvalidAccountToken) {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// The link only verifies the address it was sent to
+	result, err := tx.Exec(`
+		UPDATE users SET email_verified_at = COALESCE(email_verified_at, CURRENT_TIMESTAMP)
+		WHERE id = $1 AND email = $2
+	`, userID, email)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Email verified"})
+}
+
+// ResendVerificationEmail sends the authenticated user a new verification
+// link
+func (h *AuthHandler) ResendVerificationEmail(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if h.mailer == nil {
+		http.Error(w, "Email is not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	var username, email string
+	var verified bool
+	err := h.db.QueryRow(`
+		SELECT username, email, email_verified_at IS NOT NULL FROM users WHERE id = $1
+	`, userID).Scan(&username, &email, &verified)
+	if err == sql.ErrNoRows {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if verified {
+		http.Error(w, "Email is already verified", http.StatusConflict)
+		return
+	}
+
+	token, err := auth.CreateAccountToken(r.Context(), h.db, userID, auth.PurposeVerifyEmail, email, auth.EmailVerificationTTL)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if err := h.mailer.SendEmailVerification(r.Context(), username, email, token, auth.EmailVerificationTTL); err != nil {
+		log.Printf("Failed to send verification email to user %d: %v", userID, err)
+		http.Error(w, "Error sending email", http.StatusBadGateway)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ForgotPassword emails a password reset link if an account uses the given
+// address. The response is the same either way, so that it doesn't reveal
+// who has an account.
+func (h *AuthHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Email) == "" {
+		http.Error(w, "Email is required", http.StatusBadRequest)
+		return
+	}
+
+	// Looking the user up and sending happen in the background, so that the
+	// response takes as long for unknown addresses
+	go h.sendPasswordReset(req.Email)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "If an account uses that email, a reset link has been sent",
+	})
+}
+
+func (h *AuthHandler) sendPasswordReset(email string) {
+	ctx, cancel := context.WithTimeout(context.Background(), accountEmailTimeout)
+	defer cancel()
+
+	var userID int
+	var username string
+	err := h.db.QueryRowContext(ctx, `SELECT id, username FROM users WHERE email = $1`, email).Scan(&userID, &username)
+	if err == sql.ErrNoRows {
+		return
+	} else if err != nil {
+		log.Printf("Failed to look up user for password reset: %v", err)
+		return
+	}
+	if h.mailer == nil {
+		log.Printf("Email is not configured; cannot send a password reset link to user %d", userID)
+		return
+	}
+
+	token, err := auth.CreateAccountToken(ctx, h.db, userID, auth.PurposePasswordReset, email, auth.PasswordResetTTL)
+	if err != nil {
+		log.Printf("Failed to create password reset token for user %d: %v", userID, err)
+		return
+	}
+	if err := h.mailer.SendPasswordReset(ctx, username, email, token, auth.PasswordResetTTL); err != nil {
+		log.Printf("Failed to send password reset email to user %d: %v", userID, err)
+	}
+}
+
+// ResetPassword sets a new password with the token from a reset link, logs
+// the user out everywhere and revokes their API keys
+func (h *AuthHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Token == "" {
+		http.Error(w, "Token is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Password) < 6 {
+		http.Error(w, "Password must be at least 6 characters", http.StatusBadRequest)
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(req.Password)
+	if err != nil {
+		http.Error(w, "Error processing password", http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := h.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	userID, email, err := auth.ConsumeAccountToken(r.Context(), tx, req.Token, auth.PurposePasswordReset)
+	if errors.Is(err, auth.ErrInvalidAccountToken) {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// Receiving the link also proves the address is the user's
+	result, err := tx.Exec(`
+		UPDATE users
+		SET password = $3, email_verified_at = COALESCE(email_verified_at, CURRENT_TIMESTAMP),
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND email = $2
+	`, userID, email, hashedPassword)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	// API keys outlive sessions, so whoever held the account keeps none
+	if _, err := auth.RevokeAllAPIKeys(r.Context(), tx, userID); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// Whoever knew the old password is logged out
+	if _, err := auth.RevokeAllSessions(r.Context(), h.db, userID); err != nil {
+		log.Printf("Failed to revoke sessions of user %d after a password reset: %v", userID, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Password has been reset, please log in again"})
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestResetPassword(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewAuthHandler(db, nil)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE account_tokens SET used_at").
+		WithArgs(sqlmock.AnyArg(), "password_reset").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "email"}).AddRow(7, "alice@example.com"))
+	mock.ExpectExec("UPDATE users").
+		WithArgs(7, "alice@example.com", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// Keys made by whoever knew the old password stop working too
+	mock.ExpectExec("UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP").
+		WithArgs(7).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+	mock.ExpectQuery("UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP").
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	req := httptest.NewRequest("POST", "/api/auth/reset-password", bytes.NewBufferString(`{"token":"abc","password":"newpassword123"}`))
+	w := httptest.NewRecorder()
+
+	handler.ResetPassword(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/aung-arata/youtube-clone/backend/internal/auth"
+	"github.com/aung-arata/youtube-clone/backend/internal/middleware"
+	"github.com/gorilla/mux"
+)
+
+type fakeAPIKeyVerifier map[string]*auth.APIKeyPrincipal
+
+func (f fakeAPIKeyVerifier) VerifyAPIKey(ctx context.Context, key string) (*auth.APIKeyPrincipal, error) {
+	if p, ok := f[key]; ok {
+		return p, nil
+	}
+	return nil, auth.ErrInvalidAPIKey
+}
+
+func TestCreateAPIKey_Validation(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewAPIKeyHandler(db)
+
+	bodies := map[string]string{
+		"no name":        `{"scopes":["videos:write"]}`,
+		"no scopes":      `{"name":"ci"}`,
+		"unknown scope":  `{"name":"ci","scopes":["admin"]}`,
+		"too long":       `{"name":"ci","scopes":["videos:write"],"expires_in_days":400}`,
+		"negative":       `{"name":"ci","scopes":["videos:write"],"expires_in_days":-1}`,
+		"malformed body": `{"name":`,
+	}
+	for name, body := range bodies {
+		t.Run(name, func(t *testing.T) {
+			req := withUser(httptest.NewRequest("POST", "/api/auth/api-keys", bytes.NewBufferString(body)), 7)
+			w := httptest.NewRecorder()
+
+			handler.CreateAPIKey(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Expected status 400, got %d", w.Code)
+			}
+		})
+	}
+}
+
+func TestAPIKeyRouteScopes(t *testing.T) {
+	auth.UseAPIKeyVerifier(fakeAPIKeyVerifier{
+		"yt_profile": {UserID: 7, Username: "alice", Role: "user", Scopes: []string{auth.ScopeProfileRead}},
+		"yt_videos":  {UserID: 7, Username: "alice", Role: "user", Scopes: []string{auth.ScopeVideosWrite}},
+	})
+	defer auth.UseAPIKeyVerifier(nil)
+
+	whoami := func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := r.Context().Value(middleware.UserIDKey).(int)
+		w.Write([]byte(strconv.Itoa(userID)))
+	}
+	r := mux.NewRouter()
+	protected := r.PathPrefix("/auth").Subrouter()
+	protected.Use(middleware.AuthMiddleware)
+	protected.Handle("/me", middleware.RequireScope(auth.ScopeProfileRead, http.HandlerFunc(whoami))).Methods("GET")
+	protected.HandleFunc("/sessions", whoami).Methods("GET")
+
+	tests := []struct {
+		name   string
+		path   string
+		key    string
+		status int
+	}{
+		{"key with the scope", "/auth/me", "yt_profile", http.StatusOK},
+		{"key without the scope", "/auth/me", "yt_videos", http.StatusForbidden},
+		{"route without a scope", "/auth/sessions", "yt_profile", http.StatusForbidden},
+		{"unknown key", "/auth/me", "yt_unknown", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			req.Header.Set("Authorization", "Bearer "+tt.key)
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, w.Code)
+			}
+			if tt.status == http.StatusOK && w.Body.String() != "7" {
+				t.Errorf("Expected the key's user, got %q", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRevokeAPIKey_NotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewAPIKeyHandler(db)
+
+	// Another user's key looks the same as a missing one
+	mock.ExpectExec("UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP").
+		WithArgs(3, 7).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	req := withUser(httptest.NewRequest("DELETE", "/api/auth/api-keys/3", nil), 7)
+	req = mux.SetURLVars(req, map[string]string{"id": "3"})
+	w := httptest.NewRecorder()
+
+	handler.RevokeAPIKey(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,583 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/aung-arata/youtube-clone/backend/internal/middleware"
+	"github.com/aung-arata/youtube-clone/backend/internal/models"
+	"github.com/gorilla/mux"
+)
+
+var (
+	errChannelNotFound      = errors.New("channel not found")
+	errNoChannelAccess      = errors.New("not on the channel's team")
+	errChannelRoleForbidden = errors.New("channel role doesn't allow this")
+	channelHandlePattern    = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{2,29}$`)
+)
+
+const channelColumns = `id, user_id, handle, display_name, avatar, banner, description, links, subscriber_count, created_at, updated_at`
+
+type ChannelHandler struct {
+	db *sql.DB
+}
+
+func NewChannelHandler(db *sql.DB) *ChannelHandler {
+	return &ChannelHandler{db: db}
+}
+
+// ChannelRequest is the body accepted when creating or updating a channel
+type ChannelRequest struct {
+	Handle      string               `json:"handle"`
+	DisplayName string               `json:"display_name"`
+	Avatar      string               `json:"avatar"`
+	Banner      string               `json:"banner"`
+	Description string               `json:"description"`
+	Links       []models.ChannelLink `json:"links"`
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanChannel(row rowScanner) (models.Channel, error) {
+	var c models.Channel
+	var links []byte
+	err := row.Scan(&c.ID, &c.UserID, &c.Handle, &c.DisplayName, &c.Avatar, &c.Banner,
+		&c.Description, &links, &c.SubscriberCount, &c.CreatedAt, &c.UpdatedAt)
+	if err != nil {
+		return c, err
+	}
+
+	c.Links = []models.ChannelLink{}
+	if len(links) > 0 {
+		if err := json.Unmarshal(links, &c.Links); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+// loadOwnedChannel fetches a channel and verifies that userID owns it
+func loadOwnedChannel(db *sql.DB, channelID, userID int) (models.Channel, error) {
+	query := `SELECT ` + channelColumns + ` FROM channels WHERE id = $1`
+	c, err := scanChannel(db.QueryRow(query, channelID))
+	if err == sql.ErrNoRows {
+		return c, errChannelNotFound
+	} else if err != nil {
+		return c, err
+	}
+
+	if c.UserID == nil || *c.UserID != userID {
+		return c, errNoChannelAccess
+	}
+	return c, nil
+}
+
+// loadChannelRole fetches a channel and userID's role on its team, which is
+// empty if they aren't on it
+func loadChannelRole(db *sql.DB, channelID, userID int) (models.Channel, string, error) {
+	c, err := loadOwnedChannel(db, channelID, userID)
+	if err == nil {
+		return c, models.ChannelRoleOwner, nil
+	} else if err != errNoChannelAccess {
+		return c, "", err
+	}
+
+	role, err := channelMemberRole(db, channelID, userID)
+	return c, role, err
+}
+
+// loadChannelFor fetches a channel and verifies that userID's role on its
+// team allows action
+func loadChannelFor(db *sql.DB, channelID, userID int, action channelAction) (models.Channel, error) {
+	c, role, err := loadChannelRole(db, channelID, userID)
+	if err != nil {
+		return c, err
+	}
+	if role == "" {
+		return c, errNoChannelAccess
+	}
+	if !channelRoleAllows(role, action) {
+		return c, errChannelRoleForbidden
+	}
+	return c, nil
+}
+
+// writeChannelAccessError maps the errors of loadOwnedChannel, loadChannelFor
+// and checkVideoChannelAccess to HTTP responses
+func writeChannelAccessError(w http.ResponseWriter, err error) {
+	switch err {
+	case errChannelNotFound:
+		http.Error(w, "Channel not found", http.StatusNotFound)
+	case errVideoNotFound:
+		http.Error(w, "Video not found", http.StatusNotFound)
+	case errNoChannelAccess:
+		http.Error(w, "You do not have access to this channel", http.StatusForbidden)
+	case errChannelRoleForbidden:
+		http.Error(w, "Your role on this channel doesn't allow this", http.StatusForbidden)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (req *ChannelRequest) validate() error {
+	req.Handle = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(req.Handle, "@")))
+	req.DisplayName = strings.TrimSpace(req.DisplayName)
+
+	if !channelHandlePattern.MatchString(req.Handle) {
+		return errors.New("Handle must be 3-30 characters of letters, digits, '.', '_' or '-'")
+	}
+	if req.DisplayName == "" {
+		return errors.New("Display name is required")
+	}
+	if len(req.DisplayName) > 100 {
+		return errors.New("Display name must be at most 100 characters")
+	}
+	for _, link := range req.Links {
+		if !strings.HasPrefix(link.URL, "http://") && !strings.HasPrefix(link.URL, "https://") {
+			return errors.New("Links must be http or https URLs")
+		}
+	}
+	if req.Links == nil {
+		req.Links = []models.ChannelLink{}
+	}
+	return nil
+}
+
+// CreateChannel creates a channel owned by the authenticated user
+func (h *ChannelHandler) CreateChannel(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req ChannelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	links, err := json.Marshal(req.Links)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	query := `
+		INSERT INTO channels (user_id, handle, display_name, avatar, banner, description, links)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING ` + channelColumns
+
+	channel, err := scanChannel(h.db.QueryRow(query, userID, req.Handle, req.DisplayName,
+		req.Avatar, req.Banner, req.Description, links))
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") || strings.Contains(err.Error(), "unique constraint") {
+			http.Error(w, "Handle is already taken", http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(channel)
+}
+
+// GetChannel returns a channel by ID
+func (h *ChannelHandler) GetChannel(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+
+	query := `SELECT ` + channelColumns + ` FROM channels WHERE id = $1`
+	channel, err := scanChannel(h.db.QueryRow(query, id))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(channel)
+}
+
+// GetChannelByHandle returns a channel by its unique handle
+func (h *ChannelHandler) GetChannelByHandle(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	handle := strings.ToLower(strings.TrimPrefix(vars["handle"], "@"))
+
+	query := `SELECT ` + channelColumns + ` FROM channels WHERE handle = $1`
+	channel, err := scanChannel(h.db.QueryRow(query, handle))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(channel)
+}
+
+// GetUserChannels returns all channels owned by a user
+func (h *ChannelHandler) GetUserChannels(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID, err := strconv.Atoi(vars["userId"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	query := `SELECT ` + channelColumns + ` FROM channels WHERE user_id = $1 ORDER BY created_at ASC`
+	rows, err := h.db.Query(query, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	channels := []models.Channel{}
+	for rows.Next() {
+		channel, err := scanChannel(rows)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		channels = append(channels, channel)
+	}
+
+	if err = rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(channels)
+}
+
+// UpdateChannel updates a channel as its owner or one of its managers. The
+// denormalized channel name and avatar on the channel's videos are kept in
+// sync in the same transaction.
+func (h *ChannelHandler) UpdateChannel(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+
+	var req ChannelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := loadChannelFor(h.db, id, userID, channelEditProfile); err != nil {
+		writeChannelAccessError(w, err)
+		return
+	}
+
+	links, err := json.Marshal(req.Links)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE channels
+		SET handle = $1, display_name = $2, avatar = $3, banner = $4, description = $5,
+		    links = $6, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $7
+		RETURNING ` + channelColumns
+
+	channel, err := scanChannel(tx.QueryRow(query, req.Handle, req.DisplayName, req.Avatar,
+		req.Banner, req.Description, links, id))
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") || strings.Contains(err.Error(), "unique constraint") {
+			http.Error(w, "Handle is already taken", http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	_, err = tx.Exec(`UPDATE videos SET channel_name = $1, channel_avatar = $2 WHERE channel_id = $3`,
+		channel.DisplayName, channel.Avatar, channel.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(channel)
+}
+
+// DeleteChannel deletes a channel owned by the authenticated user along with
+// its videos and subscriptions
+func (h *ChannelHandler) DeleteChannel(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := loadOwnedChannel(h.db, id, userID); err != nil {
+		writeChannelAccessError(w, err)
+		return
+	}
+
+	if _, err := h.db.Exec(`DELETE FROM channels WHERE id = $1`, id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetBlockedWords returns the words that hold comments on the channel's
+// videos for review. Only team members who moderate comments can see the list.
+func (h *ChannelHandler) GetBlockedWords(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := loadChannelFor(h.db, id, userID, channelModerateComments); err != nil {
+		writeChannelAccessError(w, err)
+		return
+	}
+
+	var raw []byte
+	if err := h.db.QueryRow(`SELECT blocked_words FROM channels WHERE id = $1`, id).Scan(&raw); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	words, err := decodeBlockedWords(raw)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{"words": words})
+}
+
+// UpdateBlockedWords replaces the channel's blocked-words list. It applies to
+// new and edited comments; existing comments are not re-checked.
+func (h *ChannelHandler) UpdateBlockedWords(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Words []string `json:"words"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	words, err := normalizeBlockedWords(req.Words)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := loadChannelFor(h.db, id, userID, channelModerateComments); err != nil {
+		writeChannelAccessError(w, err)
+		return
+	}
+
+	raw, err := json.Marshal(words)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := h.db.Exec(`UPDATE channels SET blocked_words = $1 WHERE id = $2`, raw, id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{"words": words})
+}
+
+// GetChannelPage returns the public channel page: the channel itself, a page
+// of its uploads (newest first, keyset paginated) and its owner's playlists
+func (h *ChannelHandler) GetChannelPage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+
+	limit := parseLimit(r.URL.Query().Get("limit"), 30, 100)
+
+	query := `SELECT ` + channelColumns + ` FROM channels WHERE id = $1`
+	channel, err := scanChannel(h.db.QueryRow(query, id))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	page := models.ChannelPage{
+		Channel:   channel,
+		Videos:    []models.Video{},
+		Playlists: []models.Playlist{},
+	}
+
+	videoQuery := `
+		SELECT id, title, description, url, thumbnail, channel_id, channel_name, channel_avatar,
+		       views, likes, dislikes, category, duration, uploaded_at, created_at, updated_at
+		FROM videos
+		WHERE channel_id = $1
+	`
+	args := []interface{}{id}
+
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		uploadedAt, videoID, err := decodeCursor(cursor)
+		if err != nil {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		videoQuery += " AND (uploaded_at, id) < ($2, $3)"
+		args = append(args, uploadedAt, videoID)
+	}
+
+	videoQuery += " ORDER BY uploaded_at DESC, id DESC LIMIT $" + strconv.Itoa(len(args)+1)
+	args = append(args, limit+1)
+
+	rows, err := h.db.Query(videoQuery, args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var v models.Video
+		err := rows.Scan(&v.ID, &v.Title, &v.Description, &v.URL, &v.Thumbnail,
+			&v.ChannelID, &v.ChannelName, &v.ChannelAvatar, &v.Views, &v.Likes, &v.Dislikes,
+			&v.Category, &v.Duration, &v.UploadedAt, &v.CreatedAt, &v.UpdatedAt)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		page.Videos = append(page.Videos, v)
+	}
+
+	if err = rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if len(page.Videos) > limit {
+		page.Videos = page.Videos[:limit]
+		last := page.Videos[limit-1]
+		page.NextCursor = encodeCursor(last.UploadedAt, last.ID)
+	}
+
+	// Legacy channels have no owner and therefore no playlists
+	if channel.UserID != nil {
+		playlistQuery := `
+			SELECT id, user_id, name, description, created_at, updated_at
+			FROM playlists
+			WHERE user_id = $1
+			ORDER BY created_at DESC
+		`
+		playlistRows, err := h.db.Query(playlistQuery, *channel.UserID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer playlistRows.Close()
+
+		for playlistRows.Next() {
+			var p models.Playlist
+			if err := playlistRows.Scan(&p.ID, &p.UserID, &p.Name, &p.Description, &p.CreatedAt, &p.UpdatedAt); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			page.Playlists = append(page.Playlists, p)
+		}
+
+		if err = playlistRows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/aung-arata/youtube-clone/backend/internal/middleware"
+	"github.com/aung-arata/youtube-clone/backend/internal/models"
+	"github.com/gorilla/mux"
+)
+
+func channelRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{
+		"id", "user_id", "handle", "display_name", "avatar", "banner",
+		"description", "links", "subscriber_count", "created_at", "updated_at",
+	})
+}
+
+// withUser simulates AuthMiddleware having authenticated userID
+func withUser(req *http.Request, userID int) *http.Request {
+	ctx := context.WithValue(req.Context(), middleware.UserIDKey, userID)
+	return req.WithContext(ctx)
+}
+
+func TestCreateChannel(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewChannelHandler(db)
+
+	now := time.Now()
+	mock.ExpectQuery("INSERT INTO channels").
+		WithArgs(1, "code-master", "Code Master", "", "", "Tutorials", []byte(`[{"title":"Site","url":"https://example.com"}]`)).
+		WillReturnRows(channelRows().AddRow(1, 1, "code-master", "Code Master", "", "", "Tutorials",
+			[]byte(`[{"title":"Site","url":"https://example.com"}]`), 0, now, now))
+
+	body, _ := json.Marshal(ChannelRequest{
+		Handle:      "@Code-Master",
+		DisplayName: "Code Master",
+		Description: "Tutorials",
+		Links:       []models.ChannelLink{{Title: "Site", URL: "https://example.com"}},
+	})
+	req := httptest.NewRequest("POST", "/api/channels", bytes.NewBuffer(body))
+	req = withUser(req, 1)
+	w := httptest.NewRecorder()
+
+	handler.CreateChannel(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var channel models.Channel
+	json.NewDecoder(w.Body).Decode(&channel)
+
+	if channel.Handle != "code-master" || len(channel.Links) != 1 {
+		t.Errorf("Unexpected channel: %+v", channel)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestCreateChannel_InvalidHandle(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewChannelHandler(db)
+
+	testCases := []ChannelRequest{
+		{Handle: "ab", DisplayName: "Too Short"},
+		{Handle: "has spaces", DisplayName: "Spaces"},
+		{Handle: "valid-handle", DisplayName: ""},
+		{Handle: "valid-handle", DisplayName: "Bad Link", Links: []models.ChannelLink{{Title: "x", URL: "javascript:alert(1)"}}},
+	}
+
+	for _, tc := range testCases {
+		body, _ := json.Marshal(tc)
+		req := httptest.NewRequest("POST", "/api/channels", bytes.NewBuffer(body))
+		req = withUser(req, 1)
+		w := httptest.NewRecorder()
+
+		handler.CreateChannel(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %+v, got %d", tc, w.Code)
+		}
+	}
+}
+
+func TestUpdateChannel_NotOwner(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewChannelHandler(db)
+
+	now := time.Now()
+	mock.ExpectQuery("SELECT (.+) FROM channels WHERE id").
+		WithArgs(3).
+		WillReturnRows(channelRows().AddRow(3, 2, "other", "Other", "", "", "", []byte("[]"), 0, now, now))
+	mock.ExpectQuery("SELECT role FROM channel_members").
+		WithArgs(3, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"role"}))
+
+	body, _ := json.Marshal(ChannelRequest{Handle: "other", DisplayName: "Mine Now"})
+	req := httptest.NewRequest("PUT", "/api/channels/3", bytes.NewBuffer(body))
+	req = mux.SetURLVars(req, map[string]string{"id": "3"})
+	req = withUser(req, 1)
+	w := httptest.NewRecorder()
+
+	handler.UpdateChannel(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestUpdateChannel_SyncsVideos(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewChannelHandler(db)
+
+	now := time.Now()
+	mock.ExpectQuery("SELECT (.+) FROM channels WHERE id").
+		WithArgs(3).
+		WillReturnRows(channelRows().AddRow(3, 1, "mine", "Mine", "", "", "", []byte("[]"), 0, now, now))
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE channels").
+		WillReturnRows(channelRows().AddRow(3, 1, "mine", "Renamed", "https://example.com/a.png", "", "", []byte("[]"), 0, now, now))
+	mock.ExpectExec("UPDATE videos SET channel_name").
+		WithArgs("Renamed", "https://example.com/a.png", 3).
+		WillReturnResult(sqlmock.NewResult(0, 4))
+	mock.ExpectCommit()
+
+	body, _ := json.Marshal(ChannelRequest{Handle: "mine", DisplayName: "Renamed", Avatar: "https://example.com/a.png"})
+	req := httptest.NewRequest("PUT", "/api/channels/3", bytes.NewBuffer(body))
+	req = mux.SetURLVars(req, map[string]string{"id": "3"})
+	req = withUser(req, 1)
+	w := httptest.NewRecorder()
+
+	handler.UpdateChannel(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestGetChannelPage(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewChannelHandler(db)
+
+	now := time.Now()
+	mock.ExpectQuery("SELECT (.+) FROM channels WHERE id").
+		WithArgs(3).
+		WillReturnRows(channelRows().AddRow(3, 1, "mine", "Mine", "", "", "", []byte("[]"), 0, now, now))
+	mock.ExpectQuery("SELECT (.+) FROM videos WHERE channel_id").
+		WithArgs(3, 31).
+		WillReturnRows(sqlmock.NewRows(feedColumns).
+			AddRow(10, "Upload", "", "/v/10.mp4", "", 3, "Mine", "", 0, 0, 0, "Tech", "1:00", now, now, now))
+	mock.ExpectQuery("SELECT (.+) FROM playlists WHERE user_id").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "name", "description", "created_at", "updated_at"}).
+			AddRow(4, 1, "Favourites", "", now, now))
+
+	req := httptest.NewRequest("GET", "/api/channels/3/page", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "3"})
+	w := httptest.NewRecorder()
+
+	handler.GetChannelPage(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var page models.ChannelPage
+	json.NewDecoder(w.Body).Decode(&page)
+
+	if page.Handle != "mine" || len(page.Videos) != 1 || len(page.Playlists) != 1 {
+		t.Errorf("Unexpected page: %+v", page)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,590 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aung-arata/youtube-clone/backend/internal/middleware"
+	"github.com/aung-arata/youtube-clone/backend/internal/models"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// channelInvitationTTL is how long an invitation to a channel's team can be
+// accepted
+const channelInvitationTTL = 7 * 24 * time.Hour
+
+// channelAction is something a channel's team may be allowed to do
+type channelAction int
+
+const (
+	channelViewAnalytics channelAction = iota
+	channelManageVideos
+	channelModerateComments
+	channelEditProfile
+	channelManageTeam
+)
+
+// channelRoleActions lists what each role on a channel's team may do. Only
+// the owner may delete the channel or manage its managers.
+var channelRoleActions = map[string][]channelAction{
+	models.ChannelRoleOwner:           {channelViewAnalytics, channelManageVideos, channelModerateComments, channelEditProfile, channelManageTeam},
+	models.ChannelRoleManager:         {channelViewAnalytics, channelManageVideos, channelModerateComments, channelEditProfile, channelManageTeam},
+	models.ChannelRoleEditor:          {channelViewAnalytics, channelManageVideos, channelModerateComments},
+	models.ChannelRoleViewerAnalytics: {channelViewAnalytics},
+}
+
+// channelRoleAllows reports whether role may perform action
+func channelRoleAllows(role string, action channelAction) bool {
+	for _, a := range channelRoleActions[role] {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// assignableChannelRoles returns the roles a team member with role may
+// invite, change or remove. Managers handle everyone below them.
+func assignableChannelRoles(role string) []string {
+	switch role {
+	case models.ChannelRoleOwner:
+		return []string{models.ChannelRoleManager, models.ChannelRoleEditor, models.ChannelRoleViewerAnalytics}
+	case models.ChannelRoleManager:
+		return []string{models.ChannelRoleEditor, models.ChannelRoleViewerAnalytics}
+	}
+	return nil
+}
+
+// canAssignChannelRole reports whether a team member with role may invite,
+// change or remove members with target role
+func canAssignChannelRole(role, target string) bool {
+	for _, r := range assignableChannelRoles(role) {
+		if r == target {
+			return true
+		}
+	}
+	return false
+}
+
+// channelMemberRole returns userID's role on a channel's team, or "" if they
+// aren't a member. The owner isn't stored as a member.
+func channelMemberRole(db *sql.DB, channelID, userID int) (string, error) {
+	var role string
+	err := db.QueryRow(`SELECT role FROM channel_members WHERE channel_id = $1 AND user_id = $2`, channelID, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return role, err
+}
+
+// checkVideoChannelAccess verifies that userID's role on the team of the
+// video's channel allows action
+func checkVideoChannelAccess(db *sql.DB, videoID, userID int, action channelAction) error {
+	query := `
+		SELECT CASE WHEN ch.user_id = $2 THEN 'owner' ELSE COALESCE(m.role, '') END
+		FROM videos v
+		LEFT JOIN channels ch ON ch.id = v.channel_id
+		LEFT JOIN channel_members m ON m.channel_id = v.channel_id AND m.user_id = $2
+		WHERE v.id = $1
+	`
+
+	var role string
+	err := db.QueryRow(query, videoID, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return errVideoNotFound
+	} else if err != nil {
+		return err
+	}
+
+	if role == "" {
+		return errNoChannelAccess
+	}
+	if !channelRoleAllows(role, action) {
+		return errChannelRoleForbidden
+	}
+	return nil
+}
+
+// ChannelTeamHandler manages the members of channels' teams and the
+// invitations to join them
+type ChannelTeamHandler struct {
+	db       *sql.DB
+	notifier Notifier
+}
+
+// NewChannelTeamHandler creates a ChannelTeamHandler. Without a notifier,
+// invitees aren't told about their invitations.
+func NewChannelTeamHandler(db *sql.DB, notifier Notifier) *ChannelTeamHandler {
+	return &ChannelTeamHandler{db: db, notifier: notifier}
+}
+
+// ListMembers returns a channel's team, owner first. Anyone on the team can
+// see it.
+func (h *ChannelTeamHandler) ListMembers(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	channelID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := loadChannelFor(h.db, channelID, userID, channelViewAnalytics); err != nil {
+		writeChannelAccessError(w, err)
+		return
+	}
+
+	query := `
+		SELECT channel_id, user_id, username, avatar, role, created_at FROM (
+			SELECT ch.id AS channel_id, u.id AS user_id, u.username, COALESCE(u.avatar, '') AS avatar,
+			       'owner' AS role, ch.created_at
+			FROM channels ch INNER JOIN users u ON u.id = ch.user_id
+			WHERE ch.id = $1
+			UNION ALL
+			SELECT m.channel_id, u.id, u.username, COALESCE(u.avatar, ''), m.role, m.created_at
+			FROM channel_members m INNER JOIN users u ON u.id = m.user_id
+			WHERE m.channel_id = $1
+		) team
+		ORDER BY role <> 'owner', created_at ASC
+	`
+
+	rows, err := h.db.Query(query, channelID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	members := []models.ChannelMember{}
+	for rows.Next() {
+		var m models.ChannelMember
+		if err := rows.Scan(&m.ChannelID, &m.UserID, &m.Username, &m.Avatar, &m.Role, &m.CreatedAt); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		members = append(members, m)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(members)
+}
+
+// InviteMember invites a user to join a channel's team. Inviting someone
+// again replaces their pending invitation.
+func (h *ChannelTeamHandler) InviteMember(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	channelID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Username string `json:"username"`
+		Role     string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Username = strings.TrimSpace(strings.TrimPrefix(req.Username, "@"))
+	if req.Username == "" {
+		http.Error(w, "Username is required", http.StatusBadRequest)
+		return
+	}
+	if req.Role != models.ChannelRoleManager && req.Role != models.ChannelRoleEditor && req.Role != models.ChannelRoleViewerAnalytics {
+		http.Error(w, "Role must be manager, editor or viewer-analytics", http.StatusBadRequest)
+		return
+	}
+
+	channel, role, err := loadChannelRole(h.db, channelID, userID)
+	if err != nil {
+		writeChannelAccessError(w, err)
+		return
+	}
+	if !channelRoleAllows(role, channelManageTeam) || !canAssignChannelRole(role, req.Role) {
+		http.Error(w, "Your role on this channel doesn't allow inviting a "+req.Role, http.StatusForbidden)
+		return
+	}
+
+	inv := models.ChannelInvitation{ChannelID: channel.ID, ChannelName: channel.DisplayName, Role: req.Role, InvitedBy: &userID}
+	err = h.db.QueryRow(`SELECT id, username FROM users WHERE LOWER(username) = LOWER($1)`, req.Username).Scan(&inv.UserID, &inv.Username)
+	if err == sql.ErrNoRows {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	memberRole := models.ChannelRoleOwner
+	if channel.UserID == nil || *channel.UserID != inv.UserID {
+		if memberRole, err = channelMemberRole(h.db, channel.ID, inv.UserID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if memberRole != "" {
+		http.Error(w, "User is already on this channel's team", http.StatusConflict)
+		return
+	}
+
+	query := `
+		INSERT INTO channel_invitations (channel_id, user_id, role, invited_by, expires_at)
+		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP + $5 * INTERVAL '1 second')
+		ON CONFLICT (channel_id, user_id) DO UPDATE
+		SET role = EXCLUDED.role, invited_by = EXCLUDED.invited_by,
+		    created_at = CURRENT_TIMESTAMP, expires_at = EXCLUDED.expires_at
+		RETURNING id, created_at, expires_at
+	`
+	err = h.db.QueryRow(query, channel.ID, inv.UserID, inv.Role, userID, channelInvitationTTL.Seconds()).
+		Scan(&inv.ID, &inv.CreatedAt, &inv.ExpiresAt)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The invitation stands even if the invitee isn't told about it. Inviting
+	// someone again replaces the unread notification.
+	if h.notifier != nil {
+		_, err = h.notifier.Dispatch(r.Context(), models.CreateNotificationRequest{
+			UserID:      inv.UserID,
+			Type:        "channel_invitation",
+			Title:       "You were invited to join " + channel.DisplayName,
+			Message:     "Join the team as " + inv.Role,
+			Link:        "/channel-invitations",
+			CollapseKey: "channel_invitation:" + strconv.Itoa(channel.ID),
+		})
+		if err != nil {
+			log.Printf("Failed to notify user %d of invitation %d: %v", inv.UserID, inv.ID, err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(inv)
+}
+
+// ListChannelInvitations returns a channel's pending invitations to team
+// members who manage the team
+func (h *ChannelTeamHandler) ListChannelInvitations(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	channelID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := loadChannelFor(h.db, channelID, userID, channelManageTeam); err != nil {
+		writeChannelAccessError(w, err)
+		return
+	}
+
+	h.writeInvitations(w, `i.channel_id = $1`, channelID)
+}
+
+// CancelInvitation withdraws a pending invitation to a channel's team
+func (h *ChannelTeamHandler) CancelInvitation(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	channelID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+	invitationID, err := strconv.Atoi(vars["invitationId"])
+	if err != nil {
+		http.Error(w, "Invalid invitation ID", http.StatusBadRequest)
+		return
+	}
+
+	_, role, err := loadChannelRole(h.db, channelID, userID)
+	if err != nil {
+		writeChannelAccessError(w, err)
+		return
+	}
+	if !channelRoleAllows(role, channelManageTeam) {
+		writeChannelAccessError(w, errChannelRoleForbidden)
+		return
+	}
+
+	// Managers can't withdraw the owner's invitations to other managers
+	result, err := h.db.Exec(`DELETE FROM channel_invitations WHERE id = $1 AND channel_id = $2 AND role = ANY($3)`,
+		invitationID, channelID, pq.Array(assignableChannelRoles(role)))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Invitation not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetMyInvitations returns the authenticated user's pending invitations
+func (h *ChannelTeamHandler) GetMyInvitations(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	h.writeInvitations(w, `i.user_id = $1`, userID)
+}
+
+// writeInvitations responds with the unexpired invitations matching where,
+// newest first
+func (h *ChannelTeamHandler) writeInvitations(w http.ResponseWriter, where string, arg int) {
+	query := `
+		SELECT i.id, i.channel_id, ch.display_name, i.user_id, u.username, i.role, i.invited_by, i.created_at, i.expires_at
+		FROM channel_invitations i
+		INNER JOIN channels ch ON ch.id = i.channel_id
+		INNER JOIN users u ON u.id = i.user_id
+		WHERE ` + where + ` AND i.expires_at > CURRENT_TIMESTAMP
+		ORDER BY i.created_at DESC
+	`
+
+	rows, err := h.db.Query(query, arg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	invitations := []models.ChannelInvitation{}
+	for rows.Next() {
+		var inv models.ChannelInvitation
+		if err := rows.Scan(&inv.ID, &inv.ChannelID, &inv.ChannelName, &inv.UserID, &inv.Username,
+			&inv.Role, &inv.InvitedBy, &inv.CreatedAt, &inv.ExpiresAt); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		invitations = append(invitations, inv)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invitations)
+}
+
+// AcceptInvitation adds the authenticated user to the team that invited them
+func (h *ChannelTeamHandler) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	invitationID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid invitation ID", http.StatusBadRequest)
+		return
+	}
+
+	// Claiming the invitation and joining happen together, so an invitation
+	// is used at most once
+	query := `
+		WITH accepted AS (
+			DELETE FROM channel_invitations
+			WHERE id = $1 AND user_id = $2 AND expires_at > CURRENT_TIMESTAMP
+			RETURNING channel_id, user_id, role, invited_by
+		)
+		INSERT INTO channel_members (channel_id, user_id, role, added_by)
+		SELECT channel_id, user_id, role, invited_by FROM accepted
+		ON CONFLICT (channel_id, user_id) DO UPDATE SET role = EXCLUDED.role
+		RETURNING channel_id, user_id, role, created_at
+	`
+
+	var m models.ChannelMember
+	err = h.db.QueryRow(query, invitationID, userID).Scan(&m.ChannelID, &m.UserID, &m.Role, &m.CreatedAt)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Invitation not found or expired", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if username, ok := r.Context().Value(middleware.UsernameKey).(string); ok {
+		m.Username = username
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m)
+}
+
+// DeclineInvitation turns down one of the authenticated user's invitations
+func (h *ChannelTeamHandler) DeclineInvitation(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	invitationID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid invitation ID", http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.db.Exec(`DELETE FROM channel_invitations WHERE id = $1 AND user_id = $2`, invitationID, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Invitation not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// teamChange is a requested change to a member of a channel's team
+type teamChange struct {
+	channelID  int
+	memberID   int
+	callerRole string // the authenticated user's role on the team
+	memberRole string
+}
+
+// loadTeamChange checks that the authenticated user may change or remove a
+// member of a channel's team. It writes the error response and returns false
+// otherwise.
+func (h *ChannelTeamHandler) loadTeamChange(w http.ResponseWriter, r *http.Request) (teamChange, bool) {
+	var c teamChange
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return c, false
+	}
+
+	vars := mux.Vars(r)
+	var err error
+	if c.channelID, err = strconv.Atoi(vars["id"]); err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return c, false
+	}
+	if c.memberID, err = strconv.Atoi(vars["userId"]); err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return c, false
+	}
+
+	if _, c.callerRole, err = loadChannelRole(h.db, c.channelID, userID); err != nil {
+		writeChannelAccessError(w, err)
+		return c, false
+	}
+	if c.callerRole == "" {
+		writeChannelAccessError(w, errNoChannelAccess)
+		return c, false
+	}
+
+	if c.memberRole, err = channelMemberRole(h.db, c.channelID, c.memberID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return c, false
+	}
+	if c.memberRole == "" {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return c, false
+	}
+
+	// Anyone may leave a team
+	if c.memberID != userID && (!channelRoleAllows(c.callerRole, channelManageTeam) || !canAssignChannelRole(c.callerRole, c.memberRole)) {
+		writeChannelAccessError(w, errChannelRoleForbidden)
+		return c, false
+	}
+	return c, true
+}
+
+// UpdateMemberRole changes a team member's role
+func (h *ChannelTeamHandler) UpdateMemberRole(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	c, ok := h.loadTeamChange(w, r)
+	if !ok {
+		return
+	}
+
+	// Members can't change their own role, and managers can't make managers
+	if !canAssignChannelRole(c.callerRole, c.memberRole) || !canAssignChannelRole(c.callerRole, req.Role) {
+		http.Error(w, "Your role on this channel doesn't allow making someone a "+req.Role, http.StatusForbidden)
+		return
+	}
+
+	query := `
+		UPDATE channel_members m SET role = $1
+		FROM users u
+		WHERE m.channel_id = $2 AND m.user_id = $3 AND u.id = m.user_id
+		RETURNING m.channel_id, m.user_id, u.username, COALESCE(u.avatar, ''), m.role, m.created_at
+	`
+
+	var m models.ChannelMember
+	err := h.db.QueryRow(query, req.Role, c.channelID, c.memberID).
+		Scan(&m.ChannelID, &m.UserID, &m.Username, &m.Avatar, &m.Role, &m.CreatedAt)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m)
+}
+
+// RemoveMember removes a member from a channel's team. Members may remove
+// themselves to leave it.
+func (h *ChannelTeamHandler) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	c, ok := h.loadTeamChange(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := h.db.Exec(`DELETE FROM channel_members WHERE channel_id = $1 AND user_id = $2`, c.channelID, c.memberID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
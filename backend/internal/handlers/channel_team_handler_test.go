@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+)
+
+func TestInviteMember(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	notifier := &recordingNotifier{}
+	handler := NewChannelTeamHandler(db, notifier)
+
+	now := time.Now()
+	mock.ExpectQuery("SELECT (.+) FROM channels WHERE id").
+		WithArgs(3).
+		WillReturnRows(channelRows().AddRow(3, 7, "mine", "Mine", "", "", "", []byte("[]"), 0, now, now))
+	mock.ExpectQuery("SELECT id, username FROM users WHERE LOWER\\(username\\) = LOWER\\(\\$1\\)").
+		WithArgs("bob").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username"}).AddRow(8, "bob"))
+	mock.ExpectQuery("SELECT role FROM channel_members").
+		WithArgs(3, 8).
+		WillReturnRows(sqlmock.NewRows([]string{"role"}))
+	mock.ExpectQuery("INSERT INTO channel_invitations").
+		WithArgs(3, 8, "editor", 7, channelInvitationTTL.Seconds()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "expires_at"}).AddRow(1, now, now.Add(channelInvitationTTL)))
+
+	req := withUser(httptest.NewRequest("POST", "/api/channels/3/invitations", bytes.NewBufferString(`{"username":"@bob","role":"editor"}`)), 7)
+	req = mux.SetURLVars(req, map[string]string{"id": "3"})
+	w := httptest.NewRecorder()
+
+	handler.InviteMember(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Errorf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(notifier.sent) != 1 {
+		t.Fatalf("Expected 1 notification, got %d", len(notifier.sent))
+	}
+	if n := notifier.sent[0]; n.UserID != 8 || n.Type != "channel_invitation" || n.Title != "You were invited to join Mine" {
+		t.Errorf("Unexpected notification %+v", n)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestInviteMember_Forbidden(t *testing.T) {
+	tests := []struct {
+		name string
+		role string
+		body string
+	}{
+		{"manager inviting a manager", "manager", `{"username":"bob","role":"manager"}`},
+		{"editor inviting an editor", "editor", `{"username":"bob","role":"editor"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("Failed to create mock: %v", err)
+			}
+			defer db.Close()
+
+			handler := NewChannelTeamHandler(db, nil)
+
+			now := time.Now()
+			mock.ExpectQuery("SELECT (.+) FROM channels WHERE id").
+				WithArgs(3).
+				WillReturnRows(channelRows().AddRow(3, 2, "theirs", "Theirs", "", "", "", []byte("[]"), 0, now, now))
+			mock.ExpectQuery("SELECT role FROM channel_members").
+				WithArgs(3, 7).
+				WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow(tt.role))
+
+			req := withUser(httptest.NewRequest("POST", "/api/channels/3/invitations", bytes.NewBufferString(tt.body)), 7)
+			req = mux.SetURLVars(req, map[string]string{"id": "3"})
+			w := httptest.NewRecorder()
+
+			handler.InviteMember(w, req)
+
+			if w.Code != http.StatusForbidden {
+				t.Errorf("Expected status 403, got %d", w.Code)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestAcceptInvitation_Expired(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewChannelTeamHandler(db, nil)
+
+	// Expired, declined and other users' invitations all look missing
+	mock.ExpectQuery("DELETE FROM channel_invitations (.+) INSERT INTO channel_members").
+		WithArgs(4, 8).
+		WillReturnRows(sqlmock.NewRows([]string{"channel_id", "user_id", "role", "created_at"}))
+
+	req := withUser(httptest.NewRequest("POST", "/api/channel-invitations/4/accept", nil), 8)
+	req = mux.SetURLVars(req, map[string]string{"id": "4"})
+	w := httptest.NewRecorder()
+
+	handler.AcceptInvitation(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestRemoveMember_Leave(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewChannelTeamHandler(db, nil)
+
+	now := time.Now()
+	mock.ExpectQuery("SELECT (.+) FROM channels WHERE id").
+		WithArgs(3).
+		WillReturnRows(channelRows().AddRow(3, 2, "theirs", "Theirs", "", "", "", []byte("[]"), 0, now, now))
+	mock.ExpectQuery("SELECT role FROM channel_members").
+		WithArgs(3, 7).
+		WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow("viewer-analytics"))
+	mock.ExpectQuery("SELECT role FROM channel_members").
+		WithArgs(3, 7).
+		WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow("viewer-analytics"))
+	mock.ExpectExec("DELETE FROM channel_members").
+		WithArgs(3, 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	req := withUser(httptest.NewRequest("DELETE", "/api/channels/3/members/7", nil), 7)
+	req = mux.SetURLVars(req, map[string]string{"id": "3", "userId": "7"})
+	w := httptest.NewRecorder()
+
+	handler.RemoveMember(w, req)
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestUpdateMemberRole_OwnRole(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewChannelTeamHandler(db, nil)
+
+	now := time.Now()
+	mock.ExpectQuery("SELECT (.+) FROM channels WHERE id").
+		WithArgs(3).
+		WillReturnRows(channelRows().AddRow(3, 2, "theirs", "Theirs", "", "", "", []byte("[]"), 0, now, now))
+	mock.ExpectQuery("SELECT role FROM channel_members").
+		WithArgs(3, 7).
+		WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow("editor"))
+	mock.ExpectQuery("SELECT role FROM channel_members").
+		WithArgs(3, 7).
+		WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow("editor"))
+
+	req := withUser(httptest.NewRequest("PUT", "/api/channels/3/members/7", bytes.NewBufferString(`{"role":"manager"}`)), 7)
+	req = mux.SetURLVars(req, map[string]string{"id": "3", "userId": "7"})
+	w := httptest.NewRecorder()
+
+	handler.UpdateMemberRole(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d", w.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestVideoTeamAccess(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewVideoHandler(db, nil)
+
+	// Analysts can see a video's numbers but not edit it
+	mock.ExpectQuery("SELECT CASE WHEN ch.user_id").
+		WithArgs(5, 7).
+		WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow("viewer-analytics"))
+	mock.ExpectQuery("SELECT id, title, views, likes, dislikes, category, uploaded_at").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "views", "likes", "dislikes", "category", "uploaded_at"}).
+			AddRow(5, "Video", 100, 3, 1, "General", "2024-01-01T00:00:00Z"))
+	mock.ExpectQuery("SELECT CASE WHEN ch.user_id").
+		WithArgs(5, 7).
+		WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow("viewer-analytics"))
+
+	req := withUser(httptest.NewRequest("GET", "/api/videos/5/analytics", nil), 7)
+	req = mux.SetURLVars(req, map[string]string{"id": "5"})
+	w := httptest.NewRecorder()
+
+	handler.GetVideoAnalytics(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected analytics to be visible, got %d: %s", w.Code, w.Body.String())
+	}
+
+	req = withUser(httptest.NewRequest("PUT", "/api/videos/5", bytes.NewBufferString(`{"title":"Renamed"}`)), 7)
+	req = mux.SetURLVars(req, map[string]string{"id": "5"})
+	w = httptest.NewRecorder()
+
+	handler.UpdateVideo(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected editing to be forbidden, got %d", w.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
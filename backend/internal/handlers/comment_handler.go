@@ -1,25 +1,232 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/aung-arata/youtube-clone/backend/internal/auth"
+	"github.com/aung-arata/youtube-clone/backend/internal/middleware"
 	"github.com/aung-arata/youtube-clone/backend/internal/models"
+	"github.com/aung-arata/youtube-clone/backend/internal/moderation"
 	"github.com/gorilla/mux"
 )
 
+// commentColumns selects a comment aliased as c together with its author,
+// joined as u (see commentAuthorJoin)
+const commentColumns = `c.id, c.video_id, c.user_id, COALESCE(u.username, ''), COALESCE(u.avatar, ''),
+	c.parent_id, c.depth, c.content, c.likes, c.reply_count, c.is_pinned, c.is_hearted, c.status,
+	c.created_at, c.updated_at`
+
+const commentAuthorJoin = ` LEFT JOIN users u ON u.id = c.user_id`
+
+// maxCommentDepth is how far replies may nest below a top-level comment
+const maxCommentDepth = 2
+
+// Sort modes accepted by GetComments
+const (
+	commentSortTop    = "top"
+	commentSortNewest = "newest"
+)
+
+var (
+	errCommentNotFound = errors.New("comment not found")
+	errVideoNotFound   = errors.New("video not found")
+)
+
 type CommentHandler struct {
-	db *sql.DB
+	db       *sql.DB
+	filter   *moderation.Pipeline
+	notifier Notifier
+}
+
+// NewCommentHandler creates a comment handler whose spam filter runs the
+// built-in rules followed by any extra classifiers. Without a notifier,
+// comments notify nobody.
+func NewCommentHandler(db *sql.DB, notifier Notifier, classifiers ...moderation.Classifier) *CommentHandler {
+	return &CommentHandler{
+		db:       db,
+		filter:   moderation.NewPipeline(append(moderation.DefaultRules(), classifiers...)...),
+		notifier: notifier,
+	}
+}
+
+func scanComment(row rowScanner) (models.Comment, error) {
+	var c models.Comment
+	err := row.Scan(&c.ID, &c.VideoID, &c.UserID, &c.AuthorUsername, &c.AuthorAvatar,
+		&c.ParentID, &c.Depth, &c.Content, &c.Likes, &c.ReplyCount, &c.IsPinned, &c.IsHearted, &c.Status,
+		&c.CreatedAt, &c.UpdatedAt)
+	return c, err
+}
+
+// commentAccess is what's needed to decide who may act on a comment
+type commentAccess struct {
+	AuthorID     int
+	VideoID      int
+	ParentID     *int
+	Status       string
+	VideoOwnerID *int // owner of the video's channel; nil for unowned channels
+	BlockedWords []string
+}
+
+func (a commentAccess) isVideoOwner(userID int) bool {
+	return a.VideoOwnerID != nil && *a.VideoOwnerID == userID
+}
+
+// canModerate reports whether userID may moderate comments on the comment's
+// video, as the owner of its channel or a team member who moderates comments
+func (h *CommentHandler) canModerate(a commentAccess, userID int) (bool, error) {
+	if a.isVideoOwner(userID) {
+		return true, nil
+	}
+
+	switch err := checkVideoChannelAccess(h.db, a.VideoID, userID, channelModerateComments); err {
+	case nil:
+		return true, nil
+	case errNoChannelAccess, errChannelRoleForbidden, errVideoNotFound:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+func (h *CommentHandler) loadCommentAccess(id int) (commentAccess, error) {
+	query := `
+		SELECT c.user_id, c.video_id, c.parent_id, c.status, ch.user_id, COALESCE(ch.blocked_words, '[]')
+		FROM comments c
+		INNER JOIN videos v ON v.id = c.video_id
+		LEFT JOIN channels ch ON ch.id = v.channel_id
+		WHERE c.id = $1
+	`
+
+	var a commentAccess
+	var blockedWords []byte
+	err := h.db.QueryRow(query, id).Scan(&a.AuthorID, &a.VideoID, &a.ParentID, &a.Status, &a.VideoOwnerID, &blockedWords)
+	if err == sql.ErrNoRows {
+		return a, errCommentNotFound
+	} else if err != nil {
+		return a, err
+	}
+
+	a.BlockedWords, err = decodeBlockedWords(blockedWords)
+	return a, err
+}
+
+// loadVideoBlockedWords returns the blocked-words list of the channel a video
+// belongs to
+func (h *CommentHandler) loadVideoBlockedWords(videoID int) ([]string, error) {
+	query := `
+		SELECT COALESCE(ch.blocked_words, '[]')
+		FROM videos v
+		LEFT JOIN channels ch ON ch.id = v.channel_id
+		WHERE v.id = $1
+	`
+
+	var raw []byte
+	err := h.db.QueryRow(query, videoID).Scan(&raw)
+	if err == sql.ErrNoRows {
+		return nil, errVideoNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return decodeBlockedWords(raw)
+}
+
+// classifyComment runs a new comment through the spam filter using the
+// admin-tuned settings and the author's recent activity
+func (h *CommentHandler) classifyComment(ctx context.Context, userID int, content string) (moderation.Verdict, error) {
+	settings, err := loadCommentFilterSettings(h.db)
+	if err != nil {
+		return moderation.Verdict{}, err
+	}
+
+	query := `
+		SELECT u.created_at,
+		       (SELECT COUNT(*) FROM comments c WHERE c.user_id = u.id AND c.created_at > $3),
+		       (SELECT COUNT(*) FROM comments c WHERE c.user_id = u.id AND c.content = $2 AND c.created_at > $4)
+		FROM users u
+		WHERE u.id = $1
+	`
+
+	in := moderation.Input{Content: content, AuthorID: userID, BlockedTerms: settings.BlockedTerms}
+	now := time.Now()
+	var accountCreatedAt time.Time
+	err = h.db.QueryRow(query, userID, content, now.Add(-moderation.VelocityWindow), now.Add(-moderation.DuplicateWindow)).
+		Scan(&accountCreatedAt, &in.RecentComments, &in.RecentDuplicates)
+	if err != nil && err != sql.ErrNoRows {
+		return moderation.Verdict{}, err
+	}
+	if err == nil {
+		in.AccountAge = now.Sub(accountCreatedAt)
+	}
+
+	thresholds := moderation.Thresholds{Hold: settings.HoldThreshold, Reject: settings.RejectThreshold}
+	return h.filter.Classify(ctx, in, thresholds), nil
 }
 
-func NewCommentHandler(db *sql.DB) *CommentHandler {
-	return &CommentHandler{db: db}
+// setCommentStatus moves a comment between moderation states, keeping the
+// parent's reply count in step with its published replies
+func setCommentStatus(tx *sql.Tx, id int, parentID *int, from, to string) error {
+	if _, err := tx.Exec(`UPDATE comments SET status = $1 WHERE id = $2`, to, id); err != nil {
+		return err
+	}
+	if parentID == nil || from == to {
+		return nil
+	}
+
+	delta := 0
+	if to == models.CommentStatusPublished {
+		delta = 1
+	} else if from == models.CommentStatusPublished {
+		delta = -1
+	}
+	if delta == 0 {
+		return nil
+	}
+
+	_, err := tx.Exec(`UPDATE comments SET reply_count = GREATEST(reply_count + $1, 0) WHERE id = $2`, delta, *parentID)
+	return err
 }
 
-// GetComments returns all comments for a specific video
+// queryCommentPage runs a comment listing query and trims the extra row used
+// to detect another page. cursorFor builds the cursor from the page's last row.
+func (h *CommentHandler) queryCommentPage(query string, args []interface{}, limit int, cursorFor func(models.Comment) string) (models.CommentPage, error) {
+	page := models.CommentPage{Comments: []models.Comment{}}
+
+	rows, err := h.db.Query(query, args...)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		c, err := scanComment(rows)
+		if err != nil {
+			return page, err
+		}
+		page.Comments = append(page.Comments, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return page, err
+	}
+
+	if len(page.Comments) > limit {
+		page.Comments = page.Comments[:limit]
+		page.NextCursor = cursorFor(page.Comments[limit-1])
+	}
+	return page, nil
+}
+
+// GetComments returns a page of published top-level comments for a video,
+// sorted by likes ("top", the default) or by time ("newest"). The pinned
+// comment, if any, leads the first page.
 func (h *CommentHandler) GetComments(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	videoID, err := strconv.Atoi(vars["videoId"])
@@ -28,40 +235,120 @@ func (h *CommentHandler) GetComments(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	query := `
-		SELECT id, video_id, user_id, content, created_at, updated_at
-		FROM comments
-		WHERE video_id = $1
-		ORDER BY created_at DESC
-	`
+	sort := r.URL.Query().Get("sort")
+	if sort == "" {
+		sort = commentSortTop
+	}
+	if sort != commentSortTop && sort != commentSortNewest {
+		http.Error(w, "Sort must be top or newest", http.StatusBadRequest)
+		return
+	}
 
-	rows, err := h.db.Query(query, videoID)
+	limit := parseLimit(r.URL.Query().Get("limit"), 20, 100)
+
+	query := `SELECT ` + commentColumns + ` FROM comments c` + commentAuthorJoin + `
+		WHERE c.video_id = $1 AND c.parent_id IS NULL AND c.status = 'published' AND NOT c.is_pinned`
+	args := []interface{}{videoID}
+
+	cursor := r.URL.Query().Get("cursor")
+	var cursorFor func(models.Comment) string
+
+	if sort == commentSortTop {
+		if cursor != "" {
+			likes, id, err := decodeRankCursor(cursor)
+			if err != nil {
+				http.Error(w, "Invalid cursor", http.StatusBadRequest)
+				return
+			}
+			query += " AND (c.likes, c.id) < ($2, $3)"
+			args = append(args, likes, id)
+		}
+		query += " ORDER BY c.likes DESC, c.id DESC"
+		cursorFor = func(c models.Comment) string { return encodeRankCursor(c.Likes, c.ID) }
+	} else {
+		if cursor != "" {
+			createdAt, id, err := decodeCursor(cursor)
+			if err != nil {
+				http.Error(w, "Invalid cursor", http.StatusBadRequest)
+				return
+			}
+			query += " AND (c.created_at, c.id) < ($2, $3)"
+			args = append(args, createdAt, id)
+		}
+		query += " ORDER BY c.created_at DESC, c.id DESC"
+		cursorFor = func(c models.Comment) string { return encodeCursor(c.CreatedAt, c.ID) }
+	}
+
+	var pinned []models.Comment
+	if cursor == "" {
+		pinnedQuery := `SELECT ` + commentColumns + ` FROM comments c` + commentAuthorJoin + `
+			WHERE c.video_id = $1 AND c.is_pinned AND c.status = 'published'`
+		c, err := scanComment(h.db.QueryRow(pinnedQuery, videoID))
+		if err == nil {
+			pinned = append(pinned, c)
+		} else if err != sql.ErrNoRows {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Fetch one extra row to find out whether another page exists
+	query += " LIMIT $" + strconv.Itoa(len(args)+1)
+	args = append(args, limit+1)
+
+	page, err := h.queryCommentPage(query, args, limit, cursorFor)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
+	page.Comments = append(pinned, page.Comments...)
 
-	var comments []models.Comment
-	for rows.Next() {
-		var c models.Comment
-		err := rows.Scan(&c.ID, &c.VideoID, &c.UserID, &c.Content, &c.CreatedAt, &c.UpdatedAt)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// GetReplies returns a page of published direct replies to a comment, oldest first
+func (h *CommentHandler) GetReplies(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid comment ID", http.StatusBadRequest)
+		return
+	}
+
+	limit := parseLimit(r.URL.Query().Get("limit"), 20, 100)
+
+	query := `SELECT ` + commentColumns + ` FROM comments c` + commentAuthorJoin + `
+		WHERE c.parent_id = $1 AND c.status = 'published'`
+	args := []interface{}{id}
+
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		createdAt, replyID, err := decodeCursor(cursor)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
 			return
 		}
-		comments = append(comments, c)
+		query += " AND (c.created_at, c.id) > ($2, $3)"
+		args = append(args, createdAt, replyID)
 	}
 
-	if comments == nil {
-		comments = []models.Comment{}
+	query += " ORDER BY c.created_at ASC, c.id ASC LIMIT $" + strconv.Itoa(len(args)+1)
+	args = append(args, limit+1)
+
+	page, err := h.queryCommentPage(query, args, limit, func(c models.Comment) string {
+		return encodeCursor(c.CreatedAt, c.ID)
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(comments)
+	json.NewEncoder(w).Encode(page)
 }
 
-// GetComment returns a single comment by ID
+// GetComment returns a single published comment by ID. Held, rejected and
+// hidden comments are only shown in the moderation queues.
 func (h *CommentHandler) GetComment(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
@@ -70,15 +357,9 @@ func (h *CommentHandler) GetComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	query := `
-		SELECT id, video_id, user_id, content, created_at, updated_at
-		FROM comments
-		WHERE id = $1
-	`
-
-	var c models.Comment
-	err = h.db.QueryRow(query, id).Scan(&c.ID, &c.VideoID, &c.UserID, &c.Content, &c.CreatedAt, &c.UpdatedAt)
+	query := `SELECT ` + commentColumns + ` FROM comments c` + commentAuthorJoin + ` WHERE c.id = $1 AND c.status = 'published'`
 
+	c, err := scanComment(h.db.QueryRow(query, id))
 	if err == sql.ErrNoRows {
 		http.Error(w, "Comment not found", http.StatusNotFound)
 		return
@@ -91,8 +372,17 @@ func (h *CommentHandler) GetComment(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(c)
 }
 
-// CreateComment creates a new comment
+// CreateComment creates a comment by the authenticated user, or a reply when
+// parent_id is set. The spam filter decides whether the comment is published,
+// held for review or rejected; comments containing one of the channel's
+// blocked words are held as well.
 func (h *CommentHandler) CreateComment(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
 	vars := mux.Vars(r)
 	videoID, err := strconv.Atoi(vars["videoId"])
 	if err != nil {
@@ -100,45 +390,124 @@ func (h *CommentHandler) CreateComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var c models.Comment
-	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+	var req struct {
+		ParentID *int   `json:"parent_id"`
+		Content  string `json:"content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Set video ID from URL parameter
-	c.VideoID = videoID
-
 	// Validate required fields
-	if strings.TrimSpace(c.Content) == "" {
+	if strings.TrimSpace(req.Content) == "" {
 		http.Error(w, "Content is required", http.StatusBadRequest)
 		return
 	}
-	if c.UserID <= 0 {
-		http.Error(w, "User ID is required", http.StatusBadRequest)
+
+	blockedWords, err := h.loadVideoBlockedWords(videoID)
+	if err == errVideoNotFound {
+		http.Error(w, "Video not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	query := `
-		INSERT INTO comments (video_id, user_id, content)
-		VALUES ($1, $2, $3)
-		RETURNING id, created_at, updated_at
-	`
+	depth := 0
+	if req.ParentID != nil {
+		var parentVideoID, parentDepth int
+		var parentStatus string
+		err := h.db.QueryRow(`SELECT video_id, depth, status FROM comments WHERE id = $1`, *req.ParentID).
+			Scan(&parentVideoID, &parentDepth, &parentStatus)
+		if err == sql.ErrNoRows || (err == nil && parentStatus != models.CommentStatusPublished) {
+			http.Error(w, "Parent comment not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 
-	err = h.db.QueryRow(query, c.VideoID, c.UserID, c.Content).Scan(&c.ID, &c.CreatedAt, &c.UpdatedAt)
+		if parentVideoID != videoID {
+			http.Error(w, "Parent comment belongs to a different video", http.StatusBadRequest)
+			return
+		}
+		if parentDepth >= maxCommentDepth {
+			http.Error(w, "Replies can only be nested "+strconv.Itoa(maxCommentDepth)+" levels deep", http.StatusBadRequest)
+			return
+		}
+		depth = parentDepth + 1
+	}
 
+	verdict, err := h.classifyComment(r.Context(), userID, req.Content)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	status := models.CommentStatusPublished
+	switch {
+	case verdict.Decision == moderation.DecisionReject:
+		status = models.CommentStatusRejected
+	case verdict.Decision == moderation.DecisionHold, containsBlockedWord(req.Content, blockedWords):
+		status = models.CommentStatusHeld
+	}
+
+	signals, err := json.Marshal(verdict.Signals)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Rejected comments are kept, with their verdict, for admins to review.
+	// The parent's reply count only tracks published replies.
+	query := `
+		WITH c AS (
+			INSERT INTO comments (video_id, user_id, parent_id, depth, content, status)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING *
+		), counted AS (
+			UPDATE comments SET reply_count = reply_count + 1 WHERE id = $3 AND $6 = 'published'
+		), judged AS (
+			INSERT INTO comment_verdicts (comment_id, score, decision, signals)
+			SELECT id, $7, $8, $9 FROM c
+		)
+		SELECT ` + commentColumns + ` FROM c` + commentAuthorJoin
+
+	c, err := scanComment(h.db.QueryRow(query, videoID, userID, req.ParentID, depth, req.Content, status,
+		verdict.Score, string(verdict.Decision), signals))
+	if err != nil {
+		if strings.Contains(err.Error(), "foreign key") {
+			http.Error(w, "Video or parent comment not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if c.Status == models.CommentStatusRejected {
+		http.Error(w, "Comment rejected by the spam filter", http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err := notifyCommentRecipients(r.Context(), h.db, h.notifier, c); err != nil {
+		log.Printf("Failed to send notifications for comment %d: %v", c.ID, err)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(c)
 }
 
-// UpdateComment updates an existing comment
+// UpdateComment lets a comment's author edit it. Edits that introduce a
+// blocked word send the comment back for review.
 func (h *CommentHandler) UpdateComment(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
@@ -146,28 +515,22 @@ func (h *CommentHandler) UpdateComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var c models.Comment
-	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+	var req struct {
+		Content string `json:"content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	// Validate required fields
-	if strings.TrimSpace(c.Content) == "" {
+	if strings.TrimSpace(req.Content) == "" {
 		http.Error(w, "Content is required", http.StatusBadRequest)
 		return
 	}
 
-	query := `
-		UPDATE comments 
-		SET content = $1, updated_at = CURRENT_TIMESTAMP
-		WHERE id = $2
-		RETURNING id, video_id, user_id, content, created_at, updated_at
-	`
-
-	err = h.db.QueryRow(query, c.Content, id).Scan(&c.ID, &c.VideoID, &c.UserID, &c.Content, &c.CreatedAt, &c.UpdatedAt)
-
-	if err == sql.ErrNoRows {
+	access, err := h.loadCommentAccess(id)
+	if err == errCommentNotFound {
 		http.Error(w, "Comment not found", http.StatusNotFound)
 		return
 	} else if err != nil {
@@ -175,12 +538,118 @@ func (h *CommentHandler) UpdateComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if access.AuthorID != userID {
+		http.Error(w, "You can only edit your own comments", http.StatusForbidden)
+		return
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE comments SET content = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`, req.Content, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if access.Status == models.CommentStatusPublished && containsBlockedWord(req.Content, access.BlockedWords) {
+		if err := setCommentStatus(tx, id, access.ParentID, access.Status, models.CommentStatusHeld); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	query := `SELECT ` + commentColumns + ` FROM comments c` + commentAuthorJoin + ` WHERE c.id = $1`
+	c, err := scanComment(tx.QueryRow(query, id))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(c)
 }
 
-// DeleteComment deletes a comment
+// DeleteComment deletes a comment along with its replies. Comments may be
+// deleted by their author, the channel's team or a moderator.
 func (h *CommentHandler) DeleteComment(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid comment ID", http.StatusBadRequest)
+		return
+	}
+
+	access, err := h.loadCommentAccess(id)
+	if err == errCommentNotFound {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if access.AuthorID != userID && !middleware.HasPermission(r.Context(), auth.PermModerateComments) {
+		allowed, err := h.canModerate(access, userID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			http.Error(w, "You do not have permission to delete this comment", http.StatusForbidden)
+			return
+		}
+	}
+
+	query := `
+		WITH deleted AS (
+			DELETE FROM comments WHERE id = $1
+			RETURNING parent_id, status
+		), counted AS (
+			UPDATE comments SET reply_count = GREATEST(reply_count - 1, 0)
+			WHERE id IN (SELECT parent_id FROM deleted WHERE status = 'published')
+		)
+		SELECT COUNT(*) FROM deleted
+	`
+
+	var deleted int
+	if err := h.db.QueryRow(query, id).Scan(&deleted); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if deleted == 0 {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ModerateComment lets the team of a video's channel pin, heart, hide or
+// approve a comment on it
+func (h *CommentHandler) ModerateComment(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
@@ -188,24 +657,228 @@ func (h *CommentHandler) DeleteComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	query := `DELETE FROM comments WHERE id = $1`
+	var req struct {
+		Pinned  *bool   `json:"pinned"`
+		Hearted *bool   `json:"hearted"`
+		Status  *string `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
 
-	result, err := h.db.Exec(query, id)
+	if req.Status != nil && *req.Status != models.CommentStatusPublished && *req.Status != models.CommentStatusHidden {
+		http.Error(w, "Status must be published or hidden", http.StatusBadRequest)
+		return
+	}
+
+	access, err := h.loadCommentAccess(id)
+	if err == errCommentNotFound {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	allowed, err := h.canModerate(access, userID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if !allowed {
+		http.Error(w, "Only the channel's team can moderate comments on its videos", http.StatusForbidden)
+		return
+	}
+	if access.Status == models.CommentStatusRejected {
+		http.Error(w, "Comments rejected by the spam filter can only be reviewed by a moderator", http.StatusForbidden)
+		return
+	}
+
+	status := access.Status
+	if req.Status != nil {
+		status = *req.Status
+	}
+	if req.Pinned != nil && *req.Pinned {
+		if access.ParentID != nil {
+			http.Error(w, "Only top-level comments can be pinned", http.StatusBadRequest)
+			return
+		}
+		if status != models.CommentStatusPublished {
+			http.Error(w, "Only published comments can be pinned", http.StatusBadRequest)
+			return
+		}
+	}
 
-	rowsAffected, err := result.RowsAffected()
+	tx, err := h.db.Begin()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	defer tx.Rollback()
 
-	if rowsAffected == 0 {
+	if req.Status != nil {
+		if err := setCommentStatus(tx, id, access.ParentID, access.Status, status); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// A video has at most one pinned comment
+	if req.Pinned != nil && *req.Pinned {
+		_, err := tx.Exec(`UPDATE comments SET is_pinned = FALSE WHERE video_id = $1 AND is_pinned AND id <> $2`, access.VideoID, id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Hidden comments can't stay pinned
+	query := `
+		UPDATE comments
+		SET is_pinned = COALESCE($1, is_pinned) AND status = 'published',
+		    is_hearted = COALESCE($2, is_hearted)
+		WHERE id = $3
+	`
+	if _, err := tx.Exec(query, req.Pinned, req.Hearted, id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	c, err := scanComment(tx.QueryRow(`SELECT `+commentColumns+` FROM comments c`+commentAuthorJoin+` WHERE c.id = $1`, id))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Held comments notify nobody until they're approved
+	if access.Status == models.CommentStatusHeld && c.Status == models.CommentStatusPublished {
+		if err := notifyCommentRecipients(r.Context(), h.db, h.notifier, c); err != nil {
+			log.Printf("Failed to send notifications for comment %d: %v", c.ID, err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
+
+// GetHeldComments returns the review queue of a channel: comments on its
+// videos that were held for containing a blocked word, newest first
+func (h *CommentHandler) GetHeldComments(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	channelID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := loadChannelFor(h.db, channelID, userID, channelModerateComments); err != nil {
+		writeChannelAccessError(w, err)
+		return
+	}
+
+	limit := parseLimit(r.URL.Query().Get("limit"), 20, 100)
+
+	query := `SELECT ` + commentColumns + ` FROM comments c
+		INNER JOIN videos v ON v.id = c.video_id` + commentAuthorJoin + `
+		WHERE v.channel_id = $1 AND c.status = 'held'`
+	args := []interface{}{channelID}
+
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		createdAt, id, err := decodeCursor(cursor)
+		if err != nil {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		query += " AND (c.created_at, c.id) < ($2, $3)"
+		args = append(args, createdAt, id)
+	}
+
+	query += " ORDER BY c.created_at DESC, c.id DESC LIMIT $" + strconv.Itoa(len(args)+1)
+	args = append(args, limit+1)
+
+	page, err := h.queryCommentPage(query, args, limit, func(c models.Comment) string {
+		return encodeCursor(c.CreatedAt, c.ID)
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// LikeComment records the authenticated user's like on a comment. Liking
+// twice is a no-op.
+func (h *CommentHandler) LikeComment(w http.ResponseWriter, r *http.Request) {
+	query := `
+		WITH liked AS (
+			INSERT INTO comment_likes (comment_id, user_id)
+			VALUES ($1, $2)
+			ON CONFLICT DO NOTHING
+			RETURNING comment_id
+		)
+		UPDATE comments SET likes = likes + (SELECT COUNT(*) FROM liked)
+		WHERE id = $1
+		RETURNING likes
+	`
+	h.changeCommentLike(w, r, query)
+}
+
+// UnlikeComment removes the authenticated user's like from a comment
+func (h *CommentHandler) UnlikeComment(w http.ResponseWriter, r *http.Request) {
+	query := `
+		WITH unliked AS (
+			DELETE FROM comment_likes
+			WHERE comment_id = $1 AND user_id = $2
+			RETURNING comment_id
+		)
+		UPDATE comments SET likes = GREATEST(likes - (SELECT COUNT(*) FROM unliked), 0)
+		WHERE id = $1
+		RETURNING likes
+	`
+	h.changeCommentLike(w, r, query)
+}
+
+func (h *CommentHandler) changeCommentLike(w http.ResponseWriter, r *http.Request, query string) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid comment ID", http.StatusBadRequest)
+		return
+	}
+
+	var likes int
+	err = h.db.QueryRow(query, id, userID).Scan(&likes)
+	if err == sql.ErrNoRows || (err != nil && strings.Contains(err.Error(), "foreign key")) {
 		http.Error(w, "Comment not found", http.StatusNotFound)
 		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{
+		"id":    id,
+		"likes": likes,
+	})
 }
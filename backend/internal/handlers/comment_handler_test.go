@@ -2,9 +2,12 @@ package handlers
 
 import (
 	"bytes"
+	"database/sql/driver"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strconv"
 	"testing"
 	"time"
 
@@ -13,6 +16,38 @@ import (
 	"github.com/gorilla/mux"
 )
 
+func commentRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{
+		"id", "video_id", "user_id", "username", "avatar", "parent_id", "depth", "content",
+		"likes", "reply_count", "is_pinned", "is_hearted", "status", "created_at", "updated_at",
+	})
+}
+
+// commentRow is a published comment on video 1 by userN
+func commentRow(id, userID int, parentID interface{}, depth int, content string, likes int, createdAt time.Time) []driver.Value {
+	return []driver.Value{id, 1, userID, "user" + strconv.Itoa(userID), "", parentID, depth, content,
+		likes, 0, false, false, "published", createdAt, createdAt}
+}
+
+// accessRows answers loadCommentAccess for a comment by authorID on a video
+// whose channel is owned by ownerID
+func accessRows(authorID int, parentID interface{}, status string, ownerID interface{}, blockedWords string) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"user_id", "video_id", "parent_id", "status", "owner_id", "blocked_words"}).
+		AddRow(authorID, 1, parentID, status, ownerID, []byte(blockedWords))
+}
+
+// expectSpamFilter answers the spam filter's settings and author activity
+// lookups for an account of the given age
+func expectSpamFilter(mock sqlmock.Sqlmock, userID int, content string, blockedTerms string, accountAge time.Duration, recentComments int) {
+	mock.ExpectQuery("SELECT (.+) FROM comment_filter_settings").
+		WillReturnRows(sqlmock.NewRows([]string{"hold_threshold", "reject_threshold", "blocked_terms", "updated_at"}).
+			AddRow(0.6, 0.9, []byte(blockedTerms), time.Now()))
+	mock.ExpectQuery("SELECT u.created_at, (.+) FROM users u").
+		WithArgs(userID, content, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"created_at", "recent_comments", "recent_duplicates"}).
+			AddRow(time.Now().Add(-accountAge), recentComments, 0))
+}
+
 func TestGetComments(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -20,16 +55,22 @@ func TestGetComments(t *testing.T) {
 	}
 	defer db.Close()
 
-	handler := NewCommentHandler(db)
+	handler := NewCommentHandler(db, nil)
 
 	// Mock data
 	now := time.Now()
-	rows := sqlmock.NewRows([]string{"id", "video_id", "user_id", "content", "created_at", "updated_at"}).
-		AddRow(1, 1, 1, "Great video!", now, now).
-		AddRow(2, 1, 2, "Thanks for sharing", now, now)
+	pinned := commentRows().AddRow(commentRow(9, 5, nil, 0, "Pinned by the creator", 0, now)...)
+	rows := commentRows().
+		AddRow(commentRow(1, 1, nil, 0, "Great video!", 5, now)...).
+		AddRow(commentRow(2, 2, nil, 0, "Thanks for sharing", 1, now)...)
 
-	mock.ExpectQuery("SELECT (.+) FROM comments WHERE video_id").
+	mock.ExpectQuery("SELECT (.+) FROM comments c LEFT JOIN users u (.+) WHERE c.video_id = \\$1 AND c.is_pinned").
 		WithArgs(1).
+		WillReturnRows(pinned)
+
+	// Top sort is the default
+	mock.ExpectQuery("SELECT (.+) WHERE c.video_id = \\$1 AND c.parent_id IS NULL AND c.status = 'published' AND NOT c.is_pinned ORDER BY c.likes DESC, c.id DESC").
+		WithArgs(1, 21).
 		WillReturnRows(rows)
 
 	req := httptest.NewRequest("GET", "/api/videos/1/comments", nil)
@@ -42,11 +83,125 @@ func TestGetComments(t *testing.T) {
 		t.Errorf("Expected status 200, got %d", w.Code)
 	}
 
-	var comments []models.Comment
-	json.NewDecoder(w.Body).Decode(&comments)
+	var page models.CommentPage
+	json.NewDecoder(w.Body).Decode(&page)
+
+	if len(page.Comments) != 3 {
+		t.Fatalf("Expected 3 comments, got %d", len(page.Comments))
+	}
+	if page.Comments[0].ID != 9 {
+		t.Errorf("Expected the pinned comment first, got %d", page.Comments[0].ID)
+	}
+	if page.Comments[1].AuthorUsername != "user1" {
+		t.Errorf("Expected author username user1, got %q", page.Comments[1].AuthorUsername)
+	}
+	if page.NextCursor != "" {
+		t.Errorf("Expected no next cursor, got %q", page.NextCursor)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestGetComments_NewestWithCursor(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewCommentHandler(db, nil)
+
+	before := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	rows := commentRows().
+		AddRow(commentRow(6, 1, nil, 0, "Sixth", 0, before.Add(-time.Minute))...).
+		AddRow(commentRow(5, 2, nil, 0, "Fifth", 0, before.Add(-2*time.Minute))...).
+		AddRow(commentRow(4, 3, nil, 0, "Fourth", 0, before.Add(-3*time.Minute))...)
+
+	// Later pages don't repeat the pinned comment
+	mock.ExpectQuery("SELECT (.+) AND \\(c.created_at, c.id\\) < (.+) ORDER BY c.created_at DESC, c.id DESC").
+		WithArgs(1, before, 7, 3).
+		WillReturnRows(rows)
+
+	req := httptest.NewRequest("GET", "/api/videos/1/comments?sort=newest&limit=2&cursor="+encodeCursor(before, 7), nil)
+	req = mux.SetURLVars(req, map[string]string{"videoId": "1"})
+	w := httptest.NewRecorder()
+
+	handler.GetComments(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var page models.CommentPage
+	json.NewDecoder(w.Body).Decode(&page)
+
+	if len(page.Comments) != 2 {
+		t.Fatalf("Expected 2 comments, got %d", len(page.Comments))
+	}
 
-	if len(comments) != 2 {
-		t.Errorf("Expected 2 comments, got %d", len(comments))
+	createdAt, id, err := decodeCursor(page.NextCursor)
+	if err != nil || id != 5 || !createdAt.Equal(before.Add(-2*time.Minute)) {
+		t.Errorf("Expected cursor to point at comment 5, got id %d at %v (%v)", id, createdAt, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestGetComments_InvalidSort(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewCommentHandler(db, nil)
+
+	req := httptest.NewRequest("GET", "/api/videos/1/comments?sort=oldest", nil)
+	req = mux.SetURLVars(req, map[string]string{"videoId": "1"})
+	w := httptest.NewRecorder()
+
+	handler.GetComments(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+}
+
+func TestGetReplies(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewCommentHandler(db, nil)
+
+	now := time.Now()
+	rows := commentRows().AddRow(commentRow(2, 2, 1, 1, "Agreed", 0, now)...)
+
+	mock.ExpectQuery("SELECT (.+) WHERE c.parent_id = \\$1 AND c.status = 'published' ORDER BY c.created_at ASC, c.id ASC").
+		WithArgs(1, 21).
+		WillReturnRows(rows)
+
+	req := httptest.NewRequest("GET", "/api/comments/1/replies", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+
+	handler.GetReplies(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var page models.CommentPage
+	json.NewDecoder(w.Body).Decode(&page)
+
+	if len(page.Comments) != 1 || page.Comments[0].ParentID == nil || *page.Comments[0].ParentID != 1 {
+		t.Errorf("Unexpected replies: %+v", page.Comments)
 	}
 
 	if err := mock.ExpectationsWereMet(); err != nil {
@@ -61,14 +216,13 @@ func TestGetComment(t *testing.T) {
 	}
 	defer db.Close()
 
-	handler := NewCommentHandler(db)
+	handler := NewCommentHandler(db, nil)
 
 	// Mock data
 	now := time.Now()
-	rows := sqlmock.NewRows([]string{"id", "video_id", "user_id", "content", "created_at", "updated_at"}).
-		AddRow(1, 1, 1, "Great video!", now, now)
+	rows := commentRows().AddRow(commentRow(1, 1, nil, 0, "Great video!", 0, now)...)
 
-	mock.ExpectQuery("SELECT (.+) FROM comments WHERE id").
+	mock.ExpectQuery("SELECT (.+) FROM comments c (.+) WHERE c.id = \\$1 AND c.status = 'published'").
 		WithArgs(1).
 		WillReturnRows(rows)
 
@@ -94,32 +248,62 @@ func TestGetComment(t *testing.T) {
 	}
 }
 
-func TestCreateComment(t *testing.T) {
+func TestGetComment_Unpublished(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("Failed to create mock: %v", err)
 	}
 	defer db.Close()
 
-	handler := NewCommentHandler(db)
+	handler := NewCommentHandler(db, nil)
 
-	// Mock data
-	now := time.Now()
-	rows := sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).
-		AddRow(1, now, now)
+	// Held, rejected and hidden comments don't match
+	mock.ExpectQuery("SELECT (.+) FROM comments c (.+) WHERE c.id = \\$1 AND c.status = 'published'").
+		WithArgs(2).
+		WillReturnRows(commentRows())
 
-	mock.ExpectQuery("INSERT INTO comments").
-		WithArgs(1, 1, "Great video!").
-		WillReturnRows(rows)
+	req := httptest.NewRequest("GET", "/api/comments/2", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "2"})
+	w := httptest.NewRecorder()
 
-	comment := models.Comment{
-		UserID:  1,
-		Content: "Great video!",
+	handler.GetComment(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestCreateComment(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
 	}
+	defer db.Close()
+
+	notifier := &recordingNotifier{}
+	handler := NewCommentHandler(db, notifier)
 
-	body, _ := json.Marshal(comment)
-	req := httptest.NewRequest("POST", "/api/videos/1/comments", bytes.NewBuffer(body))
+	// Mock data
+	now := time.Now()
+	mock.ExpectQuery("SELECT (.+) FROM videos v LEFT JOIN channels ch").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"blocked_words"}).AddRow([]byte("[]")))
+	expectSpamFilter(mock, 1, "Great video!", "[]", 365*24*time.Hour, 0)
+	mock.ExpectQuery("INSERT INTO comments (.+) INSERT INTO comment_verdicts").
+		WithArgs(1, 1, nil, 0, "Great video!", "published", 0.0, "publish", sqlmock.AnyArg()).
+		WillReturnRows(commentRows().AddRow(commentRow(1, 1, nil, 0, "Great video!", 0, now)...))
+	mock.ExpectQuery("SELECT DISTINCT ON \\(r.user_id\\) r.user_id, r.type").
+		WithArgs(1, nil, "{}", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "type"}).AddRow(5, "video_comment"))
+
+	// A user_id in the body is ignored in favour of the token's user
+	body := bytes.NewBufferString(`{"user_id":99,"content":"Great video!"}`)
+	req := httptest.NewRequest("POST", "/api/videos/1/comments", body)
 	req = mux.SetURLVars(req, map[string]string{"videoId": "1"})
+	req = withUser(req, 1)
 	w := httptest.NewRecorder()
 
 	handler.CreateComment(w, req)
@@ -127,6 +311,13 @@ func TestCreateComment(t *testing.T) {
 	if w.Code != http.StatusCreated {
 		t.Errorf("Expected status 201, got %d", w.Code)
 	}
+	if len(notifier.sent) != 1 {
+		t.Fatalf("Expected 1 notification, got %d", len(notifier.sent))
+	}
+	if n := notifier.sent[0]; n.UserID != 5 || n.Title != "user1 commented on your video" ||
+		n.Message != "Great video!" || n.Link != "/videos/1?comment=1" || n.CollapseKey != "video_comment:1" {
+		t.Errorf("Unexpected notification %+v", n)
+	}
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("Unfulfilled expectations: %v", err)
@@ -140,30 +331,34 @@ func TestCreateComment_InvalidData(t *testing.T) {
 	}
 	defer db.Close()
 
-	handler := NewCommentHandler(db)
+	handler := NewCommentHandler(db, nil)
 
 	tests := []struct {
 		name       string
-		comment    models.Comment
+		userID     int
+		body       string
 		statusCode int
 	}{
 		{
 			name:       "Empty content",
-			comment:    models.Comment{UserID: 1, Content: ""},
+			userID:     1,
+			body:       `{"content":""}`,
 			statusCode: http.StatusBadRequest,
 		},
 		{
-			name:       "Missing user ID",
-			comment:    models.Comment{UserID: 0, Content: "Great video!"},
-			statusCode: http.StatusBadRequest,
+			name:       "Not authenticated",
+			body:       `{"content":"Great video!"}`,
+			statusCode: http.StatusUnauthorized,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			body, _ := json.Marshal(tt.comment)
-			req := httptest.NewRequest("POST", "/api/videos/1/comments", bytes.NewBuffer(body))
+			req := httptest.NewRequest("POST", "/api/videos/1/comments", bytes.NewBufferString(tt.body))
 			req = mux.SetURLVars(req, map[string]string{"videoId": "1"})
+			if tt.userID > 0 {
+				req = withUser(req, tt.userID)
+			}
 			w := httptest.NewRecorder()
 
 			handler.CreateComment(w, req)
@@ -175,31 +370,328 @@ func TestCreateComment_InvalidData(t *testing.T) {
 	}
 }
 
-func TestUpdateComment(t *testing.T) {
+func TestCreateComment_HeldForBlockedWord(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("Failed to create mock: %v", err)
 	}
 	defer db.Close()
 
-	handler := NewCommentHandler(db)
+	handler := NewCommentHandler(db, nil)
 
-	// Mock data
 	now := time.Now()
-	rows := sqlmock.NewRows([]string{"id", "video_id", "user_id", "content", "created_at", "updated_at"}).
-		AddRow(1, 1, 1, "Updated comment!", now, now)
+	held := commentRow(1, 1, nil, 0, "Buy CHEAP pills!", 0, now)
+	held[12] = "held"
 
-	mock.ExpectQuery("UPDATE comments").
-		WithArgs("Updated comment!", 1).
-		WillReturnRows(rows)
+	mock.ExpectQuery("SELECT (.+) FROM videos v LEFT JOIN channels ch").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"blocked_words"}).AddRow([]byte(`["cheap pills"]`)))
+	expectSpamFilter(mock, 1, "Buy CHEAP pills!", "[]", 365*24*time.Hour, 0)
+	mock.ExpectQuery("INSERT INTO comments").
+		WithArgs(1, 1, nil, 0, "Buy CHEAP pills!", "held", 0.0, "publish", sqlmock.AnyArg()).
+		WillReturnRows(commentRows().AddRow(held...))
+
+	req := httptest.NewRequest("POST", "/api/videos/1/comments", bytes.NewBufferString(`{"content":"Buy CHEAP pills!"}`))
+	req = mux.SetURLVars(req, map[string]string{"videoId": "1"})
+	req = withUser(req, 1)
+	w := httptest.NewRecorder()
+
+	handler.CreateComment(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var comment models.Comment
+	json.NewDecoder(w.Body).Decode(&comment)
+
+	if comment.Status != "held" {
+		t.Errorf("Expected status held, got %q", comment.Status)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestCreateComment_RejectedBySpamFilter(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewCommentHandler(db, nil)
+
+	now := time.Now()
+	rejected := commentRow(1, 1, nil, 0, "Get free followers now", 0, now)
+	rejected[12] = "rejected"
+
+	mock.ExpectQuery("SELECT (.+) FROM videos v LEFT JOIN channels ch").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"blocked_words"}).AddRow([]byte("[]")))
+	expectSpamFilter(mock, 1, "Get free followers now", `["free followers"]`, 365*24*time.Hour, 0)
+	mock.ExpectQuery("INSERT INTO comments (.+) INSERT INTO comment_verdicts").
+		WithArgs(1, 1, nil, 0, "Get free followers now", "rejected", 1.0, "reject", sqlmock.AnyArg()).
+		WillReturnRows(commentRows().AddRow(rejected...))
+
+	req := httptest.NewRequest("POST", "/api/videos/1/comments", bytes.NewBufferString(`{"content":"Get free followers now"}`))
+	req = mux.SetURLVars(req, map[string]string{"videoId": "1"})
+	req = withUser(req, 1)
+	w := httptest.NewRecorder()
+
+	handler.CreateComment(w, req)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestCreateComment_HeldForNewAccountVelocity(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewCommentHandler(db, nil)
+
+	now := time.Now()
+	held := commentRow(1, 1, nil, 0, "first!", 0, now)
+	held[12] = "held"
+
+	mock.ExpectQuery("SELECT (.+) FROM videos v LEFT JOIN channels ch").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"blocked_words"}).AddRow([]byte("[]")))
+	expectSpamFilter(mock, 1, "first!", "[]", time.Hour, 3)
+	mock.ExpectQuery("INSERT INTO comments").
+		WithArgs(1, 1, nil, 0, "first!", "held", 0.6, "hold", sqlmock.AnyArg()).
+		WillReturnRows(commentRows().AddRow(held...))
+
+	req := httptest.NewRequest("POST", "/api/videos/1/comments", bytes.NewBufferString(`{"content":"first!"}`))
+	req = mux.SetURLVars(req, map[string]string{"videoId": "1"})
+	req = withUser(req, 1)
+	w := httptest.NewRecorder()
+
+	handler.CreateComment(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestCreateComment_Reply(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	notifier := &recordingNotifier{}
+	handler := NewCommentHandler(db, notifier)
+
+	now := time.Now()
+	mock.ExpectQuery("SELECT (.+) FROM videos v LEFT JOIN channels ch").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"blocked_words"}).AddRow([]byte("[]")))
+	mock.ExpectQuery("SELECT video_id, depth, status FROM comments WHERE id").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"video_id", "depth", "status"}).AddRow(1, 0, "published"))
+	expectSpamFilter(mock, 2, "Agreed", "[]", 365*24*time.Hour, 0)
+	mock.ExpectQuery("INSERT INTO comments (.+) UPDATE comments SET reply_count = reply_count \\+ 1").
+		WithArgs(1, 2, 1, 1, "Agreed", "published", 0.0, "publish", sqlmock.AnyArg()).
+		WillReturnRows(commentRows().AddRow(commentRow(2, 2, 1, 1, "Agreed", 0, now)...))
+	mock.ExpectQuery("SELECT DISTINCT ON \\(r.user_id\\) r.user_id, r.type").
+		WithArgs(2, 1, "{}", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "type"}).AddRow(1, "comment_reply"))
+
+	req := httptest.NewRequest("POST", "/api/videos/1/comments", bytes.NewBufferString(`{"parent_id":1,"content":"Agreed"}`))
+	req = mux.SetURLVars(req, map[string]string{"videoId": "1"})
+	req = withUser(req, 2)
+	w := httptest.NewRecorder()
+
+	handler.CreateComment(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var comment models.Comment
+	json.NewDecoder(w.Body).Decode(&comment)
+
+	if comment.Depth != 1 {
+		t.Errorf("Expected depth 1, got %d", comment.Depth)
+	}
+	if len(notifier.sent) != 1 {
+		t.Fatalf("Expected 1 notification, got %d", len(notifier.sent))
+	}
+	if n := notifier.sent[0]; n.UserID != 1 || n.Type != "comment_reply" || n.CollapseKey != "comment_reply:1" {
+		t.Errorf("Unexpected notification %+v", n)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestCreateComment_NotifiesMentions(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	notifier := &recordingNotifier{}
+	handler := NewCommentHandler(db, notifier)
+
+	now := time.Now()
+	content := "Thanks @Alice and @bob.smith, cc @alice"
+	mock.ExpectQuery("SELECT (.+) FROM videos v LEFT JOIN channels ch").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"blocked_words"}).AddRow([]byte("[]")))
+	expectSpamFilter(mock, 3, content, "[]", 365*24*time.Hour, 0)
+	mock.ExpectQuery("INSERT INTO comments").
+		WithArgs(1, 3, nil, 0, content, "published", 0.0, "publish", sqlmock.AnyArg()).
+		WillReturnRows(commentRows().AddRow(commentRow(4, 3, nil, 0, content, 0, now)...))
+	mock.ExpectQuery("NOT EXISTS \\( SELECT 1 FROM user_mutes").
+		WithArgs(3, nil, `{"alice","bob.smith"}`, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "type"}).
+			AddRow(5, "mention").AddRow(6, "mention").AddRow(7, "video_comment"))
+
+	req := httptest.NewRequest("POST", "/api/videos/1/comments", bytes.NewBufferString(`{"content":"`+content+`"}`))
+	req = mux.SetURLVars(req, map[string]string{"videoId": "1"})
+	req = withUser(req, 3)
+	w := httptest.NewRecorder()
+
+	handler.CreateComment(w, req)
 
-	comment := models.Comment{
-		Content: "Updated comment!",
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	titles := []string{}
+	for _, n := range notifier.sent {
+		titles = append(titles, n.Title)
+	}
+	expected := []string{"user3 mentioned you in a comment", "user3 mentioned you in a comment", "user3 commented on your video"}
+	if !reflect.DeepEqual(titles, expected) {
+		t.Errorf("Expected notifications %v, got %v", expected, titles)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestParseMentions(t *testing.T) {
+	tests := []struct {
+		content  string
+		expected []string
+	}{
+		{"@alice great point", []string{"alice"}},
+		{"Thanks @Alice, @bob and @ALICE!", []string{"alice", "bob"}},
+		{"(@carol) agreed.", []string{"carol"}},
+		{"Ending a sentence with @dave.", []string{"dave"}},
+		{"mail me at someone@example.com", []string{}},
+		{"no mentions here", []string{}},
+		{"@ alone", []string{}},
+	}
+
+	for _, tt := range tests {
+		got := parseMentions(tt.content)
+		if len(got) != len(tt.expected) {
+			t.Errorf("parseMentions(%q) = %v, expected %v", tt.content, got, tt.expected)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.expected[i] {
+				t.Errorf("parseMentions(%q) = %v, expected %v", tt.content, got, tt.expected)
+				break
+			}
+		}
+	}
+}
+
+func TestCreateComment_InvalidParent(t *testing.T) {
+	tests := []struct {
+		name          string
+		parentVideoID int
+		parentDepth   int
+	}{
+		{name: "Parent on another video", parentVideoID: 2, parentDepth: 0},
+		{name: "Nested too deep", parentVideoID: 1, parentDepth: maxCommentDepth},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("Failed to create mock: %v", err)
+			}
+			defer db.Close()
+
+			handler := NewCommentHandler(db, nil)
+
+			mock.ExpectQuery("SELECT (.+) FROM videos v LEFT JOIN channels ch").
+				WithArgs(1).
+				WillReturnRows(sqlmock.NewRows([]string{"blocked_words"}).AddRow([]byte("[]")))
+			mock.ExpectQuery("SELECT video_id, depth, status FROM comments WHERE id").
+				WithArgs(3).
+				WillReturnRows(sqlmock.NewRows([]string{"video_id", "depth", "status"}).
+					AddRow(tt.parentVideoID, tt.parentDepth, "published"))
+
+			req := httptest.NewRequest("POST", "/api/videos/1/comments", bytes.NewBufferString(`{"parent_id":3,"content":"Reply"}`))
+			req = mux.SetURLVars(req, map[string]string{"videoId": "1"})
+			req = withUser(req, 2)
+			w := httptest.NewRecorder()
+
+			handler.CreateComment(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Expected status 400, got %d", w.Code)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Unfulfilled expectations: %v", err)
+			}
+		})
 	}
+}
 
-	body, _ := json.Marshal(comment)
+func TestUpdateComment(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewCommentHandler(db, nil)
+
+	// Mock data
+	now := time.Now()
+	mock.ExpectQuery("SELECT (.+) FROM comments c INNER JOIN videos v").
+		WithArgs(1).
+		WillReturnRows(accessRows(1, nil, "published", 5, "[]"))
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE comments SET content").
+		WithArgs("Updated comment!", 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT (.+) FROM comments c (.+) WHERE c.id").
+		WithArgs(1).
+		WillReturnRows(commentRows().AddRow(commentRow(1, 1, nil, 0, "Updated comment!", 0, now)...))
+	mock.ExpectCommit()
+
+	body, _ := json.Marshal(map[string]string{"content": "Updated comment!"})
 	req := httptest.NewRequest("PUT", "/api/comments/1", bytes.NewBuffer(body))
 	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	req = withUser(req, 1)
 	w := httptest.NewRecorder()
 
 	handler.UpdateComment(w, req)
@@ -213,6 +705,36 @@ func TestUpdateComment(t *testing.T) {
 	}
 }
 
+func TestUpdateComment_NotAuthor(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewCommentHandler(db, nil)
+
+	// Even the video owner can't rewrite someone else's words
+	mock.ExpectQuery("SELECT (.+) FROM comments c INNER JOIN videos v").
+		WithArgs(1).
+		WillReturnRows(accessRows(1, nil, "published", 5, "[]"))
+
+	req := httptest.NewRequest("PUT", "/api/comments/1", bytes.NewBufferString(`{"content":"Edited"}`))
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	req = withUser(req, 5)
+	w := httptest.NewRecorder()
+
+	handler.UpdateComment(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
 func TestDeleteComment(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -220,14 +742,19 @@ func TestDeleteComment(t *testing.T) {
 	}
 	defer db.Close()
 
-	handler := NewCommentHandler(db)
+	handler := NewCommentHandler(db, nil)
 
-	mock.ExpectExec("DELETE FROM comments WHERE id").
+	// The video owner may delete other people's comments
+	mock.ExpectQuery("SELECT (.+) FROM comments c INNER JOIN videos v").
 		WithArgs(1).
-		WillReturnResult(sqlmock.NewResult(0, 1))
+		WillReturnRows(accessRows(1, nil, "published", 5, "[]"))
+	mock.ExpectQuery("DELETE FROM comments WHERE id (.+) UPDATE comments SET reply_count").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
 
 	req := httptest.NewRequest("DELETE", "/api/comments/1", nil)
 	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	req = withUser(req, 5)
 	w := httptest.NewRecorder()
 
 	handler.DeleteComment(w, req)
@@ -241,6 +768,38 @@ func TestDeleteComment(t *testing.T) {
 	}
 }
 
+func TestDeleteComment_Forbidden(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewCommentHandler(db, nil)
+
+	mock.ExpectQuery("SELECT (.+) FROM comments c INNER JOIN videos v").
+		WithArgs(1).
+		WillReturnRows(accessRows(1, nil, "published", 5, "[]"))
+	mock.ExpectQuery("SELECT CASE WHEN ch.user_id").
+		WithArgs(1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow(""))
+
+	req := httptest.NewRequest("DELETE", "/api/comments/1", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	req = withUser(req, 2)
+	w := httptest.NewRecorder()
+
+	handler.DeleteComment(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
 func TestDeleteComment_NotFound(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -248,14 +807,15 @@ func TestDeleteComment_NotFound(t *testing.T) {
 	}
 	defer db.Close()
 
-	handler := NewCommentHandler(db)
+	handler := NewCommentHandler(db, nil)
 
-	mock.ExpectExec("DELETE FROM comments WHERE id").
+	mock.ExpectQuery("SELECT (.+) FROM comments c INNER JOIN videos v").
 		WithArgs(999).
-		WillReturnResult(sqlmock.NewResult(0, 0))
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "video_id", "parent_id", "status", "owner_id", "blocked_words"}))
 
 	req := httptest.NewRequest("DELETE", "/api/comments/999", nil)
 	req = mux.SetURLVars(req, map[string]string{"id": "999"})
+	req = withUser(req, 1)
 	w := httptest.NewRecorder()
 
 	handler.DeleteComment(w, req)
@@ -268,3 +828,239 @@ func TestDeleteComment_NotFound(t *testing.T) {
 		t.Errorf("Unfulfilled expectations: %v", err)
 	}
 }
+
+func TestModerateComment_PinAndHeart(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewCommentHandler(db, nil)
+
+	now := time.Now()
+	moderated := commentRow(1, 1, nil, 0, "Great video!", 0, now)
+	moderated[10], moderated[11] = true, true
+
+	mock.ExpectQuery("SELECT (.+) FROM comments c INNER JOIN videos v").
+		WithArgs(1).
+		WillReturnRows(accessRows(1, nil, "published", 5, "[]"))
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE comments SET is_pinned = FALSE WHERE video_id").
+		WithArgs(1, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE comments SET is_pinned = COALESCE").
+		WithArgs(true, true, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT (.+) FROM comments c (.+) WHERE c.id").
+		WithArgs(1).
+		WillReturnRows(commentRows().AddRow(moderated...))
+	mock.ExpectCommit()
+
+	req := httptest.NewRequest("PUT", "/api/comments/1/moderation", bytes.NewBufferString(`{"pinned":true,"hearted":true}`))
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	req = withUser(req, 5)
+	w := httptest.NewRecorder()
+
+	handler.ModerateComment(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var comment models.Comment
+	json.NewDecoder(w.Body).Decode(&comment)
+
+	if !comment.IsPinned || !comment.IsHearted {
+		t.Errorf("Expected a pinned, hearted comment, got %+v", comment)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestModerateComment_ApproveHeldReply(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	notifier := &recordingNotifier{}
+	handler := NewCommentHandler(db, notifier)
+
+	now := time.Now()
+	mock.ExpectQuery("SELECT (.+) FROM comments c INNER JOIN videos v").
+		WithArgs(2).
+		WillReturnRows(accessRows(1, 1, "held", 5, "[]"))
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE comments SET status").
+		WithArgs("published", 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE comments SET reply_count = GREATEST\\(reply_count \\+ \\$1, 0\\)").
+		WithArgs(1, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE comments SET is_pinned = COALESCE").
+		WithArgs(nil, nil, 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT (.+) FROM comments c (.+) WHERE c.id").
+		WithArgs(2).
+		WillReturnRows(commentRows().AddRow(commentRow(2, 1, 1, 1, "Reply", 0, now)...))
+	mock.ExpectCommit()
+	// Approval sends the notifications that were withheld while it was held
+	mock.ExpectQuery("SELECT DISTINCT ON \\(r.user_id\\) r.user_id, r.type").
+		WithArgs(1, 1, "{}", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "type"}).AddRow(3, "comment_reply"))
+
+	req := httptest.NewRequest("PUT", "/api/comments/2/moderation", bytes.NewBufferString(`{"status":"published"}`))
+	req = mux.SetURLVars(req, map[string]string{"id": "2"})
+	req = withUser(req, 5)
+	w := httptest.NewRecorder()
+
+	handler.ModerateComment(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(notifier.sent) != 1 || notifier.sent[0].UserID != 3 {
+		t.Errorf("Expected a notification for user 3, got %+v", notifier.sent)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestModerateComment_NotVideoOwner(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewCommentHandler(db, nil)
+
+	// Authors can't pin their own comments
+	mock.ExpectQuery("SELECT (.+) FROM comments c INNER JOIN videos v").
+		WithArgs(1).
+		WillReturnRows(accessRows(1, nil, "published", 5, "[]"))
+	mock.ExpectQuery("SELECT CASE WHEN ch.user_id").
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow(""))
+
+	req := httptest.NewRequest("PUT", "/api/comments/1/moderation", bytes.NewBufferString(`{"pinned":true}`))
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	req = withUser(req, 1)
+	w := httptest.NewRecorder()
+
+	handler.ModerateComment(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestGetHeldComments(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewCommentHandler(db, nil)
+
+	now := time.Now()
+	held := commentRow(4, 2, nil, 0, "spam spam", 0, now)
+	held[12] = "held"
+
+	mock.ExpectQuery("SELECT (.+) FROM channels WHERE id").
+		WithArgs(3).
+		WillReturnRows(channelRows().AddRow(3, 5, "mine", "Mine", "", "", "", []byte("[]"), 0, now, now))
+	mock.ExpectQuery("SELECT (.+) WHERE v.channel_id = \\$1 AND c.status = 'held'").
+		WithArgs(3, 21).
+		WillReturnRows(commentRows().AddRow(held...))
+
+	req := httptest.NewRequest("GET", "/api/channels/3/comments/held", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "3"})
+	req = withUser(req, 5)
+	w := httptest.NewRecorder()
+
+	handler.GetHeldComments(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var page models.CommentPage
+	json.NewDecoder(w.Body).Decode(&page)
+
+	if len(page.Comments) != 1 || page.Comments[0].Status != "held" {
+		t.Errorf("Unexpected queue: %+v", page.Comments)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestLikeComment(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewCommentHandler(db, nil)
+
+	mock.ExpectQuery("INSERT INTO comment_likes (.+) ON CONFLICT DO NOTHING (.+) UPDATE comments SET likes").
+		WithArgs(1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"likes"}).AddRow(6))
+
+	req := httptest.NewRequest("POST", "/api/comments/1/like", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	req = withUser(req, 2)
+	w := httptest.NewRecorder()
+
+	handler.LikeComment(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var resp map[string]int
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	if resp["likes"] != 6 {
+		t.Errorf("Expected 6 likes, got %d", resp["likes"])
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestContainsBlockedWord(t *testing.T) {
+	words := []string{"spam", "cheap pills"}
+
+	tests := []struct {
+		content string
+		want    bool
+	}{
+		{"This is SPAM!", true},
+		{"buy cheap   pills now", true},
+		{"spammy but fine", false},
+		{"cheap, but no pills here", false},
+		{"Great video", false},
+	}
+
+	for _, tt := range tests {
+		if got := containsBlockedWord(tt.content, words); got != tt.want {
+			t.Errorf("containsBlockedWord(%q) = %v, want %v", tt.content, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/aung-arata/youtube-clone/backend/internal/models"
+	"github.com/lib/pq"
+)
+
+const (
+	// maxMentionsPerComment caps how many users a single comment can notify
+	maxMentionsPerComment = 10
+	// maxNotificationExcerpt is how much of a comment is quoted in a notification
+	maxNotificationExcerpt = 200
+)
+
+// mentionPattern matches @username at the start of the text or after a
+// character that can't be part of a username (so emails don't match)
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@.])@([\w.-]{1,50})`)
+
+// parseMentions returns the distinct lowercased usernames mentioned in content
+func parseMentions(content string) []string {
+	seen := make(map[string]bool)
+	mentions := []string{}
+
+	for _, match := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		username := strings.ToLower(strings.TrimRight(match[1], ".-"))
+		if username == "" || seen[username] {
+			continue
+		}
+		seen[username] = true
+		mentions = append(mentions, username)
+		if len(mentions) == maxMentionsPerComment {
+			break
+		}
+	}
+	return mentions
+}
+
+// notificationExcerpt shortens comment content for a notification message
+func notificationExcerpt(content string) string {
+	runes := []rune(strings.TrimSpace(content))
+	if len(runes) <= maxNotificationExcerpt {
+		return string(runes)
+	}
+	return string(runes[:maxNotificationExcerpt-1]) + "…"
+}
+
+// notifyCommentRecipients tells the parent comment's author, mentioned users
+// and the video's creator about a published comment. Each user gets at most
+// one notification, preferring reply over mention over new comment. The
+// comment's author is never notified, nor is anyone who has muted them.
+// Replies to one comment, and comments on one video, are grouped until read.
+func notifyCommentRecipients(ctx context.Context, db *sql.DB, notifier Notifier, c models.Comment) error {
+	if c.Status != models.CommentStatusPublished || notifier == nil {
+		return nil
+	}
+
+	query := `
+		WITH recipients AS (
+			SELECT p.user_id, 'comment_reply' AS type, 1 AS priority
+			FROM comments p WHERE p.id = $2
+			UNION ALL
+			SELECT u.id, 'mention', 2
+			FROM users u WHERE LOWER(u.username) = ANY($3)
+			UNION ALL
+			SELECT ch.user_id, 'video_comment', 3
+			FROM videos v INNER JOIN channels ch ON ch.id = v.channel_id
+			WHERE v.id = $4
+		)
+		SELECT DISTINCT ON (r.user_id) r.user_id, r.type
+		FROM recipients r
+		WHERE r.user_id <> $1
+		  AND NOT EXISTS (
+		      SELECT 1 FROM user_mutes m WHERE m.user_id = r.user_id AND m.muted_user_id = $1
+		  )
+		ORDER BY r.user_id, r.priority
+	`
+	rows, err := db.QueryContext(ctx, query, c.UserID, c.ParentID, pq.Array(parseMentions(c.Content)), c.VideoID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var reqs []models.CreateNotificationRequest
+	for rows.Next() {
+		req := models.CreateNotificationRequest{
+			Message: notificationExcerpt(c.Content),
+			Link:    "/videos/" + strconv.Itoa(c.VideoID) + "?comment=" + strconv.Itoa(c.ID),
+		}
+		if err := rows.Scan(&req.UserID, &req.Type); err != nil {
+			return err
+		}
+		switch req.Type {
+		case "comment_reply":
+			req.Title = c.AuthorUsername + " replied to your comment"
+			if c.ParentID != nil {
+				req.CollapseKey = "comment_reply:" + strconv.Itoa(*c.ParentID)
+				req.GroupTitle = "{count} new replies to your comment"
+			}
+		case "mention":
+			req.Title = c.AuthorUsername + " mentioned you in a comment"
+		default:
+			req.Title = c.AuthorUsername + " commented on your video"
+			req.CollapseKey = "video_comment:" + strconv.Itoa(c.VideoID)
+			req.GroupTitle = "{count} new comments on your video"
+		}
+		reqs = append(reqs, req)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	for _, req := range reqs {
+		if _, err := notifier.Dispatch(ctx, req); err != nil {
+			log.Printf("Failed to notify user %d of comment %d: %v", req.UserID, c.ID, err)
+		}
+	}
+	return nil
+}
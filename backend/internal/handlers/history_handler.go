@@ -96,7 +96,7 @@ func (h *HistoryHandler) GetHistory(w http.ResponseWriter, r *http.Request) {
 	query := `
 		SELECT 
 			v.id, v.title, v.description, v.url, v.thumbnail, 
-			v.channel_name, v.channel_avatar, v.views, v.likes, v.dislikes, 
+			v.channel_id, v.channel_name, v.channel_avatar, v.views, v.likes, v.dislikes, 
 			v.category, v.duration, v.uploaded_at, v.created_at, v.updated_at,
 			wh.watched_at
 		FROM watch_history wh
@@ -123,7 +123,7 @@ func (h *HistoryHandler) GetHistory(w http.ResponseWriter, r *http.Request) {
 		var item VideoWithHistory
 		err := rows.Scan(
 			&item.ID, &item.Title, &item.Description, &item.URL, &item.Thumbnail,
-			&item.ChannelName, &item.ChannelAvatar, &item.Views, &item.Likes, &item.Dislikes,
+			&item.ChannelID, &item.ChannelName, &item.ChannelAvatar, &item.Views, &item.Likes, &item.Dislikes,
 			&item.Category, &item.Duration, &item.UploadedAt, &item.CreatedAt, &item.UpdatedAt,
 			&item.WatchedAt)
 		if err != nil {
@@ -58,11 +58,11 @@ func TestGetHistory(t *testing.T) {
 	now := time.Now()
 	rows := sqlmock.NewRows([]string{
 		"id", "title", "description", "url", "thumbnail",
-		"channel_name", "channel_avatar", "views", "likes", "dislikes",
+		"channel_id", "channel_name", "channel_avatar", "views", "likes", "dislikes",
 		"category", "duration", "uploaded_at", "created_at", "updated_at", "watched_at",
 	}).AddRow(
 		1, "Test Video", "Description", "http://example.com/video.mp4",
-		"http://example.com/thumb.jpg", "Test Channel", "http://example.com/avatar.jpg",
+		"http://example.com/thumb.jpg", 1, "Test Channel", "http://example.com/avatar.jpg",
 		100, 5, 1, "Education", "10:00", now, now, now, now,
 	)
 
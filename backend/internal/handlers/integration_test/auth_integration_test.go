@@ -6,6 +6,7 @@ import (
 "net/http"
 "net/http/httptest"
 "testing"
+"time"
 
 "github.com/aung-arata/youtube-clone/backend/internal/handlers"
 "github.com/DATA-DOG/go-sqlmock"
@@ -19,7 +20,7 @@ t.Fatalf("Failed to create mock database: %v", err)
 }
 defer db.Close()
 
-handler := handlers.NewAuthHandler(db)
+handler := handlers.NewAuthHandler(db, nil)
 
 // Test successful signup
 t.Run("Successful Signup", func(t *testing.T) {
@@ -37,13 +38,24 @@ WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
 
 // Mock database insert
 mock.ExpectQuery("INSERT INTO users").
-WithArgs("testuser", "test@example.com", sqlmock.AnyArg(), "https://example.com/avatar.jpg", "user").
+WithArgs("testuser", "test@example.com", sqlmock.AnyArg(), "https://example.com/avatar.jpg", "creator").
 WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email", "avatar", "role", "plan_id", "created_at", "updated_at"}).
-AddRow(1, "testuser", "test@example.com", "https://example.com/avatar.jpg", "user", nil, "2024-01-01", "2024-01-01"))
+AddRow(1, "testuser", "test@example.com", "https://example.com/avatar.jpg", "creator", nil, time.Now(), time.Now()))
+
+// Mock the session and refresh token for the new login
+mock.ExpectBegin()
+mock.ExpectExec("INSERT INTO sessions").
+WithArgs(sqlmock.AnyArg(), 1, "test-agent", "Unknown browser", "192.0.2.1", false, sqlmock.AnyArg()).
+WillReturnResult(sqlmock.NewResult(1, 1))
+mock.ExpectExec("INSERT INTO refresh_tokens").
+WithArgs(1, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+WillReturnResult(sqlmock.NewResult(1, 1))
+mock.ExpectCommit()
 
 body, _ := json.Marshal(signupData)
 req := httptest.NewRequest(http.MethodPost, "/auth/signup", bytes.NewBuffer(body))
 req.Header.Set("Content-Type", "application/json")
+req.Header.Set("User-Agent", "test-agent")
 w := httptest.NewRecorder()
 
 handler.Signup(w, req)
@@ -61,6 +73,9 @@ t.Error("Expected token in response")
 if response["user"] == nil {
 t.Error("Expected user in response")
 }
+if response["refresh_token"] == nil {
+t.Error("Expected refresh token in response")
+}
 })
 
 // Test signup with existing email
@@ -116,7 +131,7 @@ t.Fatalf("Failed to create mock database: %v", err)
 }
 defer db.Close()
 
-handler := handlers.NewAuthHandler(db)
+handler := handlers.NewAuthHandler(db, nil)
 
 // Test successful login
 t.Run("Successful Login", func(t *testing.T) {
@@ -128,11 +143,17 @@ loginData := map[string]interface{}{
 // Hash for "password123"
 hashedPassword := "$2a$10$YourHashedPasswordHere"
 
-// Mock database query
-mock.ExpectQuery("SELECT id, username, email, password, avatar, role, plan_id, created_at, updated_at FROM users").
+// Mock database queries
+mock.ExpectQuery("FROM login_attempts WHERE email = \\$1").
+WithArgs("test@example.com", sqlmock.AnyArg()).
+WillReturnRows(sqlmock.NewRows([]string{"count", "elapsed"}).AddRow(0, 0))
+mock.ExpectQuery("FROM login_attempts WHERE ip_address = \\$1").
+WithArgs("192.0.2.1", sqlmock.AnyArg()).
+WillReturnRows(sqlmock.NewRows([]string{"count", "elapsed"}).AddRow(0, 0))
+mock.ExpectQuery("SELECT id, username, email, password, avatar, role, plan_id, created_at, updated_at, totp_enabled, email_verified_at IS NOT NULL FROM users").
 WithArgs("test@example.com").
-WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email", "password", "avatar", "role", "plan_id", "created_at", "updated_at"}).
-AddRow(1, "testuser", "test@example.com", hashedPassword, "", "user", nil, "2024-01-01", "2024-01-01"))
+WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email", "password", "avatar", "role", "plan_id", "created_at", "updated_at", "totp_enabled", "email_verified"}).
+AddRow(1, "testuser", "test@example.com", hashedPassword, "", "user", nil, "2024-01-01", "2024-01-01", false, true))
 
 body, _ := json.Marshal(loginData)
 req := httptest.NewRequest(http.MethodPost, "/auth/login", bytes.NewBuffer(body))
@@ -155,11 +176,24 @@ loginData := map[string]interface{}{
 "password": "password123",
 }
 
+// No earlier failures
+mock.ExpectQuery("FROM login_attempts WHERE email = \\$1").
+WithArgs("nonexistent@example.com", sqlmock.AnyArg()).
+WillReturnRows(sqlmock.NewRows([]string{"count", "elapsed"}).AddRow(0, 0))
+mock.ExpectQuery("FROM login_attempts WHERE ip_address = \\$1").
+WithArgs("192.0.2.1", sqlmock.AnyArg()).
+WillReturnRows(sqlmock.NewRows([]string{"count", "elapsed"}).AddRow(0, 0))
+
 // Mock database query returns no rows
-mock.ExpectQuery("SELECT id, username, email, password, avatar, role, plan_id, created_at, updated_at FROM users").
+mock.ExpectQuery("SELECT id, username, email, password, avatar, role, plan_id, created_at, updated_at, totp_enabled, email_verified_at IS NOT NULL FROM users").
 WithArgs("nonexistent@example.com").
 WillReturnRows(sqlmock.NewRows([]string{}))
 
+// The failure is recorded without a user
+mock.ExpectExec("INSERT INTO login_attempts").
+WithArgs("nonexistent@example.com", nil, "192.0.2.1", "", false).
+WillReturnResult(sqlmock.NewResult(1, 1))
+
 body, _ := json.Marshal(loginData)
 req := httptest.NewRequest(http.MethodPost, "/auth/login", bytes.NewBuffer(body))
 req.Header.Set("Content-Type", "application/json")
@@ -171,4 +205,34 @@ if w.Code != http.StatusUnauthorized {
 t.Errorf("Expected status 401, got %d", w.Code)
 }
 })
+
+// Test login while the account is locked out
+t.Run("Login while locked out", func(t *testing.T) {
+loginData := map[string]interface{}{
+"email":    "test@example.com",
+"password": "password123",
+}
+
+// The password isn't checked at all
+mock.ExpectQuery("FROM login_attempts WHERE email = \\$1").
+WithArgs("test@example.com", sqlmock.AnyArg()).
+WillReturnRows(sqlmock.NewRows([]string{"count", "elapsed"}).AddRow(10, 60.0))
+mock.ExpectQuery("FROM login_attempts WHERE ip_address = \\$1").
+WithArgs("192.0.2.1", sqlmock.AnyArg()).
+WillReturnRows(sqlmock.NewRows([]string{"count", "elapsed"}).AddRow(10, 60.0))
+
+body, _ := json.Marshal(loginData)
+req := httptest.NewRequest(http.MethodPost, "/auth/login", bytes.NewBuffer(body))
+req.Header.Set("Content-Type", "application/json")
+w := httptest.NewRecorder()
+
+handler.Login(w, req)
+
+if w.Code != http.StatusTooManyRequests {
+t.Errorf("Expected status 429, got %d", w.Code)
+}
+if got := w.Header().Get("Retry-After"); got != "840" {
+t.Errorf("Expected Retry-After 840, got %q", got)
+}
+})
 }
@@ -8,6 +8,7 @@ import (
 "net/http/httptest"
 "os"
 "testing"
+"time"
 
 "github.com/aung-arata/youtube-clone/backend/internal/handlers"
 "github.com/aung-arata/youtube-clone/backend/internal/middleware"
@@ -33,7 +34,7 @@ if err != nil {
 t.Fatalf("Failed to create file storage: %v", err)
 }
 
-handler := handlers.NewUploadHandler(db, fileStorage)
+handler := handlers.NewUploadHandler(db, fileStorage, nil)
 
 t.Run("Successful Video Upload", func(t *testing.T) {
 // Create multipart form data
@@ -44,8 +45,7 @@ writer := multipart.NewWriter(body)
 writer.WriteField("title", "Test Video")
 writer.WriteField("description", "Test Description")
 writer.WriteField("category", "Technology")
-writer.WriteField("channel_name", "Test Channel")
-writer.WriteField("channel_avatar", "https://example.com/avatar.jpg")
+writer.WriteField("channel_id", "5")
 writer.WriteField("duration", "10:30")
 
 // Add fake video file
@@ -58,10 +58,18 @@ thumbnailWriter.Write([]byte("fake image content"))
 
 writer.Close()
 
+now := time.Now()
+
+// Mock channel ownership lookup
+mock.ExpectQuery("SELECT (.+) FROM channels WHERE id").
+WithArgs(5).
+WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "handle", "display_name", "avatar", "banner", "description", "links", "subscriber_count", "created_at", "updated_at"}).
+AddRow(5, 1, "test-channel", "Test Channel", "https://example.com/avatar.jpg", "", "", []byte("[]"), 0, now, now))
+
 // Mock database insert
 mock.ExpectQuery("INSERT INTO videos").
-WillReturnRows(sqlmock.NewRows([]string{"id", "title", "description", "url", "thumbnail", "channel_name", "channel_avatar", "views", "likes", "dislikes", "category", "duration", "uploaded_at", "created_at", "updated_at"}).
-AddRow(1, "Test Video", "Test Description", "/uploads/videos/test.mp4", "/uploads/thumbnails/thumbnail.jpg", "Test Channel", "https://example.com/avatar.jpg", 0, 0, 0, "Technology", "10:30", "2024-01-01", "2024-01-01", "2024-01-01"))
+WillReturnRows(sqlmock.NewRows([]string{"id", "title", "description", "url", "thumbnail", "channel_id", "channel_name", "channel_avatar", "views", "likes", "dislikes", "category", "duration", "uploaded_at", "created_at", "updated_at"}).
+AddRow(1, "Test Video", "Test Description", "/uploads/videos/test.mp4", "/uploads/thumbnails/thumbnail.jpg", 5, "Test Channel", "https://example.com/avatar.jpg", 0, 0, 0, "Technology", "10:30", now, now, now))
 
 req := httptest.NewRequest(http.MethodPost, "/upload/video", body)
 req.Header.Set("Content-Type", writer.FormDataContentType())
@@ -79,6 +87,38 @@ t.Errorf("Expected status 201, got %d. Body: %s", w.Code, w.Body.String())
 }
 })
 
+t.Run("Upload to a channel owned by someone else", func(t *testing.T) {
+body := &bytes.Buffer{}
+writer := multipart.NewWriter(body)
+writer.WriteField("title", "Test Video")
+writer.WriteField("channel_id", "6")
+videoWriter, _ := writer.CreateFormFile("video", "test.mp4")
+videoWriter.Write([]byte("fake video content"))
+writer.Close()
+
+now := time.Now()
+mock.ExpectQuery("SELECT (.+) FROM channels WHERE id").
+WithArgs(6).
+WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "handle", "display_name", "avatar", "banner", "description", "links", "subscriber_count", "created_at", "updated_at"}).
+AddRow(6, 2, "other-channel", "Other Channel", "", "", "", []byte("[]"), 0, now, now))
+mock.ExpectQuery("SELECT role FROM channel_members").
+WithArgs(6, 1).
+WillReturnRows(sqlmock.NewRows([]string{"role"}))
+
+req := httptest.NewRequest(http.MethodPost, "/upload/video", body)
+req.Header.Set("Content-Type", writer.FormDataContentType())
+ctx := context.WithValue(req.Context(), middleware.UserIDKey, 1)
+req = req.WithContext(ctx)
+
+w := httptest.NewRecorder()
+
+handler.UploadVideo(w, req)
+
+if w.Code != http.StatusForbidden {
+t.Errorf("Expected status 403, got %d. Body: %s", w.Code, w.Body.String())
+}
+})
+
 t.Run("Upload without authentication", func(t *testing.T) {
 body := &bytes.Buffer{}
 writer := multipart.NewWriter(body)
@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/aung-arata/youtube-clone/backend/internal/models"
+	"github.com/aung-arata/youtube-clone/backend/internal/moderation"
+	"github.com/gorilla/mux"
+)
+
+// ModerationHandler serves the admin side of the comment spam filter: the
+// site-wide review queue and the filter's settings
+type ModerationHandler struct {
+	db       *sql.DB
+	notifier Notifier
+}
+
+// NewModerationHandler creates a ModerationHandler. Without a notifier,
+// approving a comment notifies nobody.
+func NewModerationHandler(db *sql.DB, notifier Notifier) *ModerationHandler {
+	return &ModerationHandler{db: db, notifier: notifier}
+}
+
+// loadCommentFilterSettings returns the spam filter settings, falling back to
+// the defaults until an admin has saved any
+func loadCommentFilterSettings(db *sql.DB) (models.CommentFilterSettings, error) {
+	query := `
+		SELECT hold_threshold, reject_threshold, blocked_terms, updated_at
+		FROM comment_filter_settings
+		WHERE id = 1
+	`
+
+	var s models.CommentFilterSettings
+	var blockedTerms []byte
+	err := db.QueryRow(query).Scan(&s.HoldThreshold, &s.RejectThreshold, &blockedTerms, &s.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return models.CommentFilterSettings{
+			HoldThreshold:   moderation.DefaultThresholds.Hold,
+			RejectThreshold: moderation.DefaultThresholds.Reject,
+			BlockedTerms:    []string{},
+		}, nil
+	} else if err != nil {
+		return s, err
+	}
+
+	s.BlockedTerms, err = decodeBlockedWords(blockedTerms)
+	return s, err
+}
+
+// GetFilterSettings returns the spam filter's thresholds and blocklist
+func (h *ModerationHandler) GetFilterSettings(w http.ResponseWriter, r *http.Request) {
+	settings, err := loadCommentFilterSettings(h.db)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+// UpdateFilterSettings replaces the spam filter's thresholds and blocklist.
+// New settings apply to comments posted from then on.
+func (h *ModerationHandler) UpdateFilterSettings(w http.ResponseWriter, r *http.Request) {
+	var req models.CommentFilterSettings
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	thresholds := moderation.Thresholds{Hold: req.HoldThreshold, Reject: req.RejectThreshold}
+	if !thresholds.Valid() {
+		http.Error(w, "Thresholds must satisfy 0 < hold_threshold <= reject_threshold <= 1", http.StatusBadRequest)
+		return
+	}
+
+	terms, err := normalizeBlockedWords(req.BlockedTerms)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	raw, err := json.Marshal(terms)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	query := `
+		INSERT INTO comment_filter_settings (id, hold_threshold, reject_threshold, blocked_terms, updated_at)
+		VALUES (1, $1, $2, $3, CURRENT_TIMESTAMP)
+		ON CONFLICT (id) DO UPDATE
+		SET hold_threshold = EXCLUDED.hold_threshold,
+		    reject_threshold = EXCLUDED.reject_threshold,
+		    blocked_terms = EXCLUDED.blocked_terms,
+		    updated_at = EXCLUDED.updated_at
+		RETURNING updated_at
+	`
+
+	settings := models.CommentFilterSettings{
+		HoldThreshold:   req.HoldThreshold,
+		RejectThreshold: req.RejectThreshold,
+		BlockedTerms:    terms,
+	}
+	if err := h.db.QueryRow(query, req.HoldThreshold, req.RejectThreshold, raw).Scan(&settings.UpdatedAt); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+// GetReviewQueue returns held (or, with ?status=rejected, rejected) comments
+// across the whole site, newest first, together with the filter's verdicts
+func (h *ModerationHandler) GetReviewQueue(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = models.CommentStatusHeld
+	}
+	if status != models.CommentStatusHeld && status != models.CommentStatusRejected {
+		http.Error(w, "Status must be held or rejected", http.StatusBadRequest)
+		return
+	}
+
+	limit := parseLimit(r.URL.Query().Get("limit"), 20, 100)
+
+	query := `SELECT ` + commentColumns + `, cv.score, cv.decision, cv.signals, cv.created_at
+		FROM comments c` + commentAuthorJoin + `
+		LEFT JOIN comment_verdicts cv ON cv.comment_id = c.id
+		WHERE c.status = $1`
+	args := []interface{}{status}
+
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		createdAt, id, err := decodeCursor(cursor)
+		if err != nil {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		query += " AND (c.created_at, c.id) < ($2, $3)"
+		args = append(args, createdAt, id)
+	}
+
+	query += " ORDER BY c.created_at DESC, c.id DESC LIMIT $" + strconv.Itoa(len(args)+1)
+	args = append(args, limit+1)
+
+	rows, err := h.db.Query(query, args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	page := models.ReviewPage{Comments: []models.ReviewComment{}}
+	for rows.Next() {
+		var item models.ReviewComment
+		var score sql.NullFloat64
+		var decision sql.NullString
+		var signals []byte
+		var judgedAt sql.NullTime
+
+		c := &item.Comment
+		err := rows.Scan(&c.ID, &c.VideoID, &c.UserID, &c.AuthorUsername, &c.AuthorAvatar,
+			&c.ParentID, &c.Depth, &c.Content, &c.Likes, &c.ReplyCount, &c.IsPinned, &c.IsHearted, &c.Status,
+			&c.CreatedAt, &c.UpdatedAt, &score, &decision, &signals, &judgedAt)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// Comments held before the filter existed have no verdict
+		if score.Valid {
+			item.Verdict = &models.CommentVerdict{
+				Score:     score.Float64,
+				Decision:  decision.String,
+				Signals:   json.RawMessage(signals),
+				CreatedAt: judgedAt.Time,
+			}
+		}
+		page.Comments = append(page.Comments, item)
+	}
+
+	if len(page.Comments) > limit {
+		page.Comments = page.Comments[:limit]
+		last := page.Comments[limit-1]
+		page.NextCursor = encodeCursor(last.CreatedAt, last.ID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// ReviewComment lets an admin publish, hide or reject any comment, overruling
+// the spam filter and the channel's own moderation
+func (h *ModerationHandler) ReviewComment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid comment ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	switch req.Status {
+	case models.CommentStatusPublished, models.CommentStatusHidden, models.CommentStatusRejected:
+	default:
+		http.Error(w, "Status must be published, hidden or rejected", http.StatusBadRequest)
+		return
+	}
+
+	var parentID *int
+	var from string
+	err = h.db.QueryRow(`SELECT parent_id, status FROM comments WHERE id = $1`, id).Scan(&parentID, &from)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := setCommentStatus(tx, id, parentID, from, req.Status); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Only published comments can stay pinned
+	c, err := scanComment(tx.QueryRow(`
+		WITH c AS (
+			UPDATE comments SET is_pinned = is_pinned AND status = 'published'
+			WHERE id = $1
+			RETURNING *
+		)
+		SELECT `+commentColumns+` FROM c`+commentAuthorJoin, id))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Approving a comment sends the notifications it was held back from
+	if (from == models.CommentStatusHeld || from == models.CommentStatusRejected) && c.Status == models.CommentStatusPublished {
+		if err := notifyCommentRecipients(r.Context(), h.db, h.notifier, c); err != nil {
+			log.Printf("Failed to send notifications for comment %d: %v", c.ID, err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
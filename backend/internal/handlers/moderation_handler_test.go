@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/aung-arata/youtube-clone/backend/internal/models"
+	"github.com/gorilla/mux"
+)
+
+func TestUpdateFilterSettings(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewModerationHandler(db, nil)
+
+	mock.ExpectQuery("INSERT INTO comment_filter_settings (.+) ON CONFLICT").
+		WithArgs(0.5, 0.8, []byte(`["free followers"]`)).
+		WillReturnRows(sqlmock.NewRows([]string{"updated_at"}).AddRow(time.Now()))
+
+	body := `{"hold_threshold":0.5,"reject_threshold":0.8,"blocked_terms":[" Free Followers ","free followers"]}`
+	req := httptest.NewRequest("PUT", "/api/admin/comment-filter", bytes.NewBufferString(body))
+	w := httptest.NewRecorder()
+
+	handler.UpdateFilterSettings(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestUpdateFilterSettings_InvalidThresholds(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewModerationHandler(db, nil)
+
+	for _, body := range []string{
+		`{"hold_threshold":0.9,"reject_threshold":0.5}`,
+		`{"hold_threshold":0,"reject_threshold":0.5}`,
+		`{"hold_threshold":0.5,"reject_threshold":1.5}`,
+	} {
+		req := httptest.NewRequest("PUT", "/api/admin/comment-filter", bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+
+		handler.UpdateFilterSettings(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", body, w.Code)
+		}
+	}
+}
+
+func TestGetReviewQueue(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewModerationHandler(db, nil)
+
+	now := time.Now()
+	columns := []string{
+		"id", "video_id", "user_id", "username", "avatar", "parent_id", "depth", "content",
+		"likes", "reply_count", "is_pinned", "is_hearted", "status", "created_at", "updated_at",
+		"score", "decision", "signals", "judged_at",
+	}
+	held := append(commentRow(3, 2, nil, 0, "check www.example.com", 0, now),
+		0.62, "hold", []byte(`[{"classifier":"link_density","score":0.62}]`), now)
+	held[12] = "held"
+	legacy := append(commentRow(2, 4, nil, 0, "older", 0, now.Add(-time.Hour)), nil, nil, nil, nil)
+	legacy[12] = "held"
+
+	mock.ExpectQuery("SELECT (.+) LEFT JOIN comment_verdicts cv (.+) WHERE c.status = \\$1 ORDER BY c.created_at DESC").
+		WithArgs("held", 21).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(held...).AddRow(legacy...))
+
+	req := httptest.NewRequest("GET", "/api/admin/comments/review", nil)
+	w := httptest.NewRecorder()
+
+	handler.GetReviewQueue(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var page models.ReviewPage
+	json.NewDecoder(w.Body).Decode(&page)
+
+	if len(page.Comments) != 2 || page.Comments[0].Verdict == nil || page.Comments[0].Verdict.Score != 0.62 {
+		t.Fatalf("Unexpected page: %+v", page)
+	}
+	if page.Comments[1].Verdict != nil {
+		t.Errorf("Expected no verdict for a comment held before the filter, got %+v", page.Comments[1].Verdict)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestReviewComment_ApproveRejected(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	notifier := &recordingNotifier{}
+	handler := NewModerationHandler(db, notifier)
+
+	now := time.Now()
+	mock.ExpectQuery("SELECT parent_id, status FROM comments").
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"parent_id", "status"}).AddRow(nil, "rejected"))
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE comments SET status").
+		WithArgs("published", 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("UPDATE comments SET is_pinned = is_pinned AND status = 'published'").
+		WithArgs(3).
+		WillReturnRows(commentRows().AddRow(commentRow(3, 2, nil, 0, "Not spam after all", 0, now)...))
+	mock.ExpectCommit()
+	mock.ExpectQuery("SELECT DISTINCT ON \\(r.user_id\\) r.user_id, r.type").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "type"}).AddRow(5, "video_comment"))
+
+	req := httptest.NewRequest("PUT", "/api/admin/comments/3/review", bytes.NewBufferString(`{"status":"published"}`))
+	req = mux.SetURLVars(req, map[string]string{"id": "3"})
+	w := httptest.NewRecorder()
+
+	handler.ReviewComment(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(notifier.sent) != 1 || notifier.sent[0].UserID != 5 {
+		t.Errorf("Expected a notification for user 5, got %+v", notifier.sent)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
@@ -1,24 +1,49 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"strings"
 
+	"github.com/aung-arata/youtube-clone/backend/internal/middleware"
 	"github.com/aung-arata/youtube-clone/backend/internal/models"
+	"github.com/aung-arata/youtube-clone/backend/internal/notify"
 	"github.com/gorilla/mux"
+	"github.com/lib/pq"
 )
 
+const (
+	// maxCollapseKeyLength matches the notifications.collapse_key column
+	maxCollapseKeyLength = 255
+
+	// maxBulkDelete is how many notifications one bulk delete may name
+	maxBulkDelete = 100
+)
+
+// Notifier delivers a notification on the channels its recipient chose.
+// *notify.Dispatcher is the implementation; every notification goes through
+// one so that preferences, quiet hours and grouping apply alike.
+type Notifier interface {
+	Dispatch(ctx context.Context, req models.CreateNotificationRequest) (notify.Result, error)
+}
+
 type NotificationHandler struct {
-	db *sql.DB
+	db         *sql.DB
+	dispatcher *notify.Dispatcher
 }
 
-func NewNotificationHandler(db *sql.DB) *NotificationHandler {
-	return &NotificationHandler{db: db}
+// NewNotificationHandler creates a handler that delivers new notifications
+// through dispatcher
+func NewNotificationHandler(db *sql.DB, dispatcher *notify.Dispatcher) *NotificationHandler {
+	return &NotificationHandler{db: db, dispatcher: dispatcher}
 }
 
-// GetUserNotifications returns all notifications for a user
+// GetUserNotifications returns a page of a user's notifications, newest
+// first. Archived notifications are left out unless archived=true, which
+// lists only them.
 func (h *NotificationHandler) GetUserNotifications(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	userID, err := strconv.Atoi(vars["userId"])
@@ -27,29 +52,36 @@ func (h *NotificationHandler) GetUserNotifications(w http.ResponseWriter, r *htt
 		return
 	}
 
-	// Parse query parameters
-	unreadOnly := r.URL.Query().Get("unread") == "true"
-	limitStr := r.URL.Query().Get("limit")
-	limit := 50
-	if limitStr != "" {
-		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 100 {
-			limit = l
-		}
-	}
+	params := r.URL.Query()
+	limit := parseLimit(params.Get("limit"), 50, 100)
 
 	query := `
-		SELECT id, user_id, type, title, message, link, is_read, created_at
+		SELECT id, user_id, type, title, message, link, collapse_key, group_count, is_read, archived_at, created_at
 		FROM notifications
 		WHERE user_id = $1
 	`
-	
 	args := []interface{}{userID}
-	if unreadOnly {
+
+	if params.Get("archived") == "true" {
+		query += " AND archived_at IS NOT NULL"
+	} else {
+		query += " AND archived_at IS NULL"
+	}
+	if params.Get("unread") == "true" {
 		query += " AND is_read = FALSE"
 	}
-	
-	query += " ORDER BY created_at DESC LIMIT $2"
-	args = append(args, limit)
+	if cursor := params.Get("cursor"); cursor != "" {
+		createdAt, id, err := decodeCursor(cursor)
+		if err != nil {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		query += " AND (created_at, id) < ($2, $3)"
+		args = append(args, createdAt, id)
+	}
+
+	query += " ORDER BY created_at DESC, id DESC LIMIT $" + strconv.Itoa(len(args)+1)
+	args = append(args, limit+1)
 
 	rows, err := h.db.Query(query, args...)
 	if err != nil {
@@ -58,31 +90,41 @@ func (h *NotificationHandler) GetUserNotifications(w http.ResponseWriter, r *htt
 	}
 	defer rows.Close()
 
-	var notifications []models.Notification
+	page := models.NotificationPage{Notifications: []models.Notification{}}
 	for rows.Next() {
 		var n models.Notification
-		var link sql.NullString
-		err := rows.Scan(&n.ID, &n.UserID, &n.Type, &n.Title, &n.Message, 
-			&link, &n.IsRead, &n.CreatedAt)
+		var link, collapseKey sql.NullString
+		var archivedAt sql.NullTime
+		err := rows.Scan(&n.ID, &n.UserID, &n.Type, &n.Title, &n.Message,
+			&link, &collapseKey, &n.GroupCount, &n.IsRead, &archivedAt, &n.CreatedAt)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		if link.Valid {
-			n.Link = link.String
+		n.Link = link.String
+		n.CollapseKey = collapseKey.String
+		if archivedAt.Valid {
+			n.ArchivedAt = &archivedAt.Time
 		}
-		notifications = append(notifications, n)
+		page.Notifications = append(page.Notifications, n)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	if notifications == nil {
-		notifications = []models.Notification{}
+	if len(page.Notifications) > limit {
+		page.Notifications = page.Notifications[:limit]
+		last := page.Notifications[limit-1]
+		page.NextCursor = encodeCursor(last.CreatedAt, last.ID)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(notifications)
+	json.NewEncoder(w).Encode(page)
 }
 
-// CreateNotification creates a new notification
+// CreateNotification delivers a notification on the channels the recipient
+// has enabled for its type
 func (h *NotificationHandler) CreateNotification(w http.ResponseWriter, r *http.Request) {
 	var req models.CreateNotificationRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -95,36 +137,41 @@ func (h *NotificationHandler) CreateNotification(w http.ResponseWriter, r *http.
 		http.Error(w, "Missing required fields", http.StatusBadRequest)
 		return
 	}
+	if len(req.CollapseKey) > maxCollapseKeyLength {
+		http.Error(w, "Collapse key is too long", http.StatusBadRequest)
+		return
+	}
 
-	query := `
-		INSERT INTO notifications (user_id, type, title, message, link)
-		VALUES ($1, $2, $3, $4, $5)
-		RETURNING id, created_at
-	`
-
-	var notification models.Notification
-	err := h.db.QueryRow(query, req.UserID, req.Type, req.Title, req.Message, 
-		sql.NullString{String: req.Link, Valid: req.Link != ""}).
-		Scan(&notification.ID, &notification.CreatedAt)
+	result, err := h.dispatcher.Dispatch(r.Context(), req)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	notification.UserID = req.UserID
-	notification.Type = req.Type
-	notification.Title = req.Title
-	notification.Message = req.Message
-	notification.Link = req.Link
-	notification.IsRead = false
-
 	w.Header().Set("Content-Type", "application/json")
+	if !result.Stored {
+		// The recipient doesn't keep this type in-app, so there is no
+		// notification to return
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message":  "Notification delivered without being stored",
+			"channels": result.Channels,
+		})
+		return
+	}
+
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(notification)
+	json.NewEncoder(w).Encode(result.Notification)
 }
 
-// MarkAsRead marks a notification as read
+// MarkAsRead marks one of the authenticated user's notifications as read
 func (h *NotificationHandler) MarkAsRead(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
@@ -135,12 +182,12 @@ func (h *NotificationHandler) MarkAsRead(w http.ResponseWriter, r *http.Request)
 	query := `
 		UPDATE notifications
 		SET is_read = TRUE
-		WHERE id = $1
+		WHERE id = $1 AND user_id = $2
 		RETURNING id
 	`
 
 	var notificationID int
-	err = h.db.QueryRow(query, id).Scan(&notificationID)
+	err = h.db.QueryRow(query, id, userID).Scan(&notificationID)
 	if err == sql.ErrNoRows {
 		http.Error(w, "Notification not found", http.StatusNotFound)
 		return
@@ -149,6 +196,8 @@ func (h *NotificationHandler) MarkAsRead(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	h.dispatcher.PublishUnreadCount(r.Context(), userID)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"message": "Notification marked as read",
@@ -178,6 +227,9 @@ func (h *NotificationHandler) MarkAllAsRead(w http.ResponseWriter, r *http.Reque
 	}
 
 	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected > 0 {
+		h.dispatcher.PublishUnreadCount(r.Context(), userID)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -186,6 +238,150 @@ func (h *NotificationHandler) MarkAllAsRead(w http.ResponseWriter, r *http.Reque
 	})
 }
 
+// DeleteNotification deletes one of the authenticated user's notifications
+func (h *NotificationHandler) DeleteNotification(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid notification ID", http.StatusBadRequest)
+		return
+	}
+
+	var wasRead bool
+	err = h.db.QueryRow(`DELETE FROM notifications WHERE id = $1 AND user_id = $2 RETURNING is_read`, id, userID).Scan(&wasRead)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Notification not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !wasRead {
+		h.dispatcher.PublishUnreadCount(r.Context(), userID)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// BulkDeleteNotifications deletes either the listed notifications or, with
+// all_read, every notification the user has read
+func (h *NotificationHandler) BulkDeleteNotifications(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authorizeSettingsOwner(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		IDs     []int `json:"ids"`
+		AllRead bool  `json:"all_read"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var result sql.Result
+	var err error
+	switch {
+	case req.AllRead && len(req.IDs) > 0:
+		http.Error(w, "Send either ids or all_read, not both", http.StatusBadRequest)
+		return
+	case req.AllRead:
+		result, err = h.db.Exec(`DELETE FROM notifications WHERE user_id = $1 AND is_read = TRUE`, userID)
+	case len(req.IDs) == 0:
+		http.Error(w, "No notifications to delete", http.StatusBadRequest)
+		return
+	case len(req.IDs) > maxBulkDelete:
+		http.Error(w, "Too many notifications, the limit is "+strconv.Itoa(maxBulkDelete), http.StatusBadRequest)
+		return
+	default:
+		result, err = h.db.Exec(`DELETE FROM notifications WHERE user_id = $1 AND id = ANY($2)`, userID, pq.Array(req.IDs))
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	deleted, _ := result.RowsAffected()
+	if deleted > 0 && !req.AllRead {
+		h.dispatcher.PublishUnreadCount(r.Context(), userID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Notifications deleted",
+		"deleted": deleted,
+	})
+}
+
+// ArchiveNotification moves one of the authenticated user's notifications
+// out of their list. Archiving also marks it as read.
+func (h *NotificationHandler) ArchiveNotification(w http.ResponseWriter, r *http.Request) {
+	h.setArchived(w, r, true)
+}
+
+// UnarchiveNotification puts an archived notification back in the list
+func (h *NotificationHandler) UnarchiveNotification(w http.ResponseWriter, r *http.Request) {
+	h.setArchived(w, r, false)
+}
+
+func (h *NotificationHandler) setArchived(w http.ResponseWriter, r *http.Request, archive bool) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid notification ID", http.StatusBadRequest)
+		return
+	}
+
+	query := `
+		UPDATE notifications
+		SET archived_at = COALESCE(archived_at, CURRENT_TIMESTAMP), is_read = TRUE
+		WHERE id = $1 AND user_id = $2
+		RETURNING id
+	`
+	message := "Notification archived"
+	if !archive {
+		query = `
+			UPDATE notifications
+			SET archived_at = NULL
+			WHERE id = $1 AND user_id = $2
+			RETURNING id
+		`
+		message = "Notification unarchived"
+	}
+
+	var notificationID int
+	err = h.db.QueryRow(query, id, userID).Scan(&notificationID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Notification not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if archive {
+		h.dispatcher.PublishUnreadCount(r.Context(), userID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": message,
+		"id":      notificationID,
+	})
+}
+
 // GetUnreadCount returns the count of unread notifications for a user
 func (h *NotificationHandler) GetUnreadCount(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -211,3 +407,189 @@ func (h *NotificationHandler) GetUnreadCount(w http.ResponseWriter, r *http.Requ
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]int{"unread_count": count})
 }
+
+// authorizeSettingsOwner checks that the authenticated user is the one whose
+// notifications or settings are addressed by the userId path variable
+func authorizeSettingsOwner(w http.ResponseWriter, r *http.Request) (int, bool) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return 0, false
+	}
+
+	pathUserID, err := strconv.Atoi(mux.Vars(r)["userId"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return 0, false
+	}
+	if pathUserID != userID {
+		http.Error(w, "You can only manage your own notifications", http.StatusForbidden)
+		return 0, false
+	}
+	return userID, true
+}
+
+// GetMutedUsers lists the users the authenticated user has muted
+func (h *NotificationHandler) GetMutedUsers(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authorizeSettingsOwner(w, r)
+	if !ok {
+		return
+	}
+
+	query := `
+		SELECT u.id, u.username, COALESCE(u.avatar, ''), m.created_at
+		FROM user_mutes m
+		INNER JOIN users u ON u.id = m.muted_user_id
+		WHERE m.user_id = $1
+		ORDER BY m.created_at DESC
+	`
+
+	rows, err := h.db.Query(query, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	muted := []models.MutedUser{}
+	for rows.Next() {
+		var m models.MutedUser
+		if err := rows.Scan(&m.UserID, &m.Username, &m.Avatar, &m.MutedAt); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		muted = append(muted, m)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(muted)
+}
+
+// MuteUser stops another user's comments, replies and mentions from
+// notifying the authenticated user. Muting someone twice has no effect.
+func (h *NotificationHandler) MuteUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authorizeSettingsOwner(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		UserID int `json:"user_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.UserID <= 0 {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+	if req.UserID == userID {
+		http.Error(w, "You can't mute yourself", http.StatusBadRequest)
+		return
+	}
+
+	query := `
+		INSERT INTO user_mutes (user_id, muted_user_id)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id, muted_user_id) DO NOTHING
+	`
+
+	if _, err := h.db.Exec(query, userID, req.UserID); err != nil {
+		if strings.Contains(err.Error(), "foreign key") {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "User muted",
+		"user_id": req.UserID,
+	})
+}
+
+// UnmuteUser removes a user from the authenticated user's mute list
+func (h *NotificationHandler) UnmuteUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authorizeSettingsOwner(w, r)
+	if !ok {
+		return
+	}
+
+	mutedUserID, err := strconv.Atoi(mux.Vars(r)["mutedUserId"])
+	if err != nil {
+		http.Error(w, "Invalid muted user ID", http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.db.Exec(`DELETE FROM user_mutes WHERE user_id = $1 AND muted_user_id = $2`, userID, mutedUserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		http.Error(w, "User is not muted", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetPreferences returns the authenticated user's notification preferences
+// with every category filled in
+func (h *NotificationHandler) GetPreferences(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authorizeSettingsOwner(w, r)
+	if !ok {
+		return
+	}
+
+	prefs, err := notify.LoadPreferences(r.Context(), h.db, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(prefs)
+}
+
+// UpdatePreferences replaces the authenticated user's notification
+// preferences. Categories left out go back to the default, and omitting
+// quiet_hours turns them off.
+func (h *NotificationHandler) UpdatePreferences(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authorizeSettingsOwner(w, r)
+	if !ok {
+		return
+	}
+
+	var prefs models.NotificationPreferences
+	if err := json.NewDecoder(r.Body).Decode(&prefs); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	prefs.UserID = userID
+
+	if err := notify.ValidatePreferences(&prefs); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := notify.SavePreferences(r.Context(), h.db, prefs); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	prefs, err := notify.LoadPreferences(r.Context(), h.db, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(prefs)
+}
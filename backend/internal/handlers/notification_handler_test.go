@@ -0,0 +1,540 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/aung-arata/youtube-clone/backend/internal/models"
+	"github.com/aung-arata/youtube-clone/backend/internal/notify"
+	"github.com/gorilla/mux"
+)
+
+// recordingNotifier keeps the notifications it's asked to deliver
+type recordingNotifier struct {
+	mu   sync.Mutex
+	sent []models.CreateNotificationRequest
+}
+
+func (n *recordingNotifier) Dispatch(ctx context.Context, req models.CreateNotificationRequest) (notify.Result, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.sent = append(n.sent, req)
+	return notify.Result{Stored: true}, nil
+}
+
+// waitFor waits for count notifications that are dispatched in the
+// background and returns them
+func (n *recordingNotifier) waitFor(t *testing.T, count int) []models.CreateNotificationRequest {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		n.mu.Lock()
+		sent := append([]models.CreateNotificationRequest(nil), n.sent...)
+		n.mu.Unlock()
+		if len(sent) >= count || time.Now().After(deadline) {
+			return sent
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestMuteUser(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewNotificationHandler(db, notify.NewDispatcher(db, nil))
+
+	mock.ExpectExec("INSERT INTO user_mutes (.+) ON CONFLICT").
+		WithArgs(1, 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	req := httptest.NewRequest("POST", "/api/users/1/mutes", bytes.NewBufferString(`{"user_id":7}`))
+	req = mux.SetURLVars(req, map[string]string{"userId": "1"})
+	req = withUser(req, 1)
+	w := httptest.NewRecorder()
+
+	handler.MuteUser(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Errorf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestMuteUser_Invalid(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewNotificationHandler(db, notify.NewDispatcher(db, nil))
+
+	tests := []struct {
+		name       string
+		pathUserID string
+		body       string
+		statusCode int
+	}{
+		{"Someone else's list", "2", `{"user_id":7}`, http.StatusForbidden},
+		{"Missing user", "1", `{}`, http.StatusBadRequest},
+		{"Self", "1", `{"user_id":1}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/users/"+tt.pathUserID+"/mutes", bytes.NewBufferString(tt.body))
+			req = mux.SetURLVars(req, map[string]string{"userId": tt.pathUserID})
+			req = withUser(req, 1)
+			w := httptest.NewRecorder()
+
+			handler.MuteUser(w, req)
+
+			if w.Code != tt.statusCode {
+				t.Errorf("Expected status %d, got %d", tt.statusCode, w.Code)
+			}
+		})
+	}
+}
+
+func TestUnmuteUser_NotMuted(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewNotificationHandler(db, notify.NewDispatcher(db, nil))
+
+	mock.ExpectExec("DELETE FROM user_mutes").
+		WithArgs(1, 7).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	req := httptest.NewRequest("DELETE", "/api/users/1/mutes/7", nil)
+	req = mux.SetURLVars(req, map[string]string{"userId": "1", "mutedUserId": "7"})
+	req = withUser(req, 1)
+	w := httptest.NewRecorder()
+
+	handler.UnmuteUser(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestMarkAsRead(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewNotificationHandler(db, notify.NewDispatcher(db, nil))
+
+	mock.ExpectQuery("UPDATE notifications (.+) WHERE id = \\$1 AND user_id = \\$2 RETURNING id").
+		WithArgs(5, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+
+	req := withUser(httptest.NewRequest("POST", "/api/notifications/5/mark-read", nil), 1)
+	req = mux.SetURLVars(req, map[string]string{"id": "5"})
+	w := httptest.NewRecorder()
+
+	handler.MarkAsRead(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestCreateNotification_RespectsPreferences(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewNotificationHandler(db, notify.NewDispatcher(db, nil))
+
+	// Likes are kept out of the in-app list, so no row is inserted
+	mock.ExpectQuery("SELECT category, enabled, in_app, websocket, email, push FROM notification_preferences").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"category", "enabled", "in_app", "websocket", "email", "push"}).
+			AddRow("like", true, false, true, false, false))
+	mock.ExpectQuery("FROM notification_quiet_hours").
+		WithArgs(1).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("FROM notification_digests").
+		WithArgs(1).
+		WillReturnError(sql.ErrNoRows)
+
+	body := `{"user_id":1,"type":"like","title":"New like","message":"Someone liked your video"}`
+	req := httptest.NewRequest("POST", "/api/notifications", bytes.NewBufferString(body))
+	w := httptest.NewRecorder()
+
+	handler.CreateNotification(w, req)
+
+	if w.Code != http.StatusAccepted {
+		t.Errorf("Expected status 202, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestGetPreferences(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewNotificationHandler(db, notify.NewDispatcher(db, nil))
+
+	mock.ExpectQuery("FROM notification_preferences").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"category", "enabled", "in_app", "websocket", "email", "push"}).
+			AddRow("mention", true, true, true, true, false))
+	mock.ExpectQuery("FROM notification_quiet_hours").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"start", "end", "timezone"}).AddRow("22:00", "07:00", "Europe/Berlin"))
+	mock.ExpectQuery("FROM notification_digests").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"frequency"}).AddRow("weekly"))
+
+	req := httptest.NewRequest("GET", "/api/users/1/notification-preferences", nil)
+	req = mux.SetURLVars(req, map[string]string{"userId": "1"})
+	req = withUser(req, 1)
+	w := httptest.NewRecorder()
+
+	handler.GetPreferences(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var prefs struct {
+		Types map[string]struct {
+			Enabled  bool            `json:"enabled"`
+			Channels map[string]bool `json:"channels"`
+		} `json:"types"`
+		QuietHours *struct {
+			Start string `json:"start"`
+		} `json:"quiet_hours"`
+		Digest string `json:"digest"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&prefs); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(prefs.Types) != 5 {
+		t.Errorf("Expected all 5 categories, got %d", len(prefs.Types))
+	}
+	if !prefs.Types["mention"].Channels["email"] || prefs.Types["like"].Channels["email"] {
+		t.Errorf("Expected email for mentions only, got %+v", prefs.Types)
+	}
+	if prefs.QuietHours == nil || prefs.QuietHours.Start != "22:00" {
+		t.Errorf("Expected quiet hours from 22:00, got %+v", prefs.QuietHours)
+	}
+	if prefs.Digest != "weekly" {
+		t.Errorf("Expected weekly digests, got %q", prefs.Digest)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestUpdatePreferences_Invalid(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewNotificationHandler(db, notify.NewDispatcher(db, nil))
+
+	tests := []struct {
+		name       string
+		pathUserID string
+		body       string
+		statusCode int
+	}{
+		{"Someone else's preferences", "2", `{}`, http.StatusForbidden},
+		{"Unknown category", "1", `{"types":{"dislike":{"enabled":false}}}`, http.StatusBadRequest},
+		{"Bad quiet hours", "1", `{"quiet_hours":{"start":"25:00","end":"07:00"}}`, http.StatusBadRequest},
+		{"Unknown digest frequency", "1", `{"digest":"hourly"}`, http.StatusBadRequest},
+		{"Unknown time zone", "1", `{"quiet_hours":{"start":"22:00","end":"07:00","timezone":"Mars/Olympus"}}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("PUT", "/api/users/"+tt.pathUserID+"/notification-preferences", bytes.NewBufferString(tt.body))
+			req = mux.SetURLVars(req, map[string]string{"userId": tt.pathUserID})
+			req = withUser(req, 1)
+			w := httptest.NewRecorder()
+
+			handler.UpdatePreferences(w, req)
+
+			if w.Code != tt.statusCode {
+				t.Errorf("Expected status %d, got %d", tt.statusCode, w.Code)
+			}
+		})
+	}
+}
+
+func TestGetUserNotifications_Paginates(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewNotificationHandler(db, notify.NewDispatcher(db, nil))
+
+	columns := []string{"id", "user_id", "type", "title", "message", "link", "collapse_key", "group_count", "is_read", "archived_at", "created_at"}
+	newest := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	cursorTime := newest.Add(time.Hour)
+
+	mock.ExpectQuery("FROM notifications (.+) archived_at IS NULL (.+) \\(created_at, id\\) < \\(\\$2, \\$3\\) ORDER BY created_at DESC, id DESC LIMIT \\$4").
+		WithArgs(1, cursorTime, 40, 3).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(9, 1, "like", "3 people liked your video", "bob liked your video", "/videos/2", "like:video:2", 3, false, nil, newest).
+			AddRow(8, 1, "comment", "New comment", "Nice", nil, nil, 1, true, nil, newest.Add(-time.Minute)).
+			AddRow(7, 1, "comment", "New comment", "Hi", nil, nil, 1, true, nil, newest.Add(-2*time.Minute)))
+
+	req := httptest.NewRequest("GET", "/api/users/1/notifications?limit=2&cursor="+encodeCursor(cursorTime, 40), nil)
+	req = mux.SetURLVars(req, map[string]string{"userId": "1"})
+	w := httptest.NewRecorder()
+
+	handler.GetUserNotifications(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var page struct {
+		Notifications []struct {
+			ID         int    `json:"id"`
+			GroupCount int    `json:"group_count"`
+			Title      string `json:"title"`
+		} `json:"notifications"`
+		NextCursor string `json:"next_cursor"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(page.Notifications) != 2 || page.Notifications[0].GroupCount != 3 {
+		t.Errorf("Expected 2 notifications with the grouped one first, got %+v", page.Notifications)
+	}
+	if page.NextCursor != encodeCursor(newest.Add(-time.Minute), 8) {
+		t.Errorf("Expected a cursor after notification 8, got %q", page.NextCursor)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestCreateNotification_Collapses(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewNotificationHandler(db, notify.NewDispatcher(db, nil))
+
+	mock.ExpectQuery("FROM notification_preferences").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"category", "enabled", "in_app", "websocket", "email", "push"}))
+	mock.ExpectQuery("FROM notification_quiet_hours").
+		WithArgs(1).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("FROM notification_digests").
+		WithArgs(1).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("INSERT INTO notifications (.+) ON CONFLICT \\(user_id, collapse_key\\)").
+		WithArgs(1, "like", "bob liked your video", "Your video is popular", "/videos/2", "like:video:2", "{count} people liked your video").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "group_count", "created_at"}).
+			AddRow(9, "12 people liked your video", 12, time.Now()))
+
+	body := `{"user_id":1,"type":"like","title":"bob liked your video","message":"Your video is popular","link":"/videos/2",` +
+		`"collapse_key":"like:video:2","group_title":"{count} people liked your video"}`
+	req := httptest.NewRequest("POST", "/api/notifications", bytes.NewBufferString(body))
+	w := httptest.NewRecorder()
+
+	handler.CreateNotification(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var n struct {
+		ID         int    `json:"id"`
+		Title      string `json:"title"`
+		GroupCount int    `json:"group_count"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&n); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if n.ID != 9 || n.GroupCount != 12 || n.Title != "12 people liked your video" {
+		t.Errorf("Expected the grouped notification, got %+v", n)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestDeleteNotification_NotOwned(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewNotificationHandler(db, notify.NewDispatcher(db, nil))
+
+	// Someone else's notification looks the same as a missing one
+	mock.ExpectQuery("DELETE FROM notifications WHERE id = \\$1 AND user_id = \\$2").
+		WithArgs(5, 1).
+		WillReturnError(sql.ErrNoRows)
+
+	req := httptest.NewRequest("DELETE", "/api/notifications/5", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "5"})
+	req = withUser(req, 1)
+	w := httptest.NewRecorder()
+
+	handler.DeleteNotification(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestBulkDeleteNotifications(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewNotificationHandler(db, notify.NewDispatcher(db, nil))
+
+	mock.ExpectExec("DELETE FROM notifications WHERE user_id = \\$1 AND id = ANY").
+		WithArgs(1, "{3,4}").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	req := httptest.NewRequest("POST", "/api/users/1/notifications/bulk-delete", bytes.NewBufferString(`{"ids":[3,4]}`))
+	req = mux.SetURLVars(req, map[string]string{"userId": "1"})
+	req = withUser(req, 1)
+	w := httptest.NewRecorder()
+
+	handler.BulkDeleteNotifications(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Deleted int `json:"deleted"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp.Deleted != 2 {
+		t.Errorf("Expected 2 deleted, got %+v (%v)", resp, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestBulkDeleteNotifications_Invalid(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewNotificationHandler(db, notify.NewDispatcher(db, nil))
+
+	tests := []struct {
+		name       string
+		pathUserID string
+		body       string
+		statusCode int
+	}{
+		{"Someone else's notifications", "2", `{"all_read":true}`, http.StatusForbidden},
+		{"Nothing to delete", "1", `{}`, http.StatusBadRequest},
+		{"Both ids and all_read", "1", `{"ids":[1],"all_read":true}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/users/"+tt.pathUserID+"/notifications/bulk-delete", bytes.NewBufferString(tt.body))
+			req = mux.SetURLVars(req, map[string]string{"userId": tt.pathUserID})
+			req = withUser(req, 1)
+			w := httptest.NewRecorder()
+
+			handler.BulkDeleteNotifications(w, req)
+
+			if w.Code != tt.statusCode {
+				t.Errorf("Expected status %d, got %d", tt.statusCode, w.Code)
+			}
+		})
+	}
+}
+
+func TestArchiveNotification(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewNotificationHandler(db, notify.NewDispatcher(db, nil))
+
+	mock.ExpectQuery("UPDATE notifications SET archived_at = COALESCE(.+), is_read = TRUE").
+		WithArgs(5, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+
+	req := httptest.NewRequest("POST", "/api/notifications/5/archive", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "5"})
+	req = withUser(req, 1)
+	w := httptest.NewRecorder()
+
+	handler.ArchiveNotification(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/aung-arata/youtube-clone/backend/internal/auth"
+	"github.com/gorilla/mux"
+)
+
+var linkedUserRows = []string{"id", "username", "email", "avatar", "role", "plan_id", "created_at", "updated_at", "totp_enabled", "email_verified"}
+
+func newTestOIDCHandler(t *testing.T) (*OIDCHandler, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	provider, err := auth.NewOIDCProvider(auth.OIDCConfig{
+		Name:        "mock",
+		Issuer:      "http://127.0.0.1:1",
+		ClientID:    "client",
+		RedirectURL: "http://localhost:3000/auth/oidc/mock/callback",
+	})
+	if err != nil {
+		t.Fatalf("NewOIDCProvider failed: %v", err)
+	}
+	return NewOIDCHandler(db, []*auth.OIDCProvider{provider}), mock
+}
+
+func TestOIDCCallback_UnknownProvider(t *testing.T) {
+	handler, _ := newTestOIDCHandler(t)
+
+	req := httptest.NewRequest("POST", "/api/auth/oidc/other/callback", bytes.NewBufferString(`{"code":"c","state":"s"}`))
+	req = mux.SetURLVars(req, map[string]string{"provider": "other"})
+	w := httptest.NewRecorder()
+
+	handler.Callback(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+}
+
+func TestOIDCCallback_ExpiredState(t *testing.T) {
+	handler, mock := newTestOIDCHandler(t)
+
+	mock.ExpectQuery("DELETE FROM oidc_logins").
+		WithArgs(auth.HashRefreshToken("s"), "mock").
+		WillReturnRows(sqlmock.NewRows([]string{"nonce", "code_verifier"}))
+
+	req := httptest.NewRequest("POST", "/api/auth/oidc/mock/callback", bytes.NewBufferString(`{"code":"c","state":"s"}`))
+	req.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: "s"})
+	req = mux.SetURLVars(req, map[string]string{"provider": "mock"})
+	w := httptest.NewRecorder()
+
+	handler.Callback(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestOIDCCallback_StateFromAnotherBrowser(t *testing.T) {
+	tests := []struct {
+		name   string
+		cookie string
+	}{
+		{"no cookie", ""},
+		{"another login's state", "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, mock := newTestOIDCHandler(t)
+
+			req := httptest.NewRequest("POST", "/api/auth/oidc/mock/callback", bytes.NewBufferString(`{"code":"c","state":"s"}`))
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: tt.cookie})
+			}
+			req = mux.SetURLVars(req, map[string]string{"provider": "mock"})
+			w := httptest.NewRecorder()
+
+			handler.Callback(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Expected status 400, got %d", w.Code)
+			}
+			// The login isn't used up, so its own browser can still finish it
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestLinkedUser_LinksVerifiedEmail(t *testing.T) {
+	handler, mock := newTestOIDCHandler(t)
+	claims := &auth.IDTokenClaims{Email: "Alice@example.com", EmailVerified: true}
+	claims.Subject = "user-123"
+
+	now := time.Now()
+	mock.ExpectQuery("FROM user_identities i JOIN users u").
+		WithArgs("mock", "user-123").
+		WillReturnRows(sqlmock.NewRows(linkedUserRows))
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM users u WHERE LOWER\\(u.email\\) = LOWER\\(\\$1\\) FOR UPDATE").
+		WithArgs("Alice@example.com").
+		WillReturnRows(sqlmock.NewRows(linkedUserRows).
+			AddRow(7, "alice", "alice@example.com", "", "user", nil, now, now, false, true))
+	mock.ExpectExec("INSERT INTO user_identities").
+		WithArgs(7, "mock", "user-123", "Alice@example.com").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	user, _, emailVerified, err := handler.linkedUser(context.Background(), "mock", claims)
+	if err != nil {
+		t.Fatalf("linkedUser failed: %v", err)
+	}
+	if user.ID != 7 || !emailVerified {
+		t.Errorf("Expected verified user 7, got %d (verified %v)", user.ID, emailVerified)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestLinkedUser_UnverifiedEmailTaken(t *testing.T) {
+	handler, mock := newTestOIDCHandler(t)
+	claims := &auth.IDTokenClaims{Email: "alice@example.com"}
+	claims.Subject = "user-123"
+
+	now := time.Now()
+	mock.ExpectQuery("FROM user_identities i JOIN users u").
+		WithArgs("mock", "user-123").
+		WillReturnRows(sqlmock.NewRows(linkedUserRows))
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM users u WHERE LOWER").
+		WithArgs("alice@example.com").
+		WillReturnRows(sqlmock.NewRows(linkedUserRows).
+			AddRow(7, "alice", "alice@example.com", "", "user", nil, now, now, false, true))
+	mock.ExpectRollback()
+
+	if _, _, _, err := handler.linkedUser(context.Background(), "mock", claims); !errors.Is(err, errOIDCEmailTaken) {
+		t.Errorf("Expected errOIDCEmailTaken, got %v", err)
+	}
+}
+
+func TestLinkedUser_UnverifiedAccount(t *testing.T) {
+	handler, mock := newTestOIDCHandler(t)
+	claims := &auth.IDTokenClaims{Email: "alice@example.com", EmailVerified: true}
+	claims.Subject = "user-123"
+
+	// Someone signed up with Alice's address before she used the provider
+	now := time.Now()
+	mock.ExpectQuery("FROM user_identities i JOIN users u").
+		WithArgs("mock", "user-123").
+		WillReturnRows(sqlmock.NewRows(linkedUserRows))
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM users u WHERE LOWER").
+		WithArgs("alice@example.com").
+		WillReturnRows(sqlmock.NewRows(linkedUserRows).
+			AddRow(7, "alice", "alice@example.com", "", "user", nil, now, now, false, false))
+	mock.ExpectRollback()
+
+	if _, _, _, err := handler.linkedUser(context.Background(), "mock", claims); !errors.Is(err, errOIDCEmailUnclaimed) {
+		t.Errorf("Expected errOIDCEmailUnclaimed, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestOIDCUsername(t *testing.T) {
+	cases := []struct {
+		claims auth.IDTokenClaims
+		want   string
+	}{
+		{auth.IDTokenClaims{PreferredUsername: "alice", Name: "Alice A"}, "alice"},
+		{auth.IDTokenClaims{Name: "Alice Anderson"}, "Alice_Anderson"},
+		{auth.IDTokenClaims{Name: "漢字", Email: "a.b@example.com"}, "a.b"},
+		{auth.IDTokenClaims{}, "user"},
+	}
+	for _, c := range cases {
+		if got := oidcUsername(&c.claims); got != c.want {
+			t.Errorf("oidcUsername(%+v) = %q, want %q", c.claims, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+)
+
+func TestCreatePlaylist_ForOtherUser(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewPlaylistHandler(db)
+
+	req := withUser(httptest.NewRequest("POST", "/api/users/8/playlists", bytes.NewBufferString(`{"name":"Mine now"}`)), 7)
+	req = mux.SetURLVars(req, map[string]string{"userId": "8"})
+	w := httptest.NewRecorder()
+
+	handler.CreatePlaylist(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d", w.Code)
+	}
+}
+
+func TestDeletePlaylist_NotOwner(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewPlaylistHandler(db)
+
+	mock.ExpectQuery("SELECT user_id FROM playlists WHERE id = \\$1").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(8))
+
+	req := withUser(httptest.NewRequest("DELETE", "/api/playlists/5", nil), 7)
+	req = mux.SetURLVars(req, map[string]string{"id": "5"})
+	w := httptest.NewRecorder()
+
+	handler.DeletePlaylist(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d", w.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/aung-arata/youtube-clone/backend/internal/webpush"
+	"github.com/gorilla/mux"
+)
+
+func testVAPIDKeys(t *testing.T) *webpush.VAPIDKeys {
+	t.Helper()
+	public, private, err := webpush.GenerateVAPIDKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := webpush.ParseVAPIDKeys(public, private, "mailto:admin@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+// browserKeys returns a valid p256dh key and auth secret
+func browserKeys(t *testing.T) (string, string) {
+	t.Helper()
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := make([]byte, 16)
+	rand.Read(auth)
+	return base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()), base64.RawURLEncoding.EncodeToString(auth)
+}
+
+func TestRegisterPushSubscription(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewPushHandler(db, testVAPIDKeys(t))
+	p256dh, auth := browserKeys(t)
+	endpoint := "https://push.example.com/send/abc"
+
+	mock.ExpectQuery("INSERT INTO push_subscriptions (.+) ON CONFLICT \\(endpoint\\)").
+		WithArgs(1, endpoint, p256dh, auth, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(3, time.Now()))
+
+	body := fmt.Sprintf(`{"endpoint":%q,"keys":{"p256dh":%q,"auth":%q}}`, endpoint, p256dh, auth)
+	req := httptest.NewRequest("POST", "/api/users/1/push-subscriptions", bytes.NewBufferString(body))
+	req = mux.SetURLVars(req, map[string]string{"userId": "1"})
+	req = withUser(req, 1)
+	w := httptest.NewRecorder()
+
+	handler.RegisterSubscription(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Errorf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestRegisterPushSubscription_Invalid(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewPushHandler(db, testVAPIDKeys(t))
+	p256dh, auth := browserKeys(t)
+
+	tests := []struct {
+		name       string
+		pathUserID string
+		body       string
+		statusCode int
+	}{
+		{"Someone else's browser", "2", `{}`, http.StatusForbidden},
+		{"Plain HTTP endpoint", "1", fmt.Sprintf(`{"endpoint":"http://push.example.com/x","keys":{"p256dh":%q,"auth":%q}}`, p256dh, auth), http.StatusBadRequest},
+		{"Internal endpoint", "1", fmt.Sprintf(`{"endpoint":"/admin","keys":{"p256dh":%q,"auth":%q}}`, p256dh, auth), http.StatusBadRequest},
+		{"Bad keys", "1", `{"endpoint":"https://push.example.com/x","keys":{"p256dh":"AAAA","auth":"AAAA"}}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/users/"+tt.pathUserID+"/push-subscriptions", bytes.NewBufferString(tt.body))
+			req = mux.SetURLVars(req, map[string]string{"userId": tt.pathUserID})
+			req = withUser(req, 1)
+			w := httptest.NewRecorder()
+
+			handler.RegisterSubscription(w, req)
+
+			if w.Code != tt.statusCode {
+				t.Errorf("Expected status %d, got %d", tt.statusCode, w.Code)
+			}
+		})
+	}
+}
+
+func TestUnregisterPushSubscription_NotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewPushHandler(db, nil)
+
+	mock.ExpectExec("DELETE FROM push_subscriptions").
+		WithArgs(1, "https://push.example.com/send/abc").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	req := httptest.NewRequest("DELETE", "/api/users/1/push-subscriptions", bytes.NewBufferString(`{"endpoint":"https://push.example.com/send/abc"}`))
+	req = mux.SetURLVars(req, map[string]string{"userId": "1"})
+	req = withUser(req, 1)
+	w := httptest.NewRecorder()
+
+	handler.UnregisterSubscription(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestGetVAPIDPublicKey_NotConfigured(t *testing.T) {
+	handler := NewPushHandler(nil, nil)
+
+	w := httptest.NewRecorder()
+	handler.GetVAPIDPublicKey(w, httptest.NewRequest("GET", "/api/push/vapid-public-key", nil))
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", w.Code)
+	}
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/aung-arata/youtube-clone/backend/internal/auth"
+	"github.com/aung-arata/youtube-clone/backend/internal/middleware"
+	"github.com/aung-arata/youtube-clone/backend/internal/models"
+	"github.com/gorilla/mux"
+)
+
+// RoleHandler lets admins see roles and assign them to users
+type RoleHandler struct {
+	db *sql.DB
+}
+
+// NewRoleHandler creates a RoleHandler
+func NewRoleHandler(db *sql.DB) *RoleHandler {
+	return &RoleHandler{db: db}
+}
+
+// RoleInfo is a role and everything it allows
+type RoleInfo struct {
+	Role        string            `json:"role"`
+	Permissions []auth.Permission `json:"permissions"`
+}
+
+// ListRoles returns every role with its permissions
+func (h *RoleHandler) ListRoles(w http.ResponseWriter, r *http.Request) {
+	roles := make([]RoleInfo, 0, len(auth.Roles))
+	for _, role := range auth.Roles {
+		roles = append(roles, RoleInfo{Role: role, Permissions: auth.RolePermissions(role)})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(roles)
+}
+
+// UpdateUserRole gives a user another role. They are logged out everywhere,
+// so that their next login carries it.
+func (h *RoleHandler) UpdateUserRole(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !auth.ValidRole(req.Role) {
+		http.Error(w, "Role must be one of viewer, creator, moderator or admin", http.StatusBadRequest)
+		return
+	}
+
+	// Otherwise the last admin could lock everyone out
+	if id == adminID {
+		http.Error(w, "You can't change your own role", http.StatusForbidden)
+		return
+	}
+
+	query := `
+		UPDATE users u SET role = $1, updated_at = CURRENT_TIMESTAMP
+		FROM users old
+		WHERE u.id = $2 AND old.id = u.id
+		RETURNING u.id, u.username, u.email, u.avatar, u.role, u.plan_id, u.created_at, u.updated_at, old.role
+	`
+
+	var user models.User
+	var oldRole string
+	err = h.db.QueryRow(query, req.Role, id).Scan(
+		&user.ID, &user.Username, &user.Email, &user.Avatar, &user.Role, &user.PlanID, &user.CreatedAt, &user.UpdatedAt, &oldRole)
+	if err == sql.ErrNoRows {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if oldRole != user.Role {
+		log.Printf("User %d changed the role of user %d from %s to %s", adminID, user.ID, oldRole, user.Role)
+		if _, err := auth.RevokeAllSessions(r.Context(), h.db, user.ID); err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/aung-arata/youtube-clone/backend/internal/auth"
+	"github.com/aung-arata/youtube-clone/backend/internal/middleware"
+	"github.com/gorilla/mux"
+)
+
+func TestUpdateUserRole(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewRoleHandler(db)
+
+	now := time.Now()
+	mock.ExpectQuery("UPDATE users u SET role = \\$1").
+		WithArgs(auth.RoleModerator, 8).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email", "avatar", "role", "plan_id", "created_at", "updated_at", "role"}).
+			AddRow(8, "bob", "bob@example.com", "", auth.RoleModerator, nil, now, now, auth.RoleCreator))
+	// The new role only shows up in tokens issued after a fresh login
+	mock.ExpectQuery("UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP").
+		WithArgs(8).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	req := withUser(httptest.NewRequest("PUT", "/api/admin/users/8/role", bytes.NewBufferString(`{"role":"moderator"}`)), 1)
+	req = mux.SetURLVars(req, map[string]string{"id": "8"})
+	w := httptest.NewRecorder()
+
+	handler.UpdateUserRole(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestUpdateUserRole_Rejected(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewRoleHandler(db)
+
+	tests := []struct {
+		name   string
+		id     string
+		body   string
+		status int
+	}{
+		{"unknown role", "8", `{"role":"owner"}`, http.StatusBadRequest},
+		{"legacy role", "8", `{"role":"user"}`, http.StatusBadRequest},
+		{"own role", "1", `{"role":"viewer"}`, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := withUser(httptest.NewRequest("PUT", "/api/admin/users/"+tt.id+"/role", bytes.NewBufferString(tt.body)), 1)
+			req = mux.SetURLVars(req, map[string]string{"id": tt.id})
+			w := httptest.NewRecorder()
+
+			handler.UpdateUserRole(w, req)
+
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, w.Code)
+			}
+		})
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name   string
+		role   string
+		mfa    bool
+		perm   auth.Permission
+		status int
+	}{
+		{"viewer uploading", auth.RoleViewer, false, auth.PermUploadVideos, http.StatusForbidden},
+		{"creator uploading", auth.RoleCreator, false, auth.PermUploadVideos, http.StatusOK},
+		{"moderator without two-factor", auth.RoleModerator, false, auth.PermModerateComments, http.StatusForbidden},
+		{"moderator with two-factor", auth.RoleModerator, true, auth.PermModerateComments, http.StatusOK},
+		{"moderator managing plans", auth.RoleModerator, true, auth.PermManagePlans, http.StatusForbidden},
+		{"admin managing plans", auth.RoleAdmin, true, auth.PermManagePlans, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), middleware.UserIDKey, 7)
+			ctx = context.WithValue(ctx, middleware.UserRoleKey, tt.role)
+			ctx = context.WithValue(ctx, middleware.MFAKey, tt.mfa)
+			req := httptest.NewRequest("POST", "/", nil).WithContext(ctx)
+			w := httptest.NewRecorder()
+
+			middleware.RequirePermission(tt.perm, ok).ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, w.Code)
+			}
+		})
+	}
+}
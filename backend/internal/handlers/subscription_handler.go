@@ -1,16 +1,23 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aung-arata/youtube-clone/backend/internal/models"
 	"github.com/gorilla/mux"
 )
 
+// subscriberFanoutTimeout bounds telling a channel's subscribers about an
+// upload, which runs after the upload's response has been written
+const subscriberFanoutTimeout = 10 * time.Minute
+
 type SubscriptionHandler struct {
 	db *sql.DB
 }
@@ -29,32 +36,56 @@ func (h *SubscriptionHandler) Subscribe(w http.ResponseWriter, r *http.Request)
 	}
 
 	var req struct {
-		ChannelName string `json:"channel_name"`
+		ChannelID         int    `json:"channel_id"`
+		NotificationLevel string `json:"notification_level"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if req.ChannelName == "" {
-		http.Error(w, "Channel name is required", http.StatusBadRequest)
+	if req.ChannelID <= 0 {
+		http.Error(w, "Channel ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if req.NotificationLevel == "" {
+		req.NotificationLevel = models.NotificationLevelPersonalized
+	} else if !models.ValidNotificationLevel(req.NotificationLevel) {
+		http.Error(w, "Notification level must be all, personalized or none", http.StatusBadRequest)
 		return
 	}
 
+	// The subscriber count is bumped in the same statement so it can never
+	// drift from the subscriptions table
 	query := `
-		INSERT INTO subscriptions (user_id, channel_name)
-		VALUES ($1, $2)
-		RETURNING id, user_id, channel_name, created_at
+		WITH inserted AS (
+			INSERT INTO subscriptions (user_id, channel_id, notification_level)
+			VALUES ($1, $2, $3)
+			RETURNING id, user_id, channel_id, notification_level, last_visited_at, created_at
+		), counted AS (
+			UPDATE channels SET subscriber_count = subscriber_count + 1
+			WHERE id = (SELECT channel_id FROM inserted)
+			RETURNING id, display_name
+		)
+		SELECT i.id, i.user_id, i.channel_id, c.display_name, i.notification_level, i.last_visited_at, i.created_at
+		FROM inserted i
+		INNER JOIN counted c ON c.id = i.channel_id
 	`
 
 	var sub models.Subscription
-	err = h.db.QueryRow(query, userID, req.ChannelName).Scan(&sub.ID, &sub.UserID, &sub.ChannelName, &sub.CreatedAt)
+	err = h.db.QueryRow(query, userID, req.ChannelID, req.NotificationLevel).Scan(&sub.ID, &sub.UserID, &sub.ChannelID,
+		&sub.ChannelName, &sub.NotificationLevel, &sub.LastVisitedAt, &sub.CreatedAt)
 	if err != nil {
 		// Check if already subscribed (duplicate key constraint violation)
 		if strings.Contains(err.Error(), "duplicate key") || strings.Contains(err.Error(), "unique constraint") {
 			http.Error(w, "Already subscribed to this channel", http.StatusConflict)
 			return
 		}
+		if strings.Contains(err.Error(), "foreign key") {
+			http.Error(w, "Channel not found", http.StatusNotFound)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -73,14 +104,21 @@ func (h *SubscriptionHandler) Unsubscribe(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	channelName := vars["channelName"]
-	if channelName == "" {
-		http.Error(w, "Channel name is required", http.StatusBadRequest)
+	channelID, err := strconv.Atoi(vars["channelId"])
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
 		return
 	}
 
-	query := `DELETE FROM subscriptions WHERE user_id = $1 AND channel_name = $2`
-	result, err := h.db.Exec(query, userID, channelName)
+	query := `
+		WITH deleted AS (
+			DELETE FROM subscriptions WHERE user_id = $1 AND channel_id = $2
+			RETURNING channel_id
+		)
+		UPDATE channels SET subscriber_count = GREATEST(subscriber_count - 1, 0)
+		WHERE id IN (SELECT channel_id FROM deleted)
+	`
+	result, err := h.db.Exec(query, userID, channelID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -110,10 +148,11 @@ func (h *SubscriptionHandler) GetUserSubscriptions(w http.ResponseWriter, r *htt
 	}
 
 	query := `
-		SELECT id, user_id, channel_name, created_at
-		FROM subscriptions
-		WHERE user_id = $1
-		ORDER BY created_at DESC
+		SELECT s.id, s.user_id, s.channel_id, c.display_name, s.notification_level, s.last_visited_at, s.created_at
+		FROM subscriptions s
+		INNER JOIN channels c ON c.id = s.channel_id
+		WHERE s.user_id = $1
+		ORDER BY s.created_at DESC
 	`
 
 	rows, err := h.db.Query(query, userID)
@@ -126,7 +165,8 @@ func (h *SubscriptionHandler) GetUserSubscriptions(w http.ResponseWriter, r *htt
 	var subscriptions []models.Subscription
 	for rows.Next() {
 		var sub models.Subscription
-		if err := rows.Scan(&sub.ID, &sub.UserID, &sub.ChannelName, &sub.CreatedAt); err != nil {
+		if err := rows.Scan(&sub.ID, &sub.UserID, &sub.ChannelID, &sub.ChannelName, &sub.NotificationLevel,
+			&sub.LastVisitedAt, &sub.CreatedAt); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -151,15 +191,15 @@ func (h *SubscriptionHandler) CheckSubscription(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	channelName := vars["channelName"]
-	if channelName == "" {
-		http.Error(w, "Channel name is required", http.StatusBadRequest)
+	channelID, err := strconv.Atoi(vars["channelId"])
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
 		return
 	}
 
 	var exists bool
-	query := `SELECT EXISTS(SELECT 1 FROM subscriptions WHERE user_id = $1 AND channel_name = $2)`
-	err = h.db.QueryRow(query, userID, channelName).Scan(&exists)
+	query := `SELECT EXISTS(SELECT 1 FROM subscriptions WHERE user_id = $1 AND channel_id = $2)`
+	err = h.db.QueryRow(query, userID, channelID).Scan(&exists)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -168,3 +208,291 @@ func (h *SubscriptionHandler) CheckSubscription(w http.ResponseWriter, r *http.R
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]bool{"subscribed": exists})
 }
+
+// UpdateNotificationLevel changes the bell setting of a subscription
+func (h *SubscriptionHandler) UpdateNotificationLevel(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID, err := strconv.Atoi(vars["userId"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	channelID, err := strconv.Atoi(vars["channelId"])
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		NotificationLevel string `json:"notification_level"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if !models.ValidNotificationLevel(req.NotificationLevel) {
+		http.Error(w, "Notification level must be all, personalized or none", http.StatusBadRequest)
+		return
+	}
+
+	query := `UPDATE subscriptions SET notification_level = $1 WHERE user_id = $2 AND channel_id = $3`
+	result, err := h.db.Exec(query, req.NotificationLevel, userID, channelID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if rowsAffected == 0 {
+		http.Error(w, "Subscription not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"channel_id":         channelID,
+		"notification_level": req.NotificationLevel,
+	})
+}
+
+// GetSubscriptionFeed returns the newest videos from the channels a user is
+// subscribed to, newest first. Pages are keyed on (uploaded_at, id) so that
+// new uploads arriving between requests don't shift later pages.
+func (h *SubscriptionHandler) GetSubscriptionFeed(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID, err := strconv.Atoi(vars["userId"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	limit := parseLimit(r.URL.Query().Get("limit"), 20, 100)
+
+	query := `
+		SELECT v.id, v.title, v.description, v.url, v.thumbnail, v.channel_id, v.channel_name,
+		       v.channel_avatar, v.views, v.likes, v.dislikes, v.category, v.duration,
+		       v.uploaded_at, v.created_at, v.updated_at
+		FROM videos v
+		INNER JOIN subscriptions s ON s.channel_id = v.channel_id
+		WHERE s.user_id = $1
+	`
+	args := []interface{}{userID}
+
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		uploadedAt, id, err := decodeCursor(cursor)
+		if err != nil {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		query += " AND (v.uploaded_at, v.id) < ($2, $3)"
+		args = append(args, uploadedAt, id)
+	}
+
+	// Fetch one extra row to find out whether another page exists
+	query += " ORDER BY v.uploaded_at DESC, v.id DESC LIMIT $" + strconv.Itoa(len(args)+1)
+	args = append(args, limit+1)
+
+	rows, err := h.db.Query(query, args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	feed := models.SubscriptionFeed{Videos: []models.Video{}}
+	for rows.Next() {
+		var v models.Video
+		err := rows.Scan(&v.ID, &v.Title, &v.Description, &v.URL, &v.Thumbnail,
+			&v.ChannelID, &v.ChannelName, &v.ChannelAvatar, &v.Views, &v.Likes, &v.Dislikes,
+			&v.Category, &v.Duration, &v.UploadedAt, &v.CreatedAt, &v.UpdatedAt)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		feed.Videos = append(feed.Videos, v)
+	}
+
+	if err = rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if len(feed.Videos) > limit {
+		feed.Videos = feed.Videos[:limit]
+		last := feed.Videos[limit-1]
+		feed.NextCursor = encodeCursor(last.UploadedAt, last.ID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(feed)
+}
+
+// GetUnseenCounts returns, for every subscribed channel, how many videos were
+// uploaded since the user last visited that channel
+func (h *SubscriptionHandler) GetUnseenCounts(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID, err := strconv.Atoi(vars["userId"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	query := `
+		SELECT s.channel_id, c.display_name, s.last_visited_at, COUNT(v.id)
+		FROM subscriptions s
+		INNER JOIN channels c ON c.id = s.channel_id
+		LEFT JOIN videos v ON v.channel_id = s.channel_id AND v.uploaded_at > s.last_visited_at
+		WHERE s.user_id = $1
+		GROUP BY s.id, s.channel_id, c.display_name, s.last_visited_at
+		ORDER BY COUNT(v.id) DESC, c.display_name ASC
+	`
+
+	rows, err := h.db.Query(query, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	counts := []models.ChannelUnseenCount{}
+	for rows.Next() {
+		var c models.ChannelUnseenCount
+		if err := rows.Scan(&c.ChannelID, &c.ChannelName, &c.LastVisitedAt, &c.UnseenCount); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		counts = append(counts, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(counts)
+}
+
+// MarkChannelVisited moves a subscription's last-visit marker to now, which
+// resets the channel's unseen count
+func (h *SubscriptionHandler) MarkChannelVisited(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID, err := strconv.Atoi(vars["userId"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	channelID, err := strconv.Atoi(vars["channelId"])
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+
+	query := `
+		UPDATE subscriptions
+		SET last_visited_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND channel_id = $2
+		RETURNING last_visited_at
+	`
+
+	var lastVisitedAt time.Time
+	err = h.db.QueryRow(query, userID, channelID).Scan(&lastVisitedAt)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Subscription not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"channel_id":      channelID,
+		"last_visited_at": lastVisitedAt,
+	})
+}
+
+// notifySubscribers tells every subscriber of the video's channel whose bell
+// setting asks for it about a new upload. "all" always notifies;
+// "personalized" only notifies subscribers who watched the channel in the
+// last 30 days; "none" never does. Uploads from the same channel are grouped
+// until they're read.
+func notifySubscribers(ctx context.Context, db *sql.DB, notifier Notifier, video models.Video) error {
+	if video.ChannelID == nil || notifier == nil {
+		return nil
+	}
+
+	query := `
+		SELECT s.user_id
+		FROM subscriptions s
+		WHERE s.channel_id = $1
+		  AND (s.notification_level = 'all'
+		       OR (s.notification_level = 'personalized' AND EXISTS (
+		           SELECT 1 FROM watch_history wh
+		           INNER JOIN videos v ON v.id = wh.video_id
+		           WHERE wh.user_id = s.user_id AND v.channel_id = s.channel_id
+		             AND wh.watched_at > CURRENT_TIMESTAMP - INTERVAL '30 days'
+		       )))
+	`
+	userIDs, err := queryUserIDs(ctx, db, query, *video.ChannelID)
+	if err != nil {
+		return err
+	}
+
+	for _, userID := range userIDs {
+		_, err := notifier.Dispatch(ctx, models.CreateNotificationRequest{
+			UserID:      userID,
+			Type:        "new_video",
+			Title:       video.ChannelName + " uploaded a new video",
+			Message:     video.Title,
+			Link:        "/videos/" + strconv.Itoa(video.ID),
+			CollapseKey: "new_video:" + strconv.Itoa(*video.ChannelID),
+			GroupTitle:  video.ChannelName + " uploaded {count} new videos",
+		})
+		// One subscriber's failure shouldn't keep the rest from hearing
+		if err != nil {
+			log.Printf("Failed to notify user %d of video %d: %v", userID, video.ID, err)
+		}
+	}
+	return nil
+}
+
+// notifySubscribersInBackground runs notifySubscribers detached from the
+// request, so that a channel with many subscribers doesn't hold up the
+// upload and a client going away doesn't cut the fan-out short. Failures are
+// only logged.
+func notifySubscribersInBackground(db *sql.DB, notifier Notifier, video models.Video) {
+	ctx, cancel := context.WithTimeout(context.Background(), subscriberFanoutTimeout)
+	defer cancel()
+
+	if err := notifySubscribers(ctx, db, notifier, video); err != nil {
+		log.Printf("Failed to notify subscribers of video %d: %v", video.ID, err)
+	}
+}
+
+// queryUserIDs runs a query returning a single column of user IDs
+func queryUserIDs(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]int, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	userIDs := []int{}
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, rows.Err()
+}
@@ -0,0 +1,361 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/aung-arata/youtube-clone/backend/internal/models"
+	"github.com/gorilla/mux"
+)
+
+var feedColumns = []string{
+	"id", "title", "description", "url", "thumbnail",
+	"channel_id", "channel_name", "channel_avatar", "views", "likes", "dislikes",
+	"category", "duration", "uploaded_at", "created_at", "updated_at",
+}
+
+func TestGetSubscriptionFeed(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewSubscriptionHandler(db)
+
+	now := time.Now().UTC()
+	rows := sqlmock.NewRows(feedColumns).
+		AddRow(3, "Newest", "", "/v/3.mp4", "", 1, "Code Master", "", 0, 0, 0, "Tech", "10:00", now, now, now).
+		AddRow(2, "Older", "", "/v/2.mp4", "", 1, "Code Master", "", 0, 0, 0, "Tech", "10:00", now.Add(-time.Hour), now, now).
+		AddRow(1, "Oldest", "", "/v/1.mp4", "", 2, "Tech Tutorials", "", 0, 0, 0, "Tech", "10:00", now.Add(-2*time.Hour), now, now)
+
+	// limit=2 asks the database for one extra row to detect the next page
+	mock.ExpectQuery("SELECT (.+) FROM videos v INNER JOIN subscriptions s ON s.channel_id = v.channel_id").
+		WithArgs(1, 3).
+		WillReturnRows(rows)
+
+	req := httptest.NewRequest("GET", "/api/users/1/subscriptions/feed?limit=2", nil)
+	req = mux.SetURLVars(req, map[string]string{"userId": "1"})
+	w := httptest.NewRecorder()
+
+	handler.GetSubscriptionFeed(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var feed models.SubscriptionFeed
+	json.NewDecoder(w.Body).Decode(&feed)
+
+	if len(feed.Videos) != 2 {
+		t.Fatalf("Expected 2 videos, got %d", len(feed.Videos))
+	}
+	if feed.NextCursor == "" {
+		t.Fatal("Expected a next cursor")
+	}
+
+	uploadedAt, id, err := decodeCursor(feed.NextCursor)
+	if err != nil {
+		t.Fatalf("Failed to decode cursor: %v", err)
+	}
+	if id != 2 || !uploadedAt.Equal(now.Add(-time.Hour)) {
+		t.Errorf("Expected cursor to point at video 2, got id %d at %v", id, uploadedAt)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestGetSubscriptionFeedWithCursor(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewSubscriptionHandler(db)
+
+	before := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT (.+) FROM videos v (.+) AND \\(v.uploaded_at, v.id\\) <").
+		WithArgs(1, before, 7, 21).
+		WillReturnRows(sqlmock.NewRows(feedColumns))
+
+	req := httptest.NewRequest("GET", "/api/users/1/subscriptions/feed?cursor="+encodeCursor(before, 7), nil)
+	req = mux.SetURLVars(req, map[string]string{"userId": "1"})
+	w := httptest.NewRecorder()
+
+	handler.GetSubscriptionFeed(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var feed models.SubscriptionFeed
+	json.NewDecoder(w.Body).Decode(&feed)
+
+	if len(feed.Videos) != 0 || feed.NextCursor != "" {
+		t.Errorf("Expected an empty last page, got %d videos and cursor %q", len(feed.Videos), feed.NextCursor)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestGetSubscriptionFeedInvalidCursor(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewSubscriptionHandler(db)
+
+	req := httptest.NewRequest("GET", "/api/users/1/subscriptions/feed?cursor=not-a-cursor", nil)
+	req = mux.SetURLVars(req, map[string]string{"userId": "1"})
+	w := httptest.NewRecorder()
+
+	handler.GetSubscriptionFeed(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+}
+
+func TestGetUnseenCounts(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewSubscriptionHandler(db)
+
+	now := time.Now()
+	rows := sqlmock.NewRows([]string{"channel_id", "display_name", "last_visited_at", "count"}).
+		AddRow(1, "Code Master", now, 4).
+		AddRow(2, "Tech Tutorials", now, 0)
+
+	mock.ExpectQuery("SELECT (.+) FROM subscriptions s (.+) LEFT JOIN videos v").
+		WithArgs(1).
+		WillReturnRows(rows)
+
+	req := httptest.NewRequest("GET", "/api/users/1/subscriptions/unseen", nil)
+	req = mux.SetURLVars(req, map[string]string{"userId": "1"})
+	w := httptest.NewRecorder()
+
+	handler.GetUnseenCounts(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var counts []models.ChannelUnseenCount
+	json.NewDecoder(w.Body).Decode(&counts)
+
+	if len(counts) != 2 {
+		t.Fatalf("Expected 2 channels, got %d", len(counts))
+	}
+	if counts[0].ChannelID != 1 || counts[0].ChannelName != "Code Master" || counts[0].UnseenCount != 4 {
+		t.Errorf("Unexpected first entry: %+v", counts[0])
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestMarkChannelVisitedNotSubscribed(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewSubscriptionHandler(db)
+
+	mock.ExpectQuery("UPDATE subscriptions").
+		WithArgs(1, 99).
+		WillReturnRows(sqlmock.NewRows([]string{"last_visited_at"}))
+
+	req := httptest.NewRequest("POST", "/api/users/1/subscriptions/99/visit", nil)
+	req = mux.SetURLVars(req, map[string]string{"userId": "1", "channelId": "99"})
+	w := httptest.NewRecorder()
+
+	handler.MarkChannelVisited(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewSubscriptionHandler(db)
+
+	now := time.Now()
+	rows := sqlmock.NewRows([]string{"id", "user_id", "channel_id", "display_name", "notification_level", "last_visited_at", "created_at"}).
+		AddRow(1, 1, 5, "Code Master", "personalized", now, now)
+
+	mock.ExpectQuery("INSERT INTO subscriptions (.+) UPDATE channels SET subscriber_count = subscriber_count \\+ 1").
+		WithArgs(1, 5, "personalized").
+		WillReturnRows(rows)
+
+	req := httptest.NewRequest("POST", "/api/users/1/subscriptions", strings.NewReader(`{"channel_id":5}`))
+	req = mux.SetURLVars(req, map[string]string{"userId": "1"})
+	w := httptest.NewRecorder()
+
+	handler.Subscribe(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", w.Code)
+	}
+
+	var sub models.Subscription
+	json.NewDecoder(w.Body).Decode(&sub)
+
+	if sub.ChannelID != 5 || sub.ChannelName != "Code Master" || sub.NotificationLevel != "personalized" {
+		t.Errorf("Unexpected subscription: %+v", sub)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSubscribeUnknownChannel(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewSubscriptionHandler(db)
+
+	mock.ExpectQuery("INSERT INTO subscriptions").
+		WithArgs(1, 404, "personalized").
+		WillReturnError(errors.New(`pq: insert or update on table "subscriptions" violates foreign key constraint "subscriptions_channel_id_fkey"`))
+
+	req := httptest.NewRequest("POST", "/api/users/1/subscriptions", strings.NewReader(`{"channel_id":404}`))
+	req = mux.SetURLVars(req, map[string]string{"userId": "1"})
+	w := httptest.NewRecorder()
+
+	handler.Subscribe(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+}
+
+func TestSubscribeInvalidNotificationLevel(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewSubscriptionHandler(db)
+
+	req := httptest.NewRequest("POST", "/api/users/1/subscriptions", strings.NewReader(`{"channel_id":5,"notification_level":"loud"}`))
+	req = mux.SetURLVars(req, map[string]string{"userId": "1"})
+	w := httptest.NewRecorder()
+
+	handler.Subscribe(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+}
+
+func TestUnsubscribeDecrementsCount(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewSubscriptionHandler(db)
+
+	mock.ExpectExec("DELETE FROM subscriptions (.+) UPDATE channels SET subscriber_count = GREATEST\\(subscriber_count - 1, 0\\)").
+		WithArgs(1, 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	req := httptest.NewRequest("DELETE", "/api/users/1/subscriptions/5", nil)
+	req = mux.SetURLVars(req, map[string]string{"userId": "1", "channelId": "5"})
+	w := httptest.NewRecorder()
+
+	handler.Unsubscribe(w, req)
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestUpdateNotificationLevel(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewSubscriptionHandler(db)
+
+	mock.ExpectExec("UPDATE subscriptions SET notification_level").
+		WithArgs("all", 1, 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	req := httptest.NewRequest("PUT", "/api/users/1/subscriptions/5/notifications", strings.NewReader(`{"notification_level":"all"}`))
+	req = mux.SetURLVars(req, map[string]string{"userId": "1", "channelId": "5"})
+	w := httptest.NewRecorder()
+
+	handler.UpdateNotificationLevel(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestNotifySubscribersSkipsVideosWithoutChannel(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	notifier := &recordingNotifier{}
+	if err := notifySubscribers(context.Background(), db, notifier, models.Video{ID: 1, Title: "Orphan"}); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if len(notifier.sent) != 0 {
+		t.Errorf("Expected no notifications, got %d", len(notifier.sent))
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/aung-arata/youtube-clone/backend/internal/auth"
+)
+
+// expectLoginCheck expects CheckLogin for alice from httptest's address
+func expectLoginCheck(mock sqlmock.Sqlmock, failures int) {
+	mock.ExpectQuery("FROM login_attempts WHERE email = \\$1").
+		WithArgs("alice@example.com", auth.LoginFailureWindow.Seconds()).
+		WillReturnRows(sqlmock.NewRows([]string{"count", "elapsed"}).AddRow(failures, 0.0))
+	mock.ExpectQuery("FROM login_attempts WHERE ip_address = \\$1").
+		WithArgs("192.0.2.1", auth.LoginFailureWindow.Seconds()).
+		WillReturnRows(sqlmock.NewRows([]string{"count", "elapsed"}).AddRow(failures, 0.0))
+}
+
+func expectChallengeUser(mock sqlmock.Sqlmock) {
+	now := time.Now()
+	mock.ExpectQuery("SELECT user_id FROM mfa_challenges").
+		WithArgs(auth.HashRefreshToken("challenge"), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(7))
+	mock.ExpectQuery("SELECT id, username, email, avatar, role, plan_id, created_at, updated_at, email_verified_at IS NOT NULL").
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email", "avatar", "role", "plan_id", "created_at", "updated_at", "verified"}).
+			AddRow(7, "alice", "alice@example.com", "", auth.RoleCreator, nil, now, now, true))
+}
+
+func TestLogin_TwoFactorDoesNotResetFailures(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewAuthHandler(db, nil)
+
+	hash, _ := auth.HashPassword("password123")
+	now := time.Now()
+	expectLoginCheck(mock, 2)
+	mock.ExpectQuery("SELECT (.+) FROM users WHERE email = \\$1").
+		WithArgs("alice@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email", "password", "avatar", "role", "plan_id", "created_at", "updated_at", "totp_enabled", "verified"}).
+			AddRow(7, "alice", "alice@example.com", hash, "", auth.RoleCreator, nil, now, now, true, true))
+	// No successful attempt is recorded until the code is checked
+	mock.ExpectExec("DELETE FROM mfa_challenges").
+		WithArgs(7).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO mfa_challenges").
+		WithArgs(7, sqlmock.AnyArg(), auth.MFAChallengeTTL.Seconds()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	req := httptest.NewRequest("POST", "/api/auth/login", bytes.NewBufferString(`{"email":"alice@example.com","password":"password123"}`))
+	w := httptest.NewRecorder()
+
+	handler.Login(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestLoginTwoFactor_WrongCodeCountsAsFailure(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewAuthHandler(db, nil)
+
+	expectChallengeUser(mock)
+	expectLoginCheck(mock, 2)
+	mock.ExpectQuery("UPDATE mfa_challenges SET attempts = attempts \\+ 1").
+		WithArgs(auth.HashRefreshToken("challenge"), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(7))
+	mock.ExpectExec("UPDATE mfa_recovery_codes SET used_at = CURRENT_TIMESTAMP").
+		WithArgs(7, auth.HashRecoveryCode("abcde-fghjk")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO login_attempts").
+		WithArgs("alice@example.com", sqlmock.AnyArg(), "192.0.2.1", sqlmock.AnyArg(), false).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	req := httptest.NewRequest("POST", "/api/auth/login/2fa", bytes.NewBufferString(`{"mfa_token":"challenge","code":"abcde-fghjk"}`))
+	w := httptest.NewRecorder()
+
+	handler.LoginTwoFactor(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestLoginTwoFactor_LockedOut(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewAuthHandler(db, nil)
+
+	// An open challenge doesn't allow more guesses once the account is locked
+	expectChallengeUser(mock)
+	expectLoginCheck(mock, auth.MaxLoginFailures)
+
+	req := httptest.NewRequest("POST", "/api/auth/login/2fa", bytes.NewBufferString(`{"mfa_token":"challenge","code":"123456"}`))
+	w := httptest.NewRecorder()
+
+	handler.LoginTwoFactor(w, req)
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status 429, got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("Expected a Retry-After header")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+// newAttemptCountingMock creates a mock that counts the statements adding to
+// the login attempt audit, whichever expectation they are matched against
+func newAttemptCountingMock(t *testing.T) (*sql.DB, sqlmock.Sqlmock, *int) {
+	attempts := 0
+	matcher := sqlmock.QueryMatcherFunc(func(expectedSQL, actualSQL string) error {
+		if strings.Contains(actualSQL, "INSERT INTO login_attempts") {
+			attempts++
+		}
+		return sqlmock.QueryMatcherRegexp.Match(expectedSQL, actualSQL)
+	})
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(matcher))
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	return db, mock, &attempts
+}
+
+func expectIssueTokens(mock sqlmock.Sqlmock) {
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO sessions").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO refresh_tokens").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+}
+
+func TestLogin_RecordsOneSuccessfulAttempt(t *testing.T) {
+	db, mock, attempts := newAttemptCountingMock(t)
+	defer db.Close()
+
+	handler := NewAuthHandler(db, nil)
+
+	hash, _ := auth.HashPassword("password123")
+	now := time.Now()
+	expectLoginCheck(mock, 0)
+	mock.ExpectQuery("SELECT (.+) FROM users WHERE email = \\$1").
+		WithArgs("alice@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email", "password", "avatar", "role", "plan_id", "created_at", "updated_at", "totp_enabled", "verified"}).
+			AddRow(7, "alice", "alice@example.com", hash, "", auth.RoleCreator, nil, now, now, false, true))
+	mock.ExpectExec("INSERT INTO login_attempts").
+		WithArgs("alice@example.com", sqlmock.AnyArg(), "192.0.2.1", sqlmock.AnyArg(), true).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectIssueTokens(mock)
+
+	req := httptest.NewRequest("POST", "/api/auth/login", bytes.NewBufferString(`{"email":"alice@example.com","password":"password123"}`))
+	w := httptest.NewRecorder()
+	handler.Login(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if *attempts != 1 {
+		t.Errorf("Expected 1 login attempt to be recorded, got %d", *attempts)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestLoginTwoFactor_RecordsOneSuccessfulAttempt(t *testing.T) {
+	db, mock, attempts := newAttemptCountingMock(t)
+	defer db.Close()
+
+	handler := NewAuthHandler(db, nil)
+
+	hash, _ := auth.HashPassword("password123")
+	now := time.Now()
+	expectLoginCheck(mock, 0)
+	mock.ExpectQuery("SELECT (.+) FROM users WHERE email = \\$1").
+		WithArgs("alice@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email", "password", "avatar", "role", "plan_id", "created_at", "updated_at", "totp_enabled", "verified"}).
+			AddRow(7, "alice", "alice@example.com", hash, "", auth.RoleCreator, nil, now, now, true, true))
+	mock.ExpectExec("DELETE FROM mfa_challenges").
+		WithArgs(7).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO mfa_challenges").
+		WithArgs(7, sqlmock.AnyArg(), auth.MFAChallengeTTL.Seconds()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	req := httptest.NewRequest("POST", "/api/auth/login", bytes.NewBufferString(`{"email":"alice@example.com","password":"password123"}`))
+	w := httptest.NewRecorder()
+	handler.Login(w, req)
+
+	var challenge MFAChallengeResponse
+	if err := json.NewDecoder(w.Body).Decode(&challenge); err != nil || challenge.MFAToken == "" {
+		t.Fatalf("Expected an MFA challenge, got %d: %v", w.Code, err)
+	}
+
+	tokenHash := auth.HashRefreshToken(challenge.MFAToken)
+	mock.ExpectQuery("SELECT user_id FROM mfa_challenges").
+		WithArgs(tokenHash, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(7))
+	mock.ExpectQuery("SELECT id, username, email, avatar, role, plan_id, created_at, updated_at, email_verified_at IS NOT NULL").
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email", "avatar", "role", "plan_id", "created_at", "updated_at", "verified"}).
+			AddRow(7, "alice", "alice@example.com", "", auth.RoleCreator, nil, now, now, true))
+	expectLoginCheck(mock, 0)
+	mock.ExpectQuery("UPDATE mfa_challenges SET attempts = attempts \\+ 1").
+		WithArgs(tokenHash, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(7))
+	mock.ExpectExec("UPDATE mfa_recovery_codes SET used_at = CURRENT_TIMESTAMP").
+		WithArgs(7, auth.HashRecoveryCode("abcde-fghjk")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM mfa_challenges WHERE token_hash = \\$1").
+		WithArgs(tokenHash).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO login_attempts").
+		WithArgs("alice@example.com", sqlmock.AnyArg(), "192.0.2.1", sqlmock.AnyArg(), true).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectIssueTokens(mock)
+
+	body := `{"mfa_token":"` + challenge.MFAToken + `","code":"abcde-fghjk"}`
+	req = httptest.NewRequest("POST", "/api/auth/login/2fa", bytes.NewBufferString(body))
+	w = httptest.NewRecorder()
+	handler.LoginTwoFactor(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if *attempts != 1 {
+		t.Errorf("Expected 1 login attempt to be recorded, got %d", *attempts)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestDisableTwoFactor_ClearsSessionMFA(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewAuthHandler(db, nil)
+
+	hash, _ := auth.HashPassword("password123")
+	mock.ExpectQuery("SELECT password, role FROM users WHERE id = \\$1").
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"password", "role"}).AddRow(hash, auth.RoleCreator))
+	mock.ExpectExec("UPDATE mfa_recovery_codes SET used_at = CURRENT_TIMESTAMP").
+		WithArgs(7, auth.HashRecoveryCode("abcde-fghjk")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users SET totp_enabled = FALSE").
+		WithArgs(7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM mfa_recovery_codes WHERE user_id = \\$1").
+		WithArgs(7).
+		WillReturnResult(sqlmock.NewResult(0, 9))
+	// Refreshed tokens must not claim the second factor any more
+	mock.ExpectExec("UPDATE sessions SET mfa = FALSE WHERE user_id = \\$1").
+		WithArgs(7).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	req := withUser(httptest.NewRequest("POST", "/api/auth/2fa/disable", bytes.NewBufferString(`{"password":"password123","code":"abcde-fghjk"}`)), 7)
+	w := httptest.NewRecorder()
+
+	handler.DisableTwoFactor(w, req)
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
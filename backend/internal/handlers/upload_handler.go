@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/aung-arata/youtube-clone/backend/internal/middleware"
@@ -12,26 +13,29 @@ import (
 )
 
 type UploadHandler struct {
-	db      *sql.DB
-	storage *storage.FileStorage
+	db       *sql.DB
+	storage  *storage.FileStorage
+	notifier Notifier
 }
 
-func NewUploadHandler(db *sql.DB, fileStorage *storage.FileStorage) *UploadHandler {
+// NewUploadHandler creates an UploadHandler. Without a notifier, subscribers
+// aren't told about new uploads.
+func NewUploadHandler(db *sql.DB, fileStorage *storage.FileStorage, notifier Notifier) *UploadHandler {
 	return &UploadHandler{
-		db:      db,
-		storage: fileStorage,
+		db:       db,
+		storage:  fileStorage,
+		notifier: notifier,
 	}
 }
 
 // UploadVideo handles video upload with multipart form data
 func (h *UploadHandler) UploadVideo(w http.ResponseWriter, r *http.Request) {
 	// Get user ID from context (set by AuthMiddleware)
-	_, ok := r.Context().Value(middleware.UserIDKey).(int)
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
 	if !ok {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
-	// Note: userID can be used in future for tracking uploads per user
 
 	// Parse multipart form (max 500MB + 5MB for thumbnail)
 	if err := r.ParseMultipartForm(510 * 1024 * 1024); err != nil {
@@ -43,16 +47,22 @@ func (h *UploadHandler) UploadVideo(w http.ResponseWriter, r *http.Request) {
 	title := r.FormValue("title")
 	description := r.FormValue("description")
 	category := r.FormValue("category")
-	channelName := r.FormValue("channel_name")
-	channelAvatar := r.FormValue("channel_avatar")
 
 	// Validate required fields
 	if strings.TrimSpace(title) == "" {
 		http.Error(w, "Title is required", http.StatusBadRequest)
 		return
 	}
-	if strings.TrimSpace(channelName) == "" {
-		http.Error(w, "Channel name is required", http.StatusBadRequest)
+	channelID, err := strconv.Atoi(r.FormValue("channel_id"))
+	if err != nil || channelID <= 0 {
+		http.Error(w, "Channel ID is required", http.StatusBadRequest)
+		return
+	}
+
+	// Only the channel's owner and team members who manage videos may upload
+	channel, err := loadChannelFor(h.db, channelID, userID, channelManageVideos)
+	if err != nil {
+		writeChannelAccessError(w, err)
 		return
 	}
 
@@ -93,15 +103,15 @@ func (h *UploadHandler) UploadVideo(w http.ResponseWriter, r *http.Request) {
 
 	// Insert video into database
 	query := `
-		INSERT INTO videos (title, description, url, thumbnail, channel_name, channel_avatar, category, duration, views, likes, dislikes)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, 0, 0, 0)
-		RETURNING id, title, description, url, thumbnail, channel_name, channel_avatar, views, likes, dislikes, category, duration, uploaded_at, created_at, updated_at
+		INSERT INTO videos (title, description, url, thumbnail, channel_id, channel_name, channel_avatar, category, duration, views, likes, dislikes)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, 0, 0, 0)
+		RETURNING id, title, description, url, thumbnail, channel_id, channel_name, channel_avatar, views, likes, dislikes, category, duration, uploaded_at, created_at, updated_at
 	`
 
 	var video models.Video
-	err = h.db.QueryRow(query, title, description, videoURL, thumbnailURL, channelName, channelAvatar, category, duration).Scan(
+	err = h.db.QueryRow(query, title, description, videoURL, thumbnailURL, channel.ID, channel.DisplayName, channel.Avatar, category, duration).Scan(
 		&video.ID, &video.Title, &video.Description, &video.URL, &video.Thumbnail,
-		&video.ChannelName, &video.ChannelAvatar, &video.Views, &video.Likes, &video.Dislikes,
+		&video.ChannelID, &video.ChannelName, &video.ChannelAvatar, &video.Views, &video.Likes, &video.Dislikes,
 		&video.Category, &video.Duration, &video.UploadedAt, &video.CreatedAt, &video.UpdatedAt,
 	)
 
@@ -115,9 +125,13 @@ func (h *UploadHandler) UploadVideo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// A failed fan-out shouldn't undo a successful upload
+	if h.notifier != nil {
+		go notifySubscribersInBackground(h.db, h.notifier, video)
+	}
+
 	// Create video record
 	// Note: In a complete implementation, you would:
-	// - Store the userID as the uploader in a videos.user_id column
 	// - Add audit logging with userID, timestamp, and file paths
 	// - Track upload statistics per user
 
@@ -129,7 +143,7 @@ func (h *UploadHandler) UploadVideo(w http.ResponseWriter, r *http.Request) {
 // DeleteVideo handles video deletion including file cleanup
 func (h *UploadHandler) DeleteVideo(w http.ResponseWriter, r *http.Request) {
 	// Get user ID from context
-	_, ok := r.Context().Value(middleware.UserIDKey).(int)
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
 	if !ok {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
@@ -143,9 +157,10 @@ func (h *UploadHandler) DeleteVideo(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get video details first to delete files
-	query := `SELECT url, thumbnail FROM videos WHERE id = $1`
+	query := `SELECT url, thumbnail, channel_id FROM videos WHERE id = $1`
 	var videoURL, thumbnailURL string
-	err := h.db.QueryRow(query, videoID).Scan(&videoURL, &thumbnailURL)
+	var channelID sql.NullInt64
+	err := h.db.QueryRow(query, videoID).Scan(&videoURL, &thumbnailURL, &channelID)
 	if err == sql.ErrNoRows {
 		http.Error(w, "Video not found", http.StatusNotFound)
 		return
@@ -154,6 +169,16 @@ func (h *UploadHandler) DeleteVideo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Only the channel's owner and team members who manage videos may delete it
+	if !channelID.Valid {
+		http.Error(w, "You do not have access to this channel", http.StatusForbidden)
+		return
+	}
+	if _, err := loadChannelFor(h.db, int(channelID.Int64), userID, channelManageVideos); err != nil {
+		writeChannelAccessError(w, err)
+		return
+	}
+
 	// Delete video record from database
 	deleteQuery := `DELETE FROM videos WHERE id = $1`
 	_, err = h.db.Exec(deleteQuery, videoID)
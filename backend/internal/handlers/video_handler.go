@@ -9,16 +9,20 @@ import (
 	"strings"
 	"time"
 
+	"github.com/aung-arata/youtube-clone/backend/internal/middleware"
 	"github.com/aung-arata/youtube-clone/backend/internal/models"
 	"github.com/gorilla/mux"
 )
 
 type VideoHandler struct {
-	db *sql.DB
+	db       *sql.DB
+	notifier Notifier
 }
 
-func NewVideoHandler(db *sql.DB) *VideoHandler {
-	return &VideoHandler{db: db}
+// NewVideoHandler creates a VideoHandler. Without a notifier, subscribers
+// aren't told about published videos.
+func NewVideoHandler(db *sql.DB, notifier Notifier) *VideoHandler {
+	return &VideoHandler{db: db, notifier: notifier}
 }
 
 // GetVideos returns all videos with optional search, category filter and pagination
@@ -54,7 +58,7 @@ func (h *VideoHandler) GetVideos(w http.ResponseWriter, r *http.Request) {
 
 	// Build query with optional search and category filter
 	query := `
-		SELECT id, title, description, url, thumbnail, channel_name, 
+		SELECT id, title, description, url, thumbnail, channel_id, channel_name, 
 		       channel_avatar, views, likes, dislikes, category, duration, uploaded_at, created_at, updated_at
 		FROM videos
 	`
@@ -158,7 +162,7 @@ func (h *VideoHandler) GetVideos(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var v models.Video
 		err := rows.Scan(&v.ID, &v.Title, &v.Description, &v.URL, &v.Thumbnail,
-			&v.ChannelName, &v.ChannelAvatar, &v.Views, &v.Likes, &v.Dislikes, &v.Category, &v.Duration,
+			&v.ChannelID, &v.ChannelName, &v.ChannelAvatar, &v.Views, &v.Likes, &v.Dislikes, &v.Category, &v.Duration,
 			&v.UploadedAt, &v.CreatedAt, &v.UpdatedAt)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -185,7 +189,7 @@ func (h *VideoHandler) GetVideo(w http.ResponseWriter, r *http.Request) {
 	}
 
 	query := `
-		SELECT id, title, description, url, thumbnail, channel_name, 
+		SELECT id, title, description, url, thumbnail, channel_id, channel_name, 
 		       channel_avatar, views, likes, dislikes, category, duration, uploaded_at, created_at, updated_at
 		FROM videos
 		WHERE id = $1
@@ -193,7 +197,7 @@ func (h *VideoHandler) GetVideo(w http.ResponseWriter, r *http.Request) {
 
 	var v models.Video
 	err = h.db.QueryRow(query, id).Scan(&v.ID, &v.Title, &v.Description, &v.URL,
-		&v.Thumbnail, &v.ChannelName, &v.ChannelAvatar, &v.Views, &v.Likes, &v.Dislikes, &v.Category, &v.Duration,
+		&v.Thumbnail, &v.ChannelID, &v.ChannelName, &v.ChannelAvatar, &v.Views, &v.Likes, &v.Dislikes, &v.Category, &v.Duration,
 		&v.UploadedAt, &v.CreatedAt, &v.UpdatedAt)
 
 	if err == sql.ErrNoRows {
@@ -208,8 +212,15 @@ func (h *VideoHandler) GetVideo(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(v)
 }
 
-// CreateVideo creates a new video
+// CreateVideo creates a new video on a channel whose videos the authenticated
+// user manages
 func (h *VideoHandler) CreateVideo(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
 	var v models.Video
 	if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -225,30 +236,118 @@ func (h *VideoHandler) CreateVideo(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "URL is required", http.StatusBadRequest)
 		return
 	}
-	if strings.TrimSpace(v.ChannelName) == "" {
-		http.Error(w, "Channel name is required", http.StatusBadRequest)
+	if v.ChannelID == nil || *v.ChannelID <= 0 {
+		http.Error(w, "Channel ID is required", http.StatusBadRequest)
+		return
+	}
+
+	channel, err := loadChannelFor(h.db, *v.ChannelID, userID, channelManageVideos)
+	if err != nil {
+		writeChannelAccessError(w, err)
 		return
 	}
+	v.ChannelName = channel.DisplayName
+	v.ChannelAvatar = channel.Avatar
 
 	query := `
-		INSERT INTO videos (title, description, url, thumbnail, channel_name, channel_avatar, duration)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO videos (title, description, url, thumbnail, channel_id, channel_name, channel_avatar, duration)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 		RETURNING id, uploaded_at, created_at, updated_at
 	`
 
-	err := h.db.QueryRow(query, v.Title, v.Description, v.URL, v.Thumbnail,
-		v.ChannelName, v.ChannelAvatar, v.Duration).Scan(&v.ID, &v.UploadedAt, &v.CreatedAt, &v.UpdatedAt)
+	err = h.db.QueryRow(query, v.Title, v.Description, v.URL, v.Thumbnail,
+		channel.ID, v.ChannelName, v.ChannelAvatar, v.Duration).Scan(&v.ID, &v.UploadedAt, &v.CreatedAt, &v.UpdatedAt)
 
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	v.ChannelID = &channel.ID
+
+	// A failed fan-out shouldn't undo a successful publish
+	if h.notifier != nil {
+		go notifySubscribersInBackground(h.db, h.notifier, v)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(v)
 }
 
+// VideoUpdateRequest is the body accepted when editing a video's details.
+// Fields that are left out keep their value.
+type VideoUpdateRequest struct {
+	Title       *string `json:"title"`
+	Description *string `json:"description"`
+	Thumbnail   *string `json:"thumbnail"`
+	Category    *string `json:"category"`
+}
+
+// UpdateVideo edits a video's details as the channel's owner or a team member
+// who manages its videos
+func (h *VideoHandler) UpdateVideo(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid video ID", http.StatusBadRequest)
+		return
+	}
+
+	var req VideoUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Title != nil {
+		title := strings.TrimSpace(*req.Title)
+		if title == "" || len(title) > 255 {
+			http.Error(w, "Title must be 1-255 characters", http.StatusBadRequest)
+			return
+		}
+		req.Title = &title
+	}
+	if req.Category != nil && len(*req.Category) > 50 {
+		http.Error(w, "Category must be at most 50 characters", http.StatusBadRequest)
+		return
+	}
+
+	if err := checkVideoChannelAccess(h.db, id, userID, channelManageVideos); err != nil {
+		writeChannelAccessError(w, err)
+		return
+	}
+
+	query := `
+		UPDATE videos
+		SET title = COALESCE($1, title), description = COALESCE($2, description),
+		    thumbnail = COALESCE($3, thumbnail), category = COALESCE($4, category),
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = $5
+		RETURNING id, title, description, url, thumbnail, channel_id, channel_name,
+		          channel_avatar, views, likes, dislikes, category, duration, uploaded_at, created_at, updated_at
+	`
+
+	var v models.Video
+	err = h.db.QueryRow(query, req.Title, req.Description, req.Thumbnail, req.Category, id).Scan(&v.ID, &v.Title, &v.Description, &v.URL,
+		&v.Thumbnail, &v.ChannelID, &v.ChannelName, &v.ChannelAvatar, &v.Views, &v.Likes, &v.Dislikes, &v.Category, &v.Duration,
+		&v.UploadedAt, &v.CreatedAt, &v.UpdatedAt)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Video not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
 // IncrementViews increments the view count for a video
 func (h *VideoHandler) IncrementViews(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -405,7 +504,7 @@ func (h *VideoHandler) GetRecommendations(w http.ResponseWriter, r *http.Request
 
 	// Get recommended videos from the same category, sorted by views
 	query := `
-		SELECT id, title, description, url, thumbnail, channel_name, channel_avatar,
+		SELECT id, title, description, url, thumbnail, channel_id, channel_name, channel_avatar,
 		       views, likes, dislikes, category, duration, uploaded_at, created_at, updated_at
 		FROM videos
 		WHERE category = $1 AND id != $2
@@ -424,7 +523,7 @@ func (h *VideoHandler) GetRecommendations(w http.ResponseWriter, r *http.Request
 	for rows.Next() {
 		var v models.Video
 		err := rows.Scan(&v.ID, &v.Title, &v.Description, &v.URL, &v.Thumbnail,
-			&v.ChannelName, &v.ChannelAvatar, &v.Views, &v.Likes, &v.Dislikes,
+			&v.ChannelID, &v.ChannelName, &v.ChannelAvatar, &v.Views, &v.Likes, &v.Dislikes,
 			&v.Category, &v.Duration, &v.UploadedAt, &v.CreatedAt, &v.UpdatedAt)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -458,7 +557,7 @@ func (h *VideoHandler) GetTrendingVideos(w http.ResponseWriter, r *http.Request)
 
 	// Get videos uploaded or viewed recently, sorted by views
 	query := `
-		SELECT id, title, description, url, thumbnail, channel_name, 
+		SELECT id, title, description, url, thumbnail, channel_id, channel_name, 
 		       channel_avatar, views, likes, dislikes, category, duration, uploaded_at, created_at, updated_at
 		FROM videos
 		WHERE uploaded_at >= NOW() - INTERVAL '7 days'
@@ -477,7 +576,7 @@ func (h *VideoHandler) GetTrendingVideos(w http.ResponseWriter, r *http.Request)
 	for rows.Next() {
 		var v models.Video
 		err := rows.Scan(&v.ID, &v.Title, &v.Description, &v.URL, &v.Thumbnail,
-			&v.ChannelName, &v.ChannelAvatar, &v.Views, &v.Likes, &v.Dislikes,
+			&v.ChannelID, &v.ChannelName, &v.ChannelAvatar, &v.Views, &v.Likes, &v.Dislikes,
 			&v.Category, &v.Duration, &v.UploadedAt, &v.CreatedAt, &v.UpdatedAt)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -510,7 +609,7 @@ func (h *VideoHandler) GetPopularVideos(w http.ResponseWriter, r *http.Request)
 	}
 
 	query := `
-		SELECT id, title, description, url, thumbnail, channel_name, 
+		SELECT id, title, description, url, thumbnail, channel_id, channel_name, 
 		       channel_avatar, views, likes, dislikes, category, duration, uploaded_at, created_at, updated_at
 		FROM videos
 		ORDER BY views DESC, likes DESC
@@ -528,7 +627,7 @@ func (h *VideoHandler) GetPopularVideos(w http.ResponseWriter, r *http.Request)
 	for rows.Next() {
 		var v models.Video
 		err := rows.Scan(&v.ID, &v.Title, &v.Description, &v.URL, &v.Thumbnail,
-			&v.ChannelName, &v.ChannelAvatar, &v.Views, &v.Likes, &v.Dislikes,
+			&v.ChannelID, &v.ChannelName, &v.ChannelAvatar, &v.Views, &v.Likes, &v.Dislikes,
 			&v.Category, &v.Duration, &v.UploadedAt, &v.CreatedAt, &v.UpdatedAt)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -550,8 +649,15 @@ func (h *VideoHandler) GetPopularVideos(w http.ResponseWriter, r *http.Request)
 	json.NewEncoder(w).Encode(videos)
 }
 
-// GetVideoAnalytics returns detailed analytics for a specific video
+// GetVideoAnalytics returns detailed analytics for a specific video. Anyone on
+// the team of the video's channel can see them.
 func (h *VideoHandler) GetVideoAnalytics(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
@@ -559,6 +665,11 @@ func (h *VideoHandler) GetVideoAnalytics(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if err := checkVideoChannelAccess(h.db, id, userID, channelViewAnalytics); err != nil {
+		writeChannelAccessError(w, err)
+		return
+	}
+
 	query := `
 		SELECT id, title, views, likes, dislikes, category, uploaded_at
 		FROM videos
@@ -21,16 +21,16 @@ func TestGetVideos(t *testing.T) {
 	}
 	defer db.Close()
 
-	handler := NewVideoHandler(db)
+	handler := NewVideoHandler(db, nil)
 
 	now := time.Now()
 	rows := sqlmock.NewRows([]string{
 		"id", "title", "description", "url", "thumbnail",
-		"channel_name", "channel_avatar", "views", "likes", "dislikes", "category", "duration",
+		"channel_id", "channel_name", "channel_avatar", "views", "likes", "dislikes", "category", "duration",
 		"uploaded_at", "created_at", "updated_at",
 	}).AddRow(
 		1, "Test Video", "Test Description", "http://example.com/video.mp4",
-		"http://example.com/thumb.jpg", "Test Channel", "http://example.com/avatar.jpg",
+		"http://example.com/thumb.jpg", 1, "Test Channel", "http://example.com/avatar.jpg",
 		100, 0, 0, "General", "10:00", now, now, now,
 	)
 
@@ -72,33 +72,46 @@ func TestCreateVideo_Success(t *testing.T) {
 	}
 	defer db.Close()
 
-	handler := NewVideoHandler(db)
+	notifier := &recordingNotifier{}
+	handler := NewVideoHandler(db, notifier)
 
+	channelID := 7
 	video := models.Video{
-		Title:         "New Video",
-		Description:   "New Description",
-		URL:           "http://example.com/video.mp4",
-		Thumbnail:     "http://example.com/thumb.jpg",
-		ChannelName:   "New Channel",
-		ChannelAvatar: "http://example.com/avatar.jpg",
-		Duration:      "5:00",
+		Title:       "New Video",
+		Description: "New Description",
+		URL:         "http://example.com/video.mp4",
+		Thumbnail:   "http://example.com/thumb.jpg",
+		ChannelID:   &channelID,
+		ChannelName: "Spoofed Channel",
+		Duration:    "5:00",
 	}
 
 	now := time.Now()
+	mock.ExpectQuery("SELECT (.+) FROM channels WHERE id").
+		WithArgs(channelID).
+		WillReturnRows(channelRows().AddRow(channelID, 1, "new-channel", "New Channel",
+			"http://example.com/avatar.jpg", "", "", []byte("[]"), 0, now, now))
+
 	rows := sqlmock.NewRows([]string{"id", "uploaded_at", "created_at", "updated_at"}).
 		AddRow(1, now, now, now)
 
+	// Channel name and avatar come from the channel, not the request body
 	mock.ExpectQuery("INSERT INTO videos (.+) VALUES (.+) RETURNING (.+)").
 		WithArgs(video.Title, video.Description, video.URL, video.Thumbnail,
-			video.ChannelName, video.ChannelAvatar, video.Duration).
+			channelID, "New Channel", "http://example.com/avatar.jpg", video.Duration).
 		WillReturnRows(rows)
 
+	mock.ExpectQuery("SELECT s.user_id FROM subscriptions s").
+		WithArgs(channelID).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(2).AddRow(3))
+
 	body, _ := json.Marshal(video)
 	req, err := http.NewRequest("POST", "/api/videos", bytes.NewBuffer(body))
 	if err != nil {
 		t.Fatal(err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	req = withUser(req, 1)
 
 	rr := httptest.NewRecorder()
 	handler.CreateVideo(rr, req)
@@ -106,6 +119,14 @@ func TestCreateVideo_Success(t *testing.T) {
 	if status := rr.Code; status != http.StatusCreated {
 		t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusCreated)
 	}
+	// Subscribers are told after the response, in the background
+	sent := notifier.waitFor(t, 2)
+	if len(sent) != 2 {
+		t.Fatalf("Expected 2 notifications, got %d", len(sent))
+	}
+	if n := sent[0]; n.Title != "New Channel uploaded a new video" || n.Link != "/videos/1" || n.CollapseKey != "new_video:7" {
+		t.Errorf("Unexpected notification %+v", n)
+	}
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("Unfulfilled expectations: %v", err)
@@ -119,11 +140,12 @@ func TestCreateVideo_MissingTitle(t *testing.T) {
 	}
 	defer db.Close()
 
-	handler := NewVideoHandler(db)
+	handler := NewVideoHandler(db, nil)
 
+	channelID := 7
 	video := models.Video{
-		URL:         "http://example.com/video.mp4",
-		ChannelName: "Channel",
+		URL:       "http://example.com/video.mp4",
+		ChannelID: &channelID,
 	}
 
 	body, _ := json.Marshal(video)
@@ -132,6 +154,7 @@ func TestCreateVideo_MissingTitle(t *testing.T) {
 		t.Fatal(err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	req = withUser(req, 1)
 
 	rr := httptest.NewRecorder()
 	handler.CreateVideo(rr, req)
@@ -141,6 +164,43 @@ func TestCreateVideo_MissingTitle(t *testing.T) {
 	}
 }
 
+func TestCreateVideo_NotChannelOwner(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock database: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewVideoHandler(db, nil)
+
+	now := time.Now()
+	mock.ExpectQuery("SELECT (.+) FROM channels WHERE id").
+		WithArgs(7).
+		WillReturnRows(channelRows().AddRow(7, 2, "someone-else", "Someone Else", "", "", "", []byte("[]"), 0, now, now))
+	mock.ExpectQuery("SELECT role FROM channel_members").
+		WithArgs(7, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"role"}))
+
+	channelID := 7
+	body, _ := json.Marshal(models.Video{Title: "Hijack", URL: "http://example.com/v.mp4", ChannelID: &channelID})
+	req, err := http.NewRequest("POST", "/api/videos", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = withUser(req, 1)
+
+	rr := httptest.NewRecorder()
+	handler.CreateVideo(rr, req)
+
+	if status := rr.Code; status != http.StatusForbidden {
+		t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusForbidden)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
 func TestGetVideo_Success(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -148,16 +208,16 @@ func TestGetVideo_Success(t *testing.T) {
 	}
 	defer db.Close()
 
-	handler := NewVideoHandler(db)
+	handler := NewVideoHandler(db, nil)
 
 	now := time.Now()
 	rows := sqlmock.NewRows([]string{
 		"id", "title", "description", "url", "thumbnail",
-		"channel_name", "channel_avatar", "views", "likes", "dislikes", "category", "duration",
+		"channel_id", "channel_name", "channel_avatar", "views", "likes", "dislikes", "category", "duration",
 		"uploaded_at", "created_at", "updated_at",
 	}).AddRow(
 		1, "Test Video", "Test Description", "http://example.com/video.mp4",
-		"http://example.com/thumb.jpg", "Test Channel", "http://example.com/avatar.jpg",
+		"http://example.com/thumb.jpg", 1, "Test Channel", "http://example.com/avatar.jpg",
 		100, 0, 0, "General", "10:00", now, now, now,
 	)
 
@@ -189,7 +249,7 @@ func TestGetVideo_NotFound(t *testing.T) {
 	}
 	defer db.Close()
 
-	handler := NewVideoHandler(db)
+	handler := NewVideoHandler(db, nil)
 
 	mock.ExpectQuery("SELECT (.+) FROM videos WHERE id = (.+)").
 		WithArgs(999).
@@ -221,7 +281,7 @@ func TestIncrementViews_Success(t *testing.T) {
 	}
 	defer db.Close()
 
-	handler := NewVideoHandler(db)
+	handler := NewVideoHandler(db, nil)
 
 	rows := sqlmock.NewRows([]string{"views"}).AddRow(101)
 
@@ -253,7 +313,7 @@ t.Fatalf("Failed to create mock database: %v", err)
 }
 defer db.Close()
 
-handler := NewVideoHandler(db)
+handler := NewVideoHandler(db, nil)
 
 rows := sqlmock.NewRows([]string{"likes"}).AddRow(10)
 
@@ -294,7 +354,7 @@ t.Fatalf("Failed to create mock database: %v", err)
 }
 defer db.Close()
 
-handler := NewVideoHandler(db)
+handler := NewVideoHandler(db, nil)
 
 rows := sqlmock.NewRows([]string{"dislikes"}).AddRow(3)
 
@@ -304,5 +304,695 @@ func GetAllMigrations() []Migration {
 				return err
 			},
 		},
+		{
+			Version:     11,
+			Name:        "add_subscription_last_visited",
+			Description: "Adds a last-visit marker to subscriptions and a feed index on videos",
+			Up: func(db *sql.DB) error {
+				query := `
+				ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS last_visited_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
+
+				CREATE INDEX IF NOT EXISTS idx_videos_channel_uploaded ON videos (channel_name, uploaded_at DESC, id DESC);
+				`
+				_, err := db.Exec(query)
+				return err
+			},
+			Down: func(db *sql.DB) error {
+				_, err := db.Exec("DROP INDEX IF EXISTS idx_videos_channel_uploaded; ALTER TABLE subscriptions DROP COLUMN IF EXISTS last_visited_at")
+				return err
+			},
+		},
+		{
+			Version:     12,
+			Name:        "create_channels_table",
+			Description: "Creates channels, links videos and subscriptions to them and backfills legacy channel names",
+			Up: func(db *sql.DB) error {
+				query := `
+				CREATE TABLE IF NOT EXISTS channels (
+					id SERIAL PRIMARY KEY,
+					user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+					handle VARCHAR(30) UNIQUE NOT NULL,
+					display_name VARCHAR(100) NOT NULL,
+					avatar VARCHAR(500) NOT NULL DEFAULT '',
+					banner VARCHAR(500) NOT NULL DEFAULT '',
+					description TEXT NOT NULL DEFAULT '',
+					links JSONB NOT NULL DEFAULT '[]',
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_channels_user_id ON channels (user_id);
+
+				ALTER TABLE videos ADD COLUMN IF NOT EXISTS channel_id INTEGER REFERENCES channels(id) ON DELETE CASCADE;
+				ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS channel_id INTEGER REFERENCES channels(id) ON DELETE CASCADE;
+				ALTER TABLE subscriptions ALTER COLUMN channel_name DROP NOT NULL;
+
+				-- Backfill: every legacy free-text channel name becomes an unowned channel
+				INSERT INTO channels (handle, display_name, avatar)
+				SELECT DISTINCT ON (n.name)
+					COALESCE(NULLIF(left(trim(both '-' from regexp_replace(lower(n.name), '[^a-z0-9]+', '-', 'g')), 23), ''), 'channel')
+						|| '-' || substr(md5(n.name), 1, 6),
+					n.name,
+					COALESCE(n.avatar, '')
+				FROM (
+					SELECT channel_name AS name, MAX(channel_avatar) AS avatar FROM videos WHERE channel_id IS NULL GROUP BY channel_name
+					UNION ALL
+					SELECT channel_name, NULL FROM subscriptions WHERE channel_id IS NULL AND channel_name IS NOT NULL
+				) n
+				WHERE NOT EXISTS (SELECT 1 FROM channels c WHERE c.user_id IS NULL AND c.display_name = n.name)
+				ORDER BY n.name, n.avatar NULLS LAST
+				ON CONFLICT (handle) DO NOTHING;
+
+				UPDATE videos v SET channel_id = c.id
+				FROM channels c
+				WHERE v.channel_id IS NULL AND c.user_id IS NULL AND c.display_name = v.channel_name;
+
+				UPDATE subscriptions s SET channel_id = c.id
+				FROM channels c
+				WHERE s.channel_id IS NULL AND c.user_id IS NULL AND c.display_name = s.channel_name;
+
+				CREATE UNIQUE INDEX IF NOT EXISTS idx_subscriptions_user_channel ON subscriptions (user_id, channel_id);
+				CREATE INDEX IF NOT EXISTS idx_subscriptions_channel_id ON subscriptions (channel_id);
+				CREATE INDEX IF NOT EXISTS idx_videos_channel_id_uploaded ON videos (channel_id, uploaded_at DESC, id DESC);
+				`
+				_, err := db.Exec(query)
+				return err
+			},
+			Down: func(db *sql.DB) error {
+				_, err := db.Exec(`
+				DROP INDEX IF EXISTS idx_subscriptions_user_channel;
+				ALTER TABLE subscriptions DROP COLUMN IF EXISTS channel_id;
+				ALTER TABLE videos DROP COLUMN IF EXISTS channel_id;
+				DROP TABLE IF EXISTS channels CASCADE;
+				`)
+				return err
+			},
+		},
+		{
+			Version:     13,
+			Name:        "add_subscriber_counts_and_notification_levels",
+			Description: "Adds a maintained subscriber count to channels and a bell level to subscriptions",
+			Up: func(db *sql.DB) error {
+				query := `
+				ALTER TABLE channels ADD COLUMN IF NOT EXISTS subscriber_count INTEGER NOT NULL DEFAULT 0;
+				UPDATE channels c SET subscriber_count = (SELECT COUNT(*) FROM subscriptions s WHERE s.channel_id = c.id);
+
+				ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS notification_level VARCHAR(20) NOT NULL DEFAULT 'personalized'
+					CHECK (notification_level IN ('all', 'personalized', 'none'));
+				`
+				_, err := db.Exec(query)
+				return err
+			},
+			Down: func(db *sql.DB) error {
+				_, err := db.Exec(`
+				ALTER TABLE subscriptions DROP COLUMN IF EXISTS notification_level;
+				ALTER TABLE channels DROP COLUMN IF EXISTS subscriber_count;
+				`)
+				return err
+			},
+		},
+		{
+			Version:     14,
+			Name:        "add_comment_threads_and_likes",
+			Description: "Adds reply threading, reply counts and per-comment likes",
+			Up: func(db *sql.DB) error {
+				query := `
+				ALTER TABLE comments ADD COLUMN IF NOT EXISTS parent_id INTEGER REFERENCES comments(id) ON DELETE CASCADE;
+				ALTER TABLE comments ADD COLUMN IF NOT EXISTS depth SMALLINT NOT NULL DEFAULT 0;
+				ALTER TABLE comments ADD COLUMN IF NOT EXISTS likes INTEGER NOT NULL DEFAULT 0;
+				ALTER TABLE comments ADD COLUMN IF NOT EXISTS reply_count INTEGER NOT NULL DEFAULT 0;
+
+				CREATE TABLE IF NOT EXISTS comment_likes (
+					comment_id INTEGER REFERENCES comments(id) ON DELETE CASCADE,
+					user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					PRIMARY KEY (comment_id, user_id)
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_comments_video_top ON comments (video_id, likes DESC, id DESC) WHERE parent_id IS NULL;
+				CREATE INDEX IF NOT EXISTS idx_comments_video_newest ON comments (video_id, created_at DESC, id DESC) WHERE parent_id IS NULL;
+				CREATE INDEX IF NOT EXISTS idx_comments_parent ON comments (parent_id, created_at, id);
+				`
+				_, err := db.Exec(query)
+				return err
+			},
+			Down: func(db *sql.DB) error {
+				_, err := db.Exec(`
+				DROP TABLE IF EXISTS comment_likes;
+				DROP INDEX IF EXISTS idx_comments_video_top;
+				DROP INDEX IF EXISTS idx_comments_video_newest;
+				DROP INDEX IF EXISTS idx_comments_parent;
+				ALTER TABLE comments DROP COLUMN IF EXISTS reply_count;
+				ALTER TABLE comments DROP COLUMN IF EXISTS likes;
+				ALTER TABLE comments DROP COLUMN IF EXISTS depth;
+				ALTER TABLE comments DROP COLUMN IF EXISTS parent_id;
+				`)
+				return err
+			},
+		},
+		{
+			Version:     15,
+			Name:        "add_comment_moderation",
+			Description: "Adds pinned, hearted and moderation status to comments and a blocked-words list to channels",
+			Up: func(db *sql.DB) error {
+				query := `
+				ALTER TABLE comments ADD COLUMN IF NOT EXISTS is_pinned BOOLEAN NOT NULL DEFAULT FALSE;
+				ALTER TABLE comments ADD COLUMN IF NOT EXISTS is_hearted BOOLEAN NOT NULL DEFAULT FALSE;
+				ALTER TABLE comments ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'published'
+					CHECK (status IN ('published', 'held', 'hidden'));
+				ALTER TABLE channels ADD COLUMN IF NOT EXISTS blocked_words JSONB NOT NULL DEFAULT '[]';
+
+				CREATE UNIQUE INDEX IF NOT EXISTS idx_comments_one_pinned ON comments (video_id) WHERE is_pinned;
+				CREATE INDEX IF NOT EXISTS idx_comments_held ON comments (video_id, created_at DESC, id DESC) WHERE status = 'held';
+				`
+				_, err := db.Exec(query)
+				return err
+			},
+			Down: func(db *sql.DB) error {
+				_, err := db.Exec(`
+				DROP INDEX IF EXISTS idx_comments_one_pinned;
+				DROP INDEX IF EXISTS idx_comments_held;
+				ALTER TABLE channels DROP COLUMN IF EXISTS blocked_words;
+				ALTER TABLE comments DROP COLUMN IF EXISTS status;
+				ALTER TABLE comments DROP COLUMN IF EXISTS is_hearted;
+				ALTER TABLE comments DROP COLUMN IF EXISTS is_pinned;
+				`)
+				return err
+			},
+		},
+		{
+			Version:     16,
+			Name:        "add_user_mutes",
+			Description: "Creates the user_mutes table used to suppress comment notifications from muted users",
+			Up: func(db *sql.DB) error {
+				query := `
+				CREATE TABLE IF NOT EXISTS user_mutes (
+					user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+					muted_user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					PRIMARY KEY (user_id, muted_user_id),
+					CHECK (user_id <> muted_user_id)
+				);
+				`
+				_, err := db.Exec(query)
+				return err
+			},
+			Down: func(db *sql.DB) error {
+				_, err := db.Exec("DROP TABLE IF EXISTS user_mutes CASCADE")
+				return err
+			},
+		},
+		{
+			Version:     17,
+			Name:        "add_comment_spam_filter",
+			Description: "Adds the rejected comment status, per-comment spam filter verdicts and the filter's settings",
+			Up: func(db *sql.DB) error {
+				query := `
+				ALTER TABLE comments DROP CONSTRAINT IF EXISTS comments_status_check;
+				ALTER TABLE comments ADD CONSTRAINT comments_status_check
+					CHECK (status IN ('published', 'held', 'hidden', 'rejected'));
+
+				CREATE TABLE IF NOT EXISTS comment_verdicts (
+					comment_id INTEGER PRIMARY KEY REFERENCES comments(id) ON DELETE CASCADE,
+					score DOUBLE PRECISION NOT NULL,
+					decision VARCHAR(10) NOT NULL CHECK (decision IN ('publish', 'hold', 'reject')),
+					signals JSONB NOT NULL DEFAULT '[]',
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+				);
+
+				CREATE TABLE IF NOT EXISTS comment_filter_settings (
+					id INTEGER PRIMARY KEY DEFAULT 1 CHECK (id = 1),
+					hold_threshold DOUBLE PRECISION NOT NULL DEFAULT 0.6,
+					reject_threshold DOUBLE PRECISION NOT NULL DEFAULT 0.9,
+					blocked_terms JSONB NOT NULL DEFAULT '[]',
+					updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_comments_review ON comments (status, created_at DESC, id DESC) WHERE status IN ('held', 'rejected');
+				CREATE INDEX IF NOT EXISTS idx_comments_user_recent ON comments (user_id, created_at DESC);
+				`
+				_, err := db.Exec(query)
+				return err
+			},
+			Down: func(db *sql.DB) error {
+				_, err := db.Exec(`
+				DROP INDEX IF EXISTS idx_comments_user_recent;
+				DROP INDEX IF EXISTS idx_comments_review;
+				DROP TABLE IF EXISTS comment_filter_settings;
+				DROP TABLE IF EXISTS comment_verdicts;
+				UPDATE comments SET status = 'hidden' WHERE status = 'rejected';
+				ALTER TABLE comments DROP CONSTRAINT IF EXISTS comments_status_check;
+				ALTER TABLE comments ADD CONSTRAINT comments_status_check
+					CHECK (status IN ('published', 'held', 'hidden'));
+				`)
+				return err
+			},
+		},
+		{
+			Version:     18,
+			Name:        "add_notification_delivered_at",
+			Description: "Records when a websocket client acknowledged a notification and indexes notifications for resuming by ID",
+			Up: func(db *sql.DB) error {
+				query := `
+				ALTER TABLE notifications ADD COLUMN IF NOT EXISTS delivered_at TIMESTAMP;
+				CREATE INDEX IF NOT EXISTS idx_notifications_user_id_id ON notifications (user_id, id);
+				`
+				_, err := db.Exec(query)
+				return err
+			},
+			Down: func(db *sql.DB) error {
+				_, err := db.Exec(`
+				DROP INDEX IF EXISTS idx_notifications_user_id_id;
+				ALTER TABLE notifications DROP COLUMN IF EXISTS delivered_at;
+				`)
+				return err
+			},
+		},
+		{
+			Version:     19,
+			Name:        "create_notification_preferences",
+			Description: "Stores per-category notification channels and quiet hours",
+			Up: func(db *sql.DB) error {
+				query := `
+				CREATE TABLE IF NOT EXISTS notification_preferences (
+					user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					category VARCHAR(20) NOT NULL CHECK (category IN ('subscription', 'like', 'comment', 'mention', 'upload')),
+					enabled BOOLEAN NOT NULL DEFAULT TRUE,
+					in_app BOOLEAN NOT NULL DEFAULT TRUE,
+					websocket BOOLEAN NOT NULL DEFAULT TRUE,
+					email BOOLEAN NOT NULL DEFAULT FALSE,
+					push BOOLEAN NOT NULL DEFAULT FALSE,
+					PRIMARY KEY (user_id, category)
+				);
+				CREATE TABLE IF NOT EXISTS notification_quiet_hours (
+					user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+					start_time TIME NOT NULL,
+					end_time TIME NOT NULL,
+					timezone VARCHAR(64) NOT NULL DEFAULT 'UTC'
+				);
+				`
+				_, err := db.Exec(query)
+				return err
+			},
+			Down: func(db *sql.DB) error {
+				_, err := db.Exec(`
+				DROP TABLE IF EXISTS notification_quiet_hours;
+				DROP TABLE IF EXISTS notification_preferences;
+				`)
+				return err
+			},
+		},
+		{
+			Version:     20,
+			Name:        "create_email_deliveries",
+			Description: "Adds notification digest settings and an outbox recording email delivery attempts",
+			Up: func(db *sql.DB) error {
+				query := `
+				CREATE TABLE IF NOT EXISTS notification_digests (
+					user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+					frequency VARCHAR(10) NOT NULL CHECK (frequency IN ('daily', 'weekly')),
+					last_sent_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+				);
+
+				CREATE TABLE IF NOT EXISTS email_deliveries (
+					id SERIAL PRIMARY KEY,
+					user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					notification_id INTEGER REFERENCES notifications(id) ON DELETE SET NULL,
+					kind VARCHAR(20) NOT NULL CHECK (kind IN ('notification', 'digest')),
+					to_address VARCHAR(255) NOT NULL,
+					subject TEXT NOT NULL,
+					text_body TEXT NOT NULL,
+					html_body TEXT NOT NULL,
+					status VARCHAR(10) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'sent', 'failed')),
+					attempts INTEGER NOT NULL DEFAULT 0,
+					last_error TEXT,
+					next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					sent_at TIMESTAMP
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_email_deliveries_due ON email_deliveries (next_attempt_at) WHERE status = 'pending';
+				CREATE INDEX IF NOT EXISTS idx_notification_digests_last_sent ON notification_digests (last_sent_at);
+				`
+				_, err := db.Exec(query)
+				return err
+			},
+			Down: func(db *sql.DB) error {
+				_, err := db.Exec(`
+				DROP TABLE IF EXISTS email_deliveries;
+				DROP TABLE IF EXISTS notification_digests;
+				`)
+				return err
+			},
+		},
+		{
+			Version:     21,
+			Name:        "create_push_subscriptions",
+			Description: "Stores browsers registered for web push notifications",
+			Up: func(db *sql.DB) error {
+				query := `
+				CREATE TABLE IF NOT EXISTS push_subscriptions (
+					id SERIAL PRIMARY KEY,
+					user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					endpoint TEXT NOT NULL UNIQUE,
+					p256dh VARCHAR(128) NOT NULL,
+					auth VARCHAR(64) NOT NULL,
+					user_agent VARCHAR(255),
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					last_used_at TIMESTAMP
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_push_subscriptions_user_id ON push_subscriptions (user_id);
+				`
+				_, err := db.Exec(query)
+				return err
+			},
+			Down: func(db *sql.DB) error {
+				_, err := db.Exec("DROP TABLE IF EXISTS push_subscriptions")
+				return err
+			},
+		},
+		{
+			Version:     22,
+			Name:        "add_notification_grouping_and_archive",
+			Description: "Adds collapse keys, group counts and archiving to notifications, with indexes for paging and retention",
+			Up: func(db *sql.DB) error {
+				query := `
+				ALTER TABLE notifications ADD COLUMN IF NOT EXISTS collapse_key VARCHAR(255);
+				ALTER TABLE notifications ADD COLUMN IF NOT EXISTS group_count INTEGER NOT NULL DEFAULT 1;
+				ALTER TABLE notifications ADD COLUMN IF NOT EXISTS archived_at TIMESTAMP;
+
+				CREATE UNIQUE INDEX IF NOT EXISTS idx_notifications_collapse ON notifications (user_id, collapse_key) WHERE collapse_key IS NOT NULL AND is_read = FALSE;
+				CREATE INDEX IF NOT EXISTS idx_notifications_user_feed ON notifications (user_id, created_at DESC, id DESC);
+				CREATE INDEX IF NOT EXISTS idx_notifications_read_created ON notifications (created_at) WHERE is_read = TRUE;
+				`
+				_, err := db.Exec(query)
+				return err
+			},
+			Down: func(db *sql.DB) error {
+				_, err := db.Exec(`
+				DROP INDEX IF EXISTS idx_notifications_read_created;
+				DROP INDEX IF EXISTS idx_notifications_user_feed;
+				DROP INDEX IF EXISTS idx_notifications_collapse;
+				ALTER TABLE notifications DROP COLUMN IF EXISTS archived_at;
+				ALTER TABLE notifications DROP COLUMN IF EXISTS group_count;
+				ALTER TABLE notifications DROP COLUMN IF EXISTS collapse_key;
+				`)
+				return err
+			},
+		},
+		{
+			Version:     23,
+			Name:        "create_refresh_tokens",
+			Description: "Stores hashed refresh tokens, grouped into families that are revoked together",
+			Up: func(db *sql.DB) error {
+				query := `
+				CREATE TABLE IF NOT EXISTS refresh_tokens (
+					id SERIAL PRIMARY KEY,
+					user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					token_hash CHAR(64) NOT NULL UNIQUE,
+					family_id VARCHAR(32) NOT NULL,
+					replaced_by INTEGER REFERENCES refresh_tokens(id) ON DELETE SET NULL,
+					expires_at TIMESTAMP NOT NULL,
+					revoked_at TIMESTAMP,
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens (family_id);
+				CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_active ON refresh_tokens (user_id) WHERE revoked_at IS NULL;
+				`
+				_, err := db.Exec(query)
+				return err
+			},
+			Down: func(db *sql.DB) error {
+				_, err := db.Exec("DROP TABLE IF EXISTS refresh_tokens")
+				return err
+			},
+		},
+		{
+			Version:     24,
+			Name:        "create_sessions",
+			Description: "Records each login with its device so that users can review and revoke them",
+			Up: func(db *sql.DB) error {
+				query := `
+				CREATE TABLE IF NOT EXISTS sessions (
+					id VARCHAR(32) PRIMARY KEY,
+					user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					user_agent VARCHAR(512) NOT NULL DEFAULT '',
+					device VARCHAR(100) NOT NULL DEFAULT '',
+					ip_address VARCHAR(45) NOT NULL DEFAULT '',
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					last_used_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					expires_at TIMESTAMP NOT NULL,
+					revoked_at TIMESTAMP
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_sessions_user_active ON sessions (user_id) WHERE revoked_at IS NULL;
+				`
+				_, err := db.Exec(query)
+				return err
+			},
+			Down: func(db *sql.DB) error {
+				_, err := db.Exec("DROP TABLE IF EXISTS sessions")
+				return err
+			},
+		},
+		{
+			Version:     25,
+			Name:        "add_two_factor_auth",
+			Description: "Adds TOTP secrets, recovery codes and login challenges for two-factor authentication",
+			Up: func(db *sql.DB) error {
+				query := `
+				ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret VARCHAR(64);
+				ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+				ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step BIGINT;
+				ALTER TABLE sessions ADD COLUMN IF NOT EXISTS mfa BOOLEAN NOT NULL DEFAULT FALSE;
+
+				CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+					id SERIAL PRIMARY KEY,
+					user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					code_hash CHAR(64) NOT NULL,
+					used_at TIMESTAMP,
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_mfa_recovery_codes_user ON mfa_recovery_codes (user_id, code_hash);
+
+				CREATE TABLE IF NOT EXISTS mfa_challenges (
+					id SERIAL PRIMARY KEY,
+					user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					token_hash CHAR(64) NOT NULL UNIQUE,
+					attempts INTEGER NOT NULL DEFAULT 0,
+					expires_at TIMESTAMP NOT NULL,
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_mfa_challenges_user ON mfa_challenges (user_id);
+				`
+				_, err := db.Exec(query)
+				return err
+			},
+			Down: func(db *sql.DB) error {
+				query := `
+				DROP TABLE IF EXISTS mfa_challenges;
+				DROP TABLE IF EXISTS mfa_recovery_codes;
+				ALTER TABLE sessions DROP COLUMN IF EXISTS mfa;
+				ALTER TABLE users DROP COLUMN IF EXISTS totp_last_step;
+				ALTER TABLE users DROP COLUMN IF EXISTS totp_enabled;
+				ALTER TABLE users DROP COLUMN IF EXISTS totp_secret;
+				`
+				_, err := db.Exec(query)
+				return err
+			},
+		},
+		{
+			Version:     26,
+			Name:        "add_email_verification",
+			Description: "Tracks verified email addresses and stores hashed email verification and password reset tokens",
+			Up: func(db *sql.DB) error {
+				query := `
+				-- Accounts that exist before verification was introduced count as verified
+				ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
+				ALTER TABLE users ALTER COLUMN email_verified_at DROP DEFAULT;
+
+				CREATE TABLE IF NOT EXISTS account_tokens (
+					id SERIAL PRIMARY KEY,
+					user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					purpose VARCHAR(20) NOT NULL,
+					token_hash CHAR(64) NOT NULL UNIQUE,
+					email VARCHAR(255) NOT NULL,
+					expires_at TIMESTAMP NOT NULL,
+					used_at TIMESTAMP,
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_account_tokens_user_purpose ON account_tokens (user_id, purpose);
+				`
+				_, err := db.Exec(query)
+				return err
+			},
+			Down: func(db *sql.DB) error {
+				query := `
+				DROP TABLE IF EXISTS account_tokens;
+				ALTER TABLE users DROP COLUMN IF EXISTS email_verified_at;
+				`
+				_, err := db.Exec(query)
+				return err
+			},
+		},
+		{
+			Version:     27,
+			Name:        "create_login_attempts",
+			Description: "Records every login attempt so that failed logins can be throttled and audited",
+			Up: func(db *sql.DB) error {
+				query := `
+				CREATE TABLE IF NOT EXISTS login_attempts (
+					id BIGSERIAL PRIMARY KEY,
+					email VARCHAR(255) NOT NULL,
+					user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+					ip_address VARCHAR(45) NOT NULL DEFAULT '',
+					user_agent VARCHAR(512) NOT NULL DEFAULT '',
+					succeeded BOOLEAN NOT NULL,
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_login_attempts_email ON login_attempts (email, created_at);
+				CREATE INDEX IF NOT EXISTS idx_login_attempts_ip ON login_attempts (ip_address, created_at) WHERE succeeded = FALSE;
+				`
+				_, err := db.Exec(query)
+				return err
+			},
+			Down: func(db *sql.DB) error {
+				_, err := db.Exec("DROP TABLE IF EXISTS login_attempts")
+				return err
+			},
+		},
+		{
+			Version:     28,
+			Name:        "create_user_identities",
+			Description: "Links users to OpenID Connect identities and stores pending sign-ins with their PKCE verifiers",
+			Up: func(db *sql.DB) error {
+				query := `
+				CREATE TABLE IF NOT EXISTS user_identities (
+					id SERIAL PRIMARY KEY,
+					user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					provider VARCHAR(50) NOT NULL,
+					subject VARCHAR(255) NOT NULL,
+					email VARCHAR(255) NOT NULL DEFAULT '',
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					last_login_at TIMESTAMP,
+					UNIQUE (provider, subject)
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_user_identities_user ON user_identities (user_id);
+
+				CREATE TABLE IF NOT EXISTS oidc_logins (
+					state_hash CHAR(64) PRIMARY KEY,
+					provider VARCHAR(50) NOT NULL,
+					nonce VARCHAR(64) NOT NULL,
+					code_verifier VARCHAR(128) NOT NULL,
+					expires_at TIMESTAMP NOT NULL
+				);
+				`
+				_, err := db.Exec(query)
+				return err
+			},
+			Down: func(db *sql.DB) error {
+				query := `
+				DROP TABLE IF EXISTS oidc_logins;
+				DROP TABLE IF EXISTS user_identities;
+				`
+				_, err := db.Exec(query)
+				return err
+			},
+		},
+		{
+			Version:     29,
+			Name:        "create_api_keys",
+			Description: "Stores hashed personal API keys with their scopes, expiry and last use",
+			Up: func(db *sql.DB) error {
+				query := `
+				CREATE TABLE IF NOT EXISTS api_keys (
+					id SERIAL PRIMARY KEY,
+					user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					name VARCHAR(100) NOT NULL,
+					prefix VARCHAR(16) NOT NULL,
+					key_hash CHAR(64) NOT NULL UNIQUE,
+					scopes TEXT[] NOT NULL,
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					expires_at TIMESTAMP NOT NULL,
+					last_used_at TIMESTAMP,
+					revoked_at TIMESTAMP
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_api_keys_user ON api_keys (user_id);
+				`
+				_, err := db.Exec(query)
+				return err
+			},
+			Down: func(db *sql.DB) error {
+				_, err := db.Exec("DROP TABLE IF EXISTS api_keys")
+				return err
+			},
+		},
+		{
+			Version:     30,
+			Name:        "add_roles",
+			Description: "Makes creator the default role and moves users with the old user role to it",
+			Up: func(db *sql.DB) error {
+				query := `
+				ALTER TABLE users ALTER COLUMN role SET DEFAULT 'creator';
+				UPDATE users SET role = 'creator' WHERE role = 'user';
+				`
+				_, err := db.Exec(query)
+				return err
+			},
+			Down: func(db *sql.DB) error {
+				query := `
+				UPDATE users SET role = 'user' WHERE role <> 'admin';
+				ALTER TABLE users ALTER COLUMN role SET DEFAULT 'user';
+				`
+				_, err := db.Exec(query)
+				return err
+			},
+		},
+		{
+			Version:     31,
+			Name:        "create_channel_teams",
+			Description: "Adds channel team members with roles and pending invitations to join a team",
+			Up: func(db *sql.DB) error {
+				query := `
+				CREATE TABLE IF NOT EXISTS channel_members (
+					channel_id INTEGER NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+					user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					role VARCHAR(20) NOT NULL CHECK (role IN ('manager', 'editor', 'viewer-analytics')),
+					added_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					PRIMARY KEY (channel_id, user_id)
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_channel_members_user ON channel_members (user_id);
+
+				CREATE TABLE IF NOT EXISTS channel_invitations (
+					id SERIAL PRIMARY KEY,
+					channel_id INTEGER NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+					user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					role VARCHAR(20) NOT NULL CHECK (role IN ('manager', 'editor', 'viewer-analytics')),
+					invited_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					expires_at TIMESTAMP NOT NULL,
+					UNIQUE (channel_id, user_id)
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_channel_invitations_user ON channel_invitations (user_id);
+				`
+				_, err := db.Exec(query)
+				return err
+			},
+			Down: func(db *sql.DB) error {
+				_, err := db.Exec("DROP TABLE IF EXISTS channel_invitations; DROP TABLE IF EXISTS channel_members")
+				return err
+			},
+		},
 	}
 }
@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type Video struct {
 	ID            int       `json:"id"`
@@ -8,6 +11,7 @@ type Video struct {
 	Description   string    `json:"description"`
 	URL           string    `json:"url"`
 	Thumbnail     string    `json:"thumbnail"`
+	ChannelID     *int      `json:"channel_id,omitempty"`
 	ChannelName   string    `json:"channel_name"`
 	ChannelAvatar string    `json:"channel_avatar"`
 	Views         int       `json:"views"`
@@ -26,19 +30,72 @@ type User struct {
 	Email     string    `json:"email"`
 	Password  string    `json:"-"` // Never send password in JSON responses
 	Avatar    string    `json:"avatar"`
-	Role      string    `json:"role"` // "user" or "admin"
+	Role      string    `json:"role"` // "viewer", "creator", "moderator" or "admin"
 	PlanID    *int      `json:"plan_id,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
 type Comment struct {
-	ID        int       `json:"id"`
-	VideoID   int       `json:"video_id"`
-	UserID    int       `json:"user_id"`
-	Content   string    `json:"content"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID             int       `json:"id"`
+	VideoID        int       `json:"video_id"`
+	UserID         int       `json:"user_id"`
+	AuthorUsername string    `json:"author_username"`
+	AuthorAvatar   string    `json:"author_avatar"`
+	ParentID       *int      `json:"parent_id,omitempty"` // nil for top-level comments
+	Depth          int       `json:"depth"`
+	Content        string    `json:"content"`
+	Likes          int       `json:"likes"`
+	ReplyCount     int       `json:"reply_count"` // published replies only
+	IsPinned       bool      `json:"is_pinned"`
+	IsHearted      bool      `json:"is_hearted"`
+	Status         string    `json:"status"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// Comment moderation states. Only published comments are shown publicly;
+// held comments wait in the channel's review queue and rejected ones were
+// refused by the spam filter.
+const (
+	CommentStatusPublished = "published"
+	CommentStatusHeld      = "held"
+	CommentStatusHidden    = "hidden"
+	CommentStatusRejected  = "rejected"
+)
+
+// CommentVerdict is the spam filter's assessment of a new comment
+type CommentVerdict struct {
+	Score     float64         `json:"score"`
+	Decision  string          `json:"decision"`
+	Signals   json.RawMessage `json:"signals"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// ReviewComment is a comment in the admin review queue with its verdict
+type ReviewComment struct {
+	Comment
+	Verdict *CommentVerdict `json:"verdict,omitempty"`
+}
+
+// ReviewPage is a page of the admin review queue
+type ReviewPage struct {
+	Comments   []ReviewComment `json:"comments"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+// CommentFilterSettings are the admin-tunable spam filter settings
+type CommentFilterSettings struct {
+	HoldThreshold   float64   `json:"hold_threshold"`
+	RejectThreshold float64   `json:"reject_threshold"`
+	BlockedTerms    []string  `json:"blocked_terms"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// CommentPage is a page of comments or replies
+type CommentPage struct {
+	Comments   []Comment `json:"comments"`
+	NextCursor string    `json:"next_cursor,omitempty"`
 }
 
 type WatchHistory struct {
@@ -60,10 +117,103 @@ type Plan struct {
 }
 
 type Subscription struct {
+	ID                int       `json:"id"`
+	UserID            int       `json:"user_id"`
+	ChannelID         int       `json:"channel_id"`
+	ChannelName       string    `json:"channel_name"`
+	NotificationLevel string    `json:"notification_level"`
+	LastVisitedAt     time.Time `json:"last_visited_at"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// Notification levels a subscriber can pick with the channel's bell
+const (
+	NotificationLevelAll          = "all"
+	NotificationLevelPersonalized = "personalized"
+	NotificationLevelNone         = "none"
+)
+
+// ValidNotificationLevel reports whether level is one of the bell settings
+func ValidNotificationLevel(level string) bool {
+	switch level {
+	case NotificationLevelAll, NotificationLevelPersonalized, NotificationLevelNone:
+		return true
+	}
+	return false
+}
+
+// SubscriptionFeed is a page of videos from a user's subscribed channels
+type SubscriptionFeed struct {
+	Videos     []Video `json:"videos"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+}
+
+// ChannelUnseenCount is the number of uploads a subscriber hasn't seen yet
+type ChannelUnseenCount struct {
+	ChannelID     int       `json:"channel_id"`
+	ChannelName   string    `json:"channel_name"`
+	LastVisitedAt time.Time `json:"last_visited_at"`
+	UnseenCount   int       `json:"unseen_count"`
+}
+
+// Channel is a user-owned publishing identity that videos are uploaded to
+type Channel struct {
+	ID              int           `json:"id"`
+	UserID          *int          `json:"user_id,omitempty"` // nil for channels backfilled from legacy channel names
+	Handle          string        `json:"handle"`
+	DisplayName     string        `json:"display_name"`
+	Avatar          string        `json:"avatar"`
+	Banner          string        `json:"banner"`
+	Description     string        `json:"description"`
+	Links           []ChannelLink `json:"links"`
+	SubscriberCount int           `json:"subscriber_count"`
+	CreatedAt       time.Time     `json:"created_at"`
+	UpdatedAt       time.Time     `json:"updated_at"`
+}
+
+type ChannelLink struct {
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+// Roles on a channel's team. The owner is the user who created the channel;
+// everyone else joins by accepting an invitation.
+const (
+	ChannelRoleOwner           = "owner"
+	ChannelRoleManager         = "manager"
+	ChannelRoleEditor          = "editor"
+	ChannelRoleViewerAnalytics = "viewer-analytics"
+)
+
+// ChannelMember is a user on a channel's team
+type ChannelMember struct {
+	ChannelID int       `json:"channel_id"`
+	UserID    int       `json:"user_id"`
+	Username  string    `json:"username"`
+	Avatar    string    `json:"avatar"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ChannelInvitation is a pending invitation to join a channel's team
+type ChannelInvitation struct {
 	ID          int       `json:"id"`
-	UserID      int       `json:"user_id"`
+	ChannelID   int       `json:"channel_id"`
 	ChannelName string    `json:"channel_name"`
+	UserID      int       `json:"user_id"`
+	Username    string    `json:"username"`
+	Role        string    `json:"role"`
+	InvitedBy   *int      `json:"invited_by,omitempty"` // nil once the inviter's account is deleted
 	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// ChannelPage is the public view of a channel with its uploads and playlists
+type ChannelPage struct {
+	Channel
+	Videos     []Video    `json:"videos"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+	Playlists  []Playlist `json:"playlists"`
 }
 
 type Playlist struct {
@@ -3,20 +3,108 @@ package models
 import "time"
 
 type Notification struct {
-	ID        int       `json:"id"`
-	UserID    int       `json:"user_id"`
-	Type      string    `json:"type"` // "subscription", "like", "comment", etc.
-	Title     string    `json:"title"`
-	Message   string    `json:"message"`
-	Link      string    `json:"link,omitempty"`
-	IsRead    bool      `json:"is_read"`
-	CreatedAt time.Time `json:"created_at"`
+	ID          int        `json:"id"`
+	UserID      int        `json:"user_id"`
+	Type        string     `json:"type"` // "subscription", "like", "comment", etc.
+	Title       string     `json:"title"`
+	Message     string     `json:"message"`
+	Link        string     `json:"link,omitempty"`
+	CollapseKey string     `json:"collapse_key,omitempty"`
+	GroupCount  int        `json:"group_count"` // how many notifications were collapsed into this one
+	IsRead      bool       `json:"is_read"`
+	ArchivedAt  *time.Time `json:"archived_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
 }
 
+// CreateNotificationRequest describes a notification to deliver. Unread
+// notifications with the same CollapseKey are grouped into one; once grouped
+// its title becomes GroupTitle with "{count}" replaced by the group size.
 type CreateNotificationRequest struct {
-	UserID  int    `json:"user_id"`
-	Type    string `json:"type"`
-	Title   string `json:"title"`
-	Message string `json:"message"`
-	Link    string `json:"link,omitempty"`
+	UserID      int    `json:"user_id"`
+	Type        string `json:"type"`
+	Title       string `json:"title"`
+	Message     string `json:"message"`
+	Link        string `json:"link,omitempty"`
+	CollapseKey string `json:"collapse_key,omitempty"`
+	GroupTitle  string `json:"group_title,omitempty"`
+}
+
+// NotificationPage is a page of a user's notifications
+type NotificationPage struct {
+	Notifications []Notification `json:"notifications"`
+	NextCursor    string         `json:"next_cursor,omitempty"`
+}
+
+// MutedUser is a user whose comments don't notify the muting user
+type MutedUser struct {
+	UserID   int       `json:"user_id"`
+	Username string    `json:"username"`
+	Avatar   string    `json:"avatar"`
+	MutedAt  time.Time `json:"muted_at"`
+}
+
+// Notification categories users can set preferences for
+const (
+	NotificationCategorySubscription = "subscription"
+	NotificationCategoryLike         = "like"
+	NotificationCategoryComment      = "comment"
+	NotificationCategoryMention      = "mention"
+	NotificationCategoryUpload       = "upload"
+)
+
+// NotificationCategories lists every configurable category
+var NotificationCategories = []string{
+	NotificationCategorySubscription,
+	NotificationCategoryLike,
+	NotificationCategoryComment,
+	NotificationCategoryMention,
+	NotificationCategoryUpload,
+}
+
+// NotificationChannels says where a category of notification is delivered
+type NotificationChannels struct {
+	InApp     bool `json:"in_app"`    // stored in the notification list
+	WebSocket bool `json:"websocket"` // pushed to open tabs
+	Email     bool `json:"email"`
+	Push      bool `json:"push"` // browser web push
+}
+
+// NotificationTypePreference is a user's setting for one category
+type NotificationTypePreference struct {
+	Enabled  bool                 `json:"enabled"`
+	Channels NotificationChannels `json:"channels"`
+}
+
+// QuietHours silences email and push between Start and End ("HH:MM", in
+// Timezone). End before Start spans midnight.
+type QuietHours struct {
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Timezone string `json:"timezone"`
+}
+
+// How often unread notifications are summarised by email
+const (
+	DigestOff    = "off"
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// NotificationPreferences are a user's notification settings
+type NotificationPreferences struct {
+	UserID     int                                   `json:"user_id"`
+	Types      map[string]NotificationTypePreference `json:"types"`
+	QuietHours *QuietHours                           `json:"quiet_hours"`
+	Digest     string                                `json:"digest"`
+}
+
+// PushSubscription is a browser registered to receive web push
+// notifications. Its encryption keys are never returned.
+type PushSubscription struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	Endpoint   string     `json:"endpoint"`
+	UserAgent  string     `json:"user_agent,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
 }
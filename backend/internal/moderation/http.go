@@ -0,0 +1,66 @@
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// HTTPClassifier delegates to an external classification service. It POSTs
+// {"content": ..., "author_id": ...} and expects {"score": 0..1, "reason": ...}.
+type HTTPClassifier struct {
+	name   string
+	url    string
+	client *http.Client
+}
+
+// NewHTTPClassifier creates a classifier backed by the service at url
+func NewHTTPClassifier(name, url string) *HTTPClassifier {
+	return &HTTPClassifier{
+		name: name,
+		url:  url,
+		client: &http.Client{
+			Timeout: 2 * time.Second, // commenting must not wait long on an external service
+		},
+	}
+}
+
+func (c *HTTPClassifier) Name() string { return c.name }
+
+func (c *HTTPClassifier) Classify(ctx context.Context, in Input) (Signal, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"content":   in.Content,
+		"author_id": in.AuthorID,
+	})
+	if err != nil {
+		return Signal{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return Signal{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return Signal{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Signal{}, fmt.Errorf("classifier returned %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Score  float64 `json:"score"`
+		Reason string  `json:"reason"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return Signal{}, err
+	}
+	return Signal{Score: result.Score, Reason: result.Reason}, nil
+}
@@ -0,0 +1,177 @@
+package moderation
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	// VelocityWindow is how far back Input.RecentComments counts
+	VelocityWindow = 10 * time.Minute
+	// DuplicateWindow is how far back Input.RecentDuplicates counts
+	DuplicateWindow = 24 * time.Hour
+	// NewAccountAge is how old an account must be to get the normal rate limit
+	NewAccountAge = 24 * time.Hour
+)
+
+// DefaultRules returns the built-in rule-based classifiers
+func DefaultRules() []Classifier {
+	return []Classifier{
+		LinkDensityRule{MaxLinks: 3},
+		RepeatedTextRule{},
+		VelocityRule{NewAccountLimit: 3, Limit: 20},
+		BlocklistRule{},
+	}
+}
+
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S+`)
+
+// LinkDensityRule flags comments that are mostly links or carry many of them
+type LinkDensityRule struct {
+	MaxLinks int // links at which the rule alone reaches the default hold threshold
+}
+
+func (LinkDensityRule) Name() string { return "link_density" }
+
+func (r LinkDensityRule) Classify(ctx context.Context, in Input) (Signal, error) {
+	links := len(linkPattern.FindAllString(in.Content, -1))
+	if links == 0 {
+		return Signal{}, nil
+	}
+
+	words := len(strings.Fields(in.Content))
+	density := float64(links) / float64(words)
+	score := 0.6*float64(links)/float64(r.MaxLinks) + 0.4*density
+
+	return Signal{
+		Score:  score,
+		Reason: fmt.Sprintf("%d link(s) in %d word(s)", links, words),
+	}, nil
+}
+
+// RepeatedTextRule flags flooding: the same word over and over, long runs of
+// one character, or the author posting the same comment repeatedly
+type RepeatedTextRule struct{}
+
+func (RepeatedTextRule) Name() string { return "repeated_text" }
+
+func (RepeatedTextRule) Classify(ctx context.Context, in Input) (Signal, error) {
+	var signal Signal
+	raise := func(score float64, reason string) {
+		if score > signal.Score {
+			signal = Signal{Score: score, Reason: reason}
+		}
+	}
+
+	switch {
+	case in.RecentDuplicates >= 3:
+		raise(1, fmt.Sprintf("posted %d times in the last day", in.RecentDuplicates+1))
+	case in.RecentDuplicates > 0:
+		raise(0.7, "identical to a recent comment by the same author")
+	}
+
+	words := strings.FieldsFunc(strings.ToLower(in.Content), wordBoundary)
+	if len(words) >= 8 {
+		unique := make(map[string]bool)
+		for _, w := range words {
+			unique[w] = true
+		}
+		if ratio := float64(len(unique)) / float64(len(words)); ratio < 0.3 {
+			raise(0.7, fmt.Sprintf("only %d distinct word(s) out of %d", len(unique), len(words)))
+		}
+	}
+
+	if run := longestRun(in.Content); run >= 20 {
+		raise(0.6, fmt.Sprintf("the same character %d times in a row", run))
+	}
+
+	return signal, nil
+}
+
+// longestRun returns the length of the longest run of one non-space character
+func longestRun(text string) int {
+	longest, current := 0, 0
+	var prev rune
+	for _, r := range text {
+		if r == prev && !unicode.IsSpace(r) {
+			current++
+		} else {
+			current = 1
+		}
+		prev = r
+		if current > longest {
+			longest = current
+		}
+	}
+	return longest
+}
+
+// VelocityRule flags authors commenting faster than a person plausibly
+// would, with a much lower limit for accounts younger than NewAccountAge
+type VelocityRule struct {
+	NewAccountLimit int // comments per VelocityWindow for new accounts
+	Limit           int // comments per VelocityWindow for everyone else
+}
+
+func (VelocityRule) Name() string { return "velocity" }
+
+func (r VelocityRule) Classify(ctx context.Context, in Input) (Signal, error) {
+	limit := r.Limit
+	if in.AccountAge < NewAccountAge {
+		limit = r.NewAccountLimit
+	}
+	if in.RecentComments < limit {
+		return Signal{}, nil
+	}
+
+	// At the limit the comment is held; at twice the limit it's rejected
+	score := 0.6 + 0.4*float64(in.RecentComments-limit)/float64(limit)
+	return Signal{
+		Score:  score,
+		Reason: fmt.Sprintf("%d comments in the last %.0f minutes", in.RecentComments, VelocityWindow.Minutes()),
+	}, nil
+}
+
+// BlocklistRule rejects comments containing a term from the site-wide blocklist
+type BlocklistRule struct{}
+
+func (BlocklistRule) Name() string { return "blocklist" }
+
+func (BlocklistRule) Classify(ctx context.Context, in Input) (Signal, error) {
+	if term, ok := MatchBlockedWord(in.Content, in.BlockedTerms); ok {
+		return Signal{Score: 1, Reason: fmt.Sprintf("contains blocked term %q", term)}, nil
+	}
+	return Signal{}, nil
+}
+
+// wordBoundary splits text on anything that isn't a letter or digit
+func wordBoundary(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+}
+
+// normalizeWords lowercases text and collapses it to single-space separated
+// words, padded with spaces so that phrases can be matched on word boundaries
+func normalizeWords(text string) string {
+	return " " + strings.Join(strings.FieldsFunc(strings.ToLower(text), wordBoundary), " ") + " "
+}
+
+// MatchBlockedWord returns the first of words that appears in content as a
+// whole word or phrase, ignoring case and punctuation
+func MatchBlockedWord(content string, words []string) (string, bool) {
+	if len(words) == 0 {
+		return "", false
+	}
+
+	text := normalizeWords(content)
+	for _, word := range words {
+		needle := normalizeWords(word)
+		if strings.TrimSpace(needle) != "" && strings.Contains(text, needle) {
+			return word, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,73 @@
+package moderation
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDefaultRules(t *testing.T) {
+	established := 30 * 24 * time.Hour
+
+	tests := []struct {
+		name     string
+		input    Input
+		decision Decision
+	}{
+		{"Clean comment", Input{Content: "Really clear explanation, thanks!", AccountAge: established}, DecisionPublish},
+		{"One link in prose", Input{Content: "The docs at https://go.dev/doc explain this well, worth a read", AccountAge: established}, DecisionPublish},
+		{"Bare link", Input{Content: "https://spam.example.com", AccountAge: established}, DecisionHold},
+		{"Many links", Input{Content: "www.a.com www.b.com www.c.com www.d.com www.e.com", AccountAge: established}, DecisionReject},
+		{"Word flood", Input{Content: "buy buy buy buy buy buy buy buy buy now", AccountAge: established}, DecisionHold},
+		{"Character flood", Input{Content: "nooooooooooooooooooooooooo", AccountAge: established}, DecisionHold},
+		{"Repeated post", Input{Content: "sub to me", AccountAge: established, RecentDuplicates: 1}, DecisionHold},
+		{"Copy-paste spree", Input{Content: "sub to me", AccountAge: established, RecentDuplicates: 4}, DecisionReject},
+		{"New account velocity", Input{Content: "nice", AccountAge: time.Hour, RecentComments: 3}, DecisionHold},
+		{"New account flooding", Input{Content: "nice", AccountAge: time.Hour, RecentComments: 6}, DecisionReject},
+		{"Established account pace", Input{Content: "nice", AccountAge: established, RecentComments: 6}, DecisionPublish},
+		{"Site blocklist", Input{Content: "Free-Followers here", AccountAge: established, BlockedTerms: []string{"free followers"}}, DecisionReject},
+	}
+
+	pipeline := NewPipeline(DefaultRules()...)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verdict := pipeline.Classify(context.Background(), tt.input, DefaultThresholds)
+			if verdict.Decision != tt.decision {
+				t.Errorf("Expected %s, got %s (score %.2f, signals %+v)", tt.decision, verdict.Decision, verdict.Score, verdict.Signals)
+			}
+		})
+	}
+}
+
+type stubClassifier struct {
+	score float64
+	err   error
+}
+
+func (stubClassifier) Name() string { return "stub" }
+
+func (s stubClassifier) Classify(ctx context.Context, in Input) (Signal, error) {
+	return Signal{Score: s.score, Reason: "stub"}, s.err
+}
+
+func TestPipelineSkipsFailingClassifiers(t *testing.T) {
+	pipeline := NewPipeline(stubClassifier{err: errors.New("unavailable")}, stubClassifier{score: 1.7})
+
+	verdict := pipeline.Classify(context.Background(), Input{Content: "hi"}, DefaultThresholds)
+
+	if len(verdict.Signals) != 1 || verdict.Score != 1 || verdict.Decision != DecisionReject {
+		t.Errorf("Unexpected verdict: %+v", verdict)
+	}
+}
+
+func TestMatchBlockedWord(t *testing.T) {
+	words := []string{"spam", "cheap pills"}
+
+	if term, ok := MatchBlockedWord("Buy CHEAP, pills!", words); !ok || term != "cheap pills" {
+		t.Errorf("Expected a match on %q, got %q, %v", "cheap pills", term, ok)
+	}
+	if _, ok := MatchBlockedWord("spammy but fine", words); ok {
+		t.Error("Expected partial words not to match")
+	}
+}
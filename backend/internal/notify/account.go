@@ -0,0 +1,138 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"net/url"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// Account emails carry links for the user to prove they own their address.
+// They are sent at once instead of through the outbox, since the user is
+// waiting for them.
+const (
+	verifyEmailTemplate   = "verify_email"
+	passwordResetTemplate = "password_reset"
+	loginAlertTemplate    = "login_alert"
+)
+
+//go:embed templates/account
+var accountTemplateFS embed.FS
+
+var accountTemplates = mustLoadAccountTemplates()
+
+func mustLoadAccountTemplates() map[string]*accountTemplate {
+	templates := make(map[string]*accountTemplate)
+	for _, name := range []string{verifyEmailTemplate, passwordResetTemplate, loginAlertTemplate} {
+		templates[name] = &accountTemplate{
+			text: texttemplate.Must(texttemplate.ParseFS(accountTemplateFS, "templates/account/"+name+".txt")),
+			html: htmltemplate.Must(htmltemplate.ParseFS(accountTemplateFS,
+				"templates/account/layout.html", "templates/account/"+name+".html")),
+		}
+	}
+	return templates
+}
+
+type accountTemplate struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// accountEmail is the data account templates are rendered with
+type accountEmail struct {
+	Username  string
+	Link      string
+	ExpiresIn string
+	IPAddress string
+	Attempts  int
+}
+
+// AccountMailer sends email verification and password reset links, and
+// warns users about attempts to guess their password
+type AccountMailer struct {
+	mailer  Mailer
+	baseURL string
+}
+
+// NewAccountMailer sends through mailer, with links into the web app at
+// baseURL
+func NewAccountMailer(mailer Mailer, baseURL string) *AccountMailer {
+	return &AccountMailer{mailer: mailer, baseURL: strings.TrimRight(baseURL, "/")}
+}
+
+// SendEmailVerification sends a link that verifies email with token
+func (m *AccountMailer) SendEmailVerification(ctx context.Context, username, email, token string, ttl time.Duration) error {
+	return m.send(ctx, verifyEmailTemplate, email, accountEmail{
+		Username:  username,
+		Link:      m.baseURL + "/verify-email?token=" + url.QueryEscape(token),
+		ExpiresIn: formatDuration(ttl),
+	})
+}
+
+// SendPasswordReset sends a link for choosing a new password with token
+func (m *AccountMailer) SendPasswordReset(ctx context.Context, username, email, token string, ttl time.Duration) error {
+	return m.send(ctx, passwordResetTemplate, email, accountEmail{
+		Username:  username,
+		Link:      m.baseURL + "/reset-password?token=" + url.QueryEscape(token),
+		ExpiresIn: formatDuration(ttl),
+	})
+}
+
+// SendLoginAlert tells a user that their account was locked out after
+// attempts failed logins, the last from ip
+func (m *AccountMailer) SendLoginAlert(ctx context.Context, username, email, ip string, attempts int, lockout time.Duration) error {
+	return m.send(ctx, loginAlertTemplate, email, accountEmail{
+		Username:  username,
+		Link:      m.baseURL + "/forgot-password",
+		ExpiresIn: formatDuration(lockout),
+		IPAddress: ip,
+		Attempts:  attempts,
+	})
+}
+
+func (m *AccountMailer) send(ctx context.Context, name, to string, data accountEmail) error {
+	msg, err := renderAccountEmail(name, to, data)
+	if err != nil {
+		return err
+	}
+	return m.mailer.Send(ctx, msg)
+}
+
+func renderAccountEmail(name, to string, data accountEmail) (EmailMessage, error) {
+	t := accountTemplates[name]
+
+	var subject, text, html bytes.Buffer
+	if err := t.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return EmailMessage{}, err
+	}
+	if err := t.text.ExecuteTemplate(&text, "text", data); err != nil {
+		return EmailMessage{}, err
+	}
+	if err := t.html.ExecuteTemplate(&html, "layout.html", data); err != nil {
+		return EmailMessage{}, err
+	}
+
+	return EmailMessage{
+		To:      to,
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+		HTML:    html.String(),
+	}, nil
+}
+
+// formatDuration writes whole hours or minutes, such as "48 hours"
+func formatDuration(d time.Duration) string {
+	n, unit := int(d.Minutes()), "minute"
+	if d >= time.Hour && d%time.Hour == 0 {
+		n, unit = int(d.Hours()), "hour"
+	}
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
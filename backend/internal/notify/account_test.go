@@ -0,0 +1,59 @@
+package notify
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderAccountEmail(t *testing.T) {
+	m := NewAccountMailer(nil, "http://localhost:3000/")
+	data := accountEmail{
+		Username:  "alice",
+		Link:      m.baseURL + "/reset-password?token=a%2Bb",
+		ExpiresIn: formatDuration(time.Hour),
+	}
+
+	msg, err := renderAccountEmail(passwordResetTemplate, "alice@example.com", data)
+	if err != nil {
+		t.Fatalf("renderAccountEmail failed: %v", err)
+	}
+	if msg.To != "alice@example.com" || msg.Subject == "" {
+		t.Errorf("Unexpected recipient or subject: %+v", msg)
+	}
+	for _, body := range []string{msg.Text, msg.HTML} {
+		if !strings.Contains(body, "http://localhost:3000/reset-password?token=a%2Bb") {
+			t.Errorf("Expected the link in the body, got %q", body)
+		}
+		if !strings.Contains(body, "1 hour") {
+			t.Errorf("Expected the expiry in the body, got %q", body)
+		}
+	}
+
+	if _, err := renderAccountEmail(verifyEmailTemplate, "alice@example.com", data); err != nil {
+		t.Errorf("renderAccountEmail failed for verification: %v", err)
+	}
+
+	data.IPAddress, data.Attempts = "192.0.2.1", 10
+	msg, err = renderAccountEmail(loginAlertTemplate, "alice@example.com", data)
+	if err != nil {
+		t.Fatalf("renderAccountEmail failed for the login alert: %v", err)
+	}
+	if !strings.Contains(msg.Text, "192.0.2.1") || strings.Contains(msg.HTML, "you can ignore this email") {
+		t.Errorf("Expected the alert to name the address and replace the footer, got %q", msg.HTML)
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	cases := map[time.Duration]string{
+		48 * time.Hour:   "48 hours",
+		time.Hour:        "1 hour",
+		90 * time.Minute: "90 minutes",
+		time.Minute:      "1 minute",
+	}
+	for d, want := range cases {
+		if got := formatDuration(d); got != want {
+			t.Errorf("formatDuration(%v) = %q, want %q", d, got, want)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+// Package notify delivers notifications on the channels each recipient has
+// chosen: the in-app list, open websockets, and external senders such as
+// email and web push.
+package notify
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/aung-arata/youtube-clone/backend/internal/models"
+	"github.com/aung-arata/youtube-clone/backend/internal/websocket"
+)
+
+// Channel is a way of delivering a notification
+type Channel string
+
+const (
+	ChannelInApp     Channel = "in_app"
+	ChannelWebSocket Channel = "websocket"
+	ChannelEmail     Channel = "email"
+	ChannelPush      Channel = "push"
+)
+
+// ErrNotDeliverable is returned by a Sender that has no way to reach the
+// recipient, such as a user without any push subscriptions
+var ErrNotDeliverable = errors.New("recipient can't be reached on this channel")
+
+const (
+	// pushTimeout bounds how long dispatching waits on the websocket hub
+	pushTimeout = 2 * time.Second
+
+	// senderTimeout bounds each external sender, which may call out to
+	// several push services
+	senderTimeout = 5 * time.Second
+)
+
+// Sender delivers notifications over an external channel
+type Sender interface {
+	Channel() Channel
+	Send(ctx context.Context, n models.Notification) error
+}
+
+// Result says where a notification was delivered. Stored is false, and the
+// notification has no ID, when the recipient doesn't keep it in-app.
+type Result struct {
+	Notification models.Notification `json:"notification"`
+	Stored       bool                `json:"stored"`
+	Channels     []Channel           `json:"channels"`
+}
+
+// Dispatcher routes notifications according to recipients' preferences
+type Dispatcher struct {
+	db      *sql.DB
+	hub     *websocket.Hub
+	senders map[Channel]Sender
+	now     func() time.Time
+}
+
+// NewDispatcher creates a dispatcher. hub may be nil, and channels without a
+// sender are skipped.
+func NewDispatcher(db *sql.DB, hub *websocket.Hub, senders ...Sender) *Dispatcher {
+	d := &Dispatcher{
+		db:      db,
+		hub:     hub,
+		senders: make(map[Channel]Sender),
+		now:     time.Now,
+	}
+	for _, s := range senders {
+		d.senders[s.Channel()] = s
+	}
+	return d
+}
+
+// Dispatch delivers a notification on the channels the recipient enabled for
+// its type. Email and push are skipped during quiet hours and not sent
+// later; the in-app list and websockets are unaffected. Only failing to read
+// preferences or store the notification is an error; other channels are
+// best effort.
+func (d *Dispatcher) Dispatch(ctx context.Context, req models.CreateNotificationRequest) (Result, error) {
+	result := Result{
+		Notification: models.Notification{
+			UserID:      req.UserID,
+			Type:        req.Type,
+			Title:       req.Title,
+			Message:     req.Message,
+			Link:        req.Link,
+			CollapseKey: req.CollapseKey,
+			GroupCount:  1,
+		},
+		Channels: []Channel{},
+	}
+
+	prefs, err := LoadPreferences(ctx, d.db, req.UserID)
+	if err != nil {
+		return result, err
+	}
+	pref := PreferenceFor(prefs, req.Type)
+	if !pref.Enabled {
+		return result, nil
+	}
+
+	n := &result.Notification
+	if pref.Channels.InApp {
+		// An unread notification with the same collapse key absorbs this one
+		// and moves back to the top of the list
+		query := `
+			INSERT INTO notifications (user_id, type, title, message, link, collapse_key)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (user_id, collapse_key) WHERE collapse_key IS NOT NULL AND is_read = FALSE
+			DO UPDATE SET
+				group_count = notifications.group_count + 1,
+				title = CASE WHEN $7 = '' THEN EXCLUDED.title
+				             ELSE replace($7, '{count}', (notifications.group_count + 1)::text)
+				        END,
+				message = EXCLUDED.message,
+				link = EXCLUDED.link,
+				created_at = CURRENT_TIMESTAMP
+			RETURNING id, title, group_count, created_at
+		`
+		err := d.db.QueryRowContext(ctx, query, req.UserID, req.Type, req.Title, req.Message,
+			sql.NullString{String: req.Link, Valid: req.Link != ""},
+			sql.NullString{String: req.CollapseKey, Valid: req.CollapseKey != ""}, req.GroupTitle).
+			Scan(&n.ID, &n.Title, &n.GroupCount, &n.CreatedAt)
+		if err != nil {
+			return result, err
+		}
+		result.Stored = true
+		result.Channels = append(result.Channels, ChannelInApp)
+	} else {
+		n.CreatedAt = d.now()
+	}
+
+	if pref.Channels.WebSocket && d.hub != nil {
+		if err := d.sendWebSocket(ctx, *n); err != nil {
+			log.Printf("Failed to push notification for user %d: %v", n.UserID, err)
+		} else {
+			result.Channels = append(result.Channels, ChannelWebSocket)
+		}
+	}
+	if result.Stored {
+		d.PublishUnreadCount(ctx, n.UserID)
+	}
+
+	quiet := InQuietHours(prefs.QuietHours, d.now())
+	external := []struct {
+		channel Channel
+		enabled bool
+	}{
+		{ChannelEmail, pref.Channels.Email},
+		{ChannelPush, pref.Channels.Push},
+	}
+	for _, e := range external {
+		channel := e.channel
+		sender, ok := d.senders[channel]
+		if !e.enabled || quiet || !ok {
+			continue
+		}
+		// Growing a group shouldn't send another email; push messages are
+		// sent with the notification's topic, so they replace each other
+		if channel == ChannelEmail && n.GroupCount > 1 {
+			continue
+		}
+
+		sendCtx, cancel := context.WithTimeout(ctx, senderTimeout)
+		err := sender.Send(sendCtx, *n)
+		cancel()
+		if errors.Is(err, ErrNotDeliverable) {
+			continue
+		} else if err != nil {
+			log.Printf("Failed to send %s notification to user %d: %v", channel, n.UserID, err)
+			continue
+		}
+		result.Channels = append(result.Channels, channel)
+	}
+
+	return result, nil
+}
+
+func (d *Dispatcher) sendWebSocket(ctx context.Context, n models.Notification) error {
+	ctx, cancel := context.WithTimeout(ctx, pushTimeout)
+	defer cancel()
+
+	return d.hub.SendNotification(ctx, n.UserID, websocket.NotificationPayload{
+		ID:         n.ID,
+		UserID:     n.UserID,
+		Type:       n.Type,
+		Title:      n.Title,
+		Message:    n.Message,
+		Link:       n.Link,
+		GroupCount: n.GroupCount,
+		IsRead:     n.IsRead,
+		CreatedAt:  n.CreatedAt,
+	})
+}
+
+// PublishUnreadCount tells the user's connected clients their unread count
+func (d *Dispatcher) PublishUnreadCount(ctx context.Context, userID int) {
+	if d.hub == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pushTimeout)
+	defer cancel()
+
+	if err := d.hub.PublishUnreadCount(ctx, userID); err != nil {
+		log.Printf("Failed to push unread count for user %d: %v", userID, err)
+	}
+}
@@ -0,0 +1,84 @@
+package notify
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/aung-arata/youtube-clone/backend/internal/models"
+)
+
+// recordingSender counts the notifications it is asked to send
+type recordingSender struct {
+	channel Channel
+	sent    int
+}
+
+func (s *recordingSender) Channel() Channel {
+	return s.channel
+}
+
+func (s *recordingSender) Send(ctx context.Context, n models.Notification) error {
+	s.sent++
+	return nil
+}
+
+func TestDispatch_QuietHoursDropEmailAndPush(t *testing.T) {
+	tests := []struct {
+		name         string
+		now          time.Time
+		wantSent     int
+		wantChannels []Channel
+	}{
+		{"Outside quiet hours", time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC), 1, []Channel{ChannelEmail, ChannelPush}},
+		// Nothing is queued for later either
+		{"During quiet hours", time.Date(2024, 1, 2, 23, 0, 0, 0, time.UTC), 0, []Channel{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("Failed to create mock: %v", err)
+			}
+			defer db.Close()
+
+			mock.ExpectQuery("FROM notification_preferences").
+				WithArgs(7).
+				WillReturnRows(sqlmock.NewRows([]string{"category", "enabled", "in_app", "websocket", "email", "push"}).
+					AddRow(models.NotificationCategoryUpload, true, false, false, true, true))
+			mock.ExpectQuery("FROM notification_quiet_hours").
+				WithArgs(7).
+				WillReturnRows(sqlmock.NewRows([]string{"start", "end", "timezone"}).AddRow("22:00", "07:00", "UTC"))
+			mock.ExpectQuery("SELECT frequency FROM notification_digests").
+				WithArgs(7).
+				WillReturnRows(sqlmock.NewRows([]string{"frequency"}))
+
+			email := &recordingSender{channel: ChannelEmail}
+			push := &recordingSender{channel: ChannelPush}
+			d := NewDispatcher(db, nil, email, push)
+			d.now = func() time.Time { return tt.now }
+
+			result, err := d.Dispatch(context.Background(), models.CreateNotificationRequest{
+				UserID: 7,
+				Type:   "new_video",
+				Title:  "bob uploaded a video",
+			})
+			if err != nil {
+				t.Fatalf("Dispatch failed: %v", err)
+			}
+
+			if email.sent != tt.wantSent || push.sent != tt.wantSent {
+				t.Errorf("Expected %d email and push sends, got %d and %d", tt.wantSent, email.sent, push.sent)
+			}
+			if !reflect.DeepEqual(result.Channels, tt.wantChannels) {
+				t.Errorf("Expected channels %v, got %v", tt.wantChannels, result.Channels)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
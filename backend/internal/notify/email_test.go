@@ -0,0 +1,259 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/aung-arata/youtube-clone/backend/internal/models"
+)
+
+// fakeSMTP is a minimal SMTP server that records the messages it receives
+type fakeSMTP struct {
+	addr     string
+	messages chan string
+	rcpts    chan string
+}
+
+func startFakeSMTP(t *testing.T) *fakeSMTP {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	s := &fakeSMTP{
+		addr:     ln.Addr().String(),
+		messages: make(chan string, 10),
+		rcpts:    make(chan string, 10),
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+
+	reply("220 localhost fake SMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "MAIL FROM"):
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO"):
+			s.rcpts <- strings.TrimSpace(line[len("RCPT TO:"):])
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(l, "."))
+			}
+			s.messages <- data.String()
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func TestSMTPMailer_Send(t *testing.T) {
+	server := startFakeSMTP(t)
+	mailer := NewSMTPMailer(SMTPConfig{Addr: server.addr, From: "Notifications <notify@example.com>"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := mailer.Send(ctx, EmailMessage{
+		To:      "alice@example.com",
+		Subject: "Bob replied to your comment\r\nBcc: everyone@example.com",
+		Text:    "Plain body",
+		HTML:    "<p>HTML body</p>",
+	})
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	if rcpt := <-server.rcpts; rcpt != "<alice@example.com>" {
+		t.Errorf("Expected recipient <alice@example.com>, got %s", rcpt)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(<-server.messages))
+	if err != nil {
+		t.Fatalf("Failed to parse message: %v", err)
+	}
+	if got := msg.Header.Get("Subject"); got != "Bob replied to your comment  Bcc: everyone@example.com" {
+		t.Errorf("Expected the subject on one line, got %q", got)
+	}
+	if msg.Header.Get("Bcc") != "" {
+		t.Error("Subject injected a Bcc header")
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Expected multipart/alternative, got %q", msg.Header.Get("Content-Type"))
+	}
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	var types []string
+	for {
+		p, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Failed to read part: %v", err)
+		}
+		body, _ := io.ReadAll(p)
+		types = append(types, p.Header.Get("Content-Type"))
+		if strings.HasPrefix(p.Header.Get("Content-Type"), "text/html") && string(body) != "<p>HTML body</p>" {
+			t.Errorf("Unexpected HTML part %q", body)
+		}
+	}
+	if len(types) != 2 || !strings.HasPrefix(types[0], "text/plain") {
+		t.Errorf("Expected text then HTML parts, got %v", types)
+	}
+}
+
+func TestRenderNotificationEmail(t *testing.T) {
+	to := Recipient{Username: "alice", Email: "alice@example.com"}
+
+	tests := []struct {
+		notificationType string
+		wantInHTML       string
+	}{
+		{"comment_reply", "Reply"},
+		{"mention", "See the comment"},
+		{"new_video", "Watch now"},
+		{"system", "Open"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.notificationType, func(t *testing.T) {
+			msg, err := RenderNotificationEmail(to, models.Notification{
+				UserID:  1,
+				Type:    tt.notificationType,
+				Title:   "bob did something",
+				Message: "<script>alert(1)</script>",
+				Link:    "/videos/5",
+			}, "https://example.com/")
+			if err != nil {
+				t.Fatalf("Render failed: %v", err)
+			}
+
+			if msg.To != "alice@example.com" || msg.Subject == "" {
+				t.Errorf("Unexpected message %+v", msg)
+			}
+			if !strings.Contains(msg.Text, "https://example.com/videos/5") {
+				t.Errorf("Expected an absolute link in the text, got %q", msg.Text)
+			}
+			if !strings.Contains(msg.HTML, tt.wantInHTML) || !strings.Contains(msg.HTML, "Hi alice") {
+				t.Errorf("Expected %q in the HTML, got %q", tt.wantInHTML, msg.HTML)
+			}
+			if strings.Contains(msg.HTML, "<script>") {
+				t.Error("Expected the message to be escaped in the HTML")
+			}
+		})
+	}
+}
+
+func TestRenderDigestEmail(t *testing.T) {
+	msg, err := renderDigestEmail(Recipient{Username: "alice", Email: "alice@example.com"}, digestEmail{
+		Username:      "alice",
+		Period:        "daily",
+		Count:         3,
+		Notifications: []digestItem{{Title: "First", Message: "one"}, {Title: "Second", Message: "two"}},
+		More:          1,
+	})
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+
+	if msg.Subject != "You have 3 unread notifications" {
+		t.Errorf("Unexpected subject %q", msg.Subject)
+	}
+	if !strings.Contains(msg.Text, "* Second") || !strings.Contains(msg.Text, "and 1 more") {
+		t.Errorf("Expected the digest to list notifications, got %q", msg.Text)
+	}
+}
+
+func TestEmailBackoff(t *testing.T) {
+	want := []time.Duration{time.Minute, 4 * time.Minute, 16 * time.Minute, 64 * time.Minute}
+	for i, w := range want {
+		if got := emailBackoff(i + 1); got != w {
+			t.Errorf("emailBackoff(%d) = %s, want %s", i+1, got, w)
+		}
+	}
+}
+
+type failingMailer struct{}
+
+func (failingMailer) Send(ctx context.Context, msg EmailMessage) error {
+	return errors.New("connection refused")
+}
+
+func TestEmailOutbox_RetriesThenGivesUp(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	outbox := NewEmailOutbox(db, failingMailer{})
+
+	columns := []string{"id", "attempts", "to_address", "subject", "text_body", "html_body"}
+	mock.ExpectQuery("UPDATE email_deliveries (.+) FOR UPDATE SKIP LOCKED").
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(1, 2, "a@example.com", "s", "t", "h").
+			AddRow(2, maxEmailAttempts, "b@example.com", "s", "t", "h"))
+	mock.ExpectExec("UPDATE email_deliveries SET last_error = (.+) next_attempt_at").
+		WithArgs(1, "connection refused", emailBackoff(2).Seconds()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE email_deliveries SET status = 'failed'").
+		WithArgs(2, "connection refused").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	sent, err := outbox.SendDue(context.Background())
+	if err != nil || sent != 0 {
+		t.Errorf("Expected nothing sent and no error, got %d, %v", sent, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,240 @@
+package notify
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	_ "time/tzdata" // quiet hours must resolve time zones in minimal containers
+
+	"github.com/aung-arata/youtube-clone/backend/internal/models"
+)
+
+// DefaultPreference applies to categories a user hasn't configured and to
+// notification types outside any category
+var DefaultPreference = models.NotificationTypePreference{
+	Enabled:  true,
+	Channels: models.NotificationChannels{InApp: true, WebSocket: true},
+}
+
+// CategoryOf maps a notification type to the category its preferences live
+// under, or "" for types that always use DefaultPreference
+func CategoryOf(notificationType string) string {
+	switch notificationType {
+	case "subscription":
+		return models.NotificationCategorySubscription
+	case "like":
+		return models.NotificationCategoryLike
+	case "comment", "comment_reply", "video_comment":
+		return models.NotificationCategoryComment
+	case "mention":
+		return models.NotificationCategoryMention
+	case "upload", "new_video":
+		return models.NotificationCategoryUpload
+	}
+	return ""
+}
+
+// PreferenceFor returns the user's preference for a notification type
+func PreferenceFor(prefs models.NotificationPreferences, notificationType string) models.NotificationTypePreference {
+	if pref, ok := prefs.Types[CategoryOf(notificationType)]; ok {
+		return pref
+	}
+	return DefaultPreference
+}
+
+// LoadPreferences returns a user's preferences with every category filled in
+func LoadPreferences(ctx context.Context, db *sql.DB, userID int) (models.NotificationPreferences, error) {
+	prefs := models.NotificationPreferences{
+		UserID: userID,
+		Types:  make(map[string]models.NotificationTypePreference),
+		Digest: models.DigestOff,
+	}
+	for _, category := range models.NotificationCategories {
+		prefs.Types[category] = DefaultPreference
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT category, enabled, in_app, websocket, email, push
+		FROM notification_preferences
+		WHERE user_id = $1
+	`, userID)
+	if err != nil {
+		return prefs, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var category string
+		var p models.NotificationTypePreference
+		if err := rows.Scan(&category, &p.Enabled, &p.Channels.InApp, &p.Channels.WebSocket,
+			&p.Channels.Email, &p.Channels.Push); err != nil {
+			return prefs, err
+		}
+		prefs.Types[category] = p
+	}
+	if err := rows.Err(); err != nil {
+		return prefs, err
+	}
+
+	var q models.QuietHours
+	err = db.QueryRowContext(ctx, `
+		SELECT to_char(start_time, 'HH24:MI'), to_char(end_time, 'HH24:MI'), timezone
+		FROM notification_quiet_hours
+		WHERE user_id = $1
+	`, userID).Scan(&q.Start, &q.End, &q.Timezone)
+	if err == nil {
+		prefs.QuietHours = &q
+	} else if err != sql.ErrNoRows {
+		return prefs, err
+	}
+
+	err = db.QueryRowContext(ctx, `SELECT frequency FROM notification_digests WHERE user_id = $1`, userID).
+		Scan(&prefs.Digest)
+	if err != nil && err != sql.ErrNoRows {
+		return prefs, err
+	}
+
+	return prefs, nil
+}
+
+// SavePreferences replaces a user's preferences. Categories missing from
+// prefs.Types go back to the default.
+func SavePreferences(ctx context.Context, db *sql.DB, prefs models.NotificationPreferences) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM notification_preferences WHERE user_id = $1`, prefs.UserID); err != nil {
+		return err
+	}
+	for _, category := range models.NotificationCategories {
+		p, ok := prefs.Types[category]
+		if !ok {
+			continue
+		}
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO notification_preferences (user_id, category, enabled, in_app, websocket, email, push)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`, prefs.UserID, category, p.Enabled, p.Channels.InApp, p.Channels.WebSocket, p.Channels.Email, p.Channels.Push)
+		if err != nil {
+			return err
+		}
+	}
+
+	if q := prefs.QuietHours; q != nil {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO notification_quiet_hours (user_id, start_time, end_time, timezone)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (user_id) DO UPDATE
+			SET start_time = EXCLUDED.start_time,
+			    end_time = EXCLUDED.end_time,
+			    timezone = EXCLUDED.timezone
+		`, prefs.UserID, q.Start, q.End, q.Timezone)
+	} else {
+		_, err = tx.ExecContext(ctx, `DELETE FROM notification_quiet_hours WHERE user_id = $1`, prefs.UserID)
+	}
+	if err != nil {
+		return err
+	}
+
+	if prefs.Digest == models.DigestDaily || prefs.Digest == models.DigestWeekly {
+		// Keep last_sent_at so changing the frequency doesn't resend a digest
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO notification_digests (user_id, frequency)
+			VALUES ($1, $2)
+			ON CONFLICT (user_id) DO UPDATE SET frequency = EXCLUDED.frequency
+		`, prefs.UserID, prefs.Digest)
+	} else {
+		_, err = tx.ExecContext(ctx, `DELETE FROM notification_digests WHERE user_id = $1`, prefs.UserID)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ValidatePreferences checks categories, the digest frequency, times and the
+// time zone
+func ValidatePreferences(prefs *models.NotificationPreferences) error {
+	for category := range prefs.Types {
+		if !isCategory(category) {
+			return fmt.Errorf("Unknown notification category %q", category)
+		}
+	}
+
+	switch prefs.Digest {
+	case "":
+		prefs.Digest = models.DigestOff
+	case models.DigestOff, models.DigestDaily, models.DigestWeekly:
+	default:
+		return errors.New("Digest must be off, daily or weekly")
+	}
+
+	if q := prefs.QuietHours; q != nil {
+		if _, err := parseClock(q.Start); err != nil {
+			return errors.New("Quiet hours start must be HH:MM")
+		}
+		if _, err := parseClock(q.End); err != nil {
+			return errors.New("Quiet hours end must be HH:MM")
+		}
+		if q.Timezone == "" {
+			q.Timezone = "UTC"
+		}
+		if _, err := time.LoadLocation(q.Timezone); err != nil {
+			return fmt.Errorf("Unknown time zone %q", q.Timezone)
+		}
+	}
+	return nil
+}
+
+func isCategory(category string) bool {
+	for _, c := range models.NotificationCategories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// parseClock returns minutes since midnight for "HH:MM"
+func parseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// InQuietHours reports whether now falls within the user's quiet hours
+func InQuietHours(q *models.QuietHours, now time.Time) bool {
+	if q == nil {
+		return false
+	}
+
+	start, err := parseClock(q.Start)
+	if err != nil {
+		return false
+	}
+	end, err := parseClock(q.End)
+	if err != nil || start == end {
+		return false
+	}
+
+	loc, err := time.LoadLocation(q.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	local := now.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+
+	if start < end {
+		return minute >= start && minute < end
+	}
+	// Spans midnight
+	return minute >= start || minute < end
+}
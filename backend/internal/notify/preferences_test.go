@@ -0,0 +1,68 @@
+package notify
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aung-arata/youtube-clone/backend/internal/models"
+)
+
+func TestCategoryOf(t *testing.T) {
+	tests := map[string]string{
+		"comment_reply": models.NotificationCategoryComment,
+		"video_comment": models.NotificationCategoryComment,
+		"mention":       models.NotificationCategoryMention,
+		"new_video":     models.NotificationCategoryUpload,
+		"like":          models.NotificationCategoryLike,
+		"system":        "",
+	}
+
+	for notificationType, want := range tests {
+		if got := CategoryOf(notificationType); got != want {
+			t.Errorf("CategoryOf(%q) = %q, want %q", notificationType, got, want)
+		}
+	}
+}
+
+func TestInQuietHours(t *testing.T) {
+	overnight := &models.QuietHours{Start: "22:00", End: "07:00", Timezone: "America/New_York"}
+	afternoon := &models.QuietHours{Start: "13:00", End: "14:30", Timezone: "UTC"}
+
+	tests := []struct {
+		name  string
+		quiet *models.QuietHours
+		now   time.Time
+		want  bool
+	}{
+		{"No quiet hours", nil, time.Date(2024, 1, 1, 3, 0, 0, 0, time.UTC), false},
+		{"Late evening in New York", overnight, time.Date(2024, 1, 2, 4, 0, 0, 0, time.UTC), true},
+		{"Early morning in New York", overnight, time.Date(2024, 1, 2, 11, 59, 0, 0, time.UTC), true},
+		{"Morning in New York", overnight, time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC), false},
+		{"Within a daytime span", afternoon, time.Date(2024, 1, 1, 14, 0, 0, 0, time.UTC), true},
+		{"End is exclusive", afternoon, time.Date(2024, 1, 1, 14, 30, 0, 0, time.UTC), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := InQuietHours(tt.quiet, tt.now); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestValidatePreferences_Defaults(t *testing.T) {
+	prefs := models.NotificationPreferences{
+		QuietHours: &models.QuietHours{Start: "22:00", End: "07:00"},
+	}
+
+	if err := ValidatePreferences(&prefs); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if prefs.Digest != models.DigestOff {
+		t.Errorf("Expected digests to default to off, got %q", prefs.Digest)
+	}
+	if prefs.QuietHours.Timezone != "UTC" {
+		t.Errorf("Expected the time zone to default to UTC, got %q", prefs.QuietHours.Timezone)
+	}
+}
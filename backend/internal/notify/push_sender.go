@@ -0,0 +1,108 @@
+package notify
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/aung-arata/youtube-clone/backend/internal/models"
+	"github.com/aung-arata/youtube-clone/backend/internal/webpush"
+)
+
+// pushTTL is how long push services hold a notification for an offline
+// browser
+const pushTTL = 24 * time.Hour
+
+// pushMessage is the JSON a service worker receives in its push event
+type pushMessage struct {
+	ID    int    `json:"id,omitempty"`
+	Type  string `json:"type"`
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	URL   string `json:"url,omitempty"`
+}
+
+// PushSender delivers notifications to every browser a user registered for
+// web push. Subscriptions the push service reports gone are deleted.
+type PushSender struct {
+	db     *sql.DB
+	client *webpush.Client
+}
+
+// NewPushSender creates a web push sender
+func NewPushSender(db *sql.DB, client *webpush.Client) *PushSender {
+	return &PushSender{db: db, client: client}
+}
+
+func (s *PushSender) Channel() Channel {
+	return ChannelPush
+}
+
+// Send succeeds if the notification reached at least one browser
+func (s *PushSender) Send(ctx context.Context, n models.Notification) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, endpoint, p256dh, auth FROM push_subscriptions WHERE user_id = $1
+	`, n.UserID)
+	if err != nil {
+		return err
+	}
+
+	type subscription struct {
+		id int
+		webpush.Subscription
+	}
+	var subs []subscription
+	for rows.Next() {
+		var sub subscription
+		if err := rows.Scan(&sub.id, &sub.Endpoint, &sub.Keys.P256dh, &sub.Keys.Auth); err != nil {
+			rows.Close()
+			return err
+		}
+		subs = append(subs, sub)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(subs) == 0 {
+		return ErrNotDeliverable
+	}
+
+	payload, err := json.Marshal(pushMessage{ID: n.ID, Type: n.Type, Title: n.Title, Body: n.Message, URL: n.Link})
+	if err != nil {
+		return err
+	}
+	opts := webpush.Options{TTL: pushTTL, Urgency: webpush.UrgencyNormal}
+	if n.ID != 0 {
+		opts.Topic = "n" + strconv.Itoa(n.ID)
+	}
+
+	delivered := 0
+	var lastErr error
+	for _, sub := range subs {
+		err := s.client.Send(ctx, sub.Subscription, payload, opts)
+		switch {
+		case err == nil:
+			delivered++
+			s.db.ExecContext(ctx, `UPDATE push_subscriptions SET last_used_at = CURRENT_TIMESTAMP WHERE id = $1`, sub.id)
+		case errors.Is(err, webpush.ErrGone):
+			if _, err := s.db.ExecContext(ctx, `DELETE FROM push_subscriptions WHERE id = $1`, sub.id); err != nil {
+				log.Printf("Failed to delete expired push subscription %d: %v", sub.id, err)
+			}
+		default:
+			lastErr = err
+		}
+	}
+
+	if delivered > 0 {
+		return nil
+	}
+	if lastErr != nil {
+		return lastErr
+	}
+	return ErrNotDeliverable
+}
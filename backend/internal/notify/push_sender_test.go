@@ -0,0 +1,66 @@
+package notify
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/aung-arata/youtube-clone/backend/internal/models"
+	"github.com/aung-arata/youtube-clone/backend/internal/webpush"
+)
+
+func TestPushSender_RemovesGoneSubscriptions(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	// Local stand-ins for a push service: one accepts, one says the
+	// subscription has gone
+	accepted := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer accepted.Close()
+	gone := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	}))
+	defer gone.Close()
+
+	public, private, _ := webpush.GenerateVAPIDKeys()
+	keys, err := webpush.ParseVAPIDKeys(public, private, "mailto:admin@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := NewPushSender(db, webpush.NewClient(keys))
+
+	browser, _ := ecdh.P256().GenerateKey(rand.Reader)
+	p256dh := base64.RawURLEncoding.EncodeToString(browser.PublicKey().Bytes())
+	auth := base64.RawURLEncoding.EncodeToString(make([]byte, 16))
+
+	mock.ExpectQuery("SELECT id, endpoint, p256dh, auth FROM push_subscriptions").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "endpoint", "p256dh", "auth"}).
+			AddRow(10, accepted.URL, p256dh, auth).
+			AddRow(11, gone.URL, p256dh, auth))
+	mock.ExpectExec("UPDATE push_subscriptions SET last_used_at").
+		WithArgs(10).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM push_subscriptions").
+		WithArgs(11).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err = sender.Send(context.Background(), models.Notification{ID: 5, UserID: 1, Type: "mention", Title: "Hi", Message: "there"})
+	if err != nil {
+		t.Errorf("Expected delivery to succeed, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
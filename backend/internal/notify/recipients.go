@@ -0,0 +1,23 @@
+package notify
+
+import (
+	"context"
+	"database/sql"
+)
+
+// SQLRecipients reads email addresses from the users table
+type SQLRecipients struct {
+	db *sql.DB
+}
+
+// NewSQLRecipients creates recipients backed by db
+func NewSQLRecipients(db *sql.DB) *SQLRecipients {
+	return &SQLRecipients{db: db}
+}
+
+func (r *SQLRecipients) Lookup(ctx context.Context, userID int) (Recipient, error) {
+	var to Recipient
+	err := r.db.QueryRowContext(ctx, `SELECT username, email FROM users WHERE id = $1`, userID).
+		Scan(&to.Username, &to.Email)
+	return to, err
+}
@@ -0,0 +1,39 @@
+package notify
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestRetentionJob_PurgesInBatches(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	job := NewRetentionJob(db, 90*24*time.Hour)
+	maxAge := (90 * 24 * time.Hour).Seconds()
+
+	mock.ExpectExec("DELETE FROM notifications (.+) is_read = TRUE").
+		WithArgs(maxAge, retentionBatchSize).
+		WillReturnResult(sqlmock.NewResult(0, retentionBatchSize))
+	mock.ExpectExec("DELETE FROM notifications (.+) is_read = TRUE").
+		WithArgs(maxAge, retentionBatchSize).
+		WillReturnResult(sqlmock.NewResult(0, 12))
+
+	purged, err := job.Purge(context.Background())
+	if err != nil {
+		t.Fatalf("Purge failed: %v", err)
+	}
+	if purged != retentionBatchSize+12 {
+		t.Errorf("Expected %d purged, got %d", retentionBatchSize+12, purged)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package notify
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/aung-arata/youtube-clone/backend/internal/models"
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+// Email templates are named after notification categories, plus "default"
+// for types outside any category and "digest" for summaries. Each has a
+// .txt file defining "subject" and "text", and a .html file defining the
+// "content" of the shared layout.
+const (
+	defaultTemplate = "default"
+	digestTemplate  = "digest"
+)
+
+type emailTemplate struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+var emailTemplates = mustLoadTemplates()
+
+func mustLoadTemplates() map[string]emailTemplate {
+	names := append([]string{defaultTemplate, digestTemplate}, models.NotificationCategories...)
+
+	templates := make(map[string]emailTemplate, len(names))
+	for _, name := range names {
+		text := texttemplate.Must(texttemplate.ParseFS(templateFS, "templates/"+name+".txt"))
+		html := htmltemplate.Must(htmltemplate.ParseFS(templateFS, "templates/layout.html", "templates/"+name+".html"))
+		templates[name] = emailTemplate{text: text, html: html}
+	}
+	return templates
+}
+
+// Recipient is who an email is addressed to
+type Recipient struct {
+	Username string
+	Email    string
+}
+
+// notificationEmail is the data notification templates are rendered with
+type notificationEmail struct {
+	Username     string
+	Notification models.Notification
+	Link         string
+	AppLink      string
+}
+
+// digestItem is one notification listed in a digest
+type digestItem struct {
+	Title   string
+	Message string
+	Link    string
+}
+
+// digestEmail is the data the digest template is rendered with
+type digestEmail struct {
+	Username      string
+	Period        string
+	Count         int
+	Notifications []digestItem
+	More          int
+	Link          string
+	AppLink       string
+}
+
+// RenderNotificationEmail renders the email for a notification using the
+// template for its category. Relative links are made absolute with baseURL.
+func RenderNotificationEmail(to Recipient, n models.Notification, baseURL string) (EmailMessage, error) {
+	name := CategoryOf(n.Type)
+	if name == "" {
+		name = defaultTemplate
+	}
+
+	return renderEmail(name, to, notificationEmail{
+		Username:     to.Username,
+		Notification: n,
+		Link:         absoluteLink(baseURL, n.Link),
+		AppLink:      absoluteLink(baseURL, "/"),
+	})
+}
+
+func renderDigestEmail(to Recipient, data digestEmail) (EmailMessage, error) {
+	return renderEmail(digestTemplate, to, data)
+}
+
+func renderEmail(name string, to Recipient, data interface{}) (EmailMessage, error) {
+	t, ok := emailTemplates[name]
+	if !ok {
+		return EmailMessage{}, fmt.Errorf("no email template %q", name)
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := t.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return EmailMessage{}, err
+	}
+	if err := t.text.ExecuteTemplate(&text, "text", data); err != nil {
+		return EmailMessage{}, err
+	}
+	if err := t.html.ExecuteTemplate(&html, "layout.html", data); err != nil {
+		return EmailMessage{}, err
+	}
+
+	return EmailMessage{
+		To:      to.Email,
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+		HTML:    html.String(),
+	}, nil
+}
+
+func absoluteLink(baseURL, link string) string {
+	if link == "" || strings.Contains(link, "://") {
+		return link
+	}
+	return strings.TrimRight(baseURL, "/") + "/" + strings.TrimLeft(link, "/")
+}
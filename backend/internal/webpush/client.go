@@ -0,0 +1,101 @@
+package webpush
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrGone means the push service no longer accepts messages for a
+// subscription (404 or 410), so it should be forgotten
+var ErrGone = errors.New("push subscription has expired or been unsubscribed")
+
+// Subscription is where a browser receives push messages
+type Subscription struct {
+	Endpoint string `json:"endpoint"`
+	Keys     Keys   `json:"keys"`
+}
+
+// Urgency hints to the push service how soon to wake the device (RFC 8030)
+type Urgency string
+
+const (
+	UrgencyVeryLow Urgency = "very-low"
+	UrgencyLow     Urgency = "low"
+	UrgencyNormal  Urgency = "normal"
+	UrgencyHigh    Urgency = "high"
+)
+
+// Options control how a push service handles a message
+type Options struct {
+	// TTL is how long the push service keeps the message for an offline
+	// device. Zero means it is dropped unless the device is reachable now.
+	TTL     time.Duration
+	Urgency Urgency
+	// Topic replaces any undelivered message with the same topic
+	Topic string
+}
+
+// Client sends encrypted messages to push services
+type Client struct {
+	keys       *VAPIDKeys
+	httpClient *http.Client
+	now        func() time.Time
+}
+
+// NewClient creates a client that authenticates with keys
+func NewClient(keys *VAPIDKeys) *Client {
+	return &Client{
+		keys:       keys,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		now:        time.Now,
+	}
+}
+
+// Send encrypts payload for sub and posts it to the subscription's push
+// service. It returns ErrGone if the subscription no longer exists.
+func (c *Client) Send(ctx context.Context, sub Subscription, payload []byte, opts Options) error {
+	body, err := encrypt(payload, sub.Keys)
+	if err != nil {
+		return err
+	}
+	authorization, err := c.keys.authorization(sub.Endpoint, c.now())
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", strconv.Itoa(int(opts.TTL.Seconds())))
+	if opts.Urgency != "" {
+		req.Header.Set("Urgency", string(opts.Urgency))
+	}
+	if opts.Topic != "" {
+		req.Header.Set("Topic", opts.Topic)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return ErrGone
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	}
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("push service returned %d: %s", resp.StatusCode, bytes.TrimSpace(detail))
+}
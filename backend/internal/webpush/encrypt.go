@@ -0,0 +1,132 @@
+package webpush
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// recordSize is the aes128gcm record size advertised in the header. A
+	// push message is a single record.
+	recordSize = 4096
+
+	// MaxPayloadSize is the largest payload that fits in one record after
+	// the 16-byte tag and the padding delimiter
+	MaxPayloadSize = recordSize - 16 - 1
+)
+
+// Keys are a subscription's encryption keys, as returned by the browser's
+// PushSubscription.toJSON()
+type Keys struct {
+	P256dh string `json:"p256dh"`
+	Auth   string `json:"auth"`
+}
+
+// Validate checks the keys are well formed
+func (k Keys) Validate() error {
+	_, _, err := k.decode()
+	return err
+}
+
+func (k Keys) decode() (*ecdh.PublicKey, []byte, error) {
+	raw, err := decodeKey(k.P256dh)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid p256dh key: %w", err)
+	}
+	public, err := ecdh.P256().NewPublicKey(raw)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid p256dh key: %w", err)
+	}
+
+	auth, err := decodeKey(k.Auth)
+	if err != nil || len(auth) != 16 {
+		return nil, nil, errors.New("invalid auth secret")
+	}
+	return public, auth, nil
+}
+
+// encrypt encrypts payload for the user agent holding keys (RFC 8291)
+func encrypt(payload []byte, keys Keys) ([]byte, error) {
+	if len(payload) > MaxPayloadSize {
+		return nil, fmt.Errorf("payload of %d bytes exceeds %d", len(payload), MaxPayloadSize)
+	}
+
+	uaPublic, authSecret, err := keys.decode()
+	if err != nil {
+		return nil, err
+	}
+
+	asPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	cek, nonce, err := deriveContentKeys(asPrivate, uaPublic, authSecret, salt, asPrivate.PublicKey(), uaPublic)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// 0x02 marks the last (and only) record, with no padding after it
+	plaintext := append(append([]byte{}, payload...), 0x02)
+
+	asPublic := asPrivate.PublicKey().Bytes()
+	header := make([]byte, 0, 16+4+1+len(asPublic))
+	header = append(header, salt...)
+	header = binary.BigEndian.AppendUint32(header, recordSize)
+	header = append(header, byte(len(asPublic)))
+	header = append(header, asPublic...)
+
+	return gcm.Seal(header, nonce, plaintext, nil), nil
+}
+
+// deriveContentKeys computes the content encryption key and nonce from an
+// ECDH exchange between private and peer. senderPublic and receiverPublic
+// are the application server's and user agent's keys, in that order,
+// whichever side is deriving.
+func deriveContentKeys(private *ecdh.PrivateKey, peer *ecdh.PublicKey, authSecret, salt []byte,
+	senderPublic, receiverPublic *ecdh.PublicKey) (cek, nonce []byte, err error) {
+	secret, err := private.ECDH(peer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyInfo := append([]byte("WebPush: info\x00"), receiverPublic.Bytes()...)
+	keyInfo = append(keyInfo, senderPublic.Bytes()...)
+	ikm := hkdf(authSecret, secret, keyInfo, 32)
+
+	cek = hkdf(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce = hkdf(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12)
+	return cek, nonce, nil
+}
+
+// hkdf is HKDF-SHA-256 (RFC 5869) for outputs of at most one hash length
+func hkdf(salt, secret, info []byte, length int) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(secret)
+	prk := extract.Sum(nil)
+
+	expand := hmac.New(sha256.New, prk)
+	expand.Write(info)
+	expand.Write([]byte{0x01})
+	return expand.Sum(nil)[:length]
+}
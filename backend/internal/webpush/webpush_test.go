@@ -0,0 +1,250 @@
+package webpush
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// userAgent plays the browser's side of a subscription
+type userAgent struct {
+	private *ecdh.PrivateKey
+	auth    []byte
+}
+
+func newUserAgent(t *testing.T) *userAgent {
+	t.Helper()
+	private, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := make([]byte, 16)
+	rand.Read(auth)
+	return &userAgent{private: private, auth: auth}
+}
+
+func (ua *userAgent) subscription(endpoint string) Subscription {
+	return Subscription{
+		Endpoint: endpoint,
+		Keys: Keys{
+			P256dh: b64.EncodeToString(ua.private.PublicKey().Bytes()),
+			Auth:   b64.EncodeToString(ua.auth),
+		},
+	}
+}
+
+// decrypt reverses encrypt as a browser would
+func (ua *userAgent) decrypt(t *testing.T, body []byte) []byte {
+	t.Helper()
+
+	salt := body[:16]
+	if rs := binary.BigEndian.Uint32(body[16:20]); rs != recordSize {
+		t.Fatalf("Unexpected record size %d", rs)
+	}
+	idLen := int(body[20])
+	senderPublic, err := ecdh.P256().NewPublicKey(body[21 : 21+idLen])
+	if err != nil {
+		t.Fatalf("Invalid sender key: %v", err)
+	}
+
+	cek, nonce, err := deriveContentKeys(ua.private, senderPublic, ua.auth, salt, senderPublic, ua.private.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := aes.NewCipher(cek)
+	gcm, _ := cipher.NewGCM(block)
+	plaintext, err := gcm.Open(nil, nonce, body[21+idLen:], nil)
+	if err != nil {
+		t.Fatalf("Failed to decrypt: %v", err)
+	}
+	if plaintext[len(plaintext)-1] != 0x02 {
+		t.Fatalf("Expected the last-record delimiter, got %x", plaintext[len(plaintext)-1])
+	}
+	return plaintext[:len(plaintext)-1]
+}
+
+// verifyVAPID checks the Authorization header's token against the key it
+// carries
+func verifyVAPID(t *testing.T, header, wantAud string) {
+	t.Helper()
+
+	var token, key string
+	for _, part := range strings.Split(strings.TrimPrefix(header, "vapid "), ", ") {
+		if strings.HasPrefix(part, "t=") {
+			token = part[2:]
+		} else if strings.HasPrefix(part, "k=") {
+			key = part[2:]
+		}
+	}
+
+	raw, err := b64.DecodeString(key)
+	if err != nil || len(raw) != 65 {
+		t.Fatalf("Invalid VAPID key %q", key)
+	}
+	public := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(raw[1:33]), Y: new(big.Int).SetBytes(raw[33:])}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("Malformed token %q", token)
+	}
+	sig, _ := b64.DecodeString(parts[2])
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+	if !ecdsa.Verify(public, digest[:], r, s) {
+		t.Fatal("VAPID signature doesn't verify")
+	}
+
+	var claims struct {
+		Aud string `json:"aud"`
+		Exp int64  `json:"exp"`
+		Sub string `json:"sub"`
+	}
+	payload, _ := b64.DecodeString(parts[1])
+	json.Unmarshal(payload, &claims)
+	if claims.Aud != wantAud || claims.Sub != "mailto:admin@example.com" {
+		t.Errorf("Unexpected claims %+v", claims)
+	}
+	if exp := time.Unix(claims.Exp, 0); exp.Before(time.Now()) || exp.After(time.Now().Add(24*time.Hour)) {
+		t.Errorf("Expiry %s should be within 24 hours", exp)
+	}
+}
+
+func testClient(t *testing.T) *Client {
+	t.Helper()
+	public, private, err := GenerateVAPIDKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := ParseVAPIDKeys(public, private, "mailto:admin@example.com")
+	if err != nil {
+		t.Fatalf("ParseVAPIDKeys failed: %v", err)
+	}
+	return NewClient(keys)
+}
+
+func TestClient_Send(t *testing.T) {
+	ua := newUserAgent(t)
+	received := make(chan []byte, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		verifyVAPID(t, r.Header.Get("Authorization"), "http://"+r.Host)
+		if r.Header.Get("Content-Encoding") != "aes128gcm" || r.Header.Get("TTL") != "3600" || r.Header.Get("Urgency") != "high" {
+			t.Errorf("Unexpected headers %v", r.Header)
+		}
+		body, _ := io.ReadAll(r.Body)
+		received <- body
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	payload := []byte(`{"title":"Hello"}`)
+	err := testClient(t).Send(context.Background(), ua.subscription(server.URL+"/push/abc"), payload,
+		Options{TTL: time.Hour, Urgency: UrgencyHigh})
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	if got := ua.decrypt(t, <-received); string(got) != string(payload) {
+		t.Errorf("Expected %s, got %s", payload, got)
+	}
+}
+
+func TestClient_SendGone(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	}))
+	defer server.Close()
+
+	err := testClient(t).Send(context.Background(), newUserAgent(t).subscription(server.URL), []byte("hi"), Options{})
+	if !errors.Is(err, ErrGone) {
+		t.Errorf("Expected ErrGone, got %v", err)
+	}
+}
+
+func TestClient_SendTooLarge(t *testing.T) {
+	err := testClient(t).Send(context.Background(), newUserAgent(t).subscription("https://push.example.com"),
+		make([]byte, MaxPayloadSize+1), Options{})
+	if err == nil {
+		t.Error("Expected an oversized payload to be refused")
+	}
+}
+
+func TestKeys_Validate(t *testing.T) {
+	valid := newUserAgent(t).subscription("").Keys
+
+	tests := []struct {
+		name string
+		keys Keys
+		ok   bool
+	}{
+		{"Valid", valid, true},
+		{"Not a point", Keys{P256dh: b64.EncodeToString(make([]byte, 65)), Auth: valid.Auth}, false},
+		{"Short auth", Keys{P256dh: valid.P256dh, Auth: b64.EncodeToString(make([]byte, 8))}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.keys.Validate(); (err == nil) != tt.ok {
+				t.Errorf("Expected ok=%v, got %v", tt.ok, err)
+			}
+		})
+	}
+}
+
+func TestParseVAPIDKeys_Mismatch(t *testing.T) {
+	public, _, _ := GenerateVAPIDKeys()
+	_, private, _ := GenerateVAPIDKeys()
+
+	if _, err := ParseVAPIDKeys(public, private, "mailto:admin@example.com"); err == nil {
+		t.Error("Expected mismatched keys to be refused")
+	}
+}
+
+// TestDeriveContentKeys checks key derivation against the example in
+// RFC 8291 appendix A
+func TestDeriveContentKeys(t *testing.T) {
+	decode := func(s string) []byte {
+		b, err := b64.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	asPrivate, err := ecdh.P256().NewPrivateKey(decode("yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	uaPublic, err := ecdh.P256().NewPublicKey(decode("BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cek, nonce, err := deriveContentKeys(asPrivate, uaPublic, decode("BTBZMqHH6r4Tts7J_aSIgg"),
+		decode("DGv6ra1nlYgDCS1FRnbzlw"), asPrivate.PublicKey(), uaPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := b64.EncodeToString(cek); got != "oIhVW04MRdy2XN9CiKLxTg" {
+		t.Errorf("Unexpected CEK %s", got)
+	}
+	if got := b64.EncodeToString(nonce); got != "4h_95klXJ5E_qnoN" {
+		t.Errorf("Unexpected nonce %s", got)
+	}
+}
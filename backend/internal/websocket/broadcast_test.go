@@ -0,0 +1,168 @@
+package websocket
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// connect registers a connectionless client for userID
+func connect(t *testing.T, hub *Hub, userID int) *Client {
+	t.Helper()
+
+	client := NewClient(hub, nil, userID, "")
+	hub.register(client)
+	return client
+}
+
+func expectMessage(t *testing.T, client *Client, contains string) {
+	t.Helper()
+
+	select {
+	case <-client.queue.ready:
+		messages, _, _ := client.queue.drain()
+		if len(messages) == 0 || !strings.Contains(string(messages[0].data), contains) {
+			t.Errorf("Expected a message containing %q, got %v", contains, messages)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Timed out waiting for a message containing %q", contains)
+	}
+}
+
+func expectNoMessage(t *testing.T, client *Client) {
+	t.Helper()
+
+	select {
+	case <-client.queue.ready:
+		messages, _, _ := client.queue.drain()
+		t.Errorf("Expected no message, got %v", messages)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestLocalBroadcaster_MultipleHubs(t *testing.T) {
+	b := NewLocalBroadcaster()
+	defer b.Close()
+
+	hubA := NewHubWithBroadcaster(b, nil)
+	hubB := NewHubWithBroadcaster(b, nil)
+	go hubA.Run()
+	go hubB.Run()
+
+	onA := connect(t, hubA, 7)
+	onB := connect(t, hubB, 7)
+	other := connect(t, hubB, 8)
+
+	if err := hubA.SendNotification(context.Background(), 7, NotificationPayload{ID: 1, UserID: 7, Title: "Hello"}); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+
+	expectMessage(t, onA, `"id":1`)
+	expectMessage(t, onB, `"id":1`)
+	expectNoMessage(t, other)
+}
+
+func TestLocalBroadcaster_CloseStopsHubs(t *testing.T) {
+	b := NewLocalBroadcaster()
+	hub := NewHubWithBroadcaster(b, nil)
+
+	stopped := make(chan struct{})
+	go func() {
+		hub.Run()
+		close(stopped)
+	}()
+
+	b.Close()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Hub kept running after its broadcaster was closed")
+	}
+}
+
+func TestPostgresBroadcaster_RejectsOversizedMessages(t *testing.T) {
+	b := &PostgresBroadcaster{}
+
+	err := b.Publish(context.Background(), &BroadcastMessage{
+		UserID:  1,
+		Message: []byte(`"` + strings.Repeat("x", maxNotifyPayload) + `"`),
+	})
+	if err == nil {
+		t.Error("Expected an error for a payload over the NOTIFY limit")
+	}
+}
+
+func TestPostgresBroadcaster_ConcurrentClose(t *testing.T) {
+	// The listener never connects; closing it must still be safe
+	b := &PostgresBroadcaster{
+		done:     make(chan struct{}),
+		listener: pq.NewListener("host=127.0.0.1 port=1 sslmode=disable", time.Second, time.Minute, nil),
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- b.Close()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	closed := 0
+	for err := range errs {
+		if err == errBroadcasterClosed {
+			closed++
+		}
+	}
+	if closed != 7 {
+		t.Errorf("Expected 7 calls to report an already closed broadcaster, got %d", closed)
+	}
+}
+
+// TestPostgresBroadcaster_MultipleHubs needs a database, e.g.
+// WS_TEST_DATABASE_URL="host=localhost user=postgres password=postgres dbname=youtube_clone sslmode=disable"
+func TestPostgresBroadcaster_MultipleHubs(t *testing.T) {
+	connStr := os.Getenv("WS_TEST_DATABASE_URL")
+	if connStr == "" {
+		t.Skip("WS_TEST_DATABASE_URL not set")
+	}
+
+	// Each broadcaster stands in for a separate instance
+	var hubs []*Hub
+	for i := 0; i < 2; i++ {
+		db, err := sql.Open("postgres", connStr)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer db.Close()
+
+		b, err := NewPostgresBroadcaster(db, connStr)
+		if err != nil {
+			t.Fatalf("Failed to start broadcaster: %v", err)
+		}
+		defer b.Close()
+
+		hub := NewHubWithBroadcaster(b, nil)
+		go hub.Run()
+		hubs = append(hubs, hub)
+	}
+
+	onA := connect(t, hubs[0], 7)
+	onB := connect(t, hubs[1], 7)
+
+	if err := hubs[0].SendNotification(context.Background(), 7, NotificationPayload{ID: 2, UserID: 7, Title: "Across instances"}); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+
+	expectMessage(t, onA, `"id":2`)
+	expectMessage(t, onB, `"id":2`)
+}
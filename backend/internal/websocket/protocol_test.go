@@ -0,0 +1,254 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aung-arata/youtube-clone/backend/internal/auth"
+	"github.com/gorilla/websocket"
+)
+
+// fakeStore keeps one user's notifications in memory
+type fakeStore struct {
+	mu            sync.Mutex
+	notifications []NotificationPayload
+	delivered     map[int]bool
+}
+
+func (s *fakeStore) NotificationsAfter(ctx context.Context, userID, afterID, limit int) ([]NotificationPayload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := []NotificationPayload{}
+	for _, n := range s.notifications {
+		if n.UserID == userID && n.ID > afterID && len(result) < limit {
+			result = append(result, n)
+		}
+	}
+	return result, nil
+}
+
+func (s *fakeStore) MarkRead(ctx context.Context, userID int, ids []int) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var updated int64
+	for i, n := range s.notifications {
+		if n.UserID != userID || n.IsRead {
+			continue
+		}
+		match := ids == nil
+		for _, id := range ids {
+			match = match || id == n.ID
+		}
+		if match {
+			s.notifications[i].IsRead = true
+			updated++
+		}
+	}
+	return updated, nil
+}
+
+func (s *fakeStore) MarkDelivered(ctx context.Context, userID int, ids []int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		s.delivered[id] = true
+	}
+	return nil
+}
+
+func (s *fakeStore) UnreadCount(ctx context.Context, userID int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for _, n := range s.notifications {
+		if n.UserID == userID && !n.IsRead {
+			count++
+		}
+	}
+	return count, nil
+}
+
+type received struct {
+	Version int             `json:"v"`
+	Type    string          `json:"type"`
+	ID      string          `json:"id"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// testConn reads one server message at a time, splitting the batches the
+// write pump sends as newline-separated frames
+type testConn struct {
+	*websocket.Conn
+	pending []received
+}
+
+func (c *testConn) next(t *testing.T) received {
+	t.Helper()
+
+	for len(c.pending) == 0 {
+		c.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, data, err := c.ReadMessage()
+		if err != nil {
+			t.Fatalf("Failed to read message: %v", err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			var msg received
+			if err := json.Unmarshal([]byte(line), &msg); err != nil {
+				t.Fatalf("Failed to decode %s: %v", line, err)
+			}
+			c.pending = append(c.pending, msg)
+		}
+	}
+
+	msg := c.pending[0]
+	c.pending = c.pending[1:]
+	return msg
+}
+
+// until reads messages up to and including the first one of type last
+func (c *testConn) until(t *testing.T, last string) []received {
+	t.Helper()
+
+	var msgs []received
+	for {
+		msg := c.next(t)
+		msgs = append(msgs, msg)
+		if msg.Type == last {
+			return msgs
+		}
+	}
+}
+
+// dialTestHub connects user 7 to a hub backed by store and consumes the
+// hello message
+func dialTestHub(t *testing.T, store Store) (*Hub, *testConn) {
+	t.Helper()
+
+	hub := NewHubWithBroadcaster(NewLocalBroadcaster(), store)
+	go hub.Run()
+
+	server := httptest.NewServer(http.HandlerFunc(hub.ServeWS))
+	t.Cleanup(server.Close)
+
+	token, err := auth.GenerateToken(auth.TokenSubject{UserID: 7, Username: "alice", Role: "user", SessionID: "session-1"})
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	dialer := websocket.Dialer{Subprotocols: []string{authSubprotocol, token}}
+	ws, resp, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { ws.Close() })
+
+	if got := resp.Header.Get("Sec-WebSocket-Protocol"); got != authSubprotocol {
+		t.Errorf("Expected subprotocol %q, got %q", authSubprotocol, got)
+	}
+
+	conn := &testConn{Conn: ws}
+	if msg := conn.next(t); msg.Type != TypeHello || msg.Version != ProtocolVersion {
+		t.Fatalf("Expected hello, got %+v", msg)
+	}
+	return hub, conn
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		notifications: []NotificationPayload{
+			{ID: 1, UserID: 7, Title: "one"},
+			{ID: 2, UserID: 7, Title: "two"},
+			{ID: 3, UserID: 7, Title: "three"},
+			{ID: 4, UserID: 8, Title: "someone else's"},
+		},
+		delivered: make(map[int]bool),
+	}
+}
+
+func TestProtocol_Resume(t *testing.T) {
+	_, conn := dialTestHub(t, newFakeStore())
+	conn.until(t, TypeUnreadCount)
+
+	conn.WriteJSON(map[string]interface{}{"v": 1, "type": TypeResume, "id": "r1", "payload": map[string]int{"last_seen_id": 1}})
+
+	msgs := conn.until(t, TypeResumed)
+	if len(msgs) != 3 || msgs[0].Type != TypeNotification || msgs[1].Type != TypeNotification {
+		t.Fatalf("Expected two notifications then resumed, got %+v", msgs)
+	}
+	if !strings.Contains(string(msgs[0].Payload), `"id":2`) || !strings.Contains(string(msgs[1].Payload), `"id":3`) {
+		t.Errorf("Expected notifications 2 and 3, got %s and %s", msgs[0].Payload, msgs[1].Payload)
+	}
+
+	var resumed ResumedPayload
+	json.Unmarshal(msgs[2].Payload, &resumed)
+	if msgs[2].ID != "r1" || resumed.Count != 2 || resumed.HasMore {
+		t.Errorf("Unexpected resumed message: %+v", msgs[2])
+	}
+}
+
+func TestProtocol_MarkReadPushesUnreadCount(t *testing.T) {
+	store := newFakeStore()
+	_, conn := dialTestHub(t, store)
+	conn.until(t, TypeUnreadCount)
+
+	conn.WriteJSON(map[string]interface{}{"type": TypeMarkRead, "id": "m1", "payload": map[string][]int{"ids": {1, 2, 4}}})
+
+	msgs := conn.until(t, TypeUnreadCount)
+	if msgs[0].Type != TypeOK || msgs[0].ID != "m1" || !strings.Contains(string(msgs[0].Payload), `"updated":2`) {
+		t.Errorf("Expected ok with 2 updated, got %+v", msgs[0])
+	}
+
+	var unread UnreadCountPayload
+	json.Unmarshal(msgs[len(msgs)-1].Payload, &unread)
+	if unread.Count != 1 {
+		t.Errorf("Expected unread count 1, got %d", unread.Count)
+	}
+}
+
+func TestProtocol_Ack(t *testing.T) {
+	store := newFakeStore()
+	_, conn := dialTestHub(t, store)
+	conn.until(t, TypeUnreadCount)
+
+	conn.WriteJSON(map[string]interface{}{"type": TypeAck, "id": "a1", "payload": map[string][]int{"ids": {3}}})
+
+	if msg := conn.next(t); msg.Type != TypeOK || msg.ID != "a1" {
+		t.Errorf("Expected ok, got %+v", msg)
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if !store.delivered[3] {
+		t.Error("Expected notification 3 to be marked delivered")
+	}
+}
+
+func TestProtocol_Errors(t *testing.T) {
+	_, conn := dialTestHub(t, newFakeStore())
+	conn.until(t, TypeUnreadCount)
+
+	tests := []struct {
+		name string
+		msg  map[string]interface{}
+	}{
+		{"newer version", map[string]interface{}{"v": ProtocolVersion + 1, "type": TypePing, "id": "e1"}},
+		{"unknown type", map[string]interface{}{"type": "subscribe", "id": "e2"}},
+		{"no ids", map[string]interface{}{"type": TypeAck, "id": "e3", "payload": map[string][]int{"ids": {}}}},
+	}
+
+	for _, tt := range tests {
+		conn.WriteJSON(tt.msg)
+		if msg := conn.next(t); msg.Type != TypeError || msg.ID != tt.msg["id"] {
+			t.Errorf("%s: expected error reply, got %+v", tt.name, msg)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSendQueue_DropOldest(t *testing.T) {
+	q := newSendQueue(3)
+
+	for i := 1; i <= 5; i++ {
+		want := queued
+		if i > 3 {
+			want = droppedOldest
+		}
+		if result := q.push(TypeNotification, []byte(fmt.Sprint(i))); result != want {
+			t.Errorf("push %d: expected %v, got %v", i, want, result)
+		}
+	}
+
+	messages, dropped, closed := q.drain()
+	if dropped != 2 || closed {
+		t.Errorf("Expected 2 dropped and open, got %d dropped, closed=%v", dropped, closed)
+	}
+	if len(messages) != 3 || string(messages[0].data) != "3" || string(messages[2].data) != "5" {
+		t.Errorf("Expected messages 3 to 5, got %v", messages)
+	}
+}
+
+func TestSendQueue_CoalescesUnreadCounts(t *testing.T) {
+	q := newSendQueue(3)
+
+	q.push(TypeUnreadCount, []byte("1"))
+	q.push(TypeNotification, []byte("n"))
+	if result := q.push(TypeUnreadCount, []byte("2")); result != coalesced {
+		t.Errorf("Expected the unread count to be coalesced, got %v", result)
+	}
+
+	messages, _, _ := q.drain()
+	if len(messages) != 2 || string(messages[0].data) != "2" {
+		t.Errorf("Expected the latest unread count in place of the first, got %v", messages)
+	}
+}
+
+func TestSendQueue_Close(t *testing.T) {
+	q := newSendQueue(3)
+	q.close()
+	q.close()
+
+	if result := q.push(TypeNotification, []byte("late")); result != queueClosed {
+		t.Errorf("Expected push after close to be refused, got %v", result)
+	}
+	if _, _, closed := q.drain(); !closed {
+		t.Error("Expected drain to report the queue closed")
+	}
+}
+
+func TestHub_SlowClientDoesNotBlockOthers(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+
+	slow := connect(t, hub, 7)
+	fast := connect(t, hub, 8)
+
+	// The slow client never drains its queue
+	for i := 0; i < sendQueueLimit*2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		err := hub.SendNotification(ctx, 7, NotificationPayload{ID: i})
+		cancel()
+		if err != nil {
+			t.Fatalf("Publish %d blocked on a slow client: %v", i, err)
+		}
+	}
+
+	hub.SendNotification(context.Background(), 8, NotificationPayload{ID: 1000})
+	expectMessage(t, fast, `"id":1000`)
+
+	messages, dropped, _ := slow.queue.drain()
+	if len(messages) != sendQueueLimit || dropped != sendQueueLimit {
+		t.Errorf("Expected %d queued and %d dropped, got %d and %d", sendQueueLimit, sendQueueLimit, len(messages), dropped)
+	}
+	if stats := hub.Stats(); stats.Dropped != sendQueueLimit || stats.Connections != 2 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestHub_UnregisterTwice(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+
+	client := connect(t, hub, 7)
+	hub.unregister(client)
+	hub.unregister(client)
+
+	if hub.IsUserConnected(7) {
+		t.Error("Expected the user to be disconnected")
+	}
+	// Messages for a gone client are simply not delivered
+	if err := hub.SendNotification(context.Background(), 7, NotificationPayload{ID: 1}); err != nil {
+		t.Errorf("SendNotification failed: %v", err)
+	}
+}
+
+func TestHub_PublishHonoursContext(t *testing.T) {
+	// Without Run nothing drains the hub's subscription, so it fills up
+	hub := NewHub()
+
+	var err error
+	for i := 0; i <= 256 && err == nil; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		err = hub.SendNotification(ctx, 7, NotificationPayload{ID: i})
+		cancel()
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected a deadline error once the broadcaster is full, got %v", err)
+	}
+}
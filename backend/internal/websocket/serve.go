@@ -0,0 +1,123 @@
+package websocket
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/aung-arata/youtube-clone/backend/internal/auth"
+	"github.com/gorilla/websocket"
+)
+
+// authSubprotocol lets browsers, which can't set headers on a websocket
+// handshake, send their token as "Sec-WebSocket-Protocol: bearer, <token>"
+const authSubprotocol = "bearer"
+
+var (
+	allowedOrigins []string
+	originsMu      sync.RWMutex
+)
+
+// SetAllowedOrigins sets the origins browsers may open websockets from, e.g.
+// "https://example.com". "*" allows any origin. With no origins configured
+// only pages served from the same host may connect.
+func SetAllowedOrigins(origins []string) {
+	cleaned := []string{}
+	for _, origin := range origins {
+		origin = strings.TrimRight(strings.TrimSpace(origin), "/")
+		if origin != "" {
+			cleaned = append(cleaned, origin)
+		}
+	}
+
+	originsMu.Lock()
+	allowedOrigins = cleaned
+	originsMu.Unlock()
+}
+
+// checkOrigin guards against cross-site websocket hijacking
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		// Not a browser, so there's no ambient credential to abuse
+		return true
+	}
+
+	originsMu.RLock()
+	defer originsMu.RUnlock()
+
+	if len(allowedOrigins) == 0 {
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+	for _, allowed := range allowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenFromRequest finds the JWT in the subprotocol list, the Authorization
+// header or the token query parameter, in that order
+func tokenFromRequest(r *http.Request) string {
+	protocols := websocket.Subprotocols(r)
+	for i, p := range protocols {
+		if p == authSubprotocol && i+1 < len(protocols) {
+			return protocols[i+1]
+		}
+	}
+
+	if parts := strings.Split(r.Header.Get("Authorization"), " "); len(parts) == 2 && parts[0] == "Bearer" {
+		return parts[1]
+	}
+
+	return r.URL.Query().Get("token")
+}
+
+// newClientID returns a random identifier for a connection
+func newClientID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// ServeWS authenticates the request and upgrades it to a websocket that
+// receives the user's notifications
+func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request) {
+	token := tokenFromRequest(r)
+	if token == "" {
+		http.Error(w, "Authentication token required", http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := auth.ValidateToken(token)
+	if err == nil {
+		err = auth.CheckSession(r.Context(), claims)
+	}
+	if err != nil {
+		http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+		return
+	}
+
+	conn, err := Upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already written an error response
+		log.Printf("WebSocket upgrade failed: %v", err)
+		return
+	}
+
+	client := NewClient(h, conn, claims.UserID, newClientID())
+	h.register(client)
+
+	go client.WritePump()
+	client.reply(TypeHello, "", HelloPayload{ProtocolVersion: ProtocolVersion, ClientID: client.ClientID})
+	client.sendUnreadCount()
+	go client.ReadPump()
+}
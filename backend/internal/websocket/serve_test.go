@@ -0,0 +1,70 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		origin  string
+		want    bool
+	}{
+		{"no origin header", nil, "", true},
+		{"same host by default", nil, "http://example.com", true},
+		{"other host by default", nil, "http://evil.com", false},
+		{"listed origin", []string{"https://app.example.com/"}, "https://app.example.com", true},
+		{"unlisted origin", []string{"https://app.example.com"}, "http://example.com", false},
+		{"wildcard", []string{"*"}, "http://evil.com", true},
+	}
+
+	defer SetAllowedOrigins(nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetAllowedOrigins(tt.allowed)
+
+			req := httptest.NewRequest("GET", "http://example.com/api/ws", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if got := checkOrigin(req); got != tt.want {
+				t.Errorf("checkOrigin(%q) = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServeWS_RequiresToken(t *testing.T) {
+	hub := NewHub()
+
+	for _, url := range []string{"/api/ws", "/api/ws?token=not-a-jwt"} {
+		w := httptest.NewRecorder()
+		hub.ServeWS(w, httptest.NewRequest("GET", url, nil))
+
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: expected status 401, got %d", url, w.Code)
+		}
+	}
+}
+
+func TestServeWS_DeliversNotification(t *testing.T) {
+	hub, conn := dialTestHub(t, nil)
+
+	if err := hub.SendNotification(context.Background(), 7, NotificationPayload{ID: 1, UserID: 7, Type: "like", Title: "New like"}); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+
+	msg := conn.next(t)
+	var payload NotificationPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		t.Fatalf("Failed to decode payload: %v", err)
+	}
+	if msg.Type != TypeNotification || payload.ID != 1 {
+		t.Errorf("Unexpected message: %+v", msg)
+	}
+}
@@ -0,0 +1,89 @@
+package websocket
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
+)
+
+// Store is the hub's access to the notifications table
+type Store interface {
+	// NotificationsAfter returns up to limit of the user's notifications with
+	// an ID greater than afterID, oldest first, leaving out archived ones
+	NotificationsAfter(ctx context.Context, userID, afterID, limit int) ([]NotificationPayload, error)
+	// MarkRead marks the given notifications, or all of them if ids is nil,
+	// as read and returns how many changed
+	MarkRead(ctx context.Context, userID int, ids []int) (int64, error)
+	// MarkDelivered records that the user's client received the notifications
+	MarkDelivered(ctx context.Context, userID int, ids []int) error
+	UnreadCount(ctx context.Context, userID int) (int, error)
+}
+
+// SQLStore implements Store on the notifications table
+type SQLStore struct {
+	db *sql.DB
+}
+
+// NewSQLStore creates a Store backed by db
+func NewSQLStore(db *sql.DB) *SQLStore {
+	return &SQLStore{db: db}
+}
+
+func (s *SQLStore) NotificationsAfter(ctx context.Context, userID, afterID, limit int) ([]NotificationPayload, error) {
+	query := `
+		SELECT id, user_id, type, title, message, link, group_count, is_read, created_at
+		FROM notifications
+		WHERE user_id = $1 AND id > $2 AND archived_at IS NULL
+		ORDER BY id
+		LIMIT $3
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, userID, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []NotificationPayload{}
+	for rows.Next() {
+		var n NotificationPayload
+		var link sql.NullString
+		if err := rows.Scan(&n.ID, &n.UserID, &n.Type, &n.Title, &n.Message, &link, &n.GroupCount, &n.IsRead, &n.CreatedAt); err != nil {
+			return nil, err
+		}
+		n.Link = link.String
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+func (s *SQLStore) MarkRead(ctx context.Context, userID int, ids []int) (int64, error) {
+	query := `UPDATE notifications SET is_read = TRUE WHERE user_id = $1 AND is_read = FALSE`
+	args := []interface{}{userID}
+	if ids != nil {
+		query += " AND id = ANY($2)"
+		args = append(args, pq.Array(ids))
+	}
+
+	result, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (s *SQLStore) MarkDelivered(ctx context.Context, userID int, ids []int) error {
+	query := `
+		UPDATE notifications SET delivered_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND id = ANY($2) AND delivered_at IS NULL
+	`
+	_, err := s.db.ExecContext(ctx, query, userID, pq.Array(ids))
+	return err
+}
+
+func (s *SQLStore) UnreadCount(ctx context.Context, userID int) (int, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND is_read = FALSE`, userID).Scan(&count)
+	return count, err
+}
@@ -19,3 +19,17 @@ INSERT INTO videos (title, description, url, thumbnail, channel_name, channel_av
 ('Git and GitHub Workflow Best Practices', 'Master version control with Git and GitHub. Learn about branching strategies, pull requests, code reviews, and CI/CD integration.', 'https://example.com/video9.mp4', 'https://via.placeholder.com/320x180/F05032/FFFFFF?text=Git+GitHub', 'Code Versioning', 'https://via.placeholder.com/36/FC8181/FFFFFF?text=CV', 98500, '16:20', NOW() - INTERVAL '1 week'),
 
 ('REST API Design Principles and Best Practices', 'Learn how to design clean, maintainable REST APIs. Covers HTTP methods, status codes, versioning, and documentation.', 'https://example.com/video10.mp4', 'https://via.placeholder.com/320x180/10B981/FFFFFF?text=REST+API', 'API Architect', 'https://via.placeholder.com/36/68D391/FFFFFF?text=AA', 73200, '19:15', NOW() - INTERVAL '3 days');
+
+-- Give each sample channel name its own channel and link the videos to it
+INSERT INTO channels (handle, display_name, avatar)
+SELECT DISTINCT ON (channel_name)
+    trim(both '-' from regexp_replace(lower(channel_name), '[^a-z0-9]+', '-', 'g')),
+    channel_name,
+    COALESCE(channel_avatar, '')
+FROM videos
+WHERE channel_id IS NULL
+ON CONFLICT (handle) DO NOTHING;
+
+UPDATE videos v SET channel_id = c.id
+FROM channels c
+WHERE v.channel_id IS NULL AND c.user_id IS NULL AND c.display_name = v.channel_name;
@@ -4,7 +4,10 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
 	"os"
 	"strings"
 
@@ -29,26 +32,41 @@ func main() {
 
 	// Auth routes - proxy to user-service (public routes)
 	api.PathPrefix("/auth").HandlerFunc(proxyToService(userServiceURL, "/auth"))
+	r.Handle("/.well-known/jwks.json", proxyToService(userServiceURL, "/.well-known/jwks.json"))
+
+	// Comment routes - proxy to comment-service. Registered before the video
+	// and channel routes, whose prefixes would otherwise swallow them.
+	api.PathPrefix("/comments").HandlerFunc(proxyToService(commentServiceURL, "/comments"))
+	api.PathPrefix("/videos/{videoId}/comments").HandlerFunc(proxyToService(commentServiceURL, "/videos"))
+	api.PathPrefix("/channels/{id}/comments").HandlerFunc(proxyToService(commentServiceURL, "/channels"))
 
 	// Video routes - proxy to video-service
 	api.PathPrefix("/videos").HandlerFunc(proxyToService(videoServiceURL, "/videos"))
 	api.PathPrefix("/playlists").HandlerFunc(proxyToService(videoServiceURL, "/playlists"))
+	api.PathPrefix("/channels").HandlerFunc(proxyToService(videoServiceURL, "/channels"))
+	api.PathPrefix("/channel-invitations").HandlerFunc(proxyToService(videoServiceURL, "/channel-invitations"))
 
 	// User routes - proxy to user-service
 	api.PathPrefix("/users/{id}/history").HandlerFunc(proxyToService(historyServiceURL, "/users"))
 	api.PathPrefix("/users/{id}/subscriptions").HandlerFunc(proxyToService(userServiceURL, "/users"))
 	api.PathPrefix("/users/{id}/playlists").HandlerFunc(proxyToService(videoServiceURL, "/users"))
+	api.PathPrefix("/users/{id}/channels").HandlerFunc(proxyToService(videoServiceURL, "/users"))
 	api.PathPrefix("/users/{id}/plan").HandlerFunc(proxyToService(userServiceURL, "/users"))
+	api.PathPrefix("/users/{id}/mutes").HandlerFunc(proxyToService(notificationServiceURL, "/users"))
+	api.PathPrefix("/users/{id}/notification-preferences").HandlerFunc(proxyToService(notificationServiceURL, "/users"))
+	api.PathPrefix("/users/{id}/push-subscriptions").HandlerFunc(proxyToService(notificationServiceURL, "/users"))
 	api.PathPrefix("/users").HandlerFunc(proxyToService(userServiceURL, "/users"))
 	api.PathPrefix("/plans").HandlerFunc(proxyToService(userServiceURL, "/plans"))
-
-	// Comment routes - proxy to comment-service
-	api.PathPrefix("/comments").HandlerFunc(proxyToService(commentServiceURL, "/comments"))
-	api.PathPrefix("/videos/{videoId}/comments").HandlerFunc(proxyToService(commentServiceURL, "/videos"))
+	api.PathPrefix("/admin/roles").HandlerFunc(proxyToService(userServiceURL, "/admin"))
+	api.PathPrefix("/admin/users").HandlerFunc(proxyToService(userServiceURL, "/admin"))
+	api.PathPrefix("/admin/comments").HandlerFunc(proxyToService(commentServiceURL, "/admin"))
+	api.PathPrefix("/admin/comment-filter").HandlerFunc(proxyToService(commentServiceURL, "/admin"))
 
 	// Notification routes - proxy to notification-service
 	api.PathPrefix("/users/{userId}/notifications").HandlerFunc(proxyToService(notificationServiceURL, "/users"))
 	api.PathPrefix("/notifications").HandlerFunc(proxyToService(notificationServiceURL, "/notifications"))
+	api.PathPrefix("/push").HandlerFunc(proxyToService(notificationServiceURL, "/push"))
+	api.Handle("/ws", proxyWebSocket(notificationServiceURL, "/ws"))
 
 	// API Documentation routes (Swagger/OpenAPI)
 	api.HandleFunc("/docs", docs.SwaggerUIHandler).Methods("GET")
@@ -109,6 +127,14 @@ func proxyToService(serviceURL, pathPrefix string) http.HandlerFunc {
 			}
 		}
 
+		// Tell the service where the request came from
+		if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			if prior := strings.Join(proxyReq.Header.Values("X-Forwarded-For"), ", "); prior != "" {
+				ip = prior + ", " + ip
+			}
+			proxyReq.Header.Set("X-Forwarded-For", ip)
+		}
+
 		// Send request to target service
 		client := &http.Client{}
 		resp, err := client.Do(proxyReq)
@@ -134,6 +160,25 @@ func proxyToService(serviceURL, pathPrefix string) http.HandlerFunc {
 	}
 }
 
+// proxyWebSocket relays websocket connections, which proxyToService can't
+// upgrade, to the given path on the service
+func proxyWebSocket(serviceURL, path string) http.Handler {
+	target, err := url.Parse(serviceURL)
+	if err != nil {
+		log.Fatalf("Invalid service URL %q: %v", serviceURL, err)
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	director := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		director(r)
+		r.URL.Path = path
+		r.URL.RawPath = ""
+		r.Host = target.Host
+	}
+	return proxy
+}
+
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
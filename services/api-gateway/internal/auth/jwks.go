@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// jwksCacheTTL is how long fetched keys are used without checking for
+	// new ones
+	jwksCacheTTL = 10 * time.Minute
+
+	// jwksMinRefresh limits how often tokens with an unknown kid, or an
+	// unreachable signer, cause the key set to be fetched again
+	jwksMinRefresh = 30 * time.Second
+
+	// jwksFetchTimeout bounds fetching the key set
+	jwksFetchTimeout = 5 * time.Second
+)
+
+// JWKSFetcher verifies tokens against the key set another service publishes.
+// Keys are cached, and a token signed with a key that isn't cached triggers
+// a fetch, so that keys can be rotated without restarting verifiers.
+type JWKSFetcher struct {
+	url    string
+	client *http.Client
+	now    func() time.Time
+
+	mu          sync.Mutex
+	keys        localKeys
+	fetchedAt   time.Time
+	attemptedAt time.Time
+	// refreshing is closed when the fetch in flight finishes, nil otherwise
+	refreshing chan struct{}
+}
+
+// NewJWKSFetcher creates a fetcher for the key set at url
+func NewJWKSFetcher(url string) *JWKSFetcher {
+	return &JWKSFetcher{
+		url:    url,
+		client: &http.Client{Timeout: jwksFetchTimeout},
+		now:    time.Now,
+		keys:   localKeys{},
+	}
+}
+
+// publicKey looks kid up, fetching the key set if needed. The fetch runs
+// without holding the lock, so that tokens signed with known keys are still
+// verified while it is in flight; callers after an unknown kid wait for it.
+func (f *JWKSFetcher) publicKey(kid string) (crypto.PublicKey, error) {
+	f.mu.Lock()
+
+	now := f.now()
+	key, ok := f.keys[kid]
+	if ok && now.Sub(f.fetchedAt) < jwksCacheTTL {
+		f.mu.Unlock()
+		return key, nil
+	}
+	if done := f.refreshing; done != nil {
+		f.mu.Unlock()
+		if ok {
+			return key, nil
+		}
+		<-done
+		return f.cachedKey(kid)
+	}
+	if now.Sub(f.attemptedAt) < jwksMinRefresh {
+		f.mu.Unlock()
+		if ok {
+			return key, nil
+		}
+		return nil, ErrUnknownKey
+	}
+
+	f.attemptedAt = now
+	done := make(chan struct{})
+	f.refreshing = done
+	f.mu.Unlock()
+
+	keys, err := f.fetch()
+
+	f.mu.Lock()
+	if err == nil {
+		f.keys = keys
+		f.fetchedAt = now
+	}
+	f.refreshing = nil
+	close(done)
+	f.mu.Unlock()
+
+	if err != nil {
+		if ok {
+			// Keep accepting known keys while the signer is unreachable
+			log.Printf("Failed to refresh JWKS from %s: %v", f.url, err)
+			return key, nil
+		}
+		return nil, err
+	}
+	return f.cachedKey(kid)
+}
+
+func (f *JWKSFetcher) cachedKey(kid string) (crypto.PublicKey, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if key, ok := f.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+func (f *JWKSFetcher) fetch() (localKeys, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), jwksFetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS request returned %s", resp.Status)
+	}
+
+	var set JWKS
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %w", err)
+	}
+
+	keys := localKeys{}
+	for _, jwk := range set.Keys {
+		if jwk.Kid == "" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		pub, err := jwk.PublicKey()
+		if err != nil {
+			log.Printf("Skipping JWKS key %s: %v", jwk.Kid, err)
+			continue
+		}
+		keys[jwk.Kid] = pub
+	}
+	return keys, nil
+}
@@ -6,8 +6,11 @@ import (
 	"net/http"
 	"os"
 
+	"github.com/aung-arata/youtube-clone/services/comment-service/internal/auth"
 	"github.com/aung-arata/youtube-clone/services/comment-service/internal/database"
 	"github.com/aung-arata/youtube-clone/services/comment-service/internal/handlers"
+	"github.com/aung-arata/youtube-clone/services/comment-service/internal/middleware"
+	"github.com/aung-arata/youtube-clone/services/comment-service/internal/moderation"
 	"github.com/gorilla/mux"
 )
 
@@ -19,17 +22,54 @@ func main() {
 	}
 	defer db.Close()
 
+	// Services present SERVICE_TOKEN to each other's internal endpoints
+	auth.UseServiceToken(os.Getenv("SERVICE_TOKEN"))
+
+	// Reject access tokens whose session was revoked on the user service
+	if url := os.Getenv("SESSION_CHECK_URL"); url != "" {
+		auth.UseSessionChecker(auth.NewSessionCache(auth.NewRemoteSessionChecker(url), auth.SessionCheckTTL))
+	}
+
+	// Accept API keys on routes that name a scope, checking them with the
+	// user service
+	if url := os.Getenv("API_KEY_CHECK_URL"); url != "" {
+		auth.UseAPIKeyVerifier(auth.NewRemoteAPIKeyVerifier(url))
+	}
+
 	// Create router
 	r := mux.NewRouter()
 
 	// Comment routes
-	commentHandler := handlers.NewCommentHandler(db)
+	// An external spam/toxicity classifier, if configured, runs after the built-in rules
+	var classifiers []moderation.Classifier
+	if url := os.Getenv("COMMENT_CLASSIFIER_URL"); url != "" {
+		classifiers = append(classifiers, moderation.NewHTTPClassifier("external", url))
+	}
+	commentHandler := handlers.NewCommentHandler(db, classifiers...)
 	r.HandleFunc("/videos/{videoId}/comments", commentHandler.GetComments).Methods("GET")
-	r.HandleFunc("/videos/{videoId}/comments", commentHandler.CreateComment).Methods("POST")
+	r.Handle("/videos/{videoId}/comments", middleware.RequireScope(auth.ScopeCommentsWrite, middleware.Protect(auth.PermInteract, http.HandlerFunc(commentHandler.CreateComment)))).Methods("POST")
 	r.HandleFunc("/comments/{id}", commentHandler.GetComment).Methods("GET")
-	r.HandleFunc("/comments/{id}", commentHandler.UpdateComment).Methods("PUT")
-	r.HandleFunc("/comments/{id}", commentHandler.DeleteComment).Methods("DELETE")
+	r.HandleFunc("/comments/{id}/replies", commentHandler.GetReplies).Methods("GET")
+	r.Handle("/channels/{id:[0-9]+}/comments/held", middleware.AuthMiddleware(http.HandlerFunc(commentHandler.GetHeldComments))).Methods("GET")
+
+	// Protected comment routes
+	protected := r.PathPrefix("/comments").Subrouter()
+	protected.Use(middleware.AuthMiddleware)
+	protected.Handle("/{id}", middleware.RequireScope(auth.ScopeCommentsWrite, middleware.RequirePermission(auth.PermInteract, http.HandlerFunc(commentHandler.UpdateComment)))).Methods("PUT")
+	protected.Handle("/{id}", middleware.RequireScope(auth.ScopeCommentsWrite, middleware.RequirePermission(auth.PermInteract, http.HandlerFunc(commentHandler.DeleteComment)))).Methods("DELETE")
+	protected.Handle("/{id}/like", middleware.RequireScope(auth.ScopeCommentsWrite, middleware.RequirePermission(auth.PermInteract, http.HandlerFunc(commentHandler.LikeComment)))).Methods("POST")
+	protected.Handle("/{id}/like", middleware.RequireScope(auth.ScopeCommentsWrite, middleware.RequirePermission(auth.PermInteract, http.HandlerFunc(commentHandler.UnlikeComment)))).Methods("DELETE")
+	protected.Handle("/{id}/moderation", middleware.RequireScope(auth.ScopeCommentsWrite, middleware.RequirePermission(auth.PermManageChannels, http.HandlerFunc(commentHandler.ModerateComment)))).Methods("PUT")
 	
+	// Spam filter review queue and settings (protected, each needs a staff permission)
+	moderationHandler := handlers.NewModerationHandler(db, commentHandler)
+	admin := r.PathPrefix("/admin").Subrouter()
+	admin.Use(middleware.AuthMiddleware)
+	admin.Handle("/comments/review", middleware.RequirePermission(auth.PermModerateComments, http.HandlerFunc(moderationHandler.GetReviewQueue))).Methods("GET")
+	admin.Handle("/comments/{id:[0-9]+}/review", middleware.RequirePermission(auth.PermModerateComments, http.HandlerFunc(moderationHandler.ReviewComment))).Methods("PUT")
+	admin.Handle("/comment-filter", middleware.RequirePermission(auth.PermManageSettings, http.HandlerFunc(moderationHandler.GetFilterSettings))).Methods("GET")
+	admin.Handle("/comment-filter", middleware.RequirePermission(auth.PermManageSettings, http.HandlerFunc(moderationHandler.UpdateFilterSettings))).Methods("PUT")
+
 	// Health check
 	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
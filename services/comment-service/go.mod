@@ -6,3 +6,5 @@ require (
 	github.com/gorilla/mux v1.8.1
 	github.com/lib/pq v1.10.9
 )
+
+require github.com/golang-jwt/jwt/v5 v5.3.0
@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// AccessTokenTTL is how long an access token is valid. Clients keep a
+// session going with refresh tokens, which can be revoked.
+const AccessTokenTTL = 15 * time.Minute
+
+// clockSkew is how far the clocks of the signer and verifiers may drift
+const clockSkew = 30 * time.Second
+
+var (
+	// issuer and audience are checked on every token
+	issuer   = envOr("JWT_ISSUER", "youtube-clone")
+	audience = envOr("JWT_AUDIENCE", "youtube-clone")
+
+	// ErrNoSigningKey is returned by GenerateToken on services that only
+	// verify tokens
+	ErrNoSigningKey = errors.New("no JWT signing key configured")
+)
+
+// Claims represents the JWT claims
+type Claims struct {
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	// SessionID names the login the token was issued for
+	SessionID string `json:"sid,omitempty"`
+	// MFA is set when the login passed two-factor authentication
+	MFA bool `json:"mfa,omitempty"`
+	// EmailVerified is set once the user confirmed their email address
+	EmailVerified bool `json:"email_verified,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// TokenSubject is the user and login an access token is issued for
+type TokenSubject struct {
+	UserID        int
+	Username      string
+	Role          string
+	EmailVerified bool
+	SessionID     string
+	// MFA records whether the login passed two-factor authentication
+	MFA bool
+}
+
+func envOr(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// GenerateToken generates a new JWT token for a user's session
+func GenerateToken(sub TokenSubject) (string, error) {
+	if keys.signer == nil {
+		return "", ErrNoSigningKey
+	}
+
+	now := time.Now()
+	claims := &Claims{
+		UserID:        sub.UserID,
+		Username:      sub.Username,
+		Role:          sub.Role,
+		SessionID:     sub.SessionID,
+		MFA:           sub.MFA,
+		EmailVerified: sub.EmailVerified,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuer,
+			Audience:  jwt.ClaimStrings{audience},
+			ExpiresAt: jwt.NewNumericDate(now.Add(AccessTokenTTL)),
+			NotBefore: jwt.NewNumericDate(now),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+
+	token := jwt.NewWithClaims(keys.signer.method, claims)
+	token.Header["kid"] = keys.signer.id
+	return token.SignedString(keys.signer.key)
+}
+
+// ValidateToken validates a JWT token and returns the claims. The token must
+// be signed by a published key and carry our issuer, audience, expiry and
+// not-before time.
+func ValidateToken(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			return nil, errors.New("token has no key ID")
+		}
+		pub, err := keys.verifier.publicKey(kid)
+		if err != nil {
+			return nil, err
+		}
+
+		// Each key is used with one algorithm only
+		method, err := signingMethod(pub)
+		if err != nil {
+			return nil, err
+		}
+		if token.Method.Alg() != method.Alg() {
+			return nil, errors.New("invalid signing method")
+		}
+		return pub, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}),
+		jwt.WithIssuer(issuer),
+		jwt.WithAudience(audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(clockSkew),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	// nbf is only checked when present, so require it
+	if claims.NotBefore == nil {
+		return nil, errors.New("token has no not-before time")
+	}
+
+	return claims, nil
+}
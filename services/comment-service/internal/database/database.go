@@ -68,6 +68,59 @@ func runMigrations(db *sql.DB) error {
 
 	CREATE INDEX IF NOT EXISTS idx_comments_video_id ON comments (video_id);
 	CREATE INDEX IF NOT EXISTS idx_comments_user_id ON comments (user_id);
+
+	-- Threaded replies and per-comment likes
+	ALTER TABLE comments ADD COLUMN IF NOT EXISTS parent_id INTEGER REFERENCES comments(id) ON DELETE CASCADE;
+	ALTER TABLE comments ADD COLUMN IF NOT EXISTS depth SMALLINT NOT NULL DEFAULT 0;
+	ALTER TABLE comments ADD COLUMN IF NOT EXISTS likes INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE comments ADD COLUMN IF NOT EXISTS reply_count INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE comments ADD COLUMN IF NOT EXISTS author_username VARCHAR(50) NOT NULL DEFAULT '';
+
+	CREATE TABLE IF NOT EXISTS comment_likes (
+		comment_id INTEGER REFERENCES comments(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (comment_id, user_id)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_comments_video_top ON comments (video_id, likes DESC, id DESC) WHERE parent_id IS NULL;
+	CREATE INDEX IF NOT EXISTS idx_comments_video_newest ON comments (video_id, created_at DESC, id DESC) WHERE parent_id IS NULL;
+	CREATE INDEX IF NOT EXISTS idx_comments_parent ON comments (parent_id, created_at, id);
+
+	-- Creator moderation: pinned and hearted comments, and comments held for
+	-- review because they contain one of the channel's blocked words
+	ALTER TABLE comments ADD COLUMN IF NOT EXISTS channel_id INTEGER;
+	ALTER TABLE comments ADD COLUMN IF NOT EXISTS is_pinned BOOLEAN NOT NULL DEFAULT FALSE;
+	ALTER TABLE comments ADD COLUMN IF NOT EXISTS is_hearted BOOLEAN NOT NULL DEFAULT FALSE;
+	ALTER TABLE comments ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'published'
+		CHECK (status IN ('published', 'held', 'hidden'));
+
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_comments_one_pinned ON comments (video_id) WHERE is_pinned;
+	CREATE INDEX IF NOT EXISTS idx_comments_held ON comments (channel_id, created_at DESC, id DESC) WHERE status = 'held';
+
+	-- Spam filter: verdicts per comment and admin-tuned settings
+	ALTER TABLE comments DROP CONSTRAINT IF EXISTS comments_status_check;
+	ALTER TABLE comments ADD CONSTRAINT comments_status_check
+		CHECK (status IN ('published', 'held', 'hidden', 'rejected'));
+
+	CREATE TABLE IF NOT EXISTS comment_verdicts (
+		comment_id INTEGER PRIMARY KEY REFERENCES comments(id) ON DELETE CASCADE,
+		score DOUBLE PRECISION NOT NULL,
+		decision VARCHAR(10) NOT NULL CHECK (decision IN ('publish', 'hold', 'reject')),
+		signals JSONB NOT NULL DEFAULT '[]',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS comment_filter_settings (
+		id INTEGER PRIMARY KEY DEFAULT 1 CHECK (id = 1),
+		hold_threshold DOUBLE PRECISION NOT NULL DEFAULT 0.6,
+		reject_threshold DOUBLE PRECISION NOT NULL DEFAULT 0.9,
+		blocked_terms JSONB NOT NULL DEFAULT '[]',
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_comments_review ON comments (status, created_at DESC, id DESC) WHERE status IN ('held', 'rejected');
+	CREATE INDEX IF NOT EXISTS idx_comments_user_recent ON comments (user_id, created_at DESC);
 	`
 
 	_, err := db.Exec(query)
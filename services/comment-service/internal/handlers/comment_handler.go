@@ -1,68 +1,538 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"net/url"
+	"os"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/aung-arata/youtube-clone/services/comment-service/internal/auth"
+	"github.com/aung-arata/youtube-clone/services/comment-service/internal/middleware"
 	"github.com/aung-arata/youtube-clone/services/comment-service/internal/models"
+	"github.com/aung-arata/youtube-clone/services/comment-service/internal/moderation"
 	"github.com/gorilla/mux"
 )
 
+const commentColumns = `id, video_id, channel_id, user_id, author_username, parent_id, depth, content, likes, reply_count,
+	is_pinned, is_hearted, status, created_at, updated_at`
+
+// maxCommentDepth is how far replies may nest below a top-level comment
+const maxCommentDepth = 2
+
+// maxUserLookup is how many users the user service resolves per request
+const maxUserLookup = 50
+
+// Sort modes accepted by GetComments
+const (
+	commentSortTop    = "top"
+	commentSortNewest = "newest"
+)
+
+var (
+	errCommentNotFound = errors.New("comment not found")
+	errVideoNotFound   = errors.New("video not found")
+	errChannelNotFound = errors.New("channel not found")
+)
+
+// CommentHandler serves comments. Videos, channels and their teams live in
+// the video service and users in the user service, which this handler asks
+// about blocked words, who may moderate and authors' avatars.
 type CommentHandler struct {
-	db *sql.DB
+	db                     *sql.DB
+	filter                 *moderation.Pipeline
+	httpClient             *http.Client
+	videoServiceURL        string
+	userServiceURL         string
+	notificationServiceURL string
 }
 
-func NewCommentHandler(db *sql.DB) *CommentHandler {
-	return &CommentHandler{db: db}
+// NewCommentHandler creates a comment handler whose spam filter runs the
+// built-in rules followed by any extra classifiers
+func NewCommentHandler(db *sql.DB, classifiers ...moderation.Classifier) *CommentHandler {
+	videoServiceURL := os.Getenv("VIDEO_SERVICE_URL")
+	if videoServiceURL == "" {
+		videoServiceURL = "http://video-service:8081" // default for docker-compose
+	}
+
+	userServiceURL := os.Getenv("USER_SERVICE_URL")
+	if userServiceURL == "" {
+		userServiceURL = "http://user-service:8082" // default for docker-compose
+	}
+
+	notificationServiceURL := os.Getenv("NOTIFICATION_SERVICE_URL")
+	if notificationServiceURL == "" {
+		notificationServiceURL = "http://notification-service:8086" // default for docker-compose
+	}
+
+	return &CommentHandler{
+		db:                     db,
+		filter:                 moderation.NewPipeline(append(moderation.DefaultRules(), classifiers...)...),
+		videoServiceURL:        videoServiceURL,
+		userServiceURL:         userServiceURL,
+		notificationServiceURL: notificationServiceURL,
+		httpClient: &http.Client{
+			Timeout: 5 * time.Second, // 5 second timeout for video, user and notification service calls
+		},
+	}
 }
 
-// GetComments returns all comments for a specific video
-func (h *CommentHandler) GetComments(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	videoID, err := strconv.Atoi(vars["videoId"])
+// commentEvent is what the notification service needs to notify the parent
+// comment's author and any @mentioned users
+type commentEvent struct {
+	CommentID      int    `json:"comment_id"`
+	VideoID        int    `json:"video_id"`
+	AuthorID       int    `json:"author_id"`
+	AuthorUsername string `json:"author_username"`
+	ParentID       *int   `json:"parent_id,omitempty"`
+	ParentAuthorID *int   `json:"parent_author_id,omitempty"`
+	Content        string `json:"content"`
+}
+
+// publishCommentEvent hands a new comment to the notification service
+func (h *CommentHandler) publishCommentEvent(c models.Comment, parentAuthorID *int) error {
+	body, err := json.Marshal(commentEvent{
+		CommentID:      c.ID,
+		VideoID:        c.VideoID,
+		AuthorID:       c.UserID,
+		AuthorUsername: c.AuthorUsername,
+		ParentID:       c.ParentID,
+		ParentAuthorID: parentAuthorID,
+		Content:        c.Content,
+	})
 	if err != nil {
-		http.Error(w, "Invalid video ID", http.StatusBadRequest)
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, h.notificationServiceURL+"/events/comments", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	auth.SetServiceToken(req)
+
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("notification service returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// publishInBackground hands a newly published comment to the notification
+// service. Notifications are best effort and must not slow down or fail the
+// request.
+func (h *CommentHandler) publishInBackground(c models.Comment) {
+	go func() {
+		var parentAuthorID *int
+		if c.ParentID != nil {
+			var id int
+			if err := h.db.QueryRow(`SELECT user_id FROM comments WHERE id = $1`, *c.ParentID).Scan(&id); err == nil {
+				parentAuthorID = &id
+			} else if err != sql.ErrNoRows {
+				log.Printf("Failed to look up the parent of comment %d: %v", c.ID, err)
+			}
+		}
+
+		if err := h.publishCommentEvent(c, parentAuthorID); err != nil {
+			log.Printf("Failed to publish comment %d to the notification service: %v", c.ID, err)
+		}
+	}()
+}
+
+// commentPolicy is what the video service says about the channel a comment
+// is on: its blocked words, and whether the user asked about is on the team
+// that moderates its comments
+type commentPolicy struct {
+	ChannelID    *int     `json:"channel_id"`
+	BlockedWords []string `json:"blocked_words"`
+	CanModerate  bool     `json:"can_moderate"`
+}
+
+// videoCommentPolicy returns the comment policy of a video's channel
+func (h *CommentHandler) videoCommentPolicy(videoID, userID int) (commentPolicy, error) {
+	return h.fetchCommentPolicy("/internal/videos/"+strconv.Itoa(videoID)+"/comment-policy", userID, errVideoNotFound)
+}
+
+// channelCommentPolicy returns the comment policy of a channel
+func (h *CommentHandler) channelCommentPolicy(channelID, userID int) (commentPolicy, error) {
+	return h.fetchCommentPolicy("/internal/channels/"+strconv.Itoa(channelID)+"/comment-policy", userID, errChannelNotFound)
+}
+
+func (h *CommentHandler) fetchCommentPolicy(path string, userID int, notFound error) (commentPolicy, error) {
+	var policy commentPolicy
+
+	req, err := http.NewRequest(http.MethodGet, h.videoServiceURL+path+"?user_id="+strconv.Itoa(userID), nil)
+	if err != nil {
+		return policy, err
+	}
+	auth.SetServiceToken(req)
+
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return policy, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return policy, notFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return policy, fmt.Errorf("video service returned %d", resp.StatusCode)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&policy)
+	return policy, err
+}
+
+// writePolicyError maps the errors of videoCommentPolicy and
+// channelCommentPolicy to HTTP responses
+func writePolicyError(w http.ResponseWriter, err error) {
+	switch err {
+	case errVideoNotFound:
+		http.Error(w, "Video not found", http.StatusNotFound)
+	case errChannelNotFound:
+		http.Error(w, "Channel not found", http.StatusNotFound)
+	default:
+		http.Error(w, "Error checking with the video service: "+err.Error(), http.StatusBadGateway)
+	}
+}
+
+// userSummary is a user as the user service's lookup returns them
+type userSummary struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+	Avatar   string `json:"avatar"`
+}
+
+// lookupUsers resolves user IDs to users through the user service. Users
+// that no longer exist are left out.
+func (h *CommentHandler) lookupUsers(ids []int) (map[int]userSummary, error) {
+	users := make(map[int]userSummary, len(ids))
+	for start := 0; start < len(ids); start += maxUserLookup {
+		end := start + maxUserLookup
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		query := url.Values{}
+		for _, id := range ids[start:end] {
+			query.Add("id", strconv.Itoa(id))
+		}
+
+		resp, err := h.httpClient.Get(h.userServiceURL + "/users/lookup?" + query.Encode())
+		if err != nil {
+			return nil, err
+		}
+
+		var found []userSummary
+		if resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("user service returned %d", resp.StatusCode)
+		} else {
+			err = json.NewDecoder(resp.Body).Decode(&found)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, u := range found {
+			users[u.ID] = u
+		}
+	}
+	return users, nil
+}
+
+// attachAuthors fills in the current username and avatar of each comment's
+// author. This is best effort: if the user service can't be reached, comments
+// keep the username they were posted under and go without an avatar.
+func (h *CommentHandler) attachAuthors(comments []models.Comment) {
+	seen := make(map[int]bool)
+	var ids []int
+	for _, c := range comments {
+		if !seen[c.UserID] {
+			seen[c.UserID] = true
+			ids = append(ids, c.UserID)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	users, err := h.lookupUsers(ids)
+	if err != nil {
+		log.Printf("Failed to look up comment authors: %v", err)
 		return
 	}
 
+	for i := range comments {
+		if u, ok := users[comments[i].UserID]; ok {
+			comments[i].AuthorUsername = u.Username
+			comments[i].AuthorAvatar = u.Avatar
+		}
+	}
+}
+
+// withAuthor is attachAuthors for a single comment
+func (h *CommentHandler) withAuthor(c models.Comment) models.Comment {
+	comments := []models.Comment{c}
+	h.attachAuthors(comments)
+	return comments[0]
+}
+
+// accountCreatedAt asks the user service when a user signed up
+func (h *CommentHandler) accountCreatedAt(ctx context.Context, userID int) (time.Time, error) {
+	var user struct {
+		CreatedAt time.Time `json:"created_at"`
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.userServiceURL+"/users/"+strconv.Itoa(userID), nil)
+	if err != nil {
+		return user.CreatedAt, err
+	}
+
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return user.CreatedAt, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return user.CreatedAt, fmt.Errorf("user service returned %d", resp.StatusCode)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&user)
+	return user.CreatedAt, err
+}
+
+// classifyComment runs a new comment through the spam filter using the
+// admin-tuned settings and the author's recent activity. If the user service
+// can't say how old the author's account is, it's treated as a new account.
+func (h *CommentHandler) classifyComment(ctx context.Context, userID int, content string) (moderation.Verdict, error) {
+	settings, err := loadCommentFilterSettings(h.db)
+	if err != nil {
+		return moderation.Verdict{}, err
+	}
+
 	query := `
-		SELECT id, video_id, user_id, content, created_at, updated_at
+		SELECT COUNT(*) FILTER (WHERE created_at > $3),
+		       COUNT(*) FILTER (WHERE content = $2)
 		FROM comments
-		WHERE video_id = $1
-		ORDER BY created_at DESC
+		WHERE user_id = $1 AND created_at > $4
 	`
 
-	rows, err := h.db.Query(query, videoID)
+	in := moderation.Input{Content: content, AuthorID: userID, BlockedTerms: settings.BlockedTerms}
+	now := time.Now()
+	err = h.db.QueryRow(query, userID, content, now.Add(-moderation.VelocityWindow), now.Add(-moderation.DuplicateWindow)).
+		Scan(&in.RecentComments, &in.RecentDuplicates)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return moderation.Verdict{}, err
+	}
+
+	if createdAt, err := h.accountCreatedAt(ctx, userID); err == nil {
+		in.AccountAge = now.Sub(createdAt)
+	} else {
+		log.Printf("Failed to look up the account age of user %d: %v", userID, err)
+	}
+
+	thresholds := moderation.Thresholds{Hold: settings.HoldThreshold, Reject: settings.RejectThreshold}
+	return h.filter.Classify(ctx, in, thresholds), nil
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanComment(row rowScanner) (models.Comment, error) {
+	var c models.Comment
+	err := row.Scan(&c.ID, &c.VideoID, &c.ChannelID, &c.UserID, &c.AuthorUsername, &c.ParentID, &c.Depth, &c.Content,
+		&c.Likes, &c.ReplyCount, &c.IsPinned, &c.IsHearted, &c.Status, &c.CreatedAt, &c.UpdatedAt)
+	return c, err
+}
+
+// commentAccess is what's needed to decide who may act on a comment
+type commentAccess struct {
+	AuthorID int
+	VideoID  int
+	ParentID *int
+	Status   string
+}
+
+func (h *CommentHandler) loadCommentAccess(id int) (commentAccess, error) {
+	var a commentAccess
+	err := h.db.QueryRow(`SELECT user_id, video_id, parent_id, status FROM comments WHERE id = $1`, id).
+		Scan(&a.AuthorID, &a.VideoID, &a.ParentID, &a.Status)
+	if err == sql.ErrNoRows {
+		return a, errCommentNotFound
+	}
+	return a, err
+}
+
+// canModerate reports whether userID is on the team of the comment's video's
+// channel with a role that moderates comments. Comments on deleted videos
+// have no team.
+func (h *CommentHandler) canModerate(a commentAccess, userID int) (bool, error) {
+	policy, err := h.videoCommentPolicy(a.VideoID, userID)
+	if err == errVideoNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return policy.CanModerate, nil
+}
+
+// setCommentStatus moves a comment between moderation states, keeping the
+// parent's reply count in step with its published replies
+func setCommentStatus(tx *sql.Tx, id int, parentID *int, from, to string) error {
+	if _, err := tx.Exec(`UPDATE comments SET status = $1 WHERE id = $2`, to, id); err != nil {
+		return err
+	}
+	if parentID == nil || from == to {
+		return nil
+	}
+
+	delta := 0
+	if to == models.CommentStatusPublished {
+		delta = 1
+	} else if from == models.CommentStatusPublished {
+		delta = -1
+	}
+	if delta == 0 {
+		return nil
+	}
+
+	_, err := tx.Exec(`UPDATE comments SET reply_count = GREATEST(reply_count + $1, 0) WHERE id = $2`, delta, *parentID)
+	return err
+}
+
+// queryCommentPage runs a comment listing query and trims the extra row used
+// to detect another page. cursorFor builds the cursor from the page's last row.
+func (h *CommentHandler) queryCommentPage(query string, args []interface{}, limit int, cursorFor func(models.Comment) string) (models.CommentPage, error) {
+	page := models.CommentPage{Comments: []models.Comment{}}
+
+	rows, err := h.db.Query(query, args...)
+	if err != nil {
+		return page, err
 	}
 	defer rows.Close()
 
-	var comments []models.Comment
 	for rows.Next() {
-		var c models.Comment
-		err := rows.Scan(&c.ID, &c.VideoID, &c.UserID, &c.Content, &c.CreatedAt, &c.UpdatedAt)
+		c, err := scanComment(rows)
 		if err != nil {
+			return page, err
+		}
+		page.Comments = append(page.Comments, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return page, err
+	}
+
+	if len(page.Comments) > limit {
+		page.Comments = page.Comments[:limit]
+		page.NextCursor = cursorFor(page.Comments[limit-1])
+	}
+	return page, nil
+}
+
+// GetComments returns a page of published top-level comments for a video,
+// sorted by likes ("top", the default) or by time ("newest"). The pinned
+// comment, if any, leads the first page.
+func (h *CommentHandler) GetComments(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	videoID, err := strconv.Atoi(vars["videoId"])
+	if err != nil {
+		http.Error(w, "Invalid video ID", http.StatusBadRequest)
+		return
+	}
+
+	sort := r.URL.Query().Get("sort")
+	if sort == "" {
+		sort = commentSortTop
+	}
+	if sort != commentSortTop && sort != commentSortNewest {
+		http.Error(w, "Sort must be top or newest", http.StatusBadRequest)
+		return
+	}
+
+	limit := parseLimit(r.URL.Query().Get("limit"), 20, 100)
+
+	query := `SELECT ` + commentColumns + ` FROM comments
+		WHERE video_id = $1 AND parent_id IS NULL AND status = 'published' AND NOT is_pinned`
+	args := []interface{}{videoID}
+
+	cursor := r.URL.Query().Get("cursor")
+	var cursorFor func(models.Comment) string
+
+	if sort == commentSortTop {
+		if cursor != "" {
+			likes, id, err := decodeRankCursor(cursor)
+			if err != nil {
+				http.Error(w, "Invalid cursor", http.StatusBadRequest)
+				return
+			}
+			query += " AND (likes, id) < ($2, $3)"
+			args = append(args, likes, id)
+		}
+		query += " ORDER BY likes DESC, id DESC"
+		cursorFor = func(c models.Comment) string { return encodeRankCursor(c.Likes, c.ID) }
+	} else {
+		if cursor != "" {
+			createdAt, id, err := decodeCursor(cursor)
+			if err != nil {
+				http.Error(w, "Invalid cursor", http.StatusBadRequest)
+				return
+			}
+			query += " AND (created_at, id) < ($2, $3)"
+			args = append(args, createdAt, id)
+		}
+		query += " ORDER BY created_at DESC, id DESC"
+		cursorFor = func(c models.Comment) string { return encodeCursor(c.CreatedAt, c.ID) }
+	}
+
+	var pinned []models.Comment
+	if cursor == "" {
+		pinnedQuery := `SELECT ` + commentColumns + ` FROM comments WHERE video_id = $1 AND is_pinned AND status = 'published'`
+		c, err := scanComment(h.db.QueryRow(pinnedQuery, videoID))
+		if err == nil {
+			pinned = append(pinned, c)
+		} else if err != sql.ErrNoRows {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		comments = append(comments, c)
 	}
 
-	if comments == nil {
-		comments = []models.Comment{}
+	// Fetch one extra row to find out whether another page exists
+	query += " LIMIT $" + strconv.Itoa(len(args)+1)
+	args = append(args, limit+1)
+
+	page, err := h.queryCommentPage(query, args, limit, cursorFor)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	page.Comments = append(pinned, page.Comments...)
+	h.attachAuthors(page.Comments)
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(comments)
+	json.NewEncoder(w).Encode(page)
 }
 
-// GetComment returns a single comment by ID
-func (h *CommentHandler) GetComment(w http.ResponseWriter, r *http.Request) {
+// GetReplies returns a page of published direct replies to a comment, oldest first
+func (h *CommentHandler) GetReplies(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
@@ -70,15 +540,50 @@ func (h *CommentHandler) GetComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	query := `
-		SELECT id, video_id, user_id, content, created_at, updated_at
-		FROM comments
-		WHERE id = $1
-	`
+	limit := parseLimit(r.URL.Query().Get("limit"), 20, 100)
 
-	var c models.Comment
-	err = h.db.QueryRow(query, id).Scan(&c.ID, &c.VideoID, &c.UserID, &c.Content, &c.CreatedAt, &c.UpdatedAt)
+	query := `SELECT ` + commentColumns + ` FROM comments WHERE parent_id = $1 AND status = 'published'`
+	args := []interface{}{id}
 
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		createdAt, replyID, err := decodeCursor(cursor)
+		if err != nil {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		query += " AND (created_at, id) > ($2, $3)"
+		args = append(args, createdAt, replyID)
+	}
+
+	query += " ORDER BY created_at ASC, id ASC LIMIT $" + strconv.Itoa(len(args)+1)
+	args = append(args, limit+1)
+
+	page, err := h.queryCommentPage(query, args, limit, func(c models.Comment) string {
+		return encodeCursor(c.CreatedAt, c.ID)
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.attachAuthors(page.Comments)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// GetComment returns a single published comment by ID. Held, rejected and
+// hidden comments are only shown in the review queues.
+func (h *CommentHandler) GetComment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid comment ID", http.StatusBadRequest)
+		return
+	}
+
+	query := `SELECT ` + commentColumns + ` FROM comments WHERE id = $1 AND status = 'published'`
+
+	c, err := scanComment(h.db.QueryRow(query, id))
 	if err == sql.ErrNoRows {
 		http.Error(w, "Comment not found", http.StatusNotFound)
 		return
@@ -88,11 +593,22 @@ func (h *CommentHandler) GetComment(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(c)
+	json.NewEncoder(w).Encode(h.withAuthor(c))
 }
 
-// CreateComment creates a new comment
+// CreateComment creates a comment by the authenticated user, or a reply when
+// parent_id is set. The spam filter decides whether the comment is published,
+// held for review or rejected; comments containing one of the channel's
+// blocked words are held as well. The author's username is copied from the
+// token since this service has no users table to join against.
 func (h *CommentHandler) CreateComment(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	username, _ := r.Context().Value(middleware.UsernameKey).(string)
+
 	vars := mux.Vars(r)
 	videoID, err := strconv.Atoi(vars["videoId"])
 	if err != nil {
@@ -100,45 +616,124 @@ func (h *CommentHandler) CreateComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var c models.Comment
-	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+	var req struct {
+		ParentID *int   `json:"parent_id"`
+		Content  string `json:"content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Set video ID from URL parameter
-	c.VideoID = videoID
-
 	// Validate required fields
-	if strings.TrimSpace(c.Content) == "" {
+	if strings.TrimSpace(req.Content) == "" {
 		http.Error(w, "Content is required", http.StatusBadRequest)
 		return
 	}
-	if c.UserID <= 0 {
-		http.Error(w, "User ID is required", http.StatusBadRequest)
+
+	policy, err := h.videoCommentPolicy(videoID, userID)
+	if err != nil {
+		writePolicyError(w, err)
 		return
 	}
 
+	depth := 0
+	if req.ParentID != nil {
+		var parentVideoID, parentDepth int
+		var parentStatus string
+		err := h.db.QueryRow(`SELECT video_id, depth, status FROM comments WHERE id = $1`, *req.ParentID).
+			Scan(&parentVideoID, &parentDepth, &parentStatus)
+		if err == sql.ErrNoRows || (err == nil && parentStatus != models.CommentStatusPublished) {
+			http.Error(w, "Parent comment not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if parentVideoID != videoID {
+			http.Error(w, "Parent comment belongs to a different video", http.StatusBadRequest)
+			return
+		}
+		if parentDepth >= maxCommentDepth {
+			http.Error(w, "Replies can only be nested "+strconv.Itoa(maxCommentDepth)+" levels deep", http.StatusBadRequest)
+			return
+		}
+		depth = parentDepth + 1
+	}
+
+	verdict, err := h.classifyComment(r.Context(), userID, req.Content)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	status := models.CommentStatusPublished
+	switch {
+	case verdict.Decision == moderation.DecisionReject:
+		status = models.CommentStatusRejected
+	case verdict.Decision == moderation.DecisionHold, containsBlockedWord(req.Content, policy.BlockedWords):
+		status = models.CommentStatusHeld
+	}
+
+	signals, err := json.Marshal(verdict.Signals)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Rejected comments are kept, with their verdict, for admins to review.
+	// The parent's reply count only tracks published replies, and is bumped
+	// in the same statement as the insert.
 	query := `
-		INSERT INTO comments (video_id, user_id, content)
-		VALUES ($1, $2, $3)
-		RETURNING id, created_at, updated_at
+		WITH inserted AS (
+			INSERT INTO comments (video_id, channel_id, user_id, author_username, parent_id, depth, content, status)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			RETURNING ` + commentColumns + `
+		), counted AS (
+			UPDATE comments SET reply_count = reply_count + 1 WHERE id = $5 AND $8 = 'published'
+		), judged AS (
+			INSERT INTO comment_verdicts (comment_id, score, decision, signals)
+			SELECT id, $9, $10, $11 FROM inserted
+		)
+		SELECT ` + commentColumns + ` FROM inserted
 	`
 
-	err = h.db.QueryRow(query, c.VideoID, c.UserID, c.Content).Scan(&c.ID, &c.CreatedAt, &c.UpdatedAt)
-
+	c, err := scanComment(h.db.QueryRow(query, videoID, policy.ChannelID, userID, username, req.ParentID, depth, req.Content, status,
+		verdict.Score, string(verdict.Decision), signals))
 	if err != nil {
+		if strings.Contains(err.Error(), "foreign key") {
+			http.Error(w, "Parent comment not found", http.StatusNotFound)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	if c.Status == models.CommentStatusRejected {
+		http.Error(w, "Comment rejected by the spam filter", http.StatusUnprocessableEntity)
+		return
+	}
+
+	// Held comments notify nobody until they're approved
+	if c.Status == models.CommentStatusPublished {
+		h.publishInBackground(c)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(c)
+	json.NewEncoder(w).Encode(h.withAuthor(c))
 }
 
-// UpdateComment updates an existing comment
+// UpdateComment lets a comment's author edit it. Edits that introduce a
+// blocked word send the comment back for review.
 func (h *CommentHandler) UpdateComment(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
@@ -146,28 +741,22 @@ func (h *CommentHandler) UpdateComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var c models.Comment
-	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+	var req struct {
+		Content string `json:"content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	// Validate required fields
-	if strings.TrimSpace(c.Content) == "" {
+	if strings.TrimSpace(req.Content) == "" {
 		http.Error(w, "Content is required", http.StatusBadRequest)
 		return
 	}
 
-	query := `
-		UPDATE comments 
-		SET content = $1, updated_at = CURRENT_TIMESTAMP
-		WHERE id = $2
-		RETURNING id, video_id, user_id, content, created_at, updated_at
-	`
-
-	err = h.db.QueryRow(query, c.Content, id).Scan(&c.ID, &c.VideoID, &c.UserID, &c.Content, &c.CreatedAt, &c.UpdatedAt)
-
-	if err == sql.ErrNoRows {
+	access, err := h.loadCommentAccess(id)
+	if err == errCommentNotFound {
 		http.Error(w, "Comment not found", http.StatusNotFound)
 		return
 	} else if err != nil {
@@ -175,12 +764,70 @@ func (h *CommentHandler) UpdateComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if access.AuthorID != userID {
+		http.Error(w, "You can only edit your own comments", http.StatusForbidden)
+		return
+	}
+
+	// Comments on deleted videos have no blocked words to check against
+	var policy commentPolicy
+	if access.Status == models.CommentStatusPublished {
+		policy, err = h.videoCommentPolicy(access.VideoID, userID)
+		if err != nil && err != errVideoNotFound {
+			writePolicyError(w, err)
+			return
+		}
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE comments SET content = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`, req.Content, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if access.Status == models.CommentStatusPublished && containsBlockedWord(req.Content, policy.BlockedWords) {
+		if err := setCommentStatus(tx, id, access.ParentID, access.Status, models.CommentStatusHeld); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// Held comments can't stay pinned
+		if _, err := tx.Exec(`UPDATE comments SET is_pinned = FALSE WHERE id = $1`, id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	c, err := scanComment(tx.QueryRow(`SELECT `+commentColumns+` FROM comments WHERE id = $1`, id))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(c)
+	json.NewEncoder(w).Encode(h.withAuthor(c))
 }
 
-// DeleteComment deletes a comment
+// DeleteComment deletes a comment along with its replies. Comments may be
+// deleted by their author, the team of the video's channel or a moderator.
 func (h *CommentHandler) DeleteComment(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
@@ -188,24 +835,288 @@ func (h *CommentHandler) DeleteComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	query := `DELETE FROM comments WHERE id = $1`
+	access, err := h.loadCommentAccess(id)
+	if err == errCommentNotFound {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	result, err := h.db.Exec(query, id)
+	if access.AuthorID != userID && !middleware.HasPermission(r.Context(), auth.PermModerateComments) {
+		allowed, err := h.canModerate(access, userID)
+		if err != nil {
+			writePolicyError(w, err)
+			return
+		}
+		if !allowed {
+			http.Error(w, "You do not have permission to delete this comment", http.StatusForbidden)
+			return
+		}
+	}
+
+	query := `
+		WITH deleted AS (
+			DELETE FROM comments WHERE id = $1
+			RETURNING parent_id, status
+		), counted AS (
+			UPDATE comments SET reply_count = GREATEST(reply_count - 1, 0)
+			WHERE id IN (SELECT parent_id FROM deleted WHERE status = 'published')
+		)
+		SELECT COUNT(*) FROM deleted
+	`
+
+	var deleted int
+	if err := h.db.QueryRow(query, id).Scan(&deleted); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if deleted == 0 {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ModerateComment lets the team of a video's channel pin, heart, hide or
+// approve a comment on it
+func (h *CommentHandler) ModerateComment(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
+		http.Error(w, "Invalid comment ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Pinned  *bool   `json:"pinned"`
+		Hearted *bool   `json:"hearted"`
+		Status  *string `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Status != nil && *req.Status != models.CommentStatusPublished && *req.Status != models.CommentStatusHidden {
+		http.Error(w, "Status must be published or hidden", http.StatusBadRequest)
+		return
+	}
+
+	access, err := h.loadCommentAccess(id)
+	if err == errCommentNotFound {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	} else if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	allowed, err := h.canModerate(access, userID)
+	if err != nil {
+		writePolicyError(w, err)
+		return
+	}
+	if !allowed {
+		http.Error(w, "Only the channel's team can moderate comments on its videos", http.StatusForbidden)
+		return
+	}
+	if access.Status == models.CommentStatusRejected {
+		http.Error(w, "Comments rejected by the spam filter can only be reviewed by a moderator", http.StatusForbidden)
+		return
+	}
+
+	status := access.Status
+	if req.Status != nil {
+		status = *req.Status
+	}
+	if req.Pinned != nil && *req.Pinned {
+		if access.ParentID != nil {
+			http.Error(w, "Only top-level comments can be pinned", http.StatusBadRequest)
+			return
+		}
+		if status != models.CommentStatusPublished {
+			http.Error(w, "Only published comments can be pinned", http.StatusBadRequest)
+			return
+		}
+	}
+
+	tx, err := h.db.Begin()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	defer tx.Rollback()
+
+	if req.Status != nil {
+		if err := setCommentStatus(tx, id, access.ParentID, access.Status, status); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// A video has at most one pinned comment
+	if req.Pinned != nil && *req.Pinned {
+		_, err := tx.Exec(`UPDATE comments SET is_pinned = FALSE WHERE video_id = $1 AND is_pinned AND id <> $2`, access.VideoID, id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Hidden comments can't stay pinned
+	query := `
+		UPDATE comments
+		SET is_pinned = COALESCE($1, is_pinned) AND status = 'published',
+		    is_hearted = COALESCE($2, is_hearted)
+		WHERE id = $3
+		RETURNING ` + commentColumns
+
+	c, err := scanComment(tx.QueryRow(query, req.Pinned, req.Hearted, id))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Held comments notify nobody until they're approved
+	if access.Status == models.CommentStatusHeld && c.Status == models.CommentStatusPublished {
+		h.publishInBackground(c)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.withAuthor(c))
+}
+
+// GetHeldComments returns the review queue of a channel: comments on its
+// videos that were held for containing a blocked word, newest first
+func (h *CommentHandler) GetHeldComments(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
 
-	if rowsAffected == 0 {
+	vars := mux.Vars(r)
+	channelID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+
+	policy, err := h.channelCommentPolicy(channelID, userID)
+	if err != nil {
+		writePolicyError(w, err)
+		return
+	}
+	if !policy.CanModerate {
+		http.Error(w, "Only the channel's team can review its held comments", http.StatusForbidden)
+		return
+	}
+
+	limit := parseLimit(r.URL.Query().Get("limit"), 20, 100)
+
+	query := `SELECT ` + commentColumns + ` FROM comments WHERE channel_id = $1 AND status = 'held'`
+	args := []interface{}{channelID}
+
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		createdAt, id, err := decodeCursor(cursor)
+		if err != nil {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		query += " AND (created_at, id) < ($2, $3)"
+		args = append(args, createdAt, id)
+	}
+
+	query += " ORDER BY created_at DESC, id DESC LIMIT $" + strconv.Itoa(len(args)+1)
+	args = append(args, limit+1)
+
+	page, err := h.queryCommentPage(query, args, limit, func(c models.Comment) string {
+		return encodeCursor(c.CreatedAt, c.ID)
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.attachAuthors(page.Comments)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// LikeComment records the authenticated user's like on a comment. Liking
+// twice is a no-op.
+func (h *CommentHandler) LikeComment(w http.ResponseWriter, r *http.Request) {
+	query := `
+		WITH liked AS (
+			INSERT INTO comment_likes (comment_id, user_id)
+			VALUES ($1, $2)
+			ON CONFLICT DO NOTHING
+			RETURNING comment_id
+		)
+		UPDATE comments SET likes = likes + (SELECT COUNT(*) FROM liked)
+		WHERE id = $1
+		RETURNING likes
+	`
+	h.changeCommentLike(w, r, query)
+}
+
+// UnlikeComment removes the authenticated user's like from a comment
+func (h *CommentHandler) UnlikeComment(w http.ResponseWriter, r *http.Request) {
+	query := `
+		WITH unliked AS (
+			DELETE FROM comment_likes
+			WHERE comment_id = $1 AND user_id = $2
+			RETURNING comment_id
+		)
+		UPDATE comments SET likes = GREATEST(likes - (SELECT COUNT(*) FROM unliked), 0)
+		WHERE id = $1
+		RETURNING likes
+	`
+	h.changeCommentLike(w, r, query)
+}
+
+func (h *CommentHandler) changeCommentLike(w http.ResponseWriter, r *http.Request, query string) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid comment ID", http.StatusBadRequest)
+		return
+	}
+
+	var likes int
+	err = h.db.QueryRow(query, id, userID).Scan(&likes)
+	if err == sql.ErrNoRows || (err != nil && strings.Contains(err.Error(), "foreign key")) {
 		http.Error(w, "Comment not found", http.StatusNotFound)
 		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{
+		"id":    id,
+		"likes": likes,
+	})
 }
@@ -1,12 +1,69 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
+
+// Moderation states of a comment. Only published comments are shown
+// publicly; held ones wait for the channel's team or an admin to review
+// them, and rejected ones were caught by the spam filter.
+const (
+	CommentStatusPublished = "published"
+	CommentStatusHeld      = "held"
+	CommentStatusHidden    = "hidden"
+	CommentStatusRejected  = "rejected"
+)
 
 type Comment struct {
-	ID        int       `json:"id"`
-	VideoID   int       `json:"video_id"`
-	UserID    int       `json:"user_id"`
-	Content   string    `json:"content"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID             int       `json:"id"`
+	VideoID        int       `json:"video_id"`
+	ChannelID      *int      `json:"channel_id,omitempty"` // channel of the video when the comment was posted
+	UserID         int       `json:"user_id"`
+	AuthorUsername string    `json:"author_username"`
+	AuthorAvatar   string    `json:"author_avatar"`
+	ParentID       *int      `json:"parent_id,omitempty"` // nil for top-level comments
+	Depth          int       `json:"depth"`
+	Content        string    `json:"content"`
+	Likes          int       `json:"likes"`
+	ReplyCount     int       `json:"reply_count"` // published replies only
+	IsPinned       bool      `json:"is_pinned"`
+	IsHearted      bool      `json:"is_hearted"`
+	Status         string    `json:"status"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// CommentPage is a page of comments or replies
+type CommentPage struct {
+	Comments   []Comment `json:"comments"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+// CommentVerdict is the spam filter's assessment of a new comment
+type CommentVerdict struct {
+	Score     float64         `json:"score"`
+	Decision  string          `json:"decision"`
+	Signals   json.RawMessage `json:"signals"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// ReviewComment is a comment in the admin review queue with its verdict
+type ReviewComment struct {
+	Comment
+	Verdict *CommentVerdict `json:"verdict,omitempty"`
+}
+
+// ReviewPage is a page of the admin review queue
+type ReviewPage struct {
+	Comments   []ReviewComment `json:"comments"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+// CommentFilterSettings are the admin-tunable spam filter settings
+type CommentFilterSettings struct {
+	HoldThreshold   float64   `json:"hold_threshold"`
+	RejectThreshold float64   `json:"reject_threshold"`
+	BlockedTerms    []string  `json:"blocked_terms"`
+	UpdatedAt       time.Time `json:"updated_at"`
 }
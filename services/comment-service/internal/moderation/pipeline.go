@@ -0,0 +1,122 @@
+// Package moderation classifies new comments as spam or abuse. A Pipeline
+// runs a set of Classifiers, built-in rules and optional external services,
+// and turns the highest score into a publish, hold or reject decision.
+package moderation
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Decision is what should happen to a classified comment
+type Decision string
+
+const (
+	DecisionPublish Decision = "publish"
+	DecisionHold    Decision = "hold"
+	DecisionReject  Decision = "reject"
+)
+
+// Input is everything a classifier may look at
+type Input struct {
+	Content          string
+	AuthorID         int
+	AccountAge       time.Duration
+	RecentComments   int      // comments by the author within VelocityWindow
+	RecentDuplicates int      // identical comments by the author within DuplicateWindow
+	BlockedTerms     []string // site-wide blocklist
+}
+
+// Signal is one classifier's opinion of a comment. Score runs from 0 (clean)
+// to 1 (certainly spam or abuse).
+type Signal struct {
+	Classifier string  `json:"classifier"`
+	Score      float64 `json:"score"`
+	Reason     string  `json:"reason,omitempty"`
+}
+
+// Classifier scores a comment. Implementations must be safe for concurrent use.
+type Classifier interface {
+	Name() string
+	Classify(ctx context.Context, in Input) (Signal, error)
+}
+
+// Thresholds turn a score into a decision: scores at or above Reject are
+// rejected, scores at or above Hold are held for review
+type Thresholds struct {
+	Hold   float64 `json:"hold_threshold"`
+	Reject float64 `json:"reject_threshold"`
+}
+
+// DefaultThresholds are used until an admin tunes them
+var DefaultThresholds = Thresholds{Hold: 0.6, Reject: 0.9}
+
+// Valid reports whether both thresholds are within [0, 1] and in order
+func (t Thresholds) Valid() bool {
+	return t.Hold > 0 && t.Hold <= t.Reject && t.Reject <= 1
+}
+
+// Decide maps a score to a decision
+func (t Thresholds) Decide(score float64) Decision {
+	switch {
+	case score >= t.Reject:
+		return DecisionReject
+	case score >= t.Hold:
+		return DecisionHold
+	default:
+		return DecisionPublish
+	}
+}
+
+// Verdict is the outcome of running a comment through the pipeline
+type Verdict struct {
+	Score    float64
+	Decision Decision
+	Signals  []Signal
+}
+
+// Pipeline runs classifiers in order. The verdict's score is the highest
+// score any classifier gave.
+type Pipeline struct {
+	classifiers []Classifier
+}
+
+// NewPipeline creates a pipeline from the given classifiers
+func NewPipeline(classifiers ...Classifier) *Pipeline {
+	return &Pipeline{classifiers: classifiers}
+}
+
+// Classify scores a comment. A classifier that fails is logged and skipped
+// so that an unavailable external service never blocks commenting.
+func (p *Pipeline) Classify(ctx context.Context, in Input, t Thresholds) Verdict {
+	verdict := Verdict{Signals: []Signal{}}
+
+	for _, c := range p.classifiers {
+		signal, err := c.Classify(ctx, in)
+		if err != nil {
+			log.Printf("Comment classifier %s failed: %v", c.Name(), err)
+			continue
+		}
+
+		signal.Classifier = c.Name()
+		signal.Score = clampScore(signal.Score)
+		verdict.Signals = append(verdict.Signals, signal)
+		if signal.Score > verdict.Score {
+			verdict.Score = signal.Score
+		}
+	}
+
+	verdict.Decision = t.Decide(verdict.Score)
+	return verdict
+}
+
+func clampScore(score float64) float64 {
+	if score < 0 {
+		return 0
+	}
+	if score > 1 {
+		return 1
+	}
+	return score
+}
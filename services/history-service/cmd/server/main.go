@@ -6,8 +6,10 @@ import (
 	"net/http"
 	"os"
 
+	"github.com/aung-arata/youtube-clone/services/history-service/internal/auth"
 	"github.com/aung-arata/youtube-clone/services/history-service/internal/database"
 	"github.com/aung-arata/youtube-clone/services/history-service/internal/handlers"
+	"github.com/aung-arata/youtube-clone/services/history-service/internal/middleware"
 	"github.com/gorilla/mux"
 )
 
@@ -19,12 +21,20 @@ func main() {
 	}
 	defer db.Close()
 
+	// Services present SERVICE_TOKEN to each other's internal endpoints
+	auth.UseServiceToken(os.Getenv("SERVICE_TOKEN"))
+
+	// Reject access tokens whose session was revoked on the user service
+	if url := os.Getenv("SESSION_CHECK_URL"); url != "" {
+		auth.UseSessionChecker(auth.NewSessionCache(auth.NewRemoteSessionChecker(url), auth.SessionCheckTTL))
+	}
+
 	// Create router
 	r := mux.NewRouter()
 
 	// History routes
 	historyHandler := handlers.NewHistoryHandler(db)
-	r.HandleFunc("/users/{userId}/history", historyHandler.AddToHistory).Methods("POST")
+	r.Handle("/users/{userId}/history", middleware.Protect(auth.PermInteract, middleware.RequireSelf("userId", http.HandlerFunc(historyHandler.AddToHistory)))).Methods("POST")
 	r.HandleFunc("/users/{userId}/history", historyHandler.GetHistory).Methods("GET")
 	
 	// Health check
@@ -0,0 +1,292 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrUnknownKey is returned for tokens signed with a key that isn't published
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// keySource finds the public key a token names in its kid header
+type keySource interface {
+	publicKey(kid string) (crypto.PublicKey, error)
+}
+
+// localKeys are verification keys this process holds itself
+type localKeys map[string]crypto.PublicKey
+
+func (k localKeys) publicKey(kid string) (crypto.PublicKey, error) {
+	if key, ok := k[kid]; ok {
+		return key, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+// signingKey is the private key access tokens are signed with
+type signingKey struct {
+	id     string
+	method jwt.SigningMethod
+	key    crypto.Signer
+}
+
+func newSigningKey(key crypto.Signer) (*signingKey, error) {
+	method, err := signingMethod(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	id, err := KeyID(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &signingKey{id: id, method: method, key: key}, nil
+}
+
+// signingMethod returns the only algorithm a key may be used with: RS256 for
+// RSA and EdDSA for Ed25519
+func signingMethod(pub crypto.PublicKey) (jwt.SigningMethod, error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		if k.N.BitLen() < 2048 {
+			return nil, errors.New("RSA keys must be at least 2048 bits")
+		}
+		return jwt.SigningMethodRS256, nil
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T", pub)
+}
+
+// ParsePrivateKeyPEM reads an RSA or Ed25519 private key in PKCS #8 or, for
+// RSA, PKCS #1 form
+func ParsePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	return signer, nil
+}
+
+// ParsePublicKeyPEM reads a PKIX public key
+func ParsePublicKeyPEM(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// JWK is a public key in JSON Web Key form (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set, as served from /.well-known/jwks.json
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+var b64 = base64.RawURLEncoding
+
+// NewJWK describes a public signing key
+func NewJWK(pub crypto.PublicKey) (JWK, error) {
+	method, err := signingMethod(pub)
+	if err != nil {
+		return JWK{}, err
+	}
+	kid, err := KeyID(pub)
+	if err != nil {
+		return JWK{}, err
+	}
+
+	jwk := JWK{Kid: kid, Use: "sig", Alg: method.Alg()}
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = b64.EncodeToString(k.N.Bytes())
+		jwk.E = b64.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = b64.EncodeToString(k)
+	}
+	return jwk, nil
+}
+
+// PublicKey decodes the key a JWK describes
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := b64.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA modulus: %w", err)
+		}
+		e, err := b64.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := b64.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// KeyID returns a key's RFC 7638 thumbprint, which is used as its kid so
+// that the same key always gets the same ID
+func KeyID(pub crypto.PublicKey) (string, error) {
+	var members string
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		members = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`,
+			b64.EncodeToString(big.NewInt(int64(k.E)).Bytes()), b64.EncodeToString(k.N.Bytes()))
+	case ed25519.PublicKey:
+		members = fmt.Sprintf(`{"crv":"Ed25519","kty":"OKP","x":%q}`, b64.EncodeToString(k))
+	default:
+		return "", fmt.Errorf("unsupported key type %T", pub)
+	}
+	sum := sha256.Sum256([]byte(members))
+	return b64.EncodeToString(sum[:]), nil
+}
+
+// keyConfig is how this process signs and verifies tokens. Services that
+// only verify tokens have no signer.
+type keyConfig struct {
+	signer   *signingKey
+	verifier keySource
+}
+
+var keys = mustLoadKeys()
+
+func mustLoadKeys() keyConfig {
+	k, err := loadKeys()
+	if err != nil {
+		panic("invalid JWT key configuration: " + err.Error())
+	}
+	return k
+}
+
+// loadKeys configures signing from the environment:
+//
+//   - JWT_PRIVATE_KEY_FILE signs tokens, and JWT_PUBLIC_KEY_FILES lists
+//     retired keys whose tokens are still accepted and published
+//   - otherwise JWKS_URL names the key set to verify tokens against
+//   - otherwise, outside production, a temporary key is generated
+func loadKeys() (keyConfig, error) {
+	if path := os.Getenv("JWT_PRIVATE_KEY_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return keyConfig{}, err
+		}
+		priv, err := ParsePrivateKeyPEM(data)
+		if err != nil {
+			return keyConfig{}, fmt.Errorf("%s: %w", path, err)
+		}
+		signer, err := newSigningKey(priv)
+		if err != nil {
+			return keyConfig{}, fmt.Errorf("%s: %w", path, err)
+		}
+
+		local := localKeys{signer.id: priv.Public()}
+		for _, path := range strings.Split(os.Getenv("JWT_PUBLIC_KEY_FILES"), ",") {
+			path = strings.TrimSpace(path)
+			if path == "" {
+				continue
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return keyConfig{}, err
+			}
+			pub, err := ParsePublicKeyPEM(data)
+			if err != nil {
+				return keyConfig{}, fmt.Errorf("%s: %w", path, err)
+			}
+			kid, err := KeyID(pub)
+			if err != nil {
+				return keyConfig{}, fmt.Errorf("%s: %w", path, err)
+			}
+			local[kid] = pub
+		}
+		return keyConfig{signer: signer, verifier: local}, nil
+	}
+
+	if url := os.Getenv("JWKS_URL"); url != "" {
+		return keyConfig{verifier: NewJWKSFetcher(url)}, nil
+	}
+
+	if os.Getenv("GO_ENV") == "production" {
+		return keyConfig{}, errors.New("JWT_PRIVATE_KEY_FILE or JWKS_URL must be set in production")
+	}
+
+	// Development only: tokens stop working when the process restarts
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return keyConfig{}, err
+	}
+	signer, err := newSigningKey(priv)
+	if err != nil {
+		return keyConfig{}, err
+	}
+	log.Printf("Neither JWT_PRIVATE_KEY_FILE nor JWKS_URL is set; signing tokens with a temporary key")
+	return keyConfig{signer: signer, verifier: localKeys{signer.id: priv.Public()}}, nil
+}
+
+// PublicJWKS returns the keys this process signs and accepts tokens with.
+// It is empty for services that verify against another service's JWKS.
+func PublicJWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	local, ok := keys.verifier.(localKeys)
+	if !ok {
+		return set
+	}
+	for _, pub := range local {
+		jwk, err := NewJWK(pub)
+		if err != nil {
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
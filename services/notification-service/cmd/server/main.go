@@ -1,13 +1,22 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/aung-arata/youtube-clone/services/notification-service/internal/auth"
 	"github.com/aung-arata/youtube-clone/services/notification-service/internal/database"
 	"github.com/aung-arata/youtube-clone/services/notification-service/internal/handlers"
+	"github.com/aung-arata/youtube-clone/services/notification-service/internal/middleware"
+	"github.com/aung-arata/youtube-clone/services/notification-service/internal/notify"
+	"github.com/aung-arata/youtube-clone/services/notification-service/internal/webpush"
+	"github.com/aung-arata/youtube-clone/services/notification-service/internal/websocket"
 	"github.com/gorilla/mux"
 )
 
@@ -19,16 +28,141 @@ func main() {
 	}
 	defer db.Close()
 
+	// Services present SERVICE_TOKEN to each other's internal endpoints,
+	// which refuse every request without it
+	serviceToken := os.Getenv("SERVICE_TOKEN")
+	if serviceToken == "" {
+		log.Println("SERVICE_TOKEN is not set; internal endpoints will refuse all requests")
+	}
+	auth.UseServiceToken(serviceToken)
+
+	// Reject access tokens whose session was revoked on the user service
+	if url := os.Getenv("SESSION_CHECK_URL"); url != "" {
+		auth.UseSessionChecker(auth.NewSessionCache(auth.NewRemoteSessionChecker(url), auth.SessionCheckTTL))
+	}
+
+	// Hubs on all instances share notifications through Postgres, unless
+	// WS_BROADCAST_BACKEND=local for a single instance
+	var broadcaster websocket.Broadcaster
+	if os.Getenv("WS_BROADCAST_BACKEND") == "local" {
+		broadcaster = websocket.NewLocalBroadcaster()
+	} else {
+		broadcaster, err = websocket.NewPostgresBroadcaster(db, database.ConnString())
+		if err != nil {
+			log.Fatal("Failed to start notification broadcaster:", err)
+		}
+	}
+	defer broadcaster.Close()
+
+	// Start the websocket hub for real-time notifications
+	hub := websocket.NewHubWithBroadcaster(broadcaster, websocket.NewSQLStore(db))
+	go hub.Run()
+
+	// Comma-separated list of origins allowed to open websockets
+	if origins := os.Getenv("WS_ALLOWED_ORIGINS"); origins != "" {
+		websocket.SetAllowedOrigins(strings.Split(origins, ","))
+	}
+
+	// Email notifications and digests are sent only when SMTP_ADDR is set
+	var notificationSenders []notify.Sender
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		from := os.Getenv("SMTP_FROM")
+		if from == "" {
+			from = "YouTube Clone <notifications@localhost>"
+		}
+		// Links in emails point at the web app
+		baseURL := os.Getenv("APP_BASE_URL")
+		if baseURL == "" {
+			baseURL = "http://localhost:3000"
+		}
+		userServiceURL := os.Getenv("USER_SERVICE_URL")
+		if userServiceURL == "" {
+			userServiceURL = "http://user-service:8082" // default for docker-compose
+		}
+
+		mailer := notify.NewSMTPMailer(notify.SMTPConfig{
+			Addr:     addr,
+			From:     from,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		})
+		outbox := notify.NewEmailOutbox(db, mailer)
+		recipients := notify.NewUserServiceRecipients(userServiceURL)
+
+		go outbox.Run(context.Background(), 30*time.Second)
+		go notify.NewDigestScheduler(db, outbox, recipients, baseURL).Run(context.Background(), time.Hour)
+		notificationSenders = append(notificationSenders, notify.NewEmailSender(outbox, recipients, baseURL))
+	}
+
+	// Web push is sent only when a VAPID key pair is configured
+	var vapidKeys *webpush.VAPIDKeys
+	if privateKey := os.Getenv("VAPID_PRIVATE_KEY"); privateKey != "" {
+		vapidKeys, err = webpush.ParseVAPIDKeys(os.Getenv("VAPID_PUBLIC_KEY"), privateKey, os.Getenv("VAPID_SUBJECT"))
+		if err != nil {
+			log.Fatal("Invalid VAPID configuration:", err)
+		}
+		notificationSenders = append(notificationSenders, notify.NewPushSender(db, webpush.NewClient(vapidKeys)))
+	}
+
+	// Read notifications are deleted after NOTIFICATION_RETENTION_DAYS, 90 by
+	// default; 0 keeps them forever
+	retentionDays := 90
+	if v := os.Getenv("NOTIFICATION_RETENTION_DAYS"); v != "" {
+		retentionDays, err = strconv.Atoi(v)
+		if err != nil || retentionDays < 0 {
+			log.Fatal("Invalid NOTIFICATION_RETENTION_DAYS:", v)
+		}
+	}
+	if retentionDays > 0 {
+		retention := notify.NewRetentionJob(db, time.Duration(retentionDays)*24*time.Hour)
+		go retention.Run(context.Background(), time.Hour)
+	}
+
 	// Create router
 	r := mux.NewRouter()
 
+	// Everything that notifies users goes through one dispatcher
+	dispatcher := notify.NewDispatcher(db, hub, notificationSenders...)
+
 	// Notification routes
-	notificationHandler := handlers.NewNotificationHandler(db)
+	notificationHandler := handlers.NewNotificationHandler(db, dispatcher)
 	r.HandleFunc("/users/{userId}/notifications", notificationHandler.GetUserNotifications).Methods("GET")
 	r.HandleFunc("/users/{userId}/notifications/unread-count", notificationHandler.GetUnreadCount).Methods("GET")
-	r.HandleFunc("/users/{userId}/notifications/mark-all-read", notificationHandler.MarkAllAsRead).Methods("POST")
-	r.HandleFunc("/notifications", notificationHandler.CreateNotification).Methods("POST")
-	r.HandleFunc("/notifications/{id}/mark-read", notificationHandler.MarkAsRead).Methods("POST")
+	r.Handle("/users/{userId}/notifications/mark-all-read", middleware.Protect(auth.PermInteract, middleware.RequireSelf("userId", http.HandlerFunc(notificationHandler.MarkAllAsRead)))).Methods("POST")
+	r.Handle("/notifications", middleware.Protect(auth.PermSendNotifications, http.HandlerFunc(notificationHandler.CreateNotification))).Methods("POST")
+	r.Handle("/notifications/{id}/mark-read", middleware.Protect(auth.PermInteract, http.HandlerFunc(notificationHandler.MarkAsRead))).Methods("POST")
+	r.Handle("/notifications/{id}", middleware.Protect(auth.PermInteract, http.HandlerFunc(notificationHandler.DeleteNotification))).Methods("DELETE")
+	r.Handle("/notifications/{id}/archive", middleware.Protect(auth.PermInteract, http.HandlerFunc(notificationHandler.ArchiveNotification))).Methods("POST")
+	r.Handle("/notifications/{id}/unarchive", middleware.Protect(auth.PermInteract, http.HandlerFunc(notificationHandler.UnarchiveNotification))).Methods("POST")
+	r.Handle("/users/{userId}/notifications/bulk-delete", middleware.Protect(auth.PermInteract, http.HandlerFunc(notificationHandler.BulkDeleteNotifications))).Methods("POST")
+
+	// Real-time notifications; the websocket handshake authenticates itself
+	r.HandleFunc("/ws", hub.ServeWS).Methods("GET")
+	// Delivery counters for this instance (internal, not exposed by the gateway)
+	r.Handle("/ws/stats", middleware.RequireServiceToken(http.HandlerFunc(hub.StatsHandler))).Methods("GET")
+
+	// Muted users (protected)
+	r.Handle("/users/{userId}/mutes", middleware.AuthMiddleware(http.HandlerFunc(notificationHandler.GetMutedUsers))).Methods("GET")
+	r.Handle("/users/{userId}/mutes", middleware.Protect(auth.PermInteract, http.HandlerFunc(notificationHandler.MuteUser))).Methods("POST")
+	r.Handle("/users/{userId}/mutes/{mutedUserId:[0-9]+}", middleware.Protect(auth.PermInteract, http.HandlerFunc(notificationHandler.UnmuteUser))).Methods("DELETE")
+
+	// Notification preferences (protected)
+	r.Handle("/users/{userId}/notification-preferences", middleware.AuthMiddleware(http.HandlerFunc(notificationHandler.GetPreferences))).Methods("GET")
+	r.Handle("/users/{userId}/notification-preferences", middleware.Protect(auth.PermInteract, http.HandlerFunc(notificationHandler.UpdatePreferences))).Methods("PUT")
+
+	// Web push registration
+	pushHandler := handlers.NewPushHandler(db, vapidKeys)
+	r.HandleFunc("/push/vapid-public-key", pushHandler.GetVAPIDPublicKey).Methods("GET")
+	r.Handle("/users/{userId}/push-subscriptions", middleware.Protect(auth.PermInteract, http.HandlerFunc(pushHandler.RegisterSubscription))).Methods("POST")
+	r.Handle("/users/{userId}/push-subscriptions", middleware.Protect(auth.PermInteract, http.HandlerFunc(pushHandler.UnregisterSubscription))).Methods("DELETE")
+
+	// Events from other services (internal, not exposed by the gateway)
+	commentEventHandler := handlers.NewCommentEventHandler(db, dispatcher)
+	r.Handle("/events/comments", middleware.RequireServiceToken(http.HandlerFunc(commentEventHandler.HandleCommentEvent))).Methods("POST")
+	videoEventHandler := handlers.NewVideoEventHandler(dispatcher)
+	r.Handle("/events/videos", middleware.RequireServiceToken(http.HandlerFunc(videoEventHandler.HandleVideoEvent))).Methods("POST")
+	channelInvitationEventHandler := handlers.NewChannelInvitationEventHandler(dispatcher)
+	r.Handle("/events/channel-invitations", middleware.RequireServiceToken(http.HandlerFunc(channelInvitationEventHandler.HandleChannelInvitationEvent))).Methods("POST")
 
 	// Health check
 	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,20 @@
+// Command vapidkeys prints a new VAPID key pair for web push, in the form
+// the server reads from its environment
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/aung-arata/youtube-clone/services/notification-service/internal/webpush"
+)
+
+func main() {
+	publicKey, privateKey, err := webpush.GenerateVAPIDKeys()
+	if err != nil {
+		log.Fatal("Failed to generate VAPID keys:", err)
+	}
+
+	fmt.Printf("VAPID_PUBLIC_KEY=%s\n", publicKey)
+	fmt.Printf("VAPID_PRIVATE_KEY=%s\n", privateKey)
+}
@@ -8,4 +8,6 @@ require (
 	github.com/lib/pq v1.10.9
 )
 
+require github.com/golang-jwt/jwt/v5 v5.3.0
+
 require golang.org/x/net v0.17.0 // indirect
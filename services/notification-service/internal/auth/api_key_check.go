@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIKeyPrefix starts every API key, so that they can be told apart from
+// access tokens and found by secret scanners
+const APIKeyPrefix = "yt_"
+
+// Scopes an API key can be granted. Each route that accepts API keys names
+// the scope it needs; other routes only accept access tokens.
+const (
+	ScopeProfileRead    = "profile:read"
+	ScopeVideosWrite    = "videos:write"
+	ScopePlaylistsWrite = "playlists:write"
+	ScopeCommentsWrite  = "comments:write"
+)
+
+// APIKeyScopes lists every scope, in the order they are documented
+var APIKeyScopes = []string{
+	ScopeProfileRead,
+	ScopeVideosWrite,
+	ScopePlaylistsWrite,
+	ScopeCommentsWrite,
+}
+
+// APIKeyCheckTTL is how long a verified API key is cached, and so how long a
+// revoked key may still be accepted by other services
+const APIKeyCheckTTL = 30 * time.Second
+
+var (
+	// ErrInvalidAPIKey is returned for unknown, expired or revoked API keys
+	ErrInvalidAPIKey = errors.New("invalid or expired API key")
+
+	// apiKeys verifies API keys once set
+	apiKeys APIKeyVerifier
+)
+
+// IsAPIKey tells API keys from access tokens
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
+
+// HashAPIKey returns the form an API key is stored and cached in
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// ValidScope reports whether scope is one API keys can be granted
+func ValidScope(scope string) bool {
+	for _, s := range APIKeyScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeyPrincipal is the user an API key acts for, and what it may do
+type APIKeyPrincipal struct {
+	KeyID         int      `json:"key_id"`
+	UserID        int      `json:"user_id"`
+	Username      string   `json:"username"`
+	Role          string   `json:"role"`
+	EmailVerified bool     `json:"email_verified"`
+	Scopes        []string `json:"scopes"`
+}
+
+// HasScope reports whether the key was granted scope
+func (p *APIKeyPrincipal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeyVerifier looks up the user an API key belongs to
+type APIKeyVerifier interface {
+	VerifyAPIKey(ctx context.Context, key string) (*APIKeyPrincipal, error)
+}
+
+// UseAPIKeyVerifier makes VerifyAPIKey consult verifier. Without one, API
+// keys are rejected.
+func UseAPIKeyVerifier(verifier APIKeyVerifier) {
+	apiKeys = verifier
+}
+
+// VerifyAPIKey returns who an API key acts for, or ErrInvalidAPIKey
+func VerifyAPIKey(ctx context.Context, key string) (*APIKeyPrincipal, error) {
+	if apiKeys == nil {
+		return nil, ErrInvalidAPIKey
+	}
+	return apiKeys.VerifyAPIKey(ctx, key)
+}
+
+type cachedAPIKey struct {
+	principal *APIKeyPrincipal
+	checkedAt time.Time
+}
+
+// RemoteAPIKeyVerifier asks the user service who API keys belong to, and
+// remembers valid keys for APIKeyCheckTTL
+type RemoteAPIKeyVerifier struct {
+	url    string
+	client *http.Client
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]cachedAPIKey
+}
+
+// NewRemoteAPIKeyVerifier creates a verifier for the user service's
+// POST {url} endpoint
+func NewRemoteAPIKeyVerifier(url string) *RemoteAPIKeyVerifier {
+	return &RemoteAPIKeyVerifier{
+		url:     url,
+		client:  &http.Client{Timeout: 5 * time.Second},
+		now:     time.Now,
+		entries: make(map[string]cachedAPIKey),
+	}
+}
+
+// VerifyAPIKey implements APIKeyVerifier
+func (v *RemoteAPIKeyVerifier) VerifyAPIKey(ctx context.Context, key string) (*APIKeyPrincipal, error) {
+	// Keys are cached by hash, so that they don't sit in memory
+	hash := HashAPIKey(key)
+	now := v.now()
+
+	v.mu.Lock()
+	entry, ok := v.entries[hash]
+	v.mu.Unlock()
+	if ok && now.Sub(entry.checkedAt) < APIKeyCheckTTL {
+		return entry.principal, nil
+	}
+
+	body, err := json.Marshal(map[string]string{"key": key})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	SetServiceToken(req)
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusNotFound:
+		return nil, ErrInvalidAPIKey
+	default:
+		return nil, fmt.Errorf("API key check returned %s", resp.Status)
+	}
+
+	var principal APIKeyPrincipal
+	if err := json.NewDecoder(resp.Body).Decode(&principal); err != nil {
+		return nil, err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	// Drop expired entries now and then so the cache doesn't grow forever
+	if len(v.entries) > 10000 {
+		for h, e := range v.entries {
+			if now.Sub(e.checkedAt) >= APIKeyCheckTTL {
+				delete(v.entries, h)
+			}
+		}
+	}
+	v.entries[hash] = cachedAPIKey{principal: &principal, checkedAt: now}
+	return &principal, nil
+}
@@ -0,0 +1,104 @@
+package auth
+
+// Roles a user can have, from least to most privileged. Each role has every
+// permission of the roles before it.
+const (
+	RoleViewer    = "viewer"
+	RoleCreator   = "creator"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Roles lists every role, from least to most privileged
+var Roles = []string{RoleViewer, RoleCreator, RoleModerator, RoleAdmin}
+
+// roleUser is the role every non-admin had before roles were split up.
+// Access tokens issued then may still carry it until they expire.
+const roleUser = "user"
+
+// Permission names something a role allows
+type Permission string
+
+const (
+	// PermInteract allows acting as oneself: commenting, rating videos,
+	// subscribing, and keeping playlists, history and notification settings
+	PermInteract Permission = "interact"
+
+	// PermUploadVideos allows uploading and deleting one's own videos
+	PermUploadVideos Permission = "videos:upload"
+
+	// PermManageChannels allows creating and running one's own channels
+	PermManageChannels Permission = "channels:manage"
+
+	// PermModerateComments allows deleting any comment and reviewing the ones
+	// the spam filter caught
+	PermModerateComments Permission = "comments:moderate"
+
+	// PermManageSettings allows changing site-wide settings such as the spam
+	// filter's thresholds
+	PermManageSettings Permission = "settings:manage"
+
+	// PermManagePlans allows creating, changing and deleting plans
+	PermManagePlans Permission = "plans:manage"
+
+	// PermSendNotifications allows sending notifications to any user
+	PermSendNotifications Permission = "notifications:send"
+
+	// PermManageUsers allows changing other users' accounts, plans and roles
+	PermManageUsers Permission = "users:manage"
+)
+
+// rolePermissions holds what each role adds to the roles before it
+var rolePermissions = map[string][]Permission{
+	RoleViewer:    {PermInteract},
+	RoleCreator:   {PermUploadVideos, PermManageChannels},
+	RoleModerator: {PermModerateComments},
+	RoleAdmin:     {PermManageSettings, PermManagePlans, PermSendNotifications, PermManageUsers},
+}
+
+// ValidRole reports whether role is one users can be given
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// RolePermissions returns every permission role has
+func RolePermissions(role string) []Permission {
+	if role == roleUser {
+		role = RoleCreator
+	}
+	if !ValidRole(role) {
+		return nil
+	}
+
+	var perms []Permission
+	for _, r := range Roles {
+		perms = append(perms, rolePermissions[r]...)
+		if r == role {
+			break
+		}
+	}
+	return perms
+}
+
+// HasPermission reports whether role has perm
+func HasPermission(role string, perm Permission) bool {
+	for _, p := range RolePermissions(role) {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// PermissionRequiresMFA reports whether perm is only granted to staff, who
+// must have logged in with two-factor authentication to use it
+func PermissionRequiresMFA(perm Permission) bool {
+	return !HasPermission(RoleCreator, perm)
+}
+
+// RoleRequiresMFA reports whether users with role must set up two-factor
+// authentication
+func RoleRequiresMFA(role string) bool {
+	return role == RoleModerator || role == RoleAdmin
+}
@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// SessionCheckTTL is how long a session's state is cached, and so how long a
+// revoked session's access tokens may still be accepted by other instances
+const SessionCheckTTL = 30 * time.Second
+
+var (
+	// ErrSessionRevoked is returned for access tokens whose session was
+	// logged out or revoked
+	ErrSessionRevoked = errors.New("session has been revoked")
+
+	// sessions is consulted for every authenticated request once set
+	sessions SessionChecker
+)
+
+// SessionChecker reports whether a login session is still active
+type SessionChecker interface {
+	SessionActive(ctx context.Context, sessionID string) (bool, error)
+}
+
+// UseSessionChecker makes CheckSession consult checker. Without one, every
+// validly signed token is accepted until it expires.
+func UseSessionChecker(checker SessionChecker) {
+	sessions = checker
+}
+
+// CheckSession returns ErrSessionRevoked unless the session an access token
+// belongs to is still active
+func CheckSession(ctx context.Context, claims *Claims) error {
+	if sessions == nil {
+		return nil
+	}
+	if claims.SessionID == "" {
+		return ErrSessionRevoked
+	}
+
+	active, err := sessions.SessionActive(ctx, claims.SessionID)
+	if err != nil {
+		return err
+	}
+	if !active {
+		return ErrSessionRevoked
+	}
+	return nil
+}
+
+// ForgetSession drops a revoked session from the cache, if one is in use
+func ForgetSession(sessionID string) {
+	if cache, ok := sessions.(*SessionCache); ok {
+		cache.Forget(sessionID)
+	}
+}
+
+type sessionState struct {
+	active    bool
+	checkedAt time.Time
+}
+
+// SessionCache remembers a checker's answers for a short time so that most
+// requests don't need a lookup
+type SessionCache struct {
+	checker SessionChecker
+	ttl     time.Duration
+	now     func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]sessionState
+}
+
+// NewSessionCache caches checker's answers for ttl
+func NewSessionCache(checker SessionChecker, ttl time.Duration) *SessionCache {
+	return &SessionCache{
+		checker: checker,
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]sessionState),
+	}
+}
+
+// SessionActive answers from the cache, or asks the checker when the cached
+// answer is missing or too old
+func (c *SessionCache) SessionActive(ctx context.Context, sessionID string) (bool, error) {
+	now := c.now()
+
+	c.mu.Lock()
+	state, ok := c.entries[sessionID]
+	c.mu.Unlock()
+	if ok && now.Sub(state.checkedAt) < c.ttl {
+		return state.active, nil
+	}
+
+	active, err := c.checker.SessionActive(ctx, sessionID)
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Drop expired entries now and then so the cache doesn't grow forever
+	if len(c.entries) > 10000 {
+		for id, s := range c.entries {
+			if now.Sub(s.checkedAt) >= c.ttl {
+				delete(c.entries, id)
+			}
+		}
+	}
+	c.entries[sessionID] = sessionState{active: active, checkedAt: now}
+	return active, nil
+}
+
+// Forget drops a session from the cache, so that revoking it takes effect on
+// this instance at once
+func (c *SessionCache) Forget(sessionID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, sessionID)
+}
+
+// RemoteSessionChecker asks the user service whether sessions are active
+type RemoteSessionChecker struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewRemoteSessionChecker creates a checker for the user service's
+// GET {baseURL}/{sessionID} endpoint
+func NewRemoteSessionChecker(baseURL string) *RemoteSessionChecker {
+	return &RemoteSessionChecker{
+		baseURL: baseURL,
+		client:  &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// SessionActive implements SessionChecker
+func (c *RemoteSessionChecker) SessionActive(ctx context.Context, sessionID string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/"+url.PathEscape(sessionID), nil)
+	if err != nil {
+		return false, err
+	}
+	SetServiceToken(req)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("session check returned %s", resp.Status)
+	}
+
+	var status struct {
+		Active bool `json:"active"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return false, err
+	}
+	return status.Active, nil
+}
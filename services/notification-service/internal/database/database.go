@@ -9,7 +9,8 @@ import (
 	_ "github.com/lib/pq"
 )
 
-func InitDB() (*sql.DB, error) {
+// ConnString builds the Postgres connection string from the environment
+func ConnString() string {
 	// Get database configuration from environment variables
 	host := os.Getenv("DB_HOST")
 	if host == "" {
@@ -36,12 +37,13 @@ func InitDB() (*sql.DB, error) {
 		dbname = "notification_service_db"
 	}
 
-	// Build connection string
-	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
 		host, port, user, password, dbname)
+}
 
+func InitDB() (*sql.DB, error) {
 	// Open database connection
-	db, err := sql.Open("postgres", connStr)
+	db, err := sql.Open("postgres", ConnString())
 	if err != nil {
 		return nil, fmt.Errorf("error opening database: %w", err)
 	}
@@ -73,6 +75,83 @@ func InitDB() (*sql.DB, error) {
 	CREATE INDEX IF NOT EXISTS idx_notifications_user_id ON notifications (user_id);
 	CREATE INDEX IF NOT EXISTS idx_notifications_created_at ON notifications (created_at DESC);
 	CREATE INDEX IF NOT EXISTS idx_notifications_is_read ON notifications (is_read);
+
+	CREATE TABLE IF NOT EXISTS user_mutes (
+		user_id INTEGER NOT NULL,
+		muted_user_id INTEGER NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (user_id, muted_user_id),
+		CHECK (user_id <> muted_user_id)
+	);
+
+	-- Set once a websocket client acknowledges receiving the notification
+	ALTER TABLE notifications ADD COLUMN IF NOT EXISTS delivered_at TIMESTAMP;
+	CREATE INDEX IF NOT EXISTS idx_notifications_user_id_id ON notifications (user_id, id);
+
+	CREATE TABLE IF NOT EXISTS notification_preferences (
+		user_id INTEGER NOT NULL,
+		category VARCHAR(20) NOT NULL CHECK (category IN ('subscription', 'like', 'comment', 'mention', 'upload')),
+		enabled BOOLEAN NOT NULL DEFAULT TRUE,
+		in_app BOOLEAN NOT NULL DEFAULT TRUE,
+		websocket BOOLEAN NOT NULL DEFAULT TRUE,
+		email BOOLEAN NOT NULL DEFAULT FALSE,
+		push BOOLEAN NOT NULL DEFAULT FALSE,
+		PRIMARY KEY (user_id, category)
+	);
+
+	CREATE TABLE IF NOT EXISTS notification_quiet_hours (
+		user_id INTEGER PRIMARY KEY,
+		start_time TIME NOT NULL,
+		end_time TIME NOT NULL,
+		timezone VARCHAR(64) NOT NULL DEFAULT 'UTC'
+	);
+
+	CREATE TABLE IF NOT EXISTS notification_digests (
+		user_id INTEGER PRIMARY KEY,
+		frequency VARCHAR(10) NOT NULL CHECK (frequency IN ('daily', 'weekly')),
+		last_sent_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS email_deliveries (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		notification_id INTEGER REFERENCES notifications(id) ON DELETE SET NULL,
+		kind VARCHAR(20) NOT NULL CHECK (kind IN ('notification', 'digest')),
+		to_address VARCHAR(255) NOT NULL,
+		subject TEXT NOT NULL,
+		text_body TEXT NOT NULL,
+		html_body TEXT NOT NULL,
+		status VARCHAR(10) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'sent', 'failed')),
+		attempts INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT,
+		next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		sent_at TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_email_deliveries_due ON email_deliveries (next_attempt_at) WHERE status = 'pending';
+	CREATE INDEX IF NOT EXISTS idx_notification_digests_last_sent ON notification_digests (last_sent_at);
+
+	CREATE TABLE IF NOT EXISTS push_subscriptions (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		endpoint TEXT NOT NULL UNIQUE,
+		p256dh VARCHAR(128) NOT NULL,
+		auth VARCHAR(64) NOT NULL,
+		user_agent VARCHAR(255),
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		last_used_at TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_push_subscriptions_user_id ON push_subscriptions (user_id);
+
+	ALTER TABLE notifications ADD COLUMN IF NOT EXISTS collapse_key VARCHAR(255);
+	ALTER TABLE notifications ADD COLUMN IF NOT EXISTS group_count INTEGER NOT NULL DEFAULT 1;
+	ALTER TABLE notifications ADD COLUMN IF NOT EXISTS archived_at TIMESTAMP;
+
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_notifications_collapse ON notifications (user_id, collapse_key) WHERE collapse_key IS NOT NULL AND is_read = FALSE;
+	CREATE INDEX IF NOT EXISTS idx_notifications_user_feed ON notifications (user_id, created_at DESC, id DESC);
+	CREATE INDEX IF NOT EXISTS idx_notifications_read_created ON notifications (created_at) WHERE is_read = TRUE;
 	`
 
 	_, err = db.Exec(createTableQuery)
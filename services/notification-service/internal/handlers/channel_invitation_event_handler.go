@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/aung-arata/youtube-clone/services/notification-service/internal/models"
+	"github.com/aung-arata/youtube-clone/services/notification-service/internal/notify"
+)
+
+type ChannelInvitationEventHandler struct {
+	dispatcher *notify.Dispatcher
+}
+
+// NewChannelInvitationEventHandler creates a handler that tells users about
+// invitations to channels' teams through dispatcher
+func NewChannelInvitationEventHandler(dispatcher *notify.Dispatcher) *ChannelInvitationEventHandler {
+	return &ChannelInvitationEventHandler{dispatcher: dispatcher}
+}
+
+// HandleChannelInvitationEvent notifies a user they were invited to a
+// channel's team. Inviting someone again replaces the unread notification.
+func (h *ChannelInvitationEventHandler) HandleChannelInvitationEvent(w http.ResponseWriter, r *http.Request) {
+	var event models.ChannelInvitationEvent
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if event.InvitationID <= 0 || event.ChannelID <= 0 || event.UserID <= 0 || event.Role == "" {
+		http.Error(w, "Missing required fields", http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.dispatcher.Dispatch(r.Context(), models.CreateNotificationRequest{
+		UserID:      event.UserID,
+		Type:        "channel_invitation",
+		Title:       "You were invited to join " + event.ChannelName,
+		Message:     "Join the team as " + event.Role,
+		Link:        "/channel-invitations",
+		CollapseKey: fmt.Sprintf("channel_invitation:%d", event.ChannelID),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"invitation_id": event.InvitationID,
+		"notified":      len(result.Channels) > 0,
+	})
+}
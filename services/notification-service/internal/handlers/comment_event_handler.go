@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aung-arata/youtube-clone/services/notification-service/internal/models"
+	"github.com/aung-arata/youtube-clone/services/notification-service/internal/notify"
+	"github.com/lib/pq"
+)
+
+const (
+	// maxMentionsPerComment caps how many users a single comment can notify
+	maxMentionsPerComment = 10
+	// maxNotificationExcerpt is how much of a comment is quoted in a notification
+	maxNotificationExcerpt = 200
+)
+
+// mentionPattern matches @username at the start of the text or after a
+// character that can't be part of a username (so emails don't match)
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@.])@([\w.-]{1,50})`)
+
+// parseMentions returns the distinct lowercased usernames mentioned in content
+func parseMentions(content string) []string {
+	seen := make(map[string]bool)
+	mentions := []string{}
+
+	for _, match := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		username := strings.ToLower(strings.TrimRight(match[1], ".-"))
+		if username == "" || seen[username] {
+			continue
+		}
+		seen[username] = true
+		mentions = append(mentions, username)
+		if len(mentions) == maxMentionsPerComment {
+			break
+		}
+	}
+	return mentions
+}
+
+// notificationExcerpt shortens comment content for a notification message
+func notificationExcerpt(content string) string {
+	runes := []rune(strings.TrimSpace(content))
+	if len(runes) <= maxNotificationExcerpt {
+		return string(runes)
+	}
+	return string(runes[:maxNotificationExcerpt-1]) + "…"
+}
+
+type CommentEventHandler struct {
+	db             *sql.DB
+	dispatcher     *notify.Dispatcher
+	httpClient     *http.Client
+	userServiceURL string
+}
+
+// NewCommentEventHandler creates a handler that delivers comment
+// notifications through dispatcher
+func NewCommentEventHandler(db *sql.DB, dispatcher *notify.Dispatcher) *CommentEventHandler {
+	userServiceURL := os.Getenv("USER_SERVICE_URL")
+	if userServiceURL == "" {
+		userServiceURL = "http://user-service:8082" // default for docker-compose
+	}
+
+	return &CommentEventHandler{
+		db:             db,
+		dispatcher:     dispatcher,
+		userServiceURL: userServiceURL,
+		httpClient: &http.Client{
+			Timeout: 5 * time.Second, // 5 second timeout for user service calls
+		},
+	}
+}
+
+// lookupUserIDs resolves usernames to user IDs through the user service
+func (h *CommentEventHandler) lookupUserIDs(usernames []string) ([]int, error) {
+	if len(usernames) == 0 {
+		return nil, nil
+	}
+
+	query := url.Values{}
+	for _, username := range usernames {
+		query.Add("username", username)
+	}
+
+	resp, err := h.httpClient.Get(h.userServiceURL + "/users/lookup?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("user service returned %d", resp.StatusCode)
+	}
+
+	var users []struct {
+		ID int `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&users); err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, 0, len(users))
+	for _, u := range users {
+		ids = append(ids, u.ID)
+	}
+	return ids, nil
+}
+
+// HandleCommentEvent notifies the parent comment's author, mentioned users
+// and the video's creator about a published comment. Each user gets at most
+// one notification, preferring reply over mention over new comment. The
+// comment's author is never notified, nor is anyone who has muted them.
+// Replies to one comment, and comments on one video, are grouped until read.
+func (h *CommentEventHandler) HandleCommentEvent(w http.ResponseWriter, r *http.Request) {
+	var event models.CommentEvent
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if event.CommentID <= 0 || event.VideoID <= 0 || event.AuthorID <= 0 || event.AuthorUsername == "" {
+		http.Error(w, "Missing required fields", http.StatusBadRequest)
+		return
+	}
+
+	// Reply and creator notifications still go out if mentions can't be resolved
+	mentionedIDs, err := h.lookupUserIDs(parseMentions(event.Content))
+	if err != nil {
+		log.Printf("Failed to resolve mentions for comment %d: %v", event.CommentID, err)
+	}
+
+	var userIDs []int64
+	var types []string
+	seen := map[int]bool{event.AuthorID: true}
+	add := func(userID int, notificationType string) {
+		if !seen[userID] {
+			seen[userID] = true
+			userIDs = append(userIDs, int64(userID))
+			types = append(types, notificationType)
+		}
+	}
+
+	if event.ParentAuthorID != nil {
+		add(*event.ParentAuthorID, "comment_reply")
+	}
+	for _, id := range mentionedIDs {
+		add(id, "mention")
+	}
+	if event.VideoOwnerID != nil {
+		add(*event.VideoOwnerID, "video_comment")
+	}
+
+	var notified int64
+	if len(userIDs) > 0 {
+		query := `
+			SELECT r.user_id, r.type
+			FROM unnest($1::int[], $2::text[]) AS r(user_id, type)
+			WHERE NOT EXISTS (
+			    SELECT 1 FROM user_mutes m WHERE m.user_id = r.user_id AND m.muted_user_id = $3
+			)
+		`
+		rows, err := h.db.QueryContext(r.Context(), query, pq.Array(userIDs), pq.Array(types), event.AuthorID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		var reqs []models.CreateNotificationRequest
+		for rows.Next() {
+			req := models.CreateNotificationRequest{
+				Message: notificationExcerpt(event.Content),
+				Link:    fmt.Sprintf("/videos/%d?comment=%d", event.VideoID, event.CommentID),
+			}
+			if err := rows.Scan(&req.UserID, &req.Type); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			switch req.Type {
+			case "comment_reply":
+				req.Title = event.AuthorUsername + " replied to your comment"
+				if event.ParentID != nil {
+					req.CollapseKey = fmt.Sprintf("comment_reply:%d", *event.ParentID)
+					req.GroupTitle = "{count} new replies to your comment"
+				}
+			case "mention":
+				req.Title = event.AuthorUsername + " mentioned you in a comment"
+			default:
+				req.Title = event.AuthorUsername + " commented on your video"
+				req.CollapseKey = fmt.Sprintf("video_comment:%d", event.VideoID)
+				req.GroupTitle = "{count} new comments on your video"
+			}
+			reqs = append(reqs, req)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rows.Close()
+
+		// One recipient's failure shouldn't keep the rest from hearing
+		for _, req := range reqs {
+			result, err := h.dispatcher.Dispatch(r.Context(), req)
+			if err != nil {
+				log.Printf("Failed to notify user %d of comment %d: %v", req.UserID, event.CommentID, err)
+				continue
+			}
+			if len(result.Channels) > 0 {
+				notified++
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"comment_id": event.CommentID,
+		"notified":   notified,
+	})
+}
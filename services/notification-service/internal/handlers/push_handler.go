@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/aung-arata/youtube-clone/services/notification-service/internal/models"
+	"github.com/aung-arata/youtube-clone/services/notification-service/internal/webpush"
+)
+
+// maxPushEndpointLength bounds the endpoint URLs browsers register
+const maxPushEndpointLength = 2048
+
+type PushHandler struct {
+	db   *sql.DB
+	keys *webpush.VAPIDKeys
+}
+
+// NewPushHandler creates a handler for web push registration. keys is nil
+// when web push isn't configured.
+func NewPushHandler(db *sql.DB, keys *webpush.VAPIDKeys) *PushHandler {
+	return &PushHandler{db: db, keys: keys}
+}
+
+// GetVAPIDPublicKey returns the key browsers subscribe with
+func (h *PushHandler) GetVAPIDPublicKey(w http.ResponseWriter, r *http.Request) {
+	if h.keys == nil {
+		http.Error(w, "Web push is not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"public_key": h.keys.PublicKey()})
+}
+
+// RegisterSubscription stores a browser's push subscription for the
+// authenticated user. Registering an endpoint again updates its keys and
+// moves it to this user.
+func (h *PushHandler) RegisterSubscription(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authorizeSettingsOwner(w, r)
+	if !ok {
+		return
+	}
+	if h.keys == nil {
+		http.Error(w, "Web push is not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	var sub webpush.Subscription
+	if err := json.NewDecoder(r.Body).Decode(&sub); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Only push services are contacted, and they are always HTTPS
+	endpoint, err := url.Parse(sub.Endpoint)
+	if err != nil || endpoint.Scheme != "https" || endpoint.Host == "" || len(sub.Endpoint) > maxPushEndpointLength {
+		http.Error(w, "Endpoint must be an HTTPS URL", http.StatusBadRequest)
+		return
+	}
+	if err := sub.Keys.Validate(); err != nil {
+		http.Error(w, "Invalid subscription keys", http.StatusBadRequest)
+		return
+	}
+
+	userAgent := r.UserAgent()
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+
+	query := `
+		INSERT INTO push_subscriptions (user_id, endpoint, p256dh, auth, user_agent)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (endpoint) DO UPDATE
+		SET user_id = EXCLUDED.user_id,
+		    p256dh = EXCLUDED.p256dh,
+		    auth = EXCLUDED.auth,
+		    user_agent = EXCLUDED.user_agent
+		RETURNING id, created_at
+	`
+
+	result := models.PushSubscription{UserID: userID, Endpoint: sub.Endpoint, UserAgent: userAgent}
+	err = h.db.QueryRow(query, userID, sub.Endpoint, sub.Keys.P256dh, sub.Keys.Auth,
+		sql.NullString{String: userAgent, Valid: userAgent != ""}).
+		Scan(&result.ID, &result.CreatedAt)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(result)
+}
+
+// UnregisterSubscription removes one of the authenticated user's browsers,
+// identified by its endpoint
+func (h *PushHandler) UnregisterSubscription(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authorizeSettingsOwner(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Endpoint string `json:"endpoint"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Endpoint == "" {
+		http.Error(w, "Endpoint is required", http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.db.Exec(`DELETE FROM push_subscriptions WHERE user_id = $1 AND endpoint = $2`, userID, req.Endpoint)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		http.Error(w, "Push subscription not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aung-arata/youtube-clone/services/notification-service/internal/auth"
+	"github.com/aung-arata/youtube-clone/services/notification-service/internal/models"
+	"github.com/aung-arata/youtube-clone/services/notification-service/internal/notify"
+)
+
+// subscriberFanoutTimeout bounds telling a channel's subscribers about an
+// upload, which runs after the event has been accepted
+const subscriberFanoutTimeout = 10 * time.Minute
+
+type VideoEventHandler struct {
+	dispatcher     *notify.Dispatcher
+	httpClient     *http.Client
+	userServiceURL string
+}
+
+// NewVideoEventHandler creates a handler that tells subscribers about new
+// uploads through dispatcher
+func NewVideoEventHandler(dispatcher *notify.Dispatcher) *VideoEventHandler {
+	userServiceURL := os.Getenv("USER_SERVICE_URL")
+	if userServiceURL == "" {
+		userServiceURL = "http://user-service:8082" // default for docker-compose
+	}
+
+	return &VideoEventHandler{
+		dispatcher:     dispatcher,
+		userServiceURL: userServiceURL,
+		httpClient: &http.Client{
+			Timeout: 5 * time.Second, // 5 second timeout for user service calls
+		},
+	}
+}
+
+// lookupSubscribers asks the user service which of a channel's subscribers
+// want to hear about its uploads
+func (h *VideoEventHandler) lookupSubscribers(ctx context.Context, channelID int) ([]int, error) {
+	url := fmt.Sprintf("%s/internal/channels/%d/subscribers", h.userServiceURL, channelID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	auth.SetServiceToken(req)
+
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("user service returned %d", resp.StatusCode)
+	}
+
+	var userIDs []int
+	if err := json.NewDecoder(resp.Body).Decode(&userIDs); err != nil {
+		return nil, err
+	}
+	return userIDs, nil
+}
+
+// notifySubscribers tells every subscriber whose bell setting asks for it
+// about a new upload. Uploads from the same channel are grouped until they're
+// read.
+func (h *VideoEventHandler) notifySubscribers(ctx context.Context, event models.VideoEvent) error {
+	userIDs, err := h.lookupSubscribers(ctx, event.ChannelID)
+	if err != nil {
+		return err
+	}
+
+	for _, userID := range userIDs {
+		_, err := h.dispatcher.Dispatch(ctx, models.CreateNotificationRequest{
+			UserID:      userID,
+			Type:        "new_video",
+			Title:       event.ChannelName + " uploaded a new video",
+			Message:     event.Title,
+			Link:        fmt.Sprintf("/videos/%d", event.VideoID),
+			CollapseKey: fmt.Sprintf("new_video:%d", event.ChannelID),
+			GroupTitle:  event.ChannelName + " uploaded {count} new videos",
+		})
+		// One subscriber's failure shouldn't keep the rest from hearing
+		if err != nil {
+			log.Printf("Failed to notify user %d of video %d: %v", userID, event.VideoID, err)
+		}
+	}
+	return nil
+}
+
+// HandleVideoEvent accepts a new upload and notifies the channel's
+// subscribers in the background, so that a channel with many subscribers
+// doesn't hold up the video service and its request timing out doesn't cut
+// the fan-out short. Failures are only logged.
+func (h *VideoEventHandler) HandleVideoEvent(w http.ResponseWriter, r *http.Request) {
+	var event models.VideoEvent
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if event.VideoID <= 0 || event.ChannelID <= 0 {
+		http.Error(w, "Missing required fields", http.StatusBadRequest)
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), subscriberFanoutTimeout)
+		defer cancel()
+
+		if err := h.notifySubscribers(ctx, event); err != nil {
+			log.Printf("Failed to notify subscribers of video %d: %v", event.VideoID, err)
+		}
+	}()
+
+	w.WriteHeader(http.StatusAccepted)
+}
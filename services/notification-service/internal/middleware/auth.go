@@ -0,0 +1,295 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/aung-arata/youtube-clone/services/notification-service/internal/auth"
+	"github.com/gorilla/mux"
+)
+
+// ContextKey is a custom type for context keys
+type ContextKey string
+
+const (
+	// UserIDKey is the context key for user ID
+	UserIDKey ContextKey = "user_id"
+	// UsernameKey is the context key for username
+	UsernameKey ContextKey = "username"
+	// UserRoleKey is the context key for user role
+	UserRoleKey ContextKey = "role"
+	// SessionIDKey is the context key for the login session the token belongs to
+	SessionIDKey ContextKey = "session_id"
+	// MFAKey is the context key for whether the login passed two-factor authentication
+	MFAKey ContextKey = "mfa"
+	// EmailVerifiedKey is the context key for whether the user verified their email
+	EmailVerifiedKey ContextKey = "email_verified"
+	// ScopesKey is the context key for the scopes of the API key a request
+	// was made with. It is unset for access tokens, which may do anything.
+	ScopesKey ContextKey = "scopes"
+)
+
+// AuthMiddleware validates JWT tokens, and API keys on routes wrapped with
+// RequireScope
+func AuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Get token from Authorization header
+		authHeader := r.Header.Get("Authorization")
+		if authHeader == "" {
+			http.Error(w, "Authorization header required", http.StatusUnauthorized)
+			return
+		}
+
+		// Check if it's a Bearer token
+		parts := strings.Split(authHeader, " ")
+		if len(parts) != 2 || parts[0] != "Bearer" {
+			http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
+			return
+		}
+
+		tokenString := parts[1]
+
+		if auth.IsAPIKey(tokenString) {
+			ctx, ok := authenticateAPIKey(w, r, tokenString)
+			if ok {
+				next.ServeHTTP(w, r.WithContext(ctx))
+			}
+			return
+		}
+
+		// Validate token
+		claims, err := auth.ValidateToken(tokenString)
+		if err != nil {
+			http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+			return
+		}
+
+		// Reject tokens whose session was logged out
+		if err := auth.CheckSession(r.Context(), claims); errors.Is(err, auth.ErrSessionRevoked) {
+			http.Error(w, "Session has been revoked", http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			log.Printf("Failed to check session: %v", err)
+			http.Error(w, "Unable to verify session", http.StatusServiceUnavailable)
+			return
+		}
+
+		// Add user info to context
+		ctx := context.WithValue(r.Context(), UserIDKey, claims.UserID)
+		ctx = context.WithValue(ctx, UsernameKey, claims.Username)
+		ctx = context.WithValue(ctx, UserRoleKey, claims.Role)
+		ctx = context.WithValue(ctx, SessionIDKey, claims.SessionID)
+		ctx = context.WithValue(ctx, MFAKey, claims.MFA)
+		ctx = context.WithValue(ctx, EmailVerifiedKey, claims.EmailVerified)
+
+		// Call next handler with updated context
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// authenticateAPIKey checks an API key against the scope its route needs and
+// returns the context of the user it acts for. It writes the error response
+// itself.
+func authenticateAPIKey(w http.ResponseWriter, r *http.Request, key string) (context.Context, bool) {
+	// API keys only work where a route names the scope they need
+	scope := routeScope(r)
+	if scope == "" {
+		http.Error(w, "API keys can't be used for this endpoint", http.StatusForbidden)
+		return nil, false
+	}
+
+	principal, err := auth.VerifyAPIKey(r.Context(), key)
+	if errors.Is(err, auth.ErrInvalidAPIKey) {
+		http.Error(w, "Invalid or expired API key", http.StatusUnauthorized)
+		return nil, false
+	} else if err != nil {
+		log.Printf("Failed to verify API key: %v", err)
+		http.Error(w, "Unable to verify API key", http.StatusServiceUnavailable)
+		return nil, false
+	}
+	if !principal.HasScope(scope) {
+		http.Error(w, fmt.Sprintf("API key lacks the %s scope", scope), http.StatusForbidden)
+		return nil, false
+	}
+
+	ctx := context.WithValue(r.Context(), UserIDKey, principal.UserID)
+	ctx = context.WithValue(ctx, UsernameKey, principal.Username)
+	ctx = context.WithValue(ctx, UserRoleKey, principal.Role)
+	ctx = context.WithValue(ctx, MFAKey, false)
+	ctx = context.WithValue(ctx, EmailVerifiedKey, principal.EmailVerified)
+	ctx = context.WithValue(ctx, ScopesKey, principal.Scopes)
+	return ctx, true
+}
+
+// OptionalAuthMiddleware validates JWT tokens but doesn't require them
+func OptionalAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Get token from Authorization header
+		authHeader := r.Header.Get("Authorization")
+		if authHeader == "" {
+			// No token, continue without auth
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Check if it's a Bearer token
+		parts := strings.Split(authHeader, " ")
+		if len(parts) != 2 || parts[0] != "Bearer" {
+			// Invalid format, continue without auth
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		tokenString := parts[1]
+
+		// Validate token
+		claims, err := auth.ValidateToken(tokenString)
+		if err == nil {
+			err = auth.CheckSession(r.Context(), claims)
+		}
+		if err != nil {
+			// Invalid token or revoked session, continue without auth
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Add user info to context
+		ctx := context.WithValue(r.Context(), UserIDKey, claims.UserID)
+		ctx = context.WithValue(ctx, UsernameKey, claims.Username)
+		ctx = context.WithValue(ctx, UserRoleKey, claims.Role)
+		ctx = context.WithValue(ctx, SessionIDKey, claims.SessionID)
+		ctx = context.WithValue(ctx, MFAKey, claims.MFA)
+		ctx = context.WithValue(ctx, EmailVerifiedKey, claims.EmailVerified)
+
+		// Call next handler with updated context
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequirePermission ensures the user's role grants perm. Staff permissions
+// also need a login with two-factor authentication. It must run after
+// AuthMiddleware.
+func RequirePermission(perm auth.Permission, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		role, _ := r.Context().Value(UserRoleKey).(string)
+		if !auth.HasPermission(role, perm) {
+			http.Error(w, fmt.Sprintf("Your role doesn't allow %s", perm), http.StatusForbidden)
+			return
+		}
+		if mfa, _ := r.Context().Value(MFAKey).(bool); auth.PermissionRequiresMFA(perm) && !mfa {
+			http.Error(w, "Two-factor authentication is required for staff access", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Protect requires a login whose role grants perm
+func Protect(perm auth.Permission, next http.Handler) http.Handler {
+	return AuthMiddleware(RequirePermission(perm, next))
+}
+
+// HasPermission reports whether the authenticated user may use perm, as
+// RequirePermission decides
+func HasPermission(ctx context.Context, perm auth.Permission) bool {
+	role, _ := ctx.Value(UserRoleKey).(string)
+	mfa, _ := ctx.Value(MFAKey).(bool)
+	return auth.HasPermission(role, perm) && (mfa || !auth.PermissionRequiresMFA(perm))
+}
+
+// RequireSelf ensures the user ID in the route variable param is the
+// authenticated user's, unless their role may manage users. It must run
+// after AuthMiddleware.
+func RequireSelf(param string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := r.Context().Value(UserIDKey).(int)
+		if mux.Vars(r)[param] != strconv.Itoa(userID) && !HasPermission(r.Context(), auth.PermManageUsers) {
+			http.Error(w, "You can only change your own account", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireVerifiedEmail ensures the user verified their email address. It
+// must run after AuthMiddleware.
+func RequireVerifiedEmail(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if verified, _ := r.Context().Value(EmailVerifiedKey).(bool); !verified {
+			http.Error(w, "Verify your email address first", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireServiceToken keeps internal endpoints to other services of the
+// deployment, which present the shared service token
+func RequireServiceToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Not 401, which callers take as an answer about the key or session
+		// they asked about
+		if !auth.ValidServiceToken(r) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// scopedHandler is a handler that API keys with scope may call
+type scopedHandler struct {
+	scope string
+	next  http.Handler
+}
+
+func (h scopedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !HasScope(r.Context(), h.scope) {
+		http.Error(w, fmt.Sprintf("API key lacks the %s scope", h.scope), http.StatusForbidden)
+		return
+	}
+	h.next.ServeHTTP(w, r)
+}
+
+// RequireScope lets API keys with scope call next. It must be the outermost
+// wrapper of a route's handler, where AuthMiddleware looks for it; routes
+// without it only accept access tokens.
+func RequireScope(scope string, next http.Handler) http.Handler {
+	return scopedHandler{scope: scope, next: next}
+}
+
+// routeScope returns the scope the matched route accepts API keys with
+func routeScope(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return ""
+	}
+	if h, ok := route.GetHandler().(scopedHandler); ok {
+		return h.scope
+	}
+	return ""
+}
+
+// HasScope reports whether a request may do what scope allows. Requests made
+// with access tokens may do anything.
+func HasScope(ctx context.Context, scope string) bool {
+	scopes, ok := ctx.Value(ScopesKey).([]string)
+	if !ok {
+		return true
+	}
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -59,5 +59,180 @@ func GetAllMigrations() []Migration {
 				return err
 			},
 		},
+		{
+			Version:     3,
+			Name:        "add_user_mutes",
+			Description: "Creates the user_mutes table used to suppress comment notifications from muted users",
+			Up: func(db *sql.DB) error {
+				query := `
+				CREATE TABLE IF NOT EXISTS user_mutes (
+					user_id INTEGER NOT NULL,
+					muted_user_id INTEGER NOT NULL,
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					PRIMARY KEY (user_id, muted_user_id),
+					CHECK (user_id <> muted_user_id)
+				);
+				`
+				_, err := db.Exec(query)
+				return err
+			},
+			Down: func(db *sql.DB) error {
+				_, err := db.Exec("DROP TABLE IF EXISTS user_mutes CASCADE")
+				return err
+			},
+		},
+		{
+			Version:     4,
+			Name:        "add_notification_delivered_at",
+			Description: "Records when a websocket client acknowledged a notification and indexes notifications for resuming by ID",
+			Up: func(db *sql.DB) error {
+				query := `
+				ALTER TABLE notifications ADD COLUMN IF NOT EXISTS delivered_at TIMESTAMP;
+				CREATE INDEX IF NOT EXISTS idx_notifications_user_id_id ON notifications (user_id, id);
+				`
+				_, err := db.Exec(query)
+				return err
+			},
+			Down: func(db *sql.DB) error {
+				_, err := db.Exec(`
+				DROP INDEX IF EXISTS idx_notifications_user_id_id;
+				ALTER TABLE notifications DROP COLUMN IF EXISTS delivered_at;
+				`)
+				return err
+			},
+		},
+		{
+			Version:     5,
+			Name:        "create_notification_preferences",
+			Description: "Stores per-category notification channels and quiet hours",
+			Up: func(db *sql.DB) error {
+				query := `
+				CREATE TABLE IF NOT EXISTS notification_preferences (
+					user_id INTEGER NOT NULL,
+					category VARCHAR(20) NOT NULL CHECK (category IN ('subscription', 'like', 'comment', 'mention', 'upload')),
+					enabled BOOLEAN NOT NULL DEFAULT TRUE,
+					in_app BOOLEAN NOT NULL DEFAULT TRUE,
+					websocket BOOLEAN NOT NULL DEFAULT TRUE,
+					email BOOLEAN NOT NULL DEFAULT FALSE,
+					push BOOLEAN NOT NULL DEFAULT FALSE,
+					PRIMARY KEY (user_id, category)
+				);
+				CREATE TABLE IF NOT EXISTS notification_quiet_hours (
+					user_id INTEGER PRIMARY KEY,
+					start_time TIME NOT NULL,
+					end_time TIME NOT NULL,
+					timezone VARCHAR(64) NOT NULL DEFAULT 'UTC'
+				);
+				`
+				_, err := db.Exec(query)
+				return err
+			},
+			Down: func(db *sql.DB) error {
+				_, err := db.Exec(`
+				DROP TABLE IF EXISTS notification_quiet_hours;
+				DROP TABLE IF EXISTS notification_preferences;
+				`)
+				return err
+			},
+		},
+		{
+			Version:     6,
+			Name:        "create_email_deliveries",
+			Description: "Adds notification digest settings and an outbox recording email delivery attempts",
+			Up: func(db *sql.DB) error {
+				query := `
+				CREATE TABLE IF NOT EXISTS notification_digests (
+					user_id INTEGER PRIMARY KEY,
+					frequency VARCHAR(10) NOT NULL CHECK (frequency IN ('daily', 'weekly')),
+					last_sent_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+				);
+
+				CREATE TABLE IF NOT EXISTS email_deliveries (
+					id SERIAL PRIMARY KEY,
+					user_id INTEGER NOT NULL,
+					notification_id INTEGER REFERENCES notifications(id) ON DELETE SET NULL,
+					kind VARCHAR(20) NOT NULL CHECK (kind IN ('notification', 'digest')),
+					to_address VARCHAR(255) NOT NULL,
+					subject TEXT NOT NULL,
+					text_body TEXT NOT NULL,
+					html_body TEXT NOT NULL,
+					status VARCHAR(10) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'sent', 'failed')),
+					attempts INTEGER NOT NULL DEFAULT 0,
+					last_error TEXT,
+					next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					sent_at TIMESTAMP
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_email_deliveries_due ON email_deliveries (next_attempt_at) WHERE status = 'pending';
+				CREATE INDEX IF NOT EXISTS idx_notification_digests_last_sent ON notification_digests (last_sent_at);
+				`
+				_, err := db.Exec(query)
+				return err
+			},
+			Down: func(db *sql.DB) error {
+				_, err := db.Exec(`
+				DROP TABLE IF EXISTS email_deliveries;
+				DROP TABLE IF EXISTS notification_digests;
+				`)
+				return err
+			},
+		},
+		{
+			Version:     7,
+			Name:        "create_push_subscriptions",
+			Description: "Stores browsers registered for web push notifications",
+			Up: func(db *sql.DB) error {
+				query := `
+				CREATE TABLE IF NOT EXISTS push_subscriptions (
+					id SERIAL PRIMARY KEY,
+					user_id INTEGER NOT NULL,
+					endpoint TEXT NOT NULL UNIQUE,
+					p256dh VARCHAR(128) NOT NULL,
+					auth VARCHAR(64) NOT NULL,
+					user_agent VARCHAR(255),
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					last_used_at TIMESTAMP
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_push_subscriptions_user_id ON push_subscriptions (user_id);
+				`
+				_, err := db.Exec(query)
+				return err
+			},
+			Down: func(db *sql.DB) error {
+				_, err := db.Exec("DROP TABLE IF EXISTS push_subscriptions")
+				return err
+			},
+		},
+		{
+			Version:     8,
+			Name:        "add_notification_grouping_and_archive",
+			Description: "Adds collapse keys, group counts and archiving to notifications, with indexes for paging and retention",
+			Up: func(db *sql.DB) error {
+				query := `
+				ALTER TABLE notifications ADD COLUMN IF NOT EXISTS collapse_key VARCHAR(255);
+				ALTER TABLE notifications ADD COLUMN IF NOT EXISTS group_count INTEGER NOT NULL DEFAULT 1;
+				ALTER TABLE notifications ADD COLUMN IF NOT EXISTS archived_at TIMESTAMP;
+
+				CREATE UNIQUE INDEX IF NOT EXISTS idx_notifications_collapse ON notifications (user_id, collapse_key) WHERE collapse_key IS NOT NULL AND is_read = FALSE;
+				CREATE INDEX IF NOT EXISTS idx_notifications_user_feed ON notifications (user_id, created_at DESC, id DESC);
+				CREATE INDEX IF NOT EXISTS idx_notifications_read_created ON notifications (created_at) WHERE is_read = TRUE;
+				`
+				_, err := db.Exec(query)
+				return err
+			},
+			Down: func(db *sql.DB) error {
+				_, err := db.Exec(`
+				DROP INDEX IF EXISTS idx_notifications_read_created;
+				DROP INDEX IF EXISTS idx_notifications_user_feed;
+				DROP INDEX IF EXISTS idx_notifications_collapse;
+				ALTER TABLE notifications DROP COLUMN IF EXISTS archived_at;
+				ALTER TABLE notifications DROP COLUMN IF EXISTS group_count;
+				ALTER TABLE notifications DROP COLUMN IF EXISTS collapse_key;
+				`)
+				return err
+			},
+		},
 	}
 }
@@ -0,0 +1,171 @@
+package notify
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	// digestBatchSize is how many due digests one pass handles
+	digestBatchSize = 100
+
+	// digestListLimit is how many notifications a digest lists by name
+	digestListLimit = 20
+)
+
+// DigestScheduler emails users who chose daily or weekly digests a summary
+// of the notifications they haven't read since their last one
+type DigestScheduler struct {
+	db         *sql.DB
+	outbox     *EmailOutbox
+	recipients Recipients
+	baseURL    string
+	now        func() time.Time
+}
+
+// NewDigestScheduler creates a scheduler that queues digests on outbox
+func NewDigestScheduler(db *sql.DB, outbox *EmailOutbox, recipients Recipients, baseURL string) *DigestScheduler {
+	return &DigestScheduler{
+		db:         db,
+		outbox:     outbox,
+		recipients: recipients,
+		baseURL:    baseURL,
+		now:        time.Now,
+	}
+}
+
+// Run queues due digests every interval until ctx is done
+func (s *DigestScheduler) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.SendDue(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to send notification digests: %v", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// SendDue queues a digest for every user whose period has passed and
+// returns how many were queued. Users with nothing unread get no email, and
+// users in their quiet hours are tried again on a later pass.
+func (s *DigestScheduler) SendDue(ctx context.Context) (int, error) {
+	query := `
+		SELECT user_id, frequency
+		FROM notification_digests
+		WHERE last_sent_at <= CURRENT_TIMESTAMP - CASE frequency
+		                                             WHEN 'weekly' THEN INTERVAL '7 days'
+		                                             ELSE INTERVAL '1 day'
+		                                         END
+		ORDER BY last_sent_at
+		LIMIT $1
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, digestBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	type due struct {
+		userID    int
+		frequency string
+	}
+	var users []due
+	for rows.Next() {
+		var d due
+		if err := rows.Scan(&d.userID, &d.frequency); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		users = append(users, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	queued := 0
+	for _, u := range users {
+		sent, err := s.send(ctx, u.userID, u.frequency)
+		if err != nil {
+			log.Printf("Failed to send digest to user %d: %v", u.userID, err)
+			continue
+		}
+		if sent {
+			queued++
+		}
+	}
+	return queued, nil
+}
+
+// send queues one user's digest and starts their next period
+func (s *DigestScheduler) send(ctx context.Context, userID int, frequency string) (bool, error) {
+	prefs, err := LoadPreferences(ctx, s.db, userID)
+	if err != nil {
+		return false, err
+	}
+	if InQuietHours(prefs.QuietHours, s.now()) {
+		return false, nil
+	}
+
+	query := `
+		SELECT title, message, link, COUNT(*) OVER ()
+		FROM notifications
+		WHERE user_id = $1 AND is_read = FALSE
+		  AND created_at > (SELECT last_sent_at FROM notification_digests WHERE user_id = $1)
+		ORDER BY created_at DESC
+		LIMIT $2
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, userID, digestListLimit)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	data := digestEmail{Period: frequency, Link: absoluteLink(s.baseURL, "/"), AppLink: absoluteLink(s.baseURL, "/")}
+	for rows.Next() {
+		var item digestItem
+		var link sql.NullString
+		if err := rows.Scan(&item.Title, &item.Message, &link, &data.Count); err != nil {
+			return false, err
+		}
+		item.Link = absoluteLink(s.baseURL, link.String)
+		data.Notifications = append(data.Notifications, item)
+	}
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+	data.More = data.Count - len(data.Notifications)
+
+	if data.Count > 0 {
+		to, err := s.recipients.Lookup(ctx, userID)
+		if err != nil {
+			return false, err
+		}
+		if to.Email == "" {
+			return false, fmt.Errorf("user %d has no email address", userID)
+		}
+		data.Username = to.Username
+
+		msg, err := renderDigestEmail(to, data)
+		if err != nil {
+			return false, err
+		}
+		if err := s.outbox.Enqueue(ctx, userID, 0, EmailKindDigest, msg); err != nil {
+			return false, err
+		}
+	}
+
+	_, err = s.db.ExecContext(ctx, `UPDATE notification_digests SET last_sent_at = CURRENT_TIMESTAMP WHERE user_id = $1`, userID)
+	return data.Count > 0, err
+}
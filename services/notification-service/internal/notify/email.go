@@ -0,0 +1,183 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// EmailMessage is a rendered email with text and HTML alternatives
+type EmailMessage struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer sends email
+type Mailer interface {
+	Send(ctx context.Context, msg EmailMessage) error
+}
+
+// SMTPConfig says how to reach the SMTP server. Username may be empty for
+// servers that don't require authentication.
+type SMTPConfig struct {
+	Addr     string // host:port
+	From     string
+	Username string
+	Password string
+}
+
+// SMTPMailer sends email through an SMTP server, upgrading to TLS when the
+// server offers STARTTLS
+type SMTPMailer struct {
+	config SMTPConfig
+}
+
+// NewSMTPMailer creates a mailer for config
+func NewSMTPMailer(config SMTPConfig) *SMTPMailer {
+	return &SMTPMailer{config: config}
+}
+
+// Send delivers msg, giving up when ctx is done
+func (m *SMTPMailer) Send(ctx context.Context, msg EmailMessage) error {
+	from, err := mail.ParseAddress(m.config.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender address: %w", err)
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("invalid recipient address: %w", err)
+	}
+	body, err := buildMessage(from, to, msg)
+	if err != nil {
+		return err
+	}
+
+	host, _, err := net.SplitHostPort(m.config.Addr)
+	if err != nil {
+		return err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", m.config.Addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if m.config.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", m.config.Username, m.config.Password, host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to.Address); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// buildMessage encodes msg as a multipart/alternative MIME message
+func buildMessage(from, to *mail.Address, msg EmailMessage) ([]byte, error) {
+	if msg.Text == "" && msg.HTML == "" {
+		return nil, errors.New("email has no body")
+	}
+
+	var buf bytes.Buffer
+	body := multipart.NewWriter(&buf)
+
+	fmt.Fprintf(&buf, "From: %s\r\n", from.String())
+	fmt.Fprintf(&buf, "To: %s\r\n", to.String())
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", headerSafe(msg.Subject)))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: %s\r\n", messageID(from))
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", body.Boundary())
+
+	// Clients show the last alternative they understand, so HTML goes last
+	parts := []struct{ contentType, content string }{
+		{"text/plain", msg.Text},
+		{"text/html", msg.HTML},
+	}
+	for _, p := range parts {
+		if p.content == "" {
+			continue
+		}
+		w, err := body.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {p.contentType + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(p.content)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := body.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// headerSafe keeps user-supplied text from starting a new header line
+func headerSafe(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
+
+func messageID(from *mail.Address) string {
+	b := make([]byte, 12)
+	rand.Read(b)
+
+	domain := "localhost"
+	if at := strings.LastIndexByte(from.Address, '@'); at >= 0 {
+		domain = from.Address[at+1:]
+	}
+	return "<" + hex.EncodeToString(b) + "@" + domain + ">"
+}
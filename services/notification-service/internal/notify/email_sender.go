@@ -0,0 +1,47 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aung-arata/youtube-clone/services/notification-service/internal/models"
+)
+
+// Recipients looks up where to email a user
+type Recipients interface {
+	Lookup(ctx context.Context, userID int) (Recipient, error)
+}
+
+// EmailSender delivers notifications by email. It renders the email and
+// queues it on the outbox, which does the sending and retrying.
+type EmailSender struct {
+	outbox     *EmailOutbox
+	recipients Recipients
+	baseURL    string
+}
+
+// NewEmailSender creates an email sender. baseURL is the web app's address,
+// used to make notification links absolute.
+func NewEmailSender(outbox *EmailOutbox, recipients Recipients, baseURL string) *EmailSender {
+	return &EmailSender{outbox: outbox, recipients: recipients, baseURL: baseURL}
+}
+
+func (s *EmailSender) Channel() Channel {
+	return ChannelEmail
+}
+
+func (s *EmailSender) Send(ctx context.Context, n models.Notification) error {
+	to, err := s.recipients.Lookup(ctx, n.UserID)
+	if err != nil {
+		return err
+	}
+	if to.Email == "" {
+		return fmt.Errorf("user %d has no email address", n.UserID)
+	}
+
+	msg, err := RenderNotificationEmail(to, n, s.baseURL)
+	if err != nil {
+		return err
+	}
+	return s.outbox.Enqueue(ctx, n.UserID, n.ID, EmailKindNotification, msg)
+}
@@ -0,0 +1,171 @@
+package notify
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+)
+
+const (
+	// maxEmailAttempts is how many times an email is tried before it is
+	// marked failed
+	maxEmailAttempts = 5
+
+	// emailBatchSize is how many due emails one pass sends
+	emailBatchSize = 50
+
+	// emailSendTimeout bounds a single SMTP delivery
+	emailSendTimeout = 30 * time.Second
+
+	// emailClaimLease is how long a claimed email is hidden from other
+	// workers; one that crashes mid-send is retried after it
+	emailClaimLease = 5 * time.Minute
+)
+
+// Kinds of email delivery
+const (
+	EmailKindNotification = "notification"
+	EmailKindDigest       = "digest"
+)
+
+// emailBackoff returns how long to wait before retrying after the given
+// number of failed attempts: 1, 4, 16 and then 64 minutes
+func emailBackoff(attempts int) time.Duration {
+	delay := time.Minute
+	for i := 1; i < attempts; i++ {
+		delay *= 4
+	}
+	return delay
+}
+
+// EmailOutbox queues email in the email_deliveries table and sends it in
+// the background, retrying failures with backoff. Every attempt is recorded
+// on the delivery's row.
+type EmailOutbox struct {
+	db     *sql.DB
+	mailer Mailer
+}
+
+// NewEmailOutbox creates an outbox that sends through mailer
+func NewEmailOutbox(db *sql.DB, mailer Mailer) *EmailOutbox {
+	return &EmailOutbox{db: db, mailer: mailer}
+}
+
+// Enqueue records an email to send. notificationID is 0 for email that
+// isn't about a stored notification.
+func (o *EmailOutbox) Enqueue(ctx context.Context, userID, notificationID int, kind string, msg EmailMessage) error {
+	query := `
+		INSERT INTO email_deliveries (user_id, notification_id, kind, to_address, subject, text_body, html_body)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+	_, err := o.db.ExecContext(ctx, query, userID, sql.NullInt64{Int64: int64(notificationID), Valid: notificationID != 0},
+		kind, msg.To, msg.Subject, msg.Text, msg.HTML)
+	return err
+}
+
+// Run sends due email every interval until ctx is done
+func (o *EmailOutbox) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := o.SendDue(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to send queued email: %v", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+type emailDelivery struct {
+	id       int
+	attempts int
+	msg      EmailMessage
+}
+
+// SendDue sends a batch of email whose next attempt is due and returns how
+// many were sent
+func (o *EmailOutbox) SendDue(ctx context.Context) (int, error) {
+	deliveries, err := o.claim(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, d := range deliveries {
+		sendCtx, cancel := context.WithTimeout(ctx, emailSendTimeout)
+		err := o.mailer.Send(sendCtx, d.msg)
+		cancel()
+
+		if err == nil {
+			sent++
+			_, err = o.db.ExecContext(ctx, `
+				UPDATE email_deliveries
+				SET status = 'sent', sent_at = CURRENT_TIMESTAMP, last_error = NULL
+				WHERE id = $1
+			`, d.id)
+			if err != nil {
+				log.Printf("Sent email %d but failed to record it: %v", d.id, err)
+			}
+			continue
+		}
+
+		if d.attempts >= maxEmailAttempts {
+			log.Printf("Giving up on email %d to %s after %d attempts: %v", d.id, d.msg.To, d.attempts, err)
+			_, err = o.db.ExecContext(ctx, `
+				UPDATE email_deliveries SET status = 'failed', last_error = $2 WHERE id = $1
+			`, d.id, err.Error())
+		} else {
+			delay := emailBackoff(d.attempts)
+			log.Printf("Email %d to %s failed (attempt %d), retrying in %s: %v", d.id, d.msg.To, d.attempts, delay, err)
+			_, err = o.db.ExecContext(ctx, `
+				UPDATE email_deliveries
+				SET last_error = $2, next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $3)
+				WHERE id = $1
+			`, d.id, err.Error(), delay.Seconds())
+		}
+		if err != nil {
+			log.Printf("Failed to record attempt for email %d: %v", d.id, err)
+		}
+	}
+	return sent, nil
+}
+
+// claim takes due deliveries, counting the attempt and leasing them so that
+// other instances skip them while they are being sent
+func (o *EmailOutbox) claim(ctx context.Context) ([]emailDelivery, error) {
+	query := `
+		UPDATE email_deliveries
+		SET attempts = attempts + 1,
+		    next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $2)
+		WHERE id IN (
+			SELECT id FROM email_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= CURRENT_TIMESTAMP
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, attempts, to_address, subject, text_body, html_body
+	`
+
+	rows, err := o.db.QueryContext(ctx, query, emailBatchSize, emailClaimLease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []emailDelivery
+	for rows.Next() {
+		var d emailDelivery
+		if err := rows.Scan(&d.id, &d.attempts, &d.msg.To, &d.msg.Subject, &d.msg.Text, &d.msg.HTML); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
@@ -0,0 +1,51 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// UserServiceRecipients looks up email addresses through the user service
+type UserServiceRecipients struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// NewUserServiceRecipients creates recipients backed by the user service at
+// baseURL
+func NewUserServiceRecipients(baseURL string) *UserServiceRecipients {
+	return &UserServiceRecipients{
+		baseURL:    baseURL,
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (r *UserServiceRecipients) Lookup(ctx context.Context, userID int) (Recipient, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.baseURL+"/users/"+strconv.Itoa(userID), nil)
+	if err != nil {
+		return Recipient{}, err
+	}
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return Recipient{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Recipient{}, fmt.Errorf("user service returned %d", resp.StatusCode)
+	}
+
+	var user struct {
+		Username string `json:"username"`
+		Email    string `json:"email"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return Recipient{}, err
+	}
+	return Recipient{Username: user.Username, Email: user.Email}, nil
+}
@@ -0,0 +1,74 @@
+package notify
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+)
+
+// retentionBatchSize is how many notifications one delete removes, so that
+// a large backlog doesn't hold locks for long
+const retentionBatchSize = 1000
+
+// RetentionJob deletes read notifications once they are older than maxAge.
+// Unread ones are kept however old they are.
+type RetentionJob struct {
+	db     *sql.DB
+	maxAge time.Duration
+}
+
+// NewRetentionJob creates a job that purges read notifications older than
+// maxAge
+func NewRetentionJob(db *sql.DB, maxAge time.Duration) *RetentionJob {
+	return &RetentionJob{db: db, maxAge: maxAge}
+}
+
+// Run purges old notifications every interval until ctx is done
+func (j *RetentionJob) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if purged, err := j.Purge(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to purge old notifications: %v", err)
+		} else if purged > 0 {
+			log.Printf("Purged %d read notifications older than %s", purged, j.maxAge)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Purge deletes read notifications older than the job's maximum age in
+// batches and returns how many were deleted
+func (j *RetentionJob) Purge(ctx context.Context) (int64, error) {
+	query := `
+		DELETE FROM notifications
+		WHERE id IN (
+			SELECT id FROM notifications
+			WHERE is_read = TRUE AND created_at < CURRENT_TIMESTAMP - make_interval(secs => $1)
+			LIMIT $2
+		)
+	`
+
+	var total int64
+	for {
+		result, err := j.db.ExecContext(ctx, query, j.maxAge.Seconds(), retentionBatchSize)
+		if err != nil {
+			return total, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if n < retentionBatchSize {
+			return total, nil
+		}
+	}
+}
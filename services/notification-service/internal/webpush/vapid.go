@@ -0,0 +1,127 @@
+// Package webpush sends Web Push messages: VAPID authentication (RFC 8292)
+// and aes128gcm payload encryption (RFC 8291).
+package webpush
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"time"
+)
+
+// vapidTokenLifetime is how long a VAPID token is valid; RFC 8292 allows at
+// most 24 hours
+const vapidTokenLifetime = 12 * time.Hour
+
+var b64 = base64.RawURLEncoding
+
+// VAPIDKeys identify this server to push services
+type VAPIDKeys struct {
+	private *ecdsa.PrivateKey
+	public  []byte // uncompressed P-256 point
+	subject string
+}
+
+// GenerateVAPIDKeys returns a new key pair, base64url encoded as browsers
+// and ParseVAPIDKeys expect
+func GenerateVAPIDKeys() (publicKey, privateKey string, err error) {
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return b64.EncodeToString(key.PublicKey().Bytes()), b64.EncodeToString(key.Bytes()), nil
+}
+
+// ParseVAPIDKeys loads a key pair from GenerateVAPIDKeys. subject is a
+// mailto: or https: contact for the push service's operators.
+func ParseVAPIDKeys(publicKey, privateKey, subject string) (*VAPIDKeys, error) {
+	raw, err := decodeKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VAPID private key: %w", err)
+	}
+	key, err := ecdh.P256().NewPrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VAPID private key: %w", err)
+	}
+
+	public := key.PublicKey().Bytes()
+	if publicKey != "" {
+		given, err := decodeKey(publicKey)
+		if err != nil || string(given) != string(public) {
+			return nil, errors.New("VAPID public key doesn't match the private key")
+		}
+	}
+
+	u, err := url.Parse(subject)
+	if err != nil || (u.Scheme != "mailto" && u.Scheme != "https") {
+		return nil, errors.New("VAPID subject must be a mailto: or https: URL")
+	}
+
+	return &VAPIDKeys{
+		private: &ecdsa.PrivateKey{
+			PublicKey: ecdsa.PublicKey{
+				Curve: elliptic.P256(),
+				X:     new(big.Int).SetBytes(public[1:33]),
+				Y:     new(big.Int).SetBytes(public[33:]),
+			},
+			D: new(big.Int).SetBytes(raw),
+		},
+		public:  public,
+		subject: subject,
+	}, nil
+}
+
+// PublicKey returns the key browsers pass to pushManager.subscribe as
+// applicationServerKey
+func (k *VAPIDKeys) PublicKey() string {
+	return b64.EncodeToString(k.public)
+}
+
+// authorization returns the Authorization header for a push to endpoint
+func (k *VAPIDKeys) authorization(endpoint string, now time.Time) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+
+	header := b64.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": now.Add(vapidTokenLifetime).Unix(),
+		"sub": k.subject,
+	})
+	if err != nil {
+		return "", err
+	}
+	signingInput := header + "." + b64.EncodeToString(claims)
+
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, k.private, digest[:])
+	if err != nil {
+		return "", err
+	}
+	// JWS wants the fixed-width concatenation of r and s, not ASN.1
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+
+	token := signingInput + "." + b64.EncodeToString(sig)
+	return "vapid t=" + token + ", k=" + k.PublicKey(), nil
+}
+
+// decodeKey accepts base64url with or without padding, as browsers and
+// other libraries vary
+func decodeKey(s string) ([]byte, error) {
+	if b, err := b64.DecodeString(s); err == nil {
+		return b, nil
+	}
+	return base64.URLEncoding.DecodeString(s)
+}
@@ -0,0 +1,215 @@
+package websocket
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Broadcaster carries messages between hubs. A message published by any hub
+// is delivered to every subscribed hub, the publisher's included, so that a
+// user reaches all of their connections whichever instance they landed on.
+type Broadcaster interface {
+	// Publish returns ctx's error if the message can't be handed on before
+	// ctx is done
+	Publish(ctx context.Context, msg *BroadcastMessage) error
+	// Subscribe returns a channel of published messages. It is closed by Close.
+	Subscribe() <-chan *BroadcastMessage
+	Close() error
+}
+
+var errBroadcasterClosed = errors.New("broadcaster closed")
+
+// fanout hands each message to every subscriber
+type fanout struct {
+	mu     sync.RWMutex
+	subs   []chan *BroadcastMessage
+	closed bool
+}
+
+func (f *fanout) Subscribe() <-chan *BroadcastMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ch := make(chan *BroadcastMessage, 256)
+	if f.closed {
+		close(ch)
+		return ch
+	}
+	f.subs = append(f.subs, ch)
+	return ch
+}
+
+func (f *fanout) deliver(ctx context.Context, msg *BroadcastMessage) error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if f.closed {
+		return errBroadcasterClosed
+	}
+	for _, ch := range f.subs {
+		select {
+		case ch <- msg:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (f *fanout) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return
+	}
+	f.closed = true
+	for _, ch := range f.subs {
+		close(ch)
+	}
+}
+
+// LocalBroadcaster connects hubs within a single process. It is enough for a
+// single instance; replicas need a PostgresBroadcaster.
+type LocalBroadcaster struct {
+	fanout
+}
+
+// NewLocalBroadcaster creates an in-process broadcaster
+func NewLocalBroadcaster() *LocalBroadcaster {
+	return &LocalBroadcaster{}
+}
+
+func (b *LocalBroadcaster) Publish(ctx context.Context, msg *BroadcastMessage) error {
+	return b.deliver(ctx, msg)
+}
+
+func (b *LocalBroadcaster) Close() error {
+	b.close()
+	return nil
+}
+
+const (
+	// notifyChannel is the Postgres channel hubs exchange messages on
+	notifyChannel = "ws_notifications"
+
+	// maxNotifyPayload is Postgres' limit on a NOTIFY payload, less one byte
+	maxNotifyPayload = 7999
+
+	// listenerPingInterval is how long the listener may sit idle before it
+	// pings the server to detect a silently dropped connection
+	listenerPingInterval = 90 * time.Second
+)
+
+// envelope is how a BroadcastMessage travels through NOTIFY
+type envelope struct {
+	UserID  int             `json:"user_id"`
+	Type    string          `json:"type"`
+	Message json.RawMessage `json:"message"`
+}
+
+// PostgresBroadcaster connects hubs across instances through Postgres
+// LISTEN/NOTIFY. Messages published while an instance's listener is
+// disconnected are not replayed to it; it reconnects with backoff.
+type PostgresBroadcaster struct {
+	fanout
+	db        *sql.DB
+	listener  *pq.Listener
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewPostgresBroadcaster publishes through db and listens on a dedicated
+// connection opened with connStr
+func NewPostgresBroadcaster(db *sql.DB, connStr string) (*PostgresBroadcaster, error) {
+	b := &PostgresBroadcaster{
+		db:   db,
+		done: make(chan struct{}),
+	}
+
+	b.listener = pq.NewListener(connStr, time.Second, time.Minute, logListenerEvent)
+	if err := b.listener.Listen(notifyChannel); err != nil {
+		b.listener.Close()
+		return nil, err
+	}
+
+	go b.listen()
+	return b, nil
+}
+
+func logListenerEvent(event pq.ListenerEventType, err error) {
+	switch event {
+	case pq.ListenerEventDisconnected:
+		log.Printf("Notification listener disconnected: %v", err)
+	case pq.ListenerEventReconnected:
+		log.Printf("Notification listener reconnected")
+	case pq.ListenerEventConnectionAttemptFailed:
+		log.Printf("Notification listener failed to reconnect: %v", err)
+	}
+}
+
+func (b *PostgresBroadcaster) Publish(ctx context.Context, msg *BroadcastMessage) error {
+	payload, err := json.Marshal(envelope{UserID: msg.UserID, Type: msg.Type, Message: msg.Message})
+	if err != nil {
+		return err
+	}
+	if len(payload) > maxNotifyPayload {
+		return fmt.Errorf("message of %d bytes exceeds the NOTIFY limit", len(payload))
+	}
+
+	_, err = b.db.ExecContext(ctx, "SELECT pg_notify($1, $2)", notifyChannel, string(payload))
+	return err
+}
+
+// listen relays notifications to subscribers until Close
+func (b *PostgresBroadcaster) listen() {
+	ticker := time.NewTicker(listenerPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case n := <-b.listener.Notify:
+			if n == nil {
+				// pq sends nil after re-establishing the connection
+				log.Printf("Notification listener resumed; messages sent while disconnected were missed")
+				continue
+			}
+
+			var env envelope
+			if err := json.Unmarshal([]byte(n.Extra), &env); err != nil {
+				log.Printf("Ignoring malformed notification payload: %v", err)
+				continue
+			}
+			msg := &BroadcastMessage{UserID: env.UserID, Type: env.Type, Message: env.Message}
+			if err := b.deliver(context.Background(), msg); err != nil {
+				return
+			}
+
+		case <-ticker.C:
+			if err := b.listener.Ping(); err != nil {
+				log.Printf("Notification listener ping failed: %v", err)
+			}
+
+		case <-b.done:
+			return
+		}
+	}
+}
+
+func (b *PostgresBroadcaster) Close() error {
+	err := errBroadcasterClosed
+	b.closeOnce.Do(func() {
+		close(b.done)
+		err = b.listener.Close()
+		b.close()
+	})
+	return err
+}
@@ -1,10 +1,12 @@
 package websocket
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -14,37 +16,52 @@ import (
 var Upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		// In production, you should check the origin
-		return true
-	},
+	// Browsers send "bearer, <token>"; only "bearer" is echoed back
+	Subprotocols: []string{authSubprotocol},
+	CheckOrigin:  checkOrigin,
 }
 
 // Client represents a WebSocket client connection
 type Client struct {
 	Hub      *Hub
 	Conn     *websocket.Conn
-	Send     chan []byte
 	UserID   int
 	ClientID string
+
+	// Messages waiting to be written; never blocks the hub
+	queue *sendQueue
+}
+
+// NewClient creates a client for a user's connection
+func NewClient(hub *Hub, conn *websocket.Conn, userID int, clientID string) *Client {
+	return &Client{
+		Hub:      hub,
+		Conn:     conn,
+		UserID:   userID,
+		ClientID: clientID,
+		queue:    newSendQueue(sendQueueLimit),
+	}
 }
 
 // Message represents a WebSocket message
 type Message struct {
+	Version int         `json:"v"`
 	Type    string      `json:"type"`
+	ID      string      `json:"id,omitempty"`
 	Payload interface{} `json:"payload"`
 }
 
 // NotificationPayload represents a notification sent via WebSocket
 type NotificationPayload struct {
-	ID        int       `json:"id"`
-	UserID    int       `json:"user_id"`
-	Type      string    `json:"type"`
-	Title     string    `json:"title"`
-	Message   string    `json:"message"`
-	Link      string    `json:"link,omitempty"`
-	IsRead    bool      `json:"is_read"`
-	CreatedAt time.Time `json:"created_at"`
+	ID         int       `json:"id"`
+	UserID     int       `json:"user_id"`
+	Type       string    `json:"type"`
+	Title      string    `json:"title"`
+	Message    string    `json:"message"`
+	Link       string    `json:"link,omitempty"`
+	GroupCount int       `json:"group_count,omitempty"`
+	IsRead     bool      `json:"is_read"`
+	CreatedAt  time.Time `json:"created_at"`
 }
 
 // Hub maintains the set of active clients and broadcasts messages
@@ -52,97 +69,151 @@ type Hub struct {
 	// Registered clients by user ID
 	clients map[int]map[*Client]bool
 
-	// Inbound messages from clients
-	broadcast chan *BroadcastMessage
+	// Carries messages to the hubs of every instance
+	broadcaster Broadcaster
 
-	// Register requests from clients
-	register chan *Client
+	// Messages published by any hub, including this one
+	messages <-chan *BroadcastMessage
 
-	// Unregister requests from clients
-	unregister chan *Client
+	// Serves client requests; nil disables them
+	store Store
 
-	// Mutex for thread-safe operations
+	// Guards clients. Delivery only reads the map and never blocks, so
+	// registering and unregistering are never held up by slow clients.
 	mu sync.RWMutex
+
+	delivered atomic.Uint64
+	coalesced atomic.Uint64
+	dropped   atomic.Uint64
 }
 
 // BroadcastMessage represents a message to be broadcast to specific users
 type BroadcastMessage struct {
 	UserID  int
+	Type    string // decides how the message is queued for slow clients
 	Message []byte
 }
 
-// NewHub creates a new Hub
+// Stats are counters for messages handed to this hub's clients
+type Stats struct {
+	ConnectedUsers int    `json:"connected_users"`
+	Connections    int    `json:"connections"`
+	Delivered      uint64 `json:"delivered"`
+	Coalesced      uint64 `json:"coalesced"`
+	Dropped        uint64 `json:"dropped"`
+}
+
+// NewHub creates a Hub that only reaches clients of this process and can't
+// answer client requests
 func NewHub() *Hub {
+	return NewHubWithBroadcaster(NewLocalBroadcaster(), nil)
+}
+
+// NewHubWithBroadcaster creates a Hub that exchanges messages with other
+// hubs through b and answers client requests from store
+func NewHubWithBroadcaster(b Broadcaster, store Store) *Hub {
 	return &Hub{
-		clients:    make(map[int]map[*Client]bool),
-		broadcast:  make(chan *BroadcastMessage, 256),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
+		clients:     make(map[int]map[*Client]bool),
+		broadcaster: b,
+		messages:    b.Subscribe(),
+		store:       store,
 	}
 }
 
-// Run starts the hub's main loop
+// Run delivers published messages to this hub's clients. It returns once the
+// broadcaster is closed.
 func (h *Hub) Run() {
-	for {
-		select {
-		case client := <-h.register:
-			h.mu.Lock()
-			if h.clients[client.UserID] == nil {
-				h.clients[client.UserID] = make(map[*Client]bool)
-			}
-			h.clients[client.UserID][client] = true
-			h.mu.Unlock()
-			log.Printf("Client connected: user %d, client %s", client.UserID, client.ClientID)
-
-		case client := <-h.unregister:
-			h.mu.Lock()
-			if clients, ok := h.clients[client.UserID]; ok {
-				if _, exists := clients[client]; exists {
-					delete(clients, client)
-					close(client.Send)
-					if len(clients) == 0 {
-						delete(h.clients, client.UserID)
-					}
-				}
-			}
-			h.mu.Unlock()
-			log.Printf("Client disconnected: user %d, client %s", client.UserID, client.ClientID)
-
-		case message := <-h.broadcast:
-			h.mu.RLock()
-			if clients, ok := h.clients[message.UserID]; ok {
-				for client := range clients {
-					select {
-					case client.Send <- message.Message:
-					default:
-						close(client.Send)
-						delete(clients, client)
-					}
-				}
-			}
-			h.mu.RUnlock()
+	for message := range h.messages {
+		h.deliver(message)
+	}
+}
+
+// deliver queues a message for each of the user's clients without blocking
+func (h *Hub) deliver(message *BroadcastMessage) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for client := range h.clients[message.UserID] {
+		switch client.queue.push(message.Type, message.Message) {
+		case queued:
+			h.delivered.Add(1)
+		case coalesced:
+			h.coalesced.Add(1)
+		case droppedOldest:
+			h.delivered.Add(1)
+			h.dropped.Add(1)
 		}
 	}
 }
 
-// SendNotification sends a notification to a specific user
-func (h *Hub) SendNotification(userID int, notification NotificationPayload) error {
-	msg := Message{
-		Type:    "notification",
-		Payload: notification,
+// register adds a client to the hub
+func (h *Hub) register(client *Client) {
+	h.mu.Lock()
+	if h.clients[client.UserID] == nil {
+		h.clients[client.UserID] = make(map[*Client]bool)
+	}
+	h.clients[client.UserID][client] = true
+	h.mu.Unlock()
+	log.Printf("Client connected: user %d, client %s", client.UserID, client.ClientID)
+}
+
+// unregister removes a client and closes its queue, which makes its write
+// pump send a close frame and exit. It is safe to call more than once.
+func (h *Hub) unregister(client *Client) {
+	h.mu.Lock()
+	clients := h.clients[client.UserID]
+	_, exists := clients[client]
+	if exists {
+		delete(clients, client)
+		if len(clients) == 0 {
+			delete(h.clients, client.UserID)
+		}
+	}
+	h.mu.Unlock()
+
+	client.queue.close()
+	if exists {
+		log.Printf("Client disconnected: user %d, client %s", client.UserID, client.ClientID)
 	}
+}
 
-	data, err := json.Marshal(msg)
+// Publish hands a message for a user to the broadcaster. It never waits on
+// clients; it only waits for the broadcaster, and gives up with ctx's error
+// once ctx is done.
+func (h *Hub) Publish(ctx context.Context, userID int, msgType string, payload interface{}) error {
+	data, err := encode(msgType, "", payload)
 	if err != nil {
 		return err
 	}
 
-	h.broadcast <- &BroadcastMessage{
+	return h.broadcaster.Publish(ctx, &BroadcastMessage{
 		UserID:  userID,
+		Type:    msgType,
 		Message: data,
+	})
+}
+
+// SendNotification sends a notification to a specific user
+func (h *Hub) SendNotification(ctx context.Context, userID int, notification NotificationPayload) error {
+	return h.Publish(ctx, userID, TypeNotification, notification)
+}
+
+// PublishUnreadCount sends the user's current unread count to all of their
+// connections. It does nothing without a store.
+func (h *Hub) PublishUnreadCount(ctx context.Context, userID int) error {
+	if h.store == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, storeTimeout)
+	defer cancel()
+
+	count, err := h.store.UnreadCount(ctx, userID)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return h.Publish(ctx, userID, TypeUnreadCount, UnreadCountPayload{Count: count})
 }
 
 // GetConnectedUserCount returns the number of connected users
@@ -160,6 +231,27 @@ func (h *Hub) IsUserConnected(userID int) bool {
 	return ok
 }
 
+// Stats returns the hub's connection counts and message counters
+func (h *Hub) Stats() Stats {
+	h.mu.RLock()
+	stats := Stats{ConnectedUsers: len(h.clients)}
+	for _, clients := range h.clients {
+		stats.Connections += len(clients)
+	}
+	h.mu.RUnlock()
+
+	stats.Delivered = h.delivered.Load()
+	stats.Coalesced = h.coalesced.Load()
+	stats.Dropped = h.dropped.Load()
+	return stats
+}
+
+// StatsHandler serves the hub's Stats as JSON
+func (h *Hub) StatsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.Stats())
+}
+
 // Constants for WebSocket communication
 const (
 	// Time allowed to write a message to the peer
@@ -172,13 +264,13 @@ const (
 	pingPeriod = (pongWait * 9) / 10
 
 	// Maximum message size allowed from peer
-	maxMessageSize = 512
+	maxMessageSize = 4096
 )
 
 // ReadPump pumps messages from the WebSocket connection to the hub
 func (c *Client) ReadPump() {
 	defer func() {
-		c.Hub.unregister <- c
+		c.Hub.unregister(c)
 		c.Conn.Close()
 	}()
 
@@ -198,53 +290,54 @@ func (c *Client) ReadPump() {
 			break
 		}
 
-		// Handle incoming messages (e.g., ping, mark-as-read)
-		var msg Message
-		if err := json.Unmarshal(message, &msg); err == nil {
-			switch msg.Type {
-			case "ping":
-				// Respond with pong
-				pong := Message{Type: "pong", Payload: nil}
-				if data, err := json.Marshal(pong); err == nil {
-					c.Send <- data
-				}
-			}
-		}
+		c.handle(message)
 	}
 }
 
-// WritePump pumps messages from the hub to the WebSocket connection
+// WritePump pumps messages from the hub to the WebSocket connection. A client
+// too slow to keep up loses its oldest messages rather than stalling others,
+// and a connection that can't be written to within writeWait is closed.
 func (c *Client) WritePump() {
 	ticker := time.NewTicker(pingPeriod)
 	defer func() {
 		ticker.Stop()
 		c.Conn.Close()
+		// Stop queueing for a client whose connection failed
+		c.Hub.unregister(c)
 	}()
 
 	for {
 		select {
-		case message, ok := <-c.Send:
+		case <-c.queue.ready:
+			messages, dropped, closed := c.queue.drain()
 			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if !ok {
-				// The hub closed the channel
-				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
-			}
 
-			w, err := c.Conn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				return
+			if dropped > 0 {
+				lagged, err := encode(TypeLagged, "", LaggedPayload{Dropped: dropped})
+				if err == nil {
+					messages = append([]queuedMessage{{msgType: TypeLagged, data: lagged}}, messages...)
+				}
 			}
-			w.Write(message)
 
-			// Add queued messages to the current WebSocket message
-			n := len(c.Send)
-			for i := 0; i < n; i++ {
-				w.Write([]byte{'\n'})
-				w.Write(<-c.Send)
+			if len(messages) > 0 {
+				w, err := c.Conn.NextWriter(websocket.TextMessage)
+				if err != nil {
+					return
+				}
+				// Queued messages share one WebSocket message, one per line
+				for i, m := range messages {
+					if i > 0 {
+						w.Write([]byte{'\n'})
+					}
+					w.Write(m.data)
+				}
+				if err := w.Close(); err != nil {
+					return
+				}
 			}
 
-			if err := w.Close(); err != nil {
+			if closed {
+				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
 
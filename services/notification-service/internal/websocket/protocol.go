@@ -0,0 +1,246 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// ProtocolVersion is the version of the message protocol this server speaks.
+// Every server message carries it as "v"; client messages without one are
+// taken to be the current version.
+const ProtocolVersion = 1
+
+// Message types
+const (
+	// Server to client
+	TypeHello        = "hello"
+	TypeNotification = "notification"
+	TypeUnreadCount  = "unread_count"
+	TypeResumed      = "resumed"
+	TypeLagged       = "lagged"
+	TypePong         = "pong"
+	TypeOK           = "ok"
+	TypeError        = "error"
+
+	// Client to server
+	TypePing     = "ping"
+	TypeAck      = "ack"
+	TypeMarkRead = "mark_read"
+	TypeResume   = "resume"
+)
+
+const (
+	// maxRequestIDs caps the IDs a single ack or mark_read may carry
+	maxRequestIDs = 100
+
+	// resumeBatch is how many missed notifications one resume returns
+	resumeBatch = 100
+
+	// storeTimeout bounds the database work done for one client message
+	storeTimeout = 5 * time.Second
+)
+
+// HelloPayload is sent once a connection is registered
+type HelloPayload struct {
+	ProtocolVersion int    `json:"protocol_version"`
+	ClientID        string `json:"client_id"`
+}
+
+// UnreadCountPayload is pushed whenever the user's unread count may have changed
+type UnreadCountPayload struct {
+	Count int `json:"count"`
+}
+
+// ResumedPayload follows the notifications replayed for a resume request.
+// With has_more set the client should resume again from the last ID it got.
+type ResumedPayload struct {
+	Count   int  `json:"count"`
+	HasMore bool `json:"has_more"`
+}
+
+// LaggedPayload tells a client that was too slow to keep up how many
+// messages it lost. It should resume from the last notification it saw.
+type LaggedPayload struct {
+	Dropped int `json:"dropped"`
+}
+
+// ErrorPayload describes why a client message was refused
+type ErrorPayload struct {
+	Message string `json:"message"`
+}
+
+// ackRequest is the payload of ack and mark_read. mark_read accepts
+// "all": true instead of a list of IDs.
+type ackRequest struct {
+	IDs []int `json:"ids"`
+	All bool  `json:"all"`
+}
+
+// resumeRequest is the payload of resume
+type resumeRequest struct {
+	LastSeenID int `json:"last_seen_id"`
+}
+
+// clientMessage is a message received from a client. ID is chosen by the
+// client and echoed in the ok or error reply.
+type clientMessage struct {
+	Version int             `json:"v"`
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// encode builds a server message
+func encode(msgType, id string, payload interface{}) ([]byte, error) {
+	return json.Marshal(Message{
+		Version: ProtocolVersion,
+		Type:    msgType,
+		ID:      id,
+		Payload: payload,
+	})
+}
+
+// reply queues a message for this connection only
+func (c *Client) reply(msgType, id string, payload interface{}) {
+	data, err := encode(msgType, id, payload)
+	if err != nil {
+		log.Printf("Failed to encode %s message: %v", msgType, err)
+		return
+	}
+	c.queue.push(msgType, data)
+}
+
+func (c *Client) replyError(id, message string) {
+	c.reply(TypeError, id, ErrorPayload{Message: message})
+}
+
+// handle acts on one message from the client
+func (c *Client) handle(raw []byte) {
+	var msg clientMessage
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		c.replyError("", "Invalid message")
+		return
+	}
+	if msg.Version > ProtocolVersion {
+		c.replyError(msg.ID, "Unsupported protocol version")
+		return
+	}
+
+	if msg.Type == TypePing {
+		c.reply(TypePong, msg.ID, nil)
+		return
+	}
+
+	store := c.Hub.store
+	if store == nil {
+		c.replyError(msg.ID, "Not supported by this server")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	switch msg.Type {
+	case TypeAck:
+		var req ackRequest
+		if !c.decodeIDs(msg, &req, false) {
+			return
+		}
+		if err := store.MarkDelivered(ctx, c.UserID, req.IDs); err != nil {
+			log.Printf("Failed to record delivery for user %d: %v", c.UserID, err)
+			c.replyError(msg.ID, "Failed to acknowledge notifications")
+			return
+		}
+		c.reply(TypeOK, msg.ID, nil)
+
+	case TypeMarkRead:
+		var req ackRequest
+		if !c.decodeIDs(msg, &req, true) {
+			return
+		}
+		ids := req.IDs
+		if req.All {
+			ids = nil
+		}
+		updated, err := store.MarkRead(ctx, c.UserID, ids)
+		if err != nil {
+			log.Printf("Failed to mark notifications read for user %d: %v", c.UserID, err)
+			c.replyError(msg.ID, "Failed to mark notifications as read")
+			return
+		}
+		c.reply(TypeOK, msg.ID, map[string]int64{"updated": updated})
+		if updated > 0 {
+			if err := c.Hub.PublishUnreadCount(ctx, c.UserID); err != nil {
+				log.Printf("Failed to publish unread count for user %d: %v", c.UserID, err)
+			}
+		}
+
+	case TypeResume:
+		var req resumeRequest
+		if err := json.Unmarshal(msg.Payload, &req); err != nil || req.LastSeenID < 0 {
+			c.replyError(msg.ID, "Invalid resume payload")
+			return
+		}
+		c.resume(ctx, msg.ID, req.LastSeenID)
+
+	default:
+		c.replyError(msg.ID, "Unknown message type")
+	}
+}
+
+// decodeIDs parses an ack or mark_read payload, replying with an error if it
+// is invalid
+func (c *Client) decodeIDs(msg clientMessage, req *ackRequest, allowAll bool) bool {
+	if err := json.Unmarshal(msg.Payload, req); err != nil {
+		c.replyError(msg.ID, "Invalid payload")
+		return false
+	}
+	if req.All && allowAll {
+		return true
+	}
+	if len(req.IDs) == 0 || len(req.IDs) > maxRequestIDs {
+		c.replyError(msg.ID, "Between 1 and 100 notification IDs are required")
+		return false
+	}
+	return true
+}
+
+// resume replays notifications created after lastSeenID. Ones that are also
+// pushed live while this runs arrive twice, so clients de-duplicate by ID.
+func (c *Client) resume(ctx context.Context, id string, lastSeenID int) {
+	missed, err := c.Hub.store.NotificationsAfter(ctx, c.UserID, lastSeenID, resumeBatch+1)
+	if err != nil {
+		log.Printf("Failed to load missed notifications for user %d: %v", c.UserID, err)
+		c.replyError(id, "Failed to load missed notifications")
+		return
+	}
+
+	hasMore := len(missed) > resumeBatch
+	if hasMore {
+		missed = missed[:resumeBatch]
+	}
+	for _, n := range missed {
+		c.reply(TypeNotification, "", n)
+	}
+	c.reply(TypeResumed, id, ResumedPayload{Count: len(missed), HasMore: hasMore})
+	c.sendUnreadCount()
+}
+
+// sendUnreadCount sends the user's unread count to this connection only
+func (c *Client) sendUnreadCount() {
+	if c.Hub.store == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	count, err := c.Hub.store.UnreadCount(ctx, c.UserID)
+	if err != nil {
+		log.Printf("Failed to load unread count for user %d: %v", c.UserID, err)
+		return
+	}
+	c.reply(TypeUnreadCount, "", UnreadCountPayload{Count: count})
+}
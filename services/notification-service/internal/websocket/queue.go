@@ -0,0 +1,120 @@
+package websocket
+
+import "sync"
+
+// sendQueueLimit is how many messages may wait for a slow client
+const sendQueueLimit = 256
+
+// Policy decides how a message is queued for a client whose queue is full
+type Policy int
+
+const (
+	// DropOldest evicts the oldest queued message to make room. The client is
+	// told how many messages it lost with a lagged message and can resume.
+	DropOldest Policy = iota
+	// Coalesce replaces an already queued message of the same type, because
+	// only the latest one matters. Used for unread counts.
+	Coalesce
+)
+
+// policyFor returns the queueing policy for a message type
+func policyFor(msgType string) Policy {
+	if msgType == TypeUnreadCount {
+		return Coalesce
+	}
+	return DropOldest
+}
+
+type queuedMessage struct {
+	msgType string
+	data    []byte
+}
+
+// pushResult says what happened to a pushed message
+type pushResult int
+
+const (
+	queued pushResult = iota
+	coalesced
+	droppedOldest
+	queueClosed
+)
+
+// sendQueue is a client's bounded outbound queue. push never blocks, so a
+// slow client can't hold up the hub or other clients.
+type sendQueue struct {
+	mu      sync.Mutex
+	items   []queuedMessage
+	limit   int
+	dropped int // evicted since the last drain
+	closed  bool
+
+	// ready holds a token whenever there is something to drain
+	ready chan struct{}
+}
+
+func newSendQueue(limit int) *sendQueue {
+	return &sendQueue{
+		limit: limit,
+		ready: make(chan struct{}, 1),
+	}
+}
+
+func (q *sendQueue) push(msgType string, data []byte) pushResult {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return queueClosed
+	}
+
+	result := queued
+	if policyFor(msgType) == Coalesce {
+		for i := range q.items {
+			if q.items[i].msgType == msgType {
+				q.items[i].data = data
+				return coalesced
+			}
+		}
+	}
+
+	if len(q.items) >= q.limit {
+		q.items = q.items[1:]
+		q.dropped++
+		result = droppedOldest
+	}
+	q.items = append(q.items, queuedMessage{msgType: msgType, data: data})
+	q.signal()
+	return result
+}
+
+// drain empties the queue, returning its messages, how many were evicted
+// since the last drain, and whether the queue has been closed
+func (q *sendQueue) drain() ([]queuedMessage, int, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	items, dropped := q.items, q.dropped
+	q.items, q.dropped = nil, 0
+	return items, dropped, q.closed
+}
+
+// close stops the queue accepting messages and wakes the writer. It is safe
+// to call more than once.
+func (q *sendQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.closed {
+		q.closed = true
+		q.signal()
+	}
+}
+
+// signal must be called with mu held
+func (q *sendQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
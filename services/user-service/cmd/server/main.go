@@ -5,10 +5,13 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strings"
 
+	"github.com/aung-arata/youtube-clone/services/user-service/internal/auth"
 	"github.com/aung-arata/youtube-clone/services/user-service/internal/database"
 	"github.com/aung-arata/youtube-clone/services/user-service/internal/handlers"
 	"github.com/aung-arata/youtube-clone/services/user-service/internal/middleware"
+	"github.com/aung-arata/youtube-clone/services/user-service/internal/notify"
 	"github.com/gorilla/mux"
 )
 
@@ -20,43 +23,142 @@ func main() {
 	}
 	defer db.Close()
 
+	// Services present SERVICE_TOKEN to each other's internal endpoints,
+	// which refuse every request without it
+	serviceToken := os.Getenv("SERVICE_TOKEN")
+	if serviceToken == "" {
+		log.Println("SERVICE_TOKEN is not set; internal endpoints will refuse all requests")
+	}
+	auth.UseServiceToken(serviceToken)
+
+	// Reject access tokens whose session was revoked
+	auth.UseSessionChecker(auth.NewSessionCache(auth.NewSQLSessionChecker(db), auth.SessionCheckTTL))
+
+	// Accept API keys on routes that name a scope
+	auth.UseAPIKeyVerifier(auth.NewSQLAPIKeyVerifier(db))
+
+	// Verification and password reset emails are sent only when SMTP_ADDR
+	// is set
+	var accountMailer handlers.AccountMailer
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		from := os.Getenv("SMTP_FROM")
+		if from == "" {
+			from = "YouTube Clone <accounts@localhost>"
+		}
+		// Links in emails point at the web app
+		baseURL := os.Getenv("APP_BASE_URL")
+		if baseURL == "" {
+			baseURL = "http://localhost:3000"
+		}
+
+		accountMailer = notify.NewAccountMailer(notify.NewSMTPMailer(notify.SMTPConfig{
+			Addr:     addr,
+			From:     from,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		}), baseURL)
+	}
+
+	// Users can also sign in with the OpenID Connect providers in
+	// OIDC_PROVIDERS
+	var oidcProviders []*auth.OIDCProvider
+	for _, config := range auth.OIDCConfigsFromEnv() {
+		provider, err := auth.NewOIDCProvider(config)
+		if err != nil {
+			log.Fatal("Invalid OIDC configuration:", err)
+		}
+		oidcProviders = append(oidcProviders, provider)
+	}
+
+	// Comma-separated addresses or CIDR ranges of proxies in front of this
+	// server, whose X-Forwarded-For is believed when recording client IPs
+	if proxies := os.Getenv("TRUSTED_PROXIES"); proxies != "" {
+		if err := auth.SetTrustedProxies(strings.Split(proxies, ",")); err != nil {
+			log.Fatal("Invalid TRUSTED_PROXIES:", err)
+		}
+	}
+
 	// Create router
 	r := mux.NewRouter()
 
 	// Auth routes (public)
-	authHandler := handlers.NewAuthHandler(db)
+	authHandler := handlers.NewAuthHandler(db, accountMailer)
 	r.HandleFunc("/auth/signup", authHandler.Signup).Methods("POST")
 	r.HandleFunc("/auth/login", authHandler.Login).Methods("POST")
 	r.HandleFunc("/auth/refresh", authHandler.RefreshToken).Methods("POST")
+	r.HandleFunc("/auth/logout", authHandler.Logout).Methods("POST")
+	r.HandleFunc("/auth/login/2fa", authHandler.LoginTwoFactor).Methods("POST")
+	r.HandleFunc("/auth/verify-email", authHandler.VerifyEmail).Methods("POST")
+	r.HandleFunc("/auth/forgot-password", authHandler.ForgotPassword).Methods("POST")
+	r.HandleFunc("/auth/reset-password", authHandler.ResetPassword).Methods("POST")
+
+	oidcHandler := handlers.NewOIDCHandler(db, oidcProviders)
+	r.HandleFunc("/auth/oidc/providers", oidcHandler.ListProviders).Methods("GET")
+	r.HandleFunc("/auth/oidc/{provider}/authorize", oidcHandler.Authorize).Methods("GET")
+	r.HandleFunc("/auth/oidc/{provider}/callback", oidcHandler.Callback).Methods("POST")
+	r.HandleFunc("/.well-known/jwks.json", authHandler.JWKS).Methods("GET")
 	
 	// Protected auth route
 	protectedAuth := r.PathPrefix("/auth").Subrouter()
 	protectedAuth.Use(middleware.AuthMiddleware)
-	protectedAuth.HandleFunc("/me", authHandler.GetCurrentUser).Methods("GET")
+	protectedAuth.Handle("/me", middleware.RequireScope(auth.ScopeProfileRead, http.HandlerFunc(authHandler.GetCurrentUser))).Methods("GET")
+	protectedAuth.HandleFunc("/logout-all", authHandler.LogoutAll).Methods("POST")
+	protectedAuth.HandleFunc("/sessions", authHandler.ListSessions).Methods("GET")
+	protectedAuth.HandleFunc("/sessions/{id}", authHandler.RevokeSession).Methods("DELETE")
+	protectedAuth.HandleFunc("/verify-email/resend", authHandler.ResendVerificationEmail).Methods("POST")
+	protectedAuth.HandleFunc("/2fa/setup", authHandler.SetupTwoFactor).Methods("POST")
+	protectedAuth.HandleFunc("/2fa/confirm", authHandler.ConfirmTwoFactor).Methods("POST")
+	protectedAuth.HandleFunc("/2fa/disable", authHandler.DisableTwoFactor).Methods("POST")
+	protectedAuth.HandleFunc("/2fa/recovery-codes", authHandler.RegenerateRecoveryCodes).Methods("POST")
+
+	// API keys can't manage API keys
+	apiKeyHandler := handlers.NewAPIKeyHandler(db)
+	protectedAuth.HandleFunc("/api-keys", apiKeyHandler.CreateAPIKey).Methods("POST")
+	protectedAuth.HandleFunc("/api-keys", apiKeyHandler.ListAPIKeys).Methods("GET")
+	protectedAuth.HandleFunc("/api-keys/{id:[0-9]+}", apiKeyHandler.RevokeAPIKey).Methods("DELETE")
+
+	// Internal routes other services check access tokens' sessions and API
+	// keys with; the gateway only proxies /api
+	r.Handle("/internal/sessions/{id}", middleware.RequireServiceToken(http.HandlerFunc(authHandler.SessionStatus))).Methods("GET")
+	r.Handle("/internal/api-keys/verify", middleware.RequireServiceToken(http.HandlerFunc(apiKeyHandler.VerifyAPIKey))).Methods("POST")
 
 	// User routes
 	userHandler := handlers.NewUserHandler(db)
-	r.HandleFunc("/users", userHandler.CreateUser).Methods("POST")
+	r.Handle("/users", middleware.Protect(auth.PermManageUsers, http.HandlerFunc(userHandler.CreateUser))).Methods("POST")
+	r.HandleFunc("/users/lookup", userHandler.LookupUsers).Methods("GET")
 	r.HandleFunc("/users/{id}", userHandler.GetUser).Methods("GET")
-	r.HandleFunc("/users/{id}", userHandler.UpdateUser).Methods("PUT")
+	r.Handle("/users/{id}", middleware.Protect(auth.PermInteract, middleware.RequireSelf("id", http.HandlerFunc(userHandler.UpdateUser)))).Methods("PUT")
 	
 	// Plan routes
 	planHandler := handlers.NewPlanHandler(db)
 	r.HandleFunc("/plans", planHandler.GetPlans).Methods("GET")
 	r.HandleFunc("/plans/{id}", planHandler.GetPlan).Methods("GET")
-	r.HandleFunc("/plans", planHandler.CreatePlan).Methods("POST")
-	r.HandleFunc("/plans/{id}", planHandler.UpdatePlan).Methods("PUT")
-	r.HandleFunc("/plans/{id}", planHandler.DeletePlan).Methods("DELETE")
+	r.Handle("/plans", middleware.Protect(auth.PermManagePlans, http.HandlerFunc(planHandler.CreatePlan))).Methods("POST")
+	r.Handle("/plans/{id}", middleware.Protect(auth.PermManagePlans, http.HandlerFunc(planHandler.UpdatePlan))).Methods("PUT")
+	r.Handle("/plans/{id}", middleware.Protect(auth.PermManagePlans, http.HandlerFunc(planHandler.DeletePlan))).Methods("DELETE")
 	r.HandleFunc("/users/{userId}/plan", planHandler.GetUserPlan).Methods("GET")
-	r.HandleFunc("/users/{userId}/plan", planHandler.UpdateUserPlan).Methods("PUT")
+	r.Handle("/users/{userId}/plan", middleware.Protect(auth.PermInteract, middleware.RequireSelf("userId", http.HandlerFunc(planHandler.UpdateUserPlan)))).Methods("PUT")
 
 	// Subscription routes
 	subscriptionHandler := handlers.NewSubscriptionHandler(db)
 	r.HandleFunc("/users/{userId}/subscriptions", subscriptionHandler.GetUserSubscriptions).Methods("GET")
-	r.HandleFunc("/users/{userId}/subscriptions", subscriptionHandler.Subscribe).Methods("POST")
-	r.HandleFunc("/users/{userId}/subscriptions/{channelName}", subscriptionHandler.CheckSubscription).Methods("GET")
-	r.HandleFunc("/users/{userId}/subscriptions/{channelName}", subscriptionHandler.Unsubscribe).Methods("DELETE")
+	r.Handle("/users/{userId}/subscriptions", middleware.Protect(auth.PermInteract, middleware.RequireSelf("userId", http.HandlerFunc(subscriptionHandler.Subscribe)))).Methods("POST")
+	r.HandleFunc("/users/{userId}/subscriptions/feed", subscriptionHandler.GetSubscriptionFeed).Methods("GET")
+	r.HandleFunc("/users/{userId}/subscriptions/unseen", subscriptionHandler.GetUnseenCounts).Methods("GET")
+	r.Handle("/users/{userId}/subscriptions/{channelId:[0-9]+}/visit", middleware.Protect(auth.PermInteract, middleware.RequireSelf("userId", http.HandlerFunc(subscriptionHandler.MarkChannelVisited)))).Methods("POST")
+	r.Handle("/users/{userId}/subscriptions/{channelId:[0-9]+}/notifications", middleware.Protect(auth.PermInteract, middleware.RequireSelf("userId", http.HandlerFunc(subscriptionHandler.UpdateNotificationLevel)))).Methods("PUT")
+	r.HandleFunc("/users/{userId}/subscriptions/{channelId:[0-9]+}", subscriptionHandler.CheckSubscription).Methods("GET")
+	r.Handle("/users/{userId}/subscriptions/{channelId:[0-9]+}", middleware.Protect(auth.PermInteract, middleware.RequireSelf("userId", http.HandlerFunc(subscriptionHandler.Unsubscribe)))).Methods("DELETE")
+	// The notification service asks which subscribers to tell about an upload
+	r.Handle("/internal/channels/{channelId:[0-9]+}/subscribers", middleware.RequireServiceToken(http.HandlerFunc(subscriptionHandler.GetSubscribersToNotify))).Methods("GET")
 	
+	// Staff routes (protected, each needs a staff permission)
+	roleHandler := handlers.NewRoleHandler(db)
+	admin := r.PathPrefix("/admin").Subrouter()
+	admin.Use(middleware.AuthMiddleware)
+	admin.Handle("/roles", middleware.RequirePermission(auth.PermManageUsers, http.HandlerFunc(roleHandler.ListRoles))).Methods("GET")
+	admin.Handle("/users/{id:[0-9]+}/role", middleware.RequirePermission(auth.PermManageUsers, http.HandlerFunc(roleHandler.UpdateUserRole))).Methods("PUT")
+
 	// Health check
 	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Purposes of account tokens. A token only works for the purpose it was
+// created for.
+const (
+	PurposeVerifyEmail   = "verify_email"
+	PurposePasswordReset = "password_reset"
+)
+
+const (
+	// EmailVerificationTTL is how long an email verification link works
+	EmailVerificationTTL = 48 * time.Hour
+
+	// PasswordResetTTL is how long a password reset link works
+	PasswordResetTTL = time.Hour
+)
+
+// ErrInvalidAccountToken is returned for unknown, expired or used tokens
+var ErrInvalidAccountToken = errors.New("invalid or expired token")
+
+// CreateAccountToken creates a single-use token that proves access to email,
+// for sending in a link. Earlier unused tokens of the user for the same
+// purpose stop working.
+func CreateAccountToken(ctx context.Context, db *sql.DB, userID int, purpose, email string, ttl time.Duration) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM account_tokens WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL
+	`, userID, purpose); err != nil {
+		return "", err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO account_tokens (user_id, purpose, token_hash, email, expires_at)
+		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP + make_interval(secs => $5))
+	`, userID, purpose, HashRefreshToken(token), email, ttl.Seconds()); err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ConsumeAccountToken uses up a token and returns the user and email address
+// it was created for
+func ConsumeAccountToken(ctx context.Context, tx *sql.Tx, token, purpose string) (int, string, error) {
+	var userID int
+	var email string
+	err := tx.QueryRowContext(ctx, `
+		UPDATE account_tokens SET used_at = CURRENT_TIMESTAMP
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+		RETURNING user_id, email
+	`, HashRefreshToken(token), purpose).Scan(&userID, &email)
+	if err == sql.ErrNoRows {
+		return 0, "", ErrInvalidAccountToken
+	}
+	return userID, email, err
+}
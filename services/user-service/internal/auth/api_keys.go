@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// DefaultAPIKeyTTL is how long an API key works unless its owner picks
+	// another expiry
+	DefaultAPIKeyTTL = 90 * 24 * time.Hour
+
+	// MaxAPIKeyTTL is the longest an API key can work
+	MaxAPIKeyTTL = 365 * 24 * time.Hour
+
+	// apiKeyUseInterval limits how often last_used_at is written for a key
+	apiKeyUseInterval = time.Minute
+)
+
+// APIKey describes a user's API key. The key itself is only shown once, when
+// it is created.
+type APIKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// CreateAPIKey creates a key that acts for a user with the given scopes until
+// it expires or is revoked. Only its hash is stored.
+func CreateAPIKey(ctx context.Context, db *sql.DB, userID int, name string, scopes []string, ttl time.Duration) (APIKey, string, error) {
+	secret, err := randomToken(32)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	key := APIKeyPrefix + secret
+
+	apiKey := APIKey{Name: name, Prefix: key[:len(APIKeyPrefix)+6], Scopes: scopes}
+	err = db.QueryRowContext(ctx, `
+		INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP + make_interval(secs => $6))
+		RETURNING id, created_at, expires_at
+	`, userID, name, apiKey.Prefix, HashAPIKey(key), pq.Array(scopes), ttl.Seconds()).Scan(
+		&apiKey.ID, &apiKey.CreatedAt, &apiKey.ExpiresAt)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	return apiKey, key, nil
+}
+
+// ListAPIKeys returns a user's unrevoked keys, newest first. Expired keys are
+// included so that their owner sees why they stopped working.
+func ListAPIKeys(ctx context.Context, db *sql.DB, userID int) ([]APIKey, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, name, prefix, scopes, created_at, expires_at, last_used_at
+		FROM api_keys
+		WHERE user_id = $1 AND revoked_at IS NULL
+		ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		var k APIKey
+		var lastUsed sql.NullTime
+		if err := rows.Scan(&k.ID, &k.Name, &k.Prefix, pq.Array(&k.Scopes), &k.CreatedAt, &k.ExpiresAt, &lastUsed); err != nil {
+			return nil, err
+		}
+		if lastUsed.Valid {
+			k.LastUsedAt = &lastUsed.Time
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey stops one of a user's keys from working. It reports whether
+// the user had such a key.
+func RevokeAPIKey(ctx context.Context, db *sql.DB, userID, id int) (bool, error) {
+	result, err := db.ExecContext(ctx, `
+		UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`, id, userID)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// RevokeAllAPIKeys stops all of a user's keys from working as part of tx and
+// returns how many were revoked
+func RevokeAllAPIKeys(ctx context.Context, tx *sql.Tx, userID int) (int64, error) {
+	result, err := tx.ExecContext(ctx, `
+		UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND revoked_at IS NULL
+	`, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// SQLAPIKeyVerifier looks API keys up in the api_keys table
+type SQLAPIKeyVerifier struct {
+	db *sql.DB
+}
+
+// NewSQLAPIKeyVerifier creates a verifier backed by db
+func NewSQLAPIKeyVerifier(db *sql.DB) *SQLAPIKeyVerifier {
+	return &SQLAPIKeyVerifier{db: db}
+}
+
+// VerifyAPIKey implements APIKeyVerifier. It records when the key was used.
+func (v *SQLAPIKeyVerifier) VerifyAPIKey(ctx context.Context, key string) (*APIKeyPrincipal, error) {
+	if !IsAPIKey(key) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	var p APIKeyPrincipal
+	err := v.db.QueryRowContext(ctx, `
+		SELECT k.id, u.id, u.username, u.role, u.email_verified_at IS NOT NULL, k.scopes
+		FROM api_keys k JOIN users u ON u.id = k.user_id
+		WHERE k.key_hash = $1 AND k.revoked_at IS NULL AND k.expires_at > CURRENT_TIMESTAMP
+	`, HashAPIKey(key)).Scan(&p.KeyID, &p.UserID, &p.Username, &p.Role, &p.EmailVerified, pq.Array(&p.Scopes))
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidAPIKey
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Busy scripts would otherwise write on every request
+	if _, err := v.db.ExecContext(ctx, `
+		UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < CURRENT_TIMESTAMP - make_interval(secs => $2))
+	`, p.KeyID, apiKeyUseInterval.Seconds()); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
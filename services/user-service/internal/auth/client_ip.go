@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+var (
+	proxiesMu      sync.RWMutex
+	trustedProxies []*net.IPNet
+)
+
+// SetTrustedProxies sets the addresses or CIDR ranges of the proxies in front
+// of this server. Only their X-Forwarded-For headers are believed.
+func SetTrustedProxies(proxies []string) error {
+	nets := []*net.IPNet{}
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return fmt.Errorf("invalid trusted proxy %q", proxy)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				bits = 8 * net.IPv4len
+			}
+			proxy = fmt.Sprintf("%s/%d", ip, bits)
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy %q", proxy)
+		}
+		nets = append(nets, ipNet)
+	}
+
+	proxiesMu.Lock()
+	trustedProxies = nets
+	proxiesMu.Unlock()
+	return nil
+}
+
+func isTrustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	proxiesMu.RLock()
+	defer proxiesMu.RUnlock()
+	for _, ipNet := range trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address a request comes from. When the peer is a
+// trusted proxy, X-Forwarded-For is read from the right, skipping trusted
+// proxies, so that entries a client made up are never used.
+func ClientIP(r *http.Request) string {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	if !isTrustedProxy(ip) {
+		return ip
+	}
+
+	entries := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := strings.TrimSpace(entries[i])
+		if entry == "" {
+			continue
+		}
+		ip = entry
+		if !isTrustedProxy(entry) {
+			break
+		}
+	}
+	return ip
+}
@@ -8,66 +8,115 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var jwtSecret = []byte(getJWTSecret())
+// AccessTokenTTL is how long an access token is valid. Clients keep a
+// session going with refresh tokens, which can be revoked.
+const AccessTokenTTL = 15 * time.Minute
+
+// clockSkew is how far the clocks of the signer and verifiers may drift
+const clockSkew = 30 * time.Second
+
+var (
+	// issuer and audience are checked on every token
+	issuer   = envOr("JWT_ISSUER", "youtube-clone")
+	audience = envOr("JWT_AUDIENCE", "youtube-clone")
+
+	// ErrNoSigningKey is returned by GenerateToken on services that only
+	// verify tokens
+	ErrNoSigningKey = errors.New("no JWT signing key configured")
+)
 
 // Claims represents the JWT claims
 type Claims struct {
 	UserID   int    `json:"user_id"`
 	Username string `json:"username"`
 	Role     string `json:"role"`
+	// SessionID names the login the token was issued for
+	SessionID string `json:"sid,omitempty"`
+	// MFA is set when the login passed two-factor authentication
+	MFA bool `json:"mfa,omitempty"`
+	// EmailVerified is set once the user confirmed their email address
+	EmailVerified bool `json:"email_verified,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// getJWTSecret retrieves the JWT secret from environment or uses a default for development
-func getJWTSecret() string {
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		// Default secret for development only
-		// In production, JWT_SECRET environment variable MUST be set
-		secret = "youtube-clone-secret-key-change-in-production"
-		// Log a warning in production environments
-		if os.Getenv("GO_ENV") == "production" {
-			panic("JWT_SECRET environment variable must be set in production")
-		}
+// TokenSubject is the user and login an access token is issued for
+type TokenSubject struct {
+	UserID        int
+	Username      string
+	Role          string
+	EmailVerified bool
+	SessionID     string
+	// MFA records whether the login passed two-factor authentication
+	MFA bool
+}
+
+func envOr(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
 	}
-	return secret
+	return defaultValue
 }
 
-// GenerateToken generates a new JWT token for a user
-func GenerateToken(userID int, username, role string) (string, error) {
-	// Token expires in 24 hours
-	expirationTime := time.Now().Add(24 * time.Hour)
+// GenerateToken generates a new JWT token for a user's session
+func GenerateToken(sub TokenSubject) (string, error) {
+	if keys.signer == nil {
+		return "", ErrNoSigningKey
+	}
 
+	now := time.Now()
 	claims := &Claims{
-		UserID:   userID,
-		Username: username,
-		Role:     role,
+		UserID:        sub.UserID,
+		Username:      sub.Username,
+		Role:          sub.Role,
+		SessionID:     sub.SessionID,
+		MFA:           sub.MFA,
+		EmailVerified: sub.EmailVerified,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    issuer,
+			Audience:  jwt.ClaimStrings{audience},
+			ExpiresAt: jwt.NewNumericDate(now.Add(AccessTokenTTL)),
+			NotBefore: jwt.NewNumericDate(now),
+			IssuedAt:  jwt.NewNumericDate(now),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtSecret)
-	if err != nil {
-		return "", err
-	}
-
-	return tokenString, nil
+	token := jwt.NewWithClaims(keys.signer.method, claims)
+	token.Header["kid"] = keys.signer.id
+	return token.SignedString(keys.signer.key)
 }
 
-// ValidateToken validates a JWT token and returns the claims
+// ValidateToken validates a JWT token and returns the claims. The token must
+// be signed by a published key and carry our issuer, audience, expiry and
+// not-before time.
 func ValidateToken(tokenString string) (*Claims, error) {
 	claims := &Claims{}
 
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		// Verify signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			return nil, errors.New("token has no key ID")
+		}
+		pub, err := keys.verifier.publicKey(kid)
+		if err != nil {
+			return nil, err
+		}
+
+		// Each key is used with one algorithm only
+		method, err := signingMethod(pub)
+		if err != nil {
+			return nil, err
+		}
+		if token.Method.Alg() != method.Alg() {
 			return nil, errors.New("invalid signing method")
 		}
-		return jwtSecret, nil
-	})
+		return pub, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}),
+		jwt.WithIssuer(issuer),
+		jwt.WithAudience(audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(clockSkew),
+	)
 
 	if err != nil {
 		return nil, err
@@ -77,16 +126,10 @@ func ValidateToken(tokenString string) (*Claims, error) {
 		return nil, errors.New("invalid token")
 	}
 
-	return claims, nil
-}
-
-// RefreshToken generates a new token from an existing valid token
-func RefreshToken(tokenString string) (string, error) {
-	claims, err := ValidateToken(tokenString)
-	if err != nil {
-		return "", err
+	// nbf is only checked when present, so require it
+	if claims.NotBefore == nil {
+		return nil, errors.New("token has no not-before time")
 	}
 
-	// Generate new token with same claims
-	return GenerateToken(claims.UserID, claims.Username, claims.Role)
+	return claims, nil
 }
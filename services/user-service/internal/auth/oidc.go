@@ -0,0 +1,353 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// OIDCLoginTTL is how long a user has to sign in at the provider
+	OIDCLoginTTL = 10 * time.Minute
+
+	// oidcRequestTimeout bounds discovery and code exchange requests
+	oidcRequestTimeout = 10 * time.Second
+)
+
+var (
+	// ErrInvalidOIDCLogin is returned for unknown, expired or used login
+	// states
+	ErrInvalidOIDCLogin = errors.New("invalid or expired login")
+
+	// ErrInvalidIDToken is returned for ID tokens that fail verification
+	ErrInvalidIDToken = errors.New("invalid ID token")
+)
+
+// OIDCConfig is how we are registered with an OpenID Connect provider
+type OIDCConfig struct {
+	// Name identifies the provider in URLs, such as "google"
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is where the provider sends the user back to
+	RedirectURL string
+	Scopes      []string
+}
+
+// OIDCConfigsFromEnv reads the providers listed in OIDC_PROVIDERS. Each
+// provider NAME is configured with OIDC_NAME_ISSUER, OIDC_NAME_CLIENT_ID,
+// OIDC_NAME_CLIENT_SECRET and optionally OIDC_NAME_SCOPES. Users come back to
+// OIDC_REDIRECT_BASE_URL/{name}/callback.
+func OIDCConfigsFromEnv() []OIDCConfig {
+	redirectBase := strings.TrimRight(envOr("OIDC_REDIRECT_BASE_URL", "http://localhost:3000/auth/oidc"), "/")
+
+	var configs []OIDCConfig
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		configs = append(configs, OIDCConfig{
+			Name:         name,
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  redirectBase + "/" + name + "/callback",
+			Scopes:       strings.Fields(os.Getenv(prefix + "SCOPES")),
+		})
+	}
+	return configs
+}
+
+// oidcMetadata is the part of a provider's discovery document we use
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDCProvider signs users in with an OpenID Connect provider, using the
+// authorization code flow with PKCE. The provider's endpoints are discovered
+// on first use, so that a provider being down doesn't stop the server.
+type OIDCProvider struct {
+	config OIDCConfig
+	client *http.Client
+
+	mu   sync.Mutex
+	meta *oidcMetadata
+	keys *JWKSFetcher
+}
+
+// NewOIDCProvider creates a provider from its configuration
+func NewOIDCProvider(config OIDCConfig) (*OIDCProvider, error) {
+	if config.Name == "" || config.Issuer == "" || config.ClientID == "" || config.RedirectURL == "" {
+		return nil, fmt.Errorf("OIDC provider %q needs a name, issuer, client ID and redirect URL", config.Name)
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+	return &OIDCProvider{
+		config: config,
+		client: &http.Client{Timeout: oidcRequestTimeout},
+	}, nil
+}
+
+// Name identifies the provider
+func (p *OIDCProvider) Name() string {
+	return p.config.Name
+}
+
+// discover fetches the provider's discovery document, once it succeeds
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcMetadata, *JWKSFetcher, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.meta != nil {
+		return p.meta, p.keys, nil
+	}
+
+	wellKnown := strings.TrimRight(p.config.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, wellKnown, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("OIDC discovery returned %s", resp.Status)
+	}
+
+	var meta oidcMetadata
+	if err := json.NewDecoder(resp.Body).Decode(&meta); err != nil {
+		return nil, nil, fmt.Errorf("invalid OIDC discovery document: %w", err)
+	}
+	// The issuer must be the one we trust, or tokens from it wouldn't verify
+	if meta.Issuer != p.config.Issuer {
+		return nil, nil, fmt.Errorf("OIDC discovery returned issuer %q, expected %q", meta.Issuer, p.config.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, nil, errors.New("OIDC discovery document is missing endpoints")
+	}
+
+	p.meta = &meta
+	p.keys = NewJWKSFetcher(meta.JWKSURI)
+	return p.meta, p.keys, nil
+}
+
+// AuthorizationURL returns where to send the user to sign in. state and nonce
+// tie the answer to this login, and the PKCE verifier's challenge to the code
+// exchange.
+func (p *OIDCProvider) AuthorizationURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	meta, _, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {PKCEChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return meta.AuthorizationEndpoint + sep + query.Encode(), nil
+}
+
+// PKCEChallenge derives the S256 code challenge from a PKCE verifier
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return b64.EncodeToString(sum[:])
+}
+
+// IDTokenClaims are the claims of a verified ID token
+type IDTokenClaims struct {
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+	Picture           string `json:"picture"`
+	Nonce             string `json:"nonce"`
+	AuthorizedParty   string `json:"azp"`
+	jwt.RegisteredClaims
+}
+
+// Exchange trades an authorization code for the user's verified ID token
+func (p *OIDCProvider) Exchange(ctx context.Context, code, verifier, nonce string) (*IDTokenClaims, error) {
+	meta, _, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"client_id":     {p.config.ClientID},
+		"code_verifier": {verifier},
+	}
+	if p.config.ClientSecret != "" {
+		form.Set("client_secret", p.config.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return nil, fmt.Errorf("invalid token response (%s): %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK || body.Error != "" {
+		return nil, fmt.Errorf("token request failed (%s): %s %s", resp.Status, body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return nil, errors.New("token response has no ID token")
+	}
+
+	return p.VerifyIDToken(ctx, body.IDToken, nonce)
+}
+
+// VerifyIDToken checks an ID token's signature against the provider's keys,
+// and that it was issued by the provider for us and for this login
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, raw, nonce string) (*IDTokenClaims, error) {
+	meta, keys, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &IDTokenClaims{}
+	token, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			return nil, errors.New("token has no key ID")
+		}
+		pub, err := keys.publicKey(kid)
+		if err != nil {
+			return nil, err
+		}
+
+		method, err := signingMethod(pub)
+		if err != nil {
+			return nil, err
+		}
+		if token.Method.Alg() != method.Alg() {
+			return nil, errors.New("invalid signing method")
+		}
+		return pub, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}),
+		jwt.WithIssuer(meta.Issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(clockSkew),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+	if !token.Valid {
+		return nil, ErrInvalidIDToken
+	}
+
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidIDToken)
+	}
+	// A token meant for several clients has to name us as the one it was
+	// issued to
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.config.ClientID {
+		return nil, fmt.Errorf("%w: issued to %q", ErrInvalidIDToken, claims.AuthorizedParty)
+	}
+	if claims.Nonce != nonce {
+		return nil, fmt.Errorf("%w: nonce does not match", ErrInvalidIDToken)
+	}
+	return claims, nil
+}
+
+// OIDCLogin is a login started at a provider
+type OIDCLogin struct {
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+// StartOIDCLogin creates the state, nonce and PKCE verifier for a login at
+// provider, and keeps the latter two until the user comes back
+func StartOIDCLogin(ctx context.Context, db *sql.DB, provider string) (OIDCLogin, error) {
+	var login OIDCLogin
+	for _, v := range []*string{&login.State, &login.Nonce, &login.Verifier} {
+		token, err := randomToken(32)
+		if err != nil {
+			return OIDCLogin{}, err
+		}
+		*v = token
+	}
+
+	// Abandoned logins are cleared out as new ones start
+	if _, err := db.ExecContext(ctx, `DELETE FROM oidc_logins WHERE expires_at <= CURRENT_TIMESTAMP`); err != nil {
+		return OIDCLogin{}, err
+	}
+
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO oidc_logins (state_hash, provider, nonce, code_verifier, expires_at)
+		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP + make_interval(secs => $5))
+	`, HashRefreshToken(login.State), provider, login.Nonce, login.Verifier, OIDCLoginTTL.Seconds())
+	if err != nil {
+		return OIDCLogin{}, err
+	}
+	return login, nil
+}
+
+// FinishOIDCLogin uses up the login that state belongs to and returns its
+// nonce and PKCE verifier
+func FinishOIDCLogin(ctx context.Context, db *sql.DB, provider, state string) (OIDCLogin, error) {
+	login := OIDCLogin{State: state}
+	err := db.QueryRowContext(ctx, `
+		DELETE FROM oidc_logins
+		WHERE state_hash = $1 AND provider = $2 AND expires_at > CURRENT_TIMESTAMP
+		RETURNING nonce, code_verifier
+	`, HashRefreshToken(state), provider).Scan(&login.Nonce, &login.Verifier)
+	if err == sql.ErrNoRows {
+		return OIDCLogin{}, ErrInvalidOIDCLogin
+	}
+	return login, err
+}
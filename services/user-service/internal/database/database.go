@@ -108,6 +108,146 @@ func runMigrations(db *sql.DB) error {
 
 	CREATE INDEX IF NOT EXISTS idx_subscriptions_user_id ON subscriptions (user_id);
 	CREATE INDEX IF NOT EXISTS idx_subscriptions_channel_name ON subscriptions (channel_name);
+
+	-- Subscriptions point at channels, which the video service owns. Channels
+	-- may share a display name, so only the ID has to be unique.
+	ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS channel_id INTEGER;
+	ALTER TABLE subscriptions DROP CONSTRAINT IF EXISTS subscriptions_user_id_channel_name_key;
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_subscriptions_user_channel ON subscriptions (user_id, channel_id);
+
+	-- Last-visit marker used to compute unseen uploads per subscribed channel
+	ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS last_visited_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
+
+	ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS notification_level VARCHAR(20) NOT NULL DEFAULT 'personalized'
+		CHECK (notification_level IN ('all', 'personalized', 'none'));
+	CREATE INDEX IF NOT EXISTS idx_subscriptions_channel_id ON subscriptions (channel_id);
+
+	-- Columns the auth handlers rely on
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS password VARCHAR(255) NOT NULL DEFAULT '';
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(50) NOT NULL DEFAULT 'user';
+
+	CREATE TABLE IF NOT EXISTS refresh_tokens (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		token_hash CHAR(64) NOT NULL UNIQUE,
+		family_id VARCHAR(32) NOT NULL,
+		replaced_by INTEGER REFERENCES refresh_tokens(id) ON DELETE SET NULL,
+		expires_at TIMESTAMP NOT NULL,
+		revoked_at TIMESTAMP,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens (family_id);
+	CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_active ON refresh_tokens (user_id) WHERE revoked_at IS NULL;
+
+	CREATE TABLE IF NOT EXISTS sessions (
+		id VARCHAR(32) PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		user_agent VARCHAR(512) NOT NULL DEFAULT '',
+		device VARCHAR(100) NOT NULL DEFAULT '',
+		ip_address VARCHAR(45) NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		last_used_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		expires_at TIMESTAMP NOT NULL,
+		revoked_at TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_sessions_user_active ON sessions (user_id) WHERE revoked_at IS NULL;
+
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret VARCHAR(64);
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step BIGINT;
+	ALTER TABLE sessions ADD COLUMN IF NOT EXISTS mfa BOOLEAN NOT NULL DEFAULT FALSE;
+
+	CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		code_hash CHAR(64) NOT NULL,
+		used_at TIMESTAMP,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_mfa_recovery_codes_user ON mfa_recovery_codes (user_id, code_hash);
+
+	CREATE TABLE IF NOT EXISTS mfa_challenges (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		token_hash CHAR(64) NOT NULL UNIQUE,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		expires_at TIMESTAMP NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_mfa_challenges_user ON mfa_challenges (user_id);
+
+	-- Accounts that exist before verification was introduced count as verified
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
+	ALTER TABLE users ALTER COLUMN email_verified_at DROP DEFAULT;
+
+	CREATE TABLE IF NOT EXISTS account_tokens (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		purpose VARCHAR(20) NOT NULL,
+		token_hash CHAR(64) NOT NULL UNIQUE,
+		email VARCHAR(255) NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		used_at TIMESTAMP,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_account_tokens_user_purpose ON account_tokens (user_id, purpose);
+
+	CREATE TABLE IF NOT EXISTS login_attempts (
+		id BIGSERIAL PRIMARY KEY,
+		email VARCHAR(255) NOT NULL,
+		user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		ip_address VARCHAR(45) NOT NULL DEFAULT '',
+		user_agent VARCHAR(512) NOT NULL DEFAULT '',
+		succeeded BOOLEAN NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_login_attempts_email ON login_attempts (email, created_at);
+	CREATE INDEX IF NOT EXISTS idx_login_attempts_ip ON login_attempts (ip_address, created_at) WHERE succeeded = FALSE;
+
+	CREATE TABLE IF NOT EXISTS user_identities (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		provider VARCHAR(50) NOT NULL,
+		subject VARCHAR(255) NOT NULL,
+		email VARCHAR(255) NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		last_login_at TIMESTAMP,
+		UNIQUE (provider, subject)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_user_identities_user ON user_identities (user_id);
+
+	CREATE TABLE IF NOT EXISTS oidc_logins (
+		state_hash CHAR(64) PRIMARY KEY,
+		provider VARCHAR(50) NOT NULL,
+		nonce VARCHAR(64) NOT NULL,
+		code_verifier VARCHAR(128) NOT NULL,
+		expires_at TIMESTAMP NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		name VARCHAR(100) NOT NULL,
+		prefix VARCHAR(16) NOT NULL,
+		key_hash CHAR(64) NOT NULL UNIQUE,
+		scopes TEXT[] NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		expires_at TIMESTAMP NOT NULL,
+		last_used_at TIMESTAMP,
+		revoked_at TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_api_keys_user ON api_keys (user_id);
+
+	ALTER TABLE users ALTER COLUMN role SET DEFAULT 'creator';
+	UPDATE users SET role = 'creator' WHERE role = 'user';
 	`
 
 	_, err := db.Exec(query)
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aung-arata/youtube-clone/services/user-service/internal/auth"
+	"github.com/aung-arata/youtube-clone/services/user-service/internal/middleware"
+	"github.com/gorilla/mux"
+)
+
+// APIKeyHandler lets users manage API keys for their scripts
+type APIKeyHandler struct {
+	db *sql.DB
+}
+
+// NewAPIKeyHandler creates an APIKeyHandler
+func NewAPIKeyHandler(db *sql.DB) *APIKeyHandler {
+	return &APIKeyHandler{db: db}
+}
+
+// CreateAPIKeyRequest names a new key and says what it may do
+type CreateAPIKeyRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"`
+}
+
+// CreateAPIKeyResponse holds a new key. The key is only ever shown here.
+type CreateAPIKeyResponse struct {
+	auth.APIKey
+	Key string `json:"key"`
+}
+
+// CreateAPIKey creates an API key for the authenticated user
+func (h *APIKeyHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		http.Error(w, "Name must be between 1 and 100 characters", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Scopes) == 0 {
+		http.Error(w, "At least one scope is required", http.StatusBadRequest)
+		return
+	}
+	scopes := make([]string, 0, len(req.Scopes))
+	seen := make(map[string]bool)
+	for _, scope := range req.Scopes {
+		if !auth.ValidScope(scope) {
+			http.Error(w, fmt.Sprintf("Unknown scope %q, must be one of %s", scope, strings.Join(auth.APIKeyScopes, ", ")), http.StatusBadRequest)
+			return
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+
+	ttl := auth.DefaultAPIKeyTTL
+	if req.ExpiresInDays != 0 {
+		ttl = time.Duration(req.ExpiresInDays) * 24 * time.Hour
+		if req.ExpiresInDays < 0 || ttl > auth.MaxAPIKeyTTL {
+			http.Error(w, fmt.Sprintf("Expiry must be between 1 and %d days", int(auth.MaxAPIKeyTTL.Hours()/24)), http.StatusBadRequest)
+			return
+		}
+	}
+
+	apiKey, key, err := auth.CreateAPIKey(r.Context(), h.db, userID, req.Name, scopes, ttl)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CreateAPIKeyResponse{APIKey: apiKey, Key: key})
+}
+
+// ListAPIKeys returns the authenticated user's API keys, without the keys
+// themselves
+func (h *APIKeyHandler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	keys, err := auth.ListAPIKeys(r.Context(), h.db, userID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// RevokeAPIKey stops one of the authenticated user's API keys from working
+func (h *APIKeyHandler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid API key ID", http.StatusBadRequest)
+		return
+	}
+
+	revoked, err := auth.RevokeAPIKey(r.Context(), h.db, userID, id)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !revoked {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// VerifyAPIKey tells other services who an API key acts for
+func (h *APIKeyHandler) VerifyAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Key string `json:"key"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	principal, err := auth.NewSQLAPIKeyVerifier(h.db).VerifyAPIKey(r.Context(), req.Key)
+	if errors.Is(err, auth.ErrInvalidAPIKey) {
+		http.Error(w, "Invalid or expired API key", http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(principal)
+}
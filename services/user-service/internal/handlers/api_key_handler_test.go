@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/aung-arata/youtube-clone/services/user-service/internal/auth"
+)
+
+func TestVerifyAPIKey(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewAPIKeyHandler(db)
+
+	mock.ExpectQuery("SELECT k.id, u.id, u.username, u.role(.+) FROM api_keys k JOIN users u").
+		WithArgs(auth.HashAPIKey("yt_secret")).
+		WillReturnRows(sqlmock.NewRows([]string{"key_id", "user_id", "username", "role", "verified", "scopes"}).
+			AddRow(3, 7, "alice", auth.RoleCreator, true, "{videos:write}"))
+	mock.ExpectExec("UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP").
+		WithArgs(3, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	req := httptest.NewRequest("POST", "/internal/api-keys/verify", bytes.NewBufferString(`{"key":"yt_secret"}`))
+	w := httptest.NewRecorder()
+
+	handler.VerifyAPIKey(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var principal auth.APIKeyPrincipal
+	if err := json.NewDecoder(w.Body).Decode(&principal); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if principal.UserID != 7 || principal.KeyID != 3 || !principal.HasScope("videos:write") {
+		t.Errorf("Unexpected principal %+v", principal)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestVerifyAPIKey_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    string
+		expect func(mock sqlmock.Sqlmock)
+	}{
+		// Tokens that aren't API keys are turned away without a lookup
+		{"Not an API key", "eyJhbGciOi", func(mock sqlmock.Sqlmock) {}},
+		{"Unknown, revoked or expired key", "yt_revoked", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery("FROM api_keys k JOIN users u").
+				WithArgs(auth.HashAPIKey("yt_revoked")).
+				WillReturnRows(sqlmock.NewRows([]string{"key_id", "user_id", "username", "role", "verified", "scopes"}))
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("Failed to create mock: %v", err)
+			}
+			defer db.Close()
+
+			tt.expect(mock)
+
+			body, _ := json.Marshal(map[string]string{"key": tt.key})
+			req := httptest.NewRequest("POST", "/internal/api-keys/verify", bytes.NewBuffer(body))
+			w := httptest.NewRecorder()
+
+			NewAPIKeyHandler(db).VerifyAPIKey(w, req)
+
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("Expected status 401, got %d", w.Code)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
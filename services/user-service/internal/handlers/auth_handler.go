@@ -1,22 +1,40 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aung-arata/youtube-clone/services/user-service/internal/auth"
 	"github.com/aung-arata/youtube-clone/services/user-service/internal/middleware"
 	"github.com/aung-arata/youtube-clone/services/user-service/internal/models"
+	"github.com/gorilla/mux"
 )
 
+// AccountMailer sends the links that verify an email address or reset a
+// password, and warns users whose account was locked out
+type AccountMailer interface {
+	SendEmailVerification(ctx context.Context, username, email, token string, ttl time.Duration) error
+	SendPasswordReset(ctx context.Context, username, email, token string, ttl time.Duration) error
+	SendLoginAlert(ctx context.Context, username, email, ip string, attempts int, lockout time.Duration) error
+}
+
 type AuthHandler struct {
-	db *sql.DB
+	db     *sql.DB
+	mailer AccountMailer
 }
 
-func NewAuthHandler(db *sql.DB) *AuthHandler {
-	return &AuthHandler{db: db}
+// NewAuthHandler creates an AuthHandler. Without a mailer, no verification or
+// password reset emails are sent.
+func NewAuthHandler(db *sql.DB, mailer AccountMailer) *AuthHandler {
+	return &AuthHandler{db: db, mailer: mailer}
 }
 
 // SignupRequest represents the signup request body
@@ -33,10 +51,19 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
-// AuthResponse represents the authentication response
+// RefreshRequest carries a refresh token to exchange or revoke
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// AuthResponse represents the authentication response. MFASetupRequired is
+// set for moderators and admins who have yet to enable two-factor
+// authentication, which staff routes require.
 type AuthResponse struct {
-	Token string       `json:"token"`
-	User  models.User `json:"user"`
+	auth.TokenPair
+	User             models.User `json:"user"`
+	EmailVerified    bool        `json:"email_verified"`
+	MFASetupRequired bool        `json:"mfa_setup_required,omitempty"`
 }
 
 // Signup handles user registration
@@ -85,7 +112,7 @@ func (h *AuthHandler) Signup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Create user with default role "user"
+	// New users are creators, so they can start a channel
 	query := `
 		INSERT INTO users (username, email, password, avatar, role)
 		VALUES ($1, $2, $3, $4, $5)
@@ -93,7 +120,7 @@ func (h *AuthHandler) Signup(w http.ResponseWriter, r *http.Request) {
 	`
 
 	var user models.User
-	err = h.db.QueryRow(query, req.Username, req.Email, hashedPassword, req.Avatar, "user").Scan(
+	err = h.db.QueryRow(query, req.Username, req.Email, hashedPassword, req.Avatar, auth.RoleCreator).Scan(
 		&user.ID, &user.Username, &user.Email, &user.Avatar, &user.Role, &user.PlanID, &user.CreatedAt, &user.UpdatedAt)
 
 	if err != nil {
@@ -101,17 +128,25 @@ func (h *AuthHandler) Signup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Generate JWT token
-	token, err := auth.GenerateToken(user.ID, user.Username, user.Role)
+	// Start a new login with an access and refresh token
+	tokens, err := auth.IssueTokens(r.Context(), h.db, auth.TokenSubject{
+		UserID:   user.ID,
+		Username: user.Username,
+		Role:     user.Role,
+	}, clientInfo(r))
 	if err != nil {
 		http.Error(w, "Error generating token", http.StatusInternalServerError)
 		return
 	}
 
+	if h.mailer != nil {
+		go h.sendVerificationEmail(user.ID, user.Username, user.Email)
+	}
+
 	// Return response
 	response := AuthResponse{
-		Token: token,
-		User:  user,
+		TokenPair: tokens,
+		User:      user,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -137,20 +172,39 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Back off after failed logins on the account or from the address
+	client := clientInfo(r)
+	status, err := auth.CheckLogin(r.Context(), h.db, req.Email, client.IP)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if status.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(status.RetryAfter.Seconds()))))
+		http.Error(w, "Too many failed login attempts, please try again later", http.StatusTooManyRequests)
+		return
+	}
+
 	// Get user by email
 	query := `
-		SELECT id, username, email, password, avatar, role, plan_id, created_at, updated_at
+		SELECT id, username, email, password, avatar, role, plan_id, created_at, updated_at,
+		       totp_enabled, email_verified_at IS NOT NULL
 		FROM users
 		WHERE email = $1
 	`
 
 	var user models.User
 	var hashedPassword string
-	err := h.db.QueryRow(query, req.Email).Scan(
-		&user.ID, &user.Username, &user.Email, &hashedPassword, &user.Avatar, &user.Role, &user.PlanID, &user.CreatedAt, &user.UpdatedAt)
+	var totpEnabled, emailVerified bool
+	err = h.db.QueryRow(query, req.Email).Scan(
+		&user.ID, &user.Username, &user.Email, &hashedPassword, &user.Avatar, &user.Role, &user.PlanID, &user.CreatedAt, &user.UpdatedAt,
+		&totpEnabled, &emailVerified)
 
 	if err == sql.ErrNoRows {
-		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
+		// Take as long as a wrong password, so that the response doesn't
+		// reveal whether the account exists
+		auth.CompareDummyPassword(req.Password)
+		h.loginFailed(w, r, req.Email, nil, client, status)
 		return
 	} else if err != nil {
 		http.Error(w, "Database error", http.StatusInternalServerError)
@@ -159,12 +213,41 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 
 	// Compare passwords
 	if err := auth.ComparePasswords(hashedPassword, req.Password); err != nil {
-		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
+		h.loginFailed(w, r, req.Email, &user, client, status)
+		return
+	}
+
+	// With two-factor authentication, the login finishes at LoginTwoFactor.
+	// It only counts as successful there, so that knowing the password
+	// doesn't reset the failures that wrong codes add up to.
+	if totpEnabled {
+		challenge, err := auth.CreateMFAChallenge(r.Context(), h.db, user.ID)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(MFAChallengeResponse{
+			MFARequired: true,
+			MFAToken:    challenge,
+			ExpiresIn:   int(auth.MFAChallengeTTL.Seconds()),
+		})
 		return
 	}
 
-	// Generate JWT token
-	token, err := auth.GenerateToken(user.ID, user.Username, user.Role)
+	if err := auth.RecordLoginAttempt(r.Context(), h.db, req.Email, user.ID, client, true); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// Start a new login with an access and refresh token
+	tokens, err := auth.IssueTokens(r.Context(), h.db, auth.TokenSubject{
+		UserID:        user.ID,
+		Username:      user.Username,
+		Role:          user.Role,
+		EmailVerified: emailVerified,
+	}, clientInfo(r))
 	if err != nil {
 		http.Error(w, "Error generating token", http.StatusInternalServerError)
 		return
@@ -172,42 +255,182 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 
 	// Return response
 	response := AuthResponse{
-		Token: token,
-		User:  user,
+		TokenPair:        tokens,
+		User:             user,
+		EmailVerified:    emailVerified,
+		MFASetupRequired: auth.RoleRequiresMFA(user.Role),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
-// RefreshToken handles token refresh
+// loginFailed records a failed login and responds to it. user is nil when no
+// account uses the email address.
+func (h *AuthHandler) loginFailed(w http.ResponseWriter, r *http.Request, email string, user *models.User, client auth.Client, status auth.LoginStatus) {
+	if err := h.recordLoginFailure(r, email, user, client, status); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	http.Error(w, "Invalid email or password", http.StatusUnauthorized)
+}
+
+// recordLoginFailure adds a failed login, with a wrong password or a wrong
+// two-factor code, and warns the user when it locks their account
+func (h *AuthHandler) recordLoginFailure(r *http.Request, email string, user *models.User, client auth.Client, status auth.LoginStatus) error {
+	userID := 0
+	if user != nil {
+		userID = user.ID
+	}
+	if err := auth.RecordLoginAttempt(r.Context(), h.db, email, userID, client, false); err != nil {
+		return err
+	}
+
+	failures := status.Failures + 1
+	if failures == auth.MaxLoginFailures {
+		log.Printf("Login for %q locked out after %d failures, the last from %s", auth.NormalizeEmail(email), failures, client.IP)
+		if user != nil && h.mailer != nil {
+			go h.sendLoginAlert(*user, client.IP, failures)
+		}
+	}
+	return nil
+}
+
+func (h *AuthHandler) sendLoginAlert(user models.User, ip string, failures int) {
+	ctx, cancel := context.WithTimeout(context.Background(), accountEmailTimeout)
+	defer cancel()
+
+	if err := h.mailer.SendLoginAlert(ctx, user.Username, user.Email, ip, failures, auth.LockoutDuration); err != nil {
+		log.Printf("Failed to send login alert to user %d: %v", user.ID, err)
+	}
+}
+
+// RefreshToken exchanges a refresh token for a new access and refresh
+// token. Each refresh token works once.
 func (h *AuthHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
-	// Get token from Authorization header
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		http.Error(w, "Authorization header required", http.StatusUnauthorized)
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.RefreshToken == "" {
+		http.Error(w, "Refresh token is required", http.StatusBadRequest)
 		return
 	}
 
-	parts := strings.Split(authHeader, " ")
-	if len(parts) != 2 || parts[0] != "Bearer" {
-		http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
+	tokens, err := auth.RotateRefreshToken(r.Context(), h.db, req.RefreshToken, clientInfo(r))
+	if errors.Is(err, auth.ErrRefreshTokenReused) {
+		log.Printf("Refresh token reused; revoked its login")
+		http.Error(w, "Refresh token was already used, please log in again", http.StatusUnauthorized)
+		return
+	} else if errors.Is(err, auth.ErrInvalidRefreshToken) {
+		http.Error(w, "Invalid or expired refresh token", http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
 
-	// Refresh token
-	newToken, err := auth.RefreshToken(parts[1])
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// Logout revokes the login a refresh token belongs to, along with the access
+// tokens issued for it
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.RefreshToken == "" {
+		http.Error(w, "Refresh token is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := auth.RevokeRefreshToken(r.Context(), h.db, req.RefreshToken); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LogoutAll revokes every session of the authenticated user
+func (h *AuthHandler) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	revoked, err := auth.RevokeAllSessions(r.Context(), h.db, userID)
 	if err != nil {
-		http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
 
-	response := map[string]string{
-		"token": newToken,
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Logged out of all sessions",
+		"revoked": revoked,
+	})
+}
+
+// ListSessions returns where the authenticated user is logged in
+func (h *AuthHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	sessions, err := auth.ListSessions(r.Context(), h.db, userID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	current, _ := r.Context().Value(middleware.SessionIDKey).(string)
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == current
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// RevokeSession logs the authenticated user out of one of their sessions
+func (h *AuthHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	revoked, err := auth.RevokeSession(r.Context(), h.db, userID, mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !revoked {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SessionStatus tells other services whether a session is still active
+func (h *AuthHandler) SessionStatus(w http.ResponseWriter, r *http.Request) {
+	active, err := auth.NewSQLSessionChecker(h.db).SessionActive(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"active": active})
 }
 
 // GetCurrentUser returns the currently authenticated user
@@ -241,3 +464,16 @@ func (h *AuthHandler) GetCurrentUser(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(user)
 }
+
+// JWKS publishes the public keys access tokens are signed with, so that
+// other services can verify tokens without being able to issue them
+func (h *AuthHandler) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(auth.PublicJWKS())
+}
+
+// clientInfo describes the device a request comes from
+func clientInfo(r *http.Request) auth.Client {
+	return auth.Client{UserAgent: r.UserAgent(), IP: auth.ClientIP(r)}
+}
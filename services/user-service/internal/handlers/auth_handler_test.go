@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+)
+
+func TestSessionStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		rows   *sqlmock.Rows
+		active bool
+	}{
+		{"active session", sqlmock.NewRows([]string{"active"}).AddRow(true), true},
+		{"revoked session", sqlmock.NewRows([]string{"active"}).AddRow(false), false},
+		{"unknown session", sqlmock.NewRows([]string{"active"}), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("Failed to create mock: %v", err)
+			}
+			defer db.Close()
+
+			mock.ExpectQuery("SELECT revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP FROM sessions").
+				WithArgs("session-1").
+				WillReturnRows(tt.rows)
+
+			req := httptest.NewRequest("GET", "/internal/sessions/session-1", nil)
+			req = mux.SetURLVars(req, map[string]string{"id": "session-1"})
+			rr := httptest.NewRecorder()
+
+			NewAuthHandler(db, nil).SessionStatus(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, rr.Code)
+			}
+			var body map[string]bool
+			if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if body["active"] != tt.active {
+				t.Errorf("Expected active %v, got %v", tt.active, body["active"])
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,334 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aung-arata/youtube-clone/services/user-service/internal/auth"
+	"github.com/aung-arata/youtube-clone/services/user-service/internal/models"
+	"github.com/gorilla/mux"
+)
+
+var (
+	// errOIDCNoEmail is returned when a provider doesn't share an email
+	// address, which every account needs
+	errOIDCNoEmail = errors.New("identity provider did not share an email address")
+
+	// errOIDCEmailTaken is returned when an account uses the address but the
+	// provider hasn't verified it, so it can't be linked
+	errOIDCEmailTaken = errors.New("email address belongs to another account")
+
+	// errOIDCEmailUnclaimed is returned when an account uses the address but
+	// has never verified it. Whoever signed up with it may not own it, and
+	// linking would let them keep their password.
+	errOIDCEmailUnclaimed = errors.New("email address belongs to an unverified account")
+
+	// usernameUnsafe matches characters left out of generated usernames
+	usernameUnsafe = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+)
+
+// oidcStateCookie ties a login's state to the browser that started it, so
+// that nobody can finish their own login in someone else's browser
+const oidcStateCookie = "oidc_state"
+
+// OIDCHandler signs users in with external OpenID Connect providers
+type OIDCHandler struct {
+	db        *sql.DB
+	providers map[string]*auth.OIDCProvider
+}
+
+// NewOIDCHandler creates an OIDCHandler for the given providers
+func NewOIDCHandler(db *sql.DB, providers []*auth.OIDCProvider) *OIDCHandler {
+	h := &OIDCHandler{db: db, providers: make(map[string]*auth.OIDCProvider)}
+	for _, p := range providers {
+		h.providers[p.Name()] = p
+	}
+	return h
+}
+
+// OIDCCallbackRequest carries what the provider sent the user back with
+type OIDCCallbackRequest struct {
+	Code  string `json:"code"`
+	State string `json:"state"`
+}
+
+// OIDCAuthorizeResponse holds where to send the user to sign in
+type OIDCAuthorizeResponse struct {
+	AuthorizationURL string `json:"authorization_url"`
+	State            string `json:"state"`
+}
+
+// ListProviders returns the names of the providers users can sign in with
+func (h *OIDCHandler) ListProviders(w http.ResponseWriter, r *http.Request) {
+	names := make([]string, 0, len(h.providers))
+	for name := range h.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{"providers": names})
+}
+
+// Authorize starts a login at a provider. The client keeps the returned state
+// and sends the user to the authorization URL. The state is also set in an
+// HttpOnly cookie, which Callback checks.
+func (h *OIDCHandler) Authorize(w http.ResponseWriter, r *http.Request) {
+	provider, ok := h.providers[mux.Vars(r)["provider"]]
+	if !ok {
+		http.Error(w, "Unknown identity provider", http.StatusNotFound)
+		return
+	}
+
+	login, err := auth.StartOIDCLogin(r.Context(), h.db, provider.Name())
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	authURL, err := provider.AuthorizationURL(r.Context(), login.State, login.Nonce, login.Verifier)
+	if err != nil {
+		log.Printf("Failed to reach identity provider %s: %v", provider.Name(), err)
+		http.Error(w, "Identity provider is unavailable", http.StatusBadGateway)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    login.State,
+		Path:     "/",
+		MaxAge:   int(auth.OIDCLoginTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(OIDCAuthorizeResponse{AuthorizationURL: authURL, State: login.State})
+}
+
+// Callback finishes a login with the code and state the provider sent the
+// user back with. The provider's account is linked to ours by its subject,
+// or on first use to the account with the same verified email address. Users
+// without an account get a new one.
+func (h *OIDCHandler) Callback(w http.ResponseWriter, r *http.Request) {
+	provider, ok := h.providers[mux.Vars(r)["provider"]]
+	if !ok {
+		http.Error(w, "Unknown identity provider", http.StatusNotFound)
+		return
+	}
+
+	var req OIDCCallbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Code == "" || req.State == "" {
+		http.Error(w, "Code and state are required", http.StatusBadRequest)
+		return
+	}
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(req.State)) != 1 {
+		http.Error(w, "Login was started in another browser, please try again", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/", MaxAge: -1, HttpOnly: true})
+
+	login, err := auth.FinishOIDCLogin(r.Context(), h.db, provider.Name(), req.State)
+	if errors.Is(err, auth.ErrInvalidOIDCLogin) {
+		http.Error(w, "Login expired, please try again", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	claims, err := provider.Exchange(r.Context(), req.Code, login.Verifier, login.Nonce)
+	if err != nil {
+		log.Printf("Login with %s failed: %v", provider.Name(), err)
+		http.Error(w, "Identity provider rejected the login", http.StatusUnauthorized)
+		return
+	}
+
+	user, totpEnabled, emailVerified, err := h.linkedUser(r.Context(), provider.Name(), claims)
+	if errors.Is(err, errOIDCNoEmail) {
+		http.Error(w, "The identity provider didn't share your email address", http.StatusBadRequest)
+		return
+	} else if errors.Is(err, errOIDCEmailTaken) {
+		http.Error(w, "An account already uses this email address, please log in with your password", http.StatusConflict)
+		return
+	} else if errors.Is(err, errOIDCEmailUnclaimed) {
+		http.Error(w, "An account with this email address hasn't verified it, please reset its password to claim it", http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// With two-factor authentication, the login finishes at LoginTwoFactor
+	if totpEnabled {
+		challenge, err := auth.CreateMFAChallenge(r.Context(), h.db, user.ID)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(MFAChallengeResponse{
+			MFARequired: true,
+			MFAToken:    challenge,
+			ExpiresIn:   int(auth.MFAChallengeTTL.Seconds()),
+		})
+		return
+	}
+
+	tokens, err := auth.IssueTokens(r.Context(), h.db, auth.TokenSubject{
+		UserID:        user.ID,
+		Username:      user.Username,
+		Role:          user.Role,
+		EmailVerified: emailVerified,
+	}, clientInfo(r))
+	if err != nil {
+		http.Error(w, "Error generating token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AuthResponse{
+		TokenPair:        tokens,
+		User:             user,
+		EmailVerified:    emailVerified,
+		MFASetupRequired: auth.RoleRequiresMFA(user.Role),
+	})
+}
+
+const linkedUserColumns = `
+	u.id, u.username, u.email, u.avatar, u.role, u.plan_id, u.created_at, u.updated_at,
+	u.totp_enabled, u.email_verified_at IS NOT NULL
+`
+
+// linkedUser finds or creates the account a provider's user signs in to
+func (h *OIDCHandler) linkedUser(ctx context.Context, provider string, claims *auth.IDTokenClaims) (models.User, bool, bool, error) {
+	var user models.User
+	var totpEnabled, emailVerified bool
+	scan := func(row *sql.Row) error {
+		return row.Scan(&user.ID, &user.Username, &user.Email, &user.Avatar, &user.Role, &user.PlanID, &user.CreatedAt, &user.UpdatedAt,
+			&totpEnabled, &emailVerified)
+	}
+
+	err := scan(h.db.QueryRowContext(ctx, `
+		SELECT `+linkedUserColumns+`
+		FROM user_identities i JOIN users u ON u.id = i.user_id
+		WHERE i.provider = $1 AND i.subject = $2
+	`, provider, claims.Subject))
+	if err == nil {
+		_, err = h.db.ExecContext(ctx, `
+			UPDATE user_identities SET email = $3, last_login_at = CURRENT_TIMESTAMP
+			WHERE provider = $1 AND subject = $2
+		`, provider, claims.Subject, claims.Email)
+		return user, totpEnabled, emailVerified, err
+	} else if err != sql.ErrNoRows {
+		return user, false, false, err
+	}
+
+	if claims.Email == "" {
+		return user, false, false, errOIDCNoEmail
+	}
+
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return user, false, false, err
+	}
+	defer tx.Rollback()
+
+	err = scan(tx.QueryRowContext(ctx, `
+		SELECT `+linkedUserColumns+`
+		FROM users u
+		WHERE LOWER(u.email) = LOWER($1)
+		FOR UPDATE
+	`, claims.Email))
+	switch {
+	case err == nil:
+		// Only an address the provider checked proves it's the same person
+		if !claims.EmailVerified {
+			return user, false, false, errOIDCEmailTaken
+		}
+		// A password reset proves the address first, and logs out and
+		// revokes the keys of whoever signed up with it
+		if !emailVerified {
+			return user, false, false, errOIDCEmailUnclaimed
+		}
+	case err == sql.ErrNoRows:
+		if err := createOIDCUser(ctx, tx, claims, &user); err != nil {
+			return user, false, false, err
+		}
+		totpEnabled, emailVerified = false, claims.EmailVerified
+	default:
+		return user, false, false, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO user_identities (user_id, provider, subject, email, last_login_at)
+		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP)
+	`, user.ID, provider, claims.Subject, claims.Email); err != nil {
+		return user, false, false, err
+	}
+
+	return user, totpEnabled, emailVerified, tx.Commit()
+}
+
+// createOIDCUser creates an account without a password for a provider's user.
+// They can set one through a password reset.
+func createOIDCUser(ctx context.Context, tx *sql.Tx, claims *auth.IDTokenClaims, user *models.User) error {
+	base := oidcUsername(claims)
+
+	// Try a few suffixes if the name is taken
+	username := base
+	for attempt := 0; attempt < 5; attempt++ {
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO users (username, email, password, avatar, role, email_verified_at)
+			VALUES ($1, $2, '', $3, $5, CASE WHEN $4 THEN CURRENT_TIMESTAMP END)
+			ON CONFLICT DO NOTHING
+			RETURNING id, username, email, avatar, role, plan_id, created_at, updated_at
+		`, username, claims.Email, claims.Picture, claims.EmailVerified, auth.RoleCreator).Scan(
+			&user.ID, &user.Username, &user.Email, &user.Avatar, &user.Role, &user.PlanID, &user.CreatedAt, &user.UpdatedAt)
+		if err != sql.ErrNoRows {
+			return err
+		}
+
+		n, err := rand.Int(rand.Reader, big.NewInt(10000))
+		if err != nil {
+			return err
+		}
+		username = fmt.Sprintf("%s%04d", base, n)
+	}
+	return errors.New("could not find a free username")
+}
+
+// oidcUsername suggests a username from what the provider knows about the
+// user
+func oidcUsername(claims *auth.IDTokenClaims) string {
+	local, _, _ := strings.Cut(claims.Email, "@")
+	for _, candidate := range []string{claims.PreferredUsername, claims.Name, local} {
+		name := usernameUnsafe.ReplaceAllString(strings.ReplaceAll(candidate, " ", "_"), "")
+		if name != "" {
+			if len(name) > 40 {
+				name = name[:40]
+			}
+			return name
+		}
+	}
+	return "user"
+}
@@ -1,22 +1,160 @@
 package handlers
 
 import (
+	"bytes"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/aung-arata/youtube-clone/services/user-service/internal/auth"
 	"github.com/aung-arata/youtube-clone/services/user-service/internal/models"
 	"github.com/gorilla/mux"
 )
 
+var (
+	errChannelNotFound = errors.New("channel not found")
+	errInvalidCursor   = errors.New("invalid cursor")
+)
+
 type SubscriptionHandler struct {
-	db *sql.DB
+	db              *sql.DB
+	httpClient      *http.Client
+	videoServiceURL string
 }
 
 func NewSubscriptionHandler(db *sql.DB) *SubscriptionHandler {
-	return &SubscriptionHandler{db: db}
+	videoServiceURL := os.Getenv("VIDEO_SERVICE_URL")
+	if videoServiceURL == "" {
+		videoServiceURL = "http://video-service:8081" // default for docker-compose
+	}
+
+	return &SubscriptionHandler{
+		db:              db,
+		videoServiceURL: videoServiceURL,
+		httpClient: &http.Client{
+			Timeout: 5 * time.Second, // 5 second timeout for video service calls
+		},
+	}
+}
+
+// lookupChannelName returns the display name of a channel, which the video
+// service owns
+func (h *SubscriptionHandler) lookupChannelName(channelID int) (string, error) {
+	resp, err := h.httpClient.Get(fmt.Sprintf("%s/channels/%d", h.videoServiceURL, channelID))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", errChannelNotFound
+	} else if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("video service returned %d", resp.StatusCode)
+	}
+
+	var channel struct {
+		DisplayName string `json:"display_name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&channel); err != nil {
+		return "", err
+	}
+	return channel.DisplayName, nil
+}
+
+// videoServiceRequest sends a JSON body to one of the video service's
+// internal endpoints
+func (h *SubscriptionHandler) videoServiceRequest(method, path string, body interface{}) (*http.Response, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(method, h.videoServiceURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	auth.SetServiceToken(req)
+
+	return h.httpClient.Do(req)
+}
+
+// postVideoService sends an internal request to the video service and decodes
+// its JSON response into out
+func (h *SubscriptionHandler) postVideoService(path string, body, out interface{}) error {
+	resp, err := h.videoServiceRequest(http.MethodPost, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusBadRequest {
+		return errInvalidCursor
+	} else if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("video service returned %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// syncSubscriberCount recounts a channel's subscribers inside tx and sends the
+// count to the video service, which shows it on the channel. It runs before
+// the subscription change is committed, so a failure rolls the change back
+// instead of leaving the count stale.
+func (h *SubscriptionHandler) syncSubscriberCount(tx *sql.Tx, channelID int) error {
+	var count int
+	err := tx.QueryRow(`SELECT COUNT(*) FROM subscriptions WHERE channel_id = $1`, channelID).Scan(&count)
+	if err != nil {
+		return err
+	}
+
+	path := fmt.Sprintf("/internal/channels/%d/subscriber-count", channelID)
+	resp, err := h.videoServiceRequest(http.MethodPut, path, map[string]int{"subscriber_count": count})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errChannelNotFound
+	} else if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("video service returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// lockChannelSubscriptions serializes subscription changes to one channel
+// until tx ends, so counts reach the video service in the order they were
+// taken
+func lockChannelSubscriptions(tx *sql.Tx, channelID int) error {
+	_, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, channelID)
+	return err
+}
+
+// subscribedChannelIDs returns the channels a user is subscribed to.
+// Subscriptions made by channel name before channels existed are left out.
+func (h *SubscriptionHandler) subscribedChannelIDs(userID int) ([]int, error) {
+	rows, err := h.db.Query(`SELECT channel_id FROM subscriptions WHERE user_id = $1 AND channel_id IS NOT NULL`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
 }
 
 // Subscribe allows a user to subscribe to a channel
@@ -29,26 +167,63 @@ func (h *SubscriptionHandler) Subscribe(w http.ResponseWriter, r *http.Request)
 	}
 
 	var req struct {
-		ChannelName string `json:"channel_name"`
+		ChannelID         int    `json:"channel_id"`
+		NotificationLevel string `json:"notification_level"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if req.ChannelName == "" {
-		http.Error(w, "Channel name is required", http.StatusBadRequest)
+	if req.ChannelID <= 0 {
+		http.Error(w, "Channel ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if req.NotificationLevel == "" {
+		req.NotificationLevel = models.NotificationLevelPersonalized
+	} else if !models.ValidNotificationLevel(req.NotificationLevel) {
+		http.Error(w, "Notification level must be all, personalized or none", http.StatusBadRequest)
+		return
+	}
+
+	// The channel's name is kept for listing subscriptions without asking the
+	// video service again
+	channelName, err := h.lookupChannelName(req.ChannelID)
+	if err == errChannelNotFound {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Error looking up channel: "+err.Error(), http.StatusBadGateway)
 		return
 	}
 
+	tx, err := h.db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := lockChannelSubscriptions(tx, req.ChannelID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// A subscription made by the channel's name before channels existed is
+	// replaced
 	query := `
-		INSERT INTO subscriptions (user_id, channel_name)
-		VALUES ($1, $2)
-		RETURNING id, user_id, channel_name, created_at
+		WITH legacy AS (
+			DELETE FROM subscriptions WHERE user_id = $1 AND channel_id IS NULL AND channel_name = $3
+		)
+		INSERT INTO subscriptions (user_id, channel_id, channel_name, notification_level)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, user_id, channel_id, channel_name, notification_level, created_at
 	`
 
 	var sub models.Subscription
-	err = h.db.QueryRow(query, userID, req.ChannelName).Scan(&sub.ID, &sub.UserID, &sub.ChannelName, &sub.CreatedAt)
+	err = tx.QueryRow(query, userID, req.ChannelID, channelName, req.NotificationLevel).Scan(&sub.ID, &sub.UserID,
+		&sub.ChannelID, &sub.ChannelName, &sub.NotificationLevel, &sub.CreatedAt)
 	if err != nil {
 		// Check if already subscribed (duplicate key constraint violation)
 		if strings.Contains(err.Error(), "duplicate key") || strings.Contains(err.Error(), "unique constraint") {
@@ -59,6 +234,16 @@ func (h *SubscriptionHandler) Subscribe(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if err := h.syncSubscriberCount(tx, req.ChannelID); err != nil {
+		http.Error(w, "Error updating the subscriber count: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(sub)
@@ -73,14 +258,26 @@ func (h *SubscriptionHandler) Unsubscribe(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	channelName := vars["channelName"]
-	if channelName == "" {
-		http.Error(w, "Channel name is required", http.StatusBadRequest)
+	channelID, err := strconv.Atoi(vars["channelId"])
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
 		return
 	}
 
-	query := `DELETE FROM subscriptions WHERE user_id = $1 AND channel_name = $2`
-	result, err := h.db.Exec(query, userID, channelName)
+	tx, err := h.db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := lockChannelSubscriptions(tx, channelID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	query := `DELETE FROM subscriptions WHERE user_id = $1 AND channel_id = $2`
+	result, err := tx.Exec(query, userID, channelID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -97,6 +294,18 @@ func (h *SubscriptionHandler) Unsubscribe(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// A channel deleted since the subscription was made has no count to keep
+	err = h.syncSubscriberCount(tx, channelID)
+	if err != nil && err != errChannelNotFound {
+		http.Error(w, "Error updating the subscriber count: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -109,8 +318,10 @@ func (h *SubscriptionHandler) GetUserSubscriptions(w http.ResponseWriter, r *htt
 		return
 	}
 
+	// Subscriptions made by channel name before channels existed have no
+	// channel ID until the user subscribes again
 	query := `
-		SELECT id, user_id, channel_name, created_at
+		SELECT id, user_id, channel_id, channel_name, notification_level, created_at
 		FROM subscriptions
 		WHERE user_id = $1
 		ORDER BY created_at DESC
@@ -126,7 +337,7 @@ func (h *SubscriptionHandler) GetUserSubscriptions(w http.ResponseWriter, r *htt
 	var subscriptions []models.Subscription
 	for rows.Next() {
 		var sub models.Subscription
-		if err := rows.Scan(&sub.ID, &sub.UserID, &sub.ChannelName, &sub.CreatedAt); err != nil {
+		if err := rows.Scan(&sub.ID, &sub.UserID, &sub.ChannelID, &sub.ChannelName, &sub.NotificationLevel, &sub.CreatedAt); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -151,15 +362,15 @@ func (h *SubscriptionHandler) CheckSubscription(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	channelName := vars["channelName"]
-	if channelName == "" {
-		http.Error(w, "Channel name is required", http.StatusBadRequest)
+	channelID, err := strconv.Atoi(vars["channelId"])
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
 		return
 	}
 
 	var exists bool
-	query := `SELECT EXISTS(SELECT 1 FROM subscriptions WHERE user_id = $1 AND channel_name = $2)`
-	err = h.db.QueryRow(query, userID, channelName).Scan(&exists)
+	query := `SELECT EXISTS(SELECT 1 FROM subscriptions WHERE user_id = $1 AND channel_id = $2)`
+	err = h.db.QueryRow(query, userID, channelID).Scan(&exists)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -168,3 +379,261 @@ func (h *SubscriptionHandler) CheckSubscription(w http.ResponseWriter, r *http.R
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]bool{"subscribed": exists})
 }
+
+// UpdateNotificationLevel changes the bell setting of a subscription
+func (h *SubscriptionHandler) UpdateNotificationLevel(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID, err := strconv.Atoi(vars["userId"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	channelID, err := strconv.Atoi(vars["channelId"])
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		NotificationLevel string `json:"notification_level"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if !models.ValidNotificationLevel(req.NotificationLevel) {
+		http.Error(w, "Notification level must be all, personalized or none", http.StatusBadRequest)
+		return
+	}
+
+	query := `UPDATE subscriptions SET notification_level = $1 WHERE user_id = $2 AND channel_id = $3`
+	result, err := h.db.Exec(query, req.NotificationLevel, userID, channelID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if rowsAffected == 0 {
+		http.Error(w, "Subscription not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"channel_id":         channelID,
+		"notification_level": req.NotificationLevel,
+	})
+}
+
+// GetSubscribersToNotify returns the IDs of a channel's subscribers whose
+// bell setting asks to hear about a new upload. "all" always does; "none"
+// never does. Watch history lives in the history service, so "personalized"
+// asks for subscribers who visited the channel in the last 30 days instead of
+// ones who watched it.
+func (h *SubscriptionHandler) GetSubscribersToNotify(w http.ResponseWriter, r *http.Request) {
+	channelID, err := strconv.Atoi(mux.Vars(r)["channelId"])
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+
+	query := `
+		SELECT user_id
+		FROM subscriptions
+		WHERE channel_id = $1
+		  AND (notification_level = 'all'
+		       OR (notification_level = 'personalized'
+		           AND last_visited_at > CURRENT_TIMESTAMP - INTERVAL '30 days'))
+	`
+
+	rows, err := h.db.Query(query, channelID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	userIDs := []int{}
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		userIDs = append(userIDs, userID)
+	}
+
+	if err = rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(userIDs)
+}
+
+// GetSubscriptionFeed returns the newest videos from the channels a user is
+// subscribed to, newest first. The videos live in the video service, which
+// pages them on (uploaded_at, id) so that new uploads arriving between
+// requests don't shift later pages.
+func (h *SubscriptionHandler) GetSubscriptionFeed(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID, err := strconv.Atoi(vars["userId"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	channelIDs, err := h.subscribedChannelIDs(userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	feed := models.SubscriptionFeed{Videos: []json.RawMessage{}}
+	if len(channelIDs) > 0 {
+		req := map[string]interface{}{
+			"channel_ids": channelIDs,
+			"cursor":      r.URL.Query().Get("cursor"),
+			"limit":       limit,
+		}
+		err = h.postVideoService("/internal/channels/uploads", req, &feed)
+		if err == errInvalidCursor {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, "Error loading videos: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(feed)
+}
+
+// GetUnseenCounts returns, for every subscribed channel, how many videos were
+// uploaded since the user last visited that channel
+func (h *SubscriptionHandler) GetUnseenCounts(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID, err := strconv.Atoi(vars["userId"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	query := `
+		SELECT channel_id, channel_name, last_visited_at
+		FROM subscriptions
+		WHERE user_id = $1 AND channel_id IS NOT NULL
+	`
+
+	rows, err := h.db.Query(query, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	counts := []models.ChannelUnseenCount{}
+	for rows.Next() {
+		var c models.ChannelUnseenCount
+		if err := rows.Scan(&c.ChannelID, &c.ChannelName, &c.LastVisitedAt); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		counts = append(counts, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if len(counts) > 0 {
+		type since struct {
+			ChannelID int       `json:"channel_id"`
+			Since     time.Time `json:"since"`
+		}
+		req := make([]since, len(counts))
+		for i, c := range counts {
+			req[i] = since{ChannelID: c.ChannelID, Since: c.LastVisitedAt}
+		}
+
+		var uploads []struct {
+			ChannelID int `json:"channel_id"`
+			Count     int `json:"count"`
+		}
+		if err := h.postVideoService("/internal/channels/upload-counts", req, &uploads); err != nil {
+			http.Error(w, "Error counting uploads: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		byChannel := make(map[int]int, len(uploads))
+		for _, u := range uploads {
+			byChannel[u.ChannelID] = u.Count
+		}
+		for i := range counts {
+			counts[i].UnseenCount = byChannel[counts[i].ChannelID]
+		}
+	}
+
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].UnseenCount != counts[j].UnseenCount {
+			return counts[i].UnseenCount > counts[j].UnseenCount
+		}
+		return counts[i].ChannelName < counts[j].ChannelName
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(counts)
+}
+
+// MarkChannelVisited moves a subscription's last-visit marker to now, which
+// resets the channel's unseen count
+func (h *SubscriptionHandler) MarkChannelVisited(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID, err := strconv.Atoi(vars["userId"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	channelID, err := strconv.Atoi(vars["channelId"])
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+
+	query := `
+		UPDATE subscriptions
+		SET last_visited_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND channel_id = $2
+		RETURNING last_visited_at
+	`
+
+	var lastVisitedAt time.Time
+	err = h.db.QueryRow(query, userID, channelID).Scan(&lastVisitedAt)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Subscription not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"channel_id":      channelID,
+		"last_visited_at": lastVisitedAt,
+	})
+}
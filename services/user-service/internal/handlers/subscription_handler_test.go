@@ -0,0 +1,473 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+)
+
+// newFakeVideoService serves the video service endpoints the subscription
+// handler calls
+func newFakeVideoService(t *testing.T, routes map[string]http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	serveMux := http.NewServeMux()
+	for path, handler := range routes {
+		serveMux.HandleFunc(path, handler)
+	}
+	srv := httptest.NewServer(serveMux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestSubscribe_LooksUpChannelNameAndSyncsCount(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	var sentCount map[string]int
+	srv := newFakeVideoService(t, map[string]http.HandlerFunc{
+		"/channels/7": func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(map[string]interface{}{"id": 7, "display_name": "Tech Channel"})
+		},
+		"/internal/channels/7/subscriber-count": func(w http.ResponseWriter, r *http.Request) {
+			json.NewDecoder(r.Body).Decode(&sentCount)
+			w.WriteHeader(http.StatusNoContent)
+		},
+	})
+
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("INSERT INTO subscriptions").
+		WithArgs(1, 7, "Tech Channel", "personalized").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "channel_id", "channel_name", "notification_level", "created_at"}).
+			AddRow(1, 1, 7, "Tech Channel", "personalized", time.Now()))
+	mock.ExpectQuery("SELECT COUNT").WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(42))
+	mock.ExpectCommit()
+
+	h := NewSubscriptionHandler(db)
+	h.videoServiceURL = srv.URL
+
+	req := httptest.NewRequest("POST", "/users/1/subscriptions", strings.NewReader(`{"channel_id": 7}`))
+	req = mux.SetURLVars(req, map[string]string{"userId": "1"})
+	rr := httptest.NewRecorder()
+
+	h.Subscribe(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	if sentCount["subscriber_count"] != 42 {
+		t.Errorf("Expected subscriber count 42 sent to the video service, got %v", sentCount)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSubscribe_CountSyncFailureRollsBack(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	srv := newFakeVideoService(t, map[string]http.HandlerFunc{
+		"/channels/7": func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(map[string]interface{}{"id": 7, "display_name": "Tech Channel"})
+		},
+		"/internal/channels/7/subscriber-count": func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		},
+	})
+
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("INSERT INTO subscriptions").
+		WithArgs(1, 7, "Tech Channel", "all").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "channel_id", "channel_name", "notification_level", "created_at"}).
+			AddRow(1, 1, 7, "Tech Channel", "all", time.Now()))
+	mock.ExpectQuery("SELECT COUNT").WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectRollback()
+
+	h := NewSubscriptionHandler(db)
+	h.videoServiceURL = srv.URL
+
+	req := httptest.NewRequest("POST", "/users/1/subscriptions", strings.NewReader(`{"channel_id": 7, "notification_level": "all"}`))
+	req = mux.SetURLVars(req, map[string]string{"userId": "1"})
+	rr := httptest.NewRecorder()
+
+	h.Subscribe(rr, req)
+
+	if rr.Code != http.StatusBadGateway {
+		t.Errorf("Expected status %d, got %d", http.StatusBadGateway, rr.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSubscribe_InvalidNotificationLevel(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	req := httptest.NewRequest("POST", "/users/1/subscriptions", strings.NewReader(`{"channel_id": 7, "notification_level": "loud"}`))
+	req = mux.SetURLVars(req, map[string]string{"userId": "1"})
+	rr := httptest.NewRecorder()
+
+	NewSubscriptionHandler(db).Subscribe(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestUnsubscribe_SyncsCount(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	var sentCount map[string]int
+	srv := newFakeVideoService(t, map[string]http.HandlerFunc{
+		"/internal/channels/7/subscriber-count": func(w http.ResponseWriter, r *http.Request) {
+			json.NewDecoder(r.Body).Decode(&sentCount)
+			w.WriteHeader(http.StatusNoContent)
+		},
+	})
+
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM subscriptions").WithArgs(1, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT COUNT").WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectCommit()
+
+	h := NewSubscriptionHandler(db)
+	h.videoServiceURL = srv.URL
+
+	req := httptest.NewRequest("DELETE", "/users/1/subscriptions/7", nil)
+	req = mux.SetURLVars(req, map[string]string{"userId": "1", "channelId": "7"})
+	rr := httptest.NewRecorder()
+
+	h.Unsubscribe(rr, req)
+
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusNoContent, rr.Code, rr.Body.String())
+	}
+	if count, ok := sentCount["subscriber_count"]; !ok || count != 0 {
+		t.Errorf("Expected subscriber count 0 sent to the video service, got %v", sentCount)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestUnsubscribe_NotSubscribed(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM subscriptions").WithArgs(1, 7).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	req := httptest.NewRequest("DELETE", "/users/1/subscriptions/7", nil)
+	req = mux.SetURLVars(req, map[string]string{"userId": "1", "channelId": "7"})
+	rr := httptest.NewRecorder()
+
+	NewSubscriptionHandler(db).Unsubscribe(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, rr.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSubscribe_UnknownChannel(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	srv := newFakeVideoService(t, nil)
+
+	h := NewSubscriptionHandler(db)
+	h.videoServiceURL = srv.URL
+
+	req := httptest.NewRequest("POST", "/users/1/subscriptions", strings.NewReader(`{"channel_id": 7}`))
+	req = mux.SetURLVars(req, map[string]string{"userId": "1"})
+	rr := httptest.NewRecorder()
+
+	h.Subscribe(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, rr.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestGetSubscriptionFeed(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	var got struct {
+		ChannelIDs []int  `json:"channel_ids"`
+		Cursor     string `json:"cursor"`
+		Limit      int    `json:"limit"`
+	}
+	srv := newFakeVideoService(t, map[string]http.HandlerFunc{
+		"/internal/channels/uploads": func(w http.ResponseWriter, r *http.Request) {
+			json.NewDecoder(r.Body).Decode(&got)
+			w.Write([]byte(`{"videos": [{"id": 9, "title": "New upload"}], "next_cursor": "abc"}`))
+		},
+	})
+
+	mock.ExpectQuery("SELECT channel_id FROM subscriptions").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"channel_id"}).AddRow(7).AddRow(8))
+
+	h := NewSubscriptionHandler(db)
+	h.videoServiceURL = srv.URL
+
+	req := httptest.NewRequest("GET", "/users/1/subscriptions/feed?limit=5&cursor=xyz", nil)
+	req = mux.SetURLVars(req, map[string]string{"userId": "1"})
+	rr := httptest.NewRecorder()
+
+	h.GetSubscriptionFeed(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if len(got.ChannelIDs) != 2 || got.Cursor != "xyz" || got.Limit != 5 {
+		t.Errorf("Unexpected request to the video service: %+v", got)
+	}
+
+	var feed struct {
+		Videos     []map[string]interface{} `json:"videos"`
+		NextCursor string                   `json:"next_cursor"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&feed); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(feed.Videos) != 1 || feed.Videos[0]["title"] != "New upload" {
+		t.Errorf("Expected the video service's videos, got %v", feed.Videos)
+	}
+	if feed.NextCursor != "abc" {
+		t.Errorf("Expected next cursor %q, got %q", "abc", feed.NextCursor)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestGetSubscriptionFeed_InvalidCursor(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	srv := newFakeVideoService(t, map[string]http.HandlerFunc{
+		"/internal/channels/uploads": func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		},
+	})
+
+	mock.ExpectQuery("SELECT channel_id FROM subscriptions").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"channel_id"}).AddRow(7))
+
+	h := NewSubscriptionHandler(db)
+	h.videoServiceURL = srv.URL
+
+	req := httptest.NewRequest("GET", "/users/1/subscriptions/feed?cursor=bad", nil)
+	req = mux.SetURLVars(req, map[string]string{"userId": "1"})
+	rr := httptest.NewRecorder()
+
+	h.GetSubscriptionFeed(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestGetUnseenCounts(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	srv := newFakeVideoService(t, map[string]http.HandlerFunc{
+		"/internal/channels/upload-counts": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`[{"channel_id": 7, "count": 0}, {"channel_id": 8, "count": 3}, {"channel_id": 9, "count": 0}]`))
+		},
+	})
+
+	visited := time.Now().Add(-time.Hour)
+	mock.ExpectQuery("SELECT channel_id, channel_name, last_visited_at FROM subscriptions").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"channel_id", "channel_name", "last_visited_at"}).
+			AddRow(7, "Zeta", visited).
+			AddRow(8, "Music", visited).
+			AddRow(9, "Alpha", visited))
+
+	h := NewSubscriptionHandler(db)
+	h.videoServiceURL = srv.URL
+
+	req := httptest.NewRequest("GET", "/users/1/subscriptions/unseen", nil)
+	req = mux.SetURLVars(req, map[string]string{"userId": "1"})
+	rr := httptest.NewRecorder()
+
+	h.GetUnseenCounts(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	var counts []struct {
+		ChannelID   int `json:"channel_id"`
+		UnseenCount int `json:"unseen_count"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&counts); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	// Most unseen first, then by channel name
+	wantIDs := []int{8, 9, 7}
+	if len(counts) != len(wantIDs) {
+		t.Fatalf("Expected %d channels, got %d", len(wantIDs), len(counts))
+	}
+	for i, id := range wantIDs {
+		if counts[i].ChannelID != id {
+			t.Errorf("Position %d: expected channel %d, got %d", i, id, counts[i].ChannelID)
+		}
+	}
+	if counts[0].UnseenCount != 3 {
+		t.Errorf("Expected 3 unseen uploads, got %d", counts[0].UnseenCount)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestMarkChannelVisited_NotSubscribed(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("UPDATE subscriptions SET last_visited_at").
+		WithArgs(1, 7).
+		WillReturnRows(sqlmock.NewRows([]string{"last_visited_at"}))
+
+	req := httptest.NewRequest("POST", "/users/1/subscriptions/7/visit", nil)
+	req = mux.SetURLVars(req, map[string]string{"userId": "1", "channelId": "7"})
+	rr := httptest.NewRecorder()
+
+	NewSubscriptionHandler(db).MarkChannelVisited(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, rr.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestUpdateNotificationLevel(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		rows       int64
+		wantStatus int
+	}{
+		{"valid level", `{"notification_level": "all"}`, 1, http.StatusOK},
+		{"invalid level", `{"notification_level": "sometimes"}`, 0, http.StatusBadRequest},
+		{"not subscribed", `{"notification_level": "none"}`, 0, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("Failed to create mock: %v", err)
+			}
+			defer db.Close()
+
+			if tt.wantStatus != http.StatusBadRequest {
+				mock.ExpectExec("UPDATE subscriptions SET notification_level").
+					WillReturnResult(sqlmock.NewResult(0, tt.rows))
+			}
+
+			req := httptest.NewRequest("PUT", "/users/1/subscriptions/7/notifications", strings.NewReader(tt.body))
+			req = mux.SetURLVars(req, map[string]string{"userId": "1", "channelId": "7"})
+			rr := httptest.NewRecorder()
+
+			NewSubscriptionHandler(db).UpdateNotificationLevel(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, rr.Code)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestGetSubscribersToNotify(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT user_id FROM subscriptions WHERE channel_id = \\$1 AND \\(notification_level = 'all'").
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(2).AddRow(3))
+
+	req := httptest.NewRequest("GET", "/internal/channels/7/subscribers", nil)
+	req = mux.SetURLVars(req, map[string]string{"channelId": "7"})
+	rr := httptest.NewRecorder()
+
+	NewSubscriptionHandler(db).GetSubscribersToNotify(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	var userIDs []int
+	if err := json.NewDecoder(rr.Body).Decode(&userIDs); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(userIDs) != 2 || userIDs[0] != 2 || userIDs[1] != 3 {
+		t.Errorf("Expected subscribers [2 3], got %v", userIDs)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
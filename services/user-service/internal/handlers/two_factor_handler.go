@@ -0,0 +1,384 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aung-arata/youtube-clone/services/user-service/internal/auth"
+	"github.com/aung-arata/youtube-clone/services/user-service/internal/middleware"
+	"github.com/aung-arata/youtube-clone/services/user-service/internal/models"
+)
+
+// TwoFactorCodeRequest carries a code from an authenticator app, or a
+// recovery code where the endpoint accepts one
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// TwoFactorLoginRequest completes a login that needs a second factor
+type TwoFactorLoginRequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+// DisableTwoFactorRequest represents the request body to turn off 2FA
+type DisableTwoFactorRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+// MFAChallengeResponse is returned by Login when the user has 2FA enabled
+type MFAChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// TwoFactorSetupResponse holds a new secret for the user's authenticator app
+type TwoFactorSetupResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+// RecoveryCodesResponse holds recovery codes, which are shown only once
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// LoginTwoFactor exchanges the challenge from Login and a TOTP or recovery
+// code for the login's tokens. Wrong codes count as failed logins, so they
+// are throttled and lock the account out like wrong passwords.
+func (h *AuthHandler) LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req TwoFactorLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.MFAToken == "" || strings.TrimSpace(req.Code) == "" {
+		http.Error(w, "MFA token and code are required", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := auth.MFAChallengeUser(r.Context(), h.db, req.MFAToken)
+	if errors.Is(err, auth.ErrInvalidMFAChallenge) {
+		http.Error(w, "Login expired, please log in again", http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	query := `
+		SELECT id, username, email, avatar, role, plan_id, created_at, updated_at, email_verified_at IS NOT NULL
+		FROM users
+		WHERE id = $1
+	`
+
+	var user models.User
+	var emailVerified bool
+	err = h.db.QueryRow(query, userID).Scan(
+		&user.ID, &user.Username, &user.Email, &user.Avatar, &user.Role, &user.PlanID, &user.CreatedAt, &user.UpdatedAt,
+		&emailVerified)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// Challenges opened before a lockout can't be used to keep guessing
+	client := clientInfo(r)
+	status, err := auth.CheckLogin(r.Context(), h.db, user.Email, client.IP)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if status.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(status.RetryAfter.Seconds()))))
+		http.Error(w, "Too many failed login attempts, please try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	_, err = auth.CompleteMFAChallenge(r.Context(), h.db, req.MFAToken, req.Code)
+	if errors.Is(err, auth.ErrInvalidMFAChallenge) {
+		http.Error(w, "Login expired, please log in again", http.StatusUnauthorized)
+		return
+	} else if errors.Is(err, auth.ErrInvalidMFACode) {
+		if err := h.recordLoginFailure(r, user.Email, &user, client, status); err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, "Invalid two-factor code", http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := auth.RecordLoginAttempt(r.Context(), h.db, user.Email, user.ID, client, true); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	tokens, err := auth.IssueTokens(r.Context(), h.db, auth.TokenSubject{
+		UserID:        user.ID,
+		Username:      user.Username,
+		Role:          user.Role,
+		EmailVerified: emailVerified,
+		MFA:           true,
+	}, client)
+	if err != nil {
+		http.Error(w, "Error generating token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AuthResponse{TokenPair: tokens, User: user, EmailVerified: emailVerified})
+}
+
+// SetupTwoFactor generates a new TOTP secret for the authenticated user.
+// 2FA is only turned on once ConfirmTwoFactor sees a code for it.
+func (h *AuthHandler) SetupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var email string
+	var enabled bool
+	err := h.db.QueryRow(`SELECT email, totp_enabled FROM users WHERE id = $1`, userID).Scan(&email, &enabled)
+	if err == sql.ErrNoRows {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if enabled {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		http.Error(w, "Error generating secret", http.StatusInternalServerError)
+		return
+	}
+	if _, err := h.db.Exec(`UPDATE users SET totp_secret = $2 WHERE id = $1`, userID, secret); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TwoFactorSetupResponse{
+		Secret:     secret,
+		OTPAuthURI: auth.TOTPURI(email, secret),
+	})
+}
+
+// ConfirmTwoFactor turns on 2FA once the user enters a code from their app,
+// and returns their recovery codes. The current session counts as having
+// passed 2FA from its next refresh.
+func (h *AuthHandler) ConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var secret sql.NullString
+	var enabled bool
+	err := h.db.QueryRow(`SELECT totp_secret, totp_enabled FROM users WHERE id = $1`, userID).Scan(&secret, &enabled)
+	if err == sql.ErrNoRows {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if enabled {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+	if !secret.Valid {
+		http.Error(w, "Start two-factor setup first", http.StatusBadRequest)
+		return
+	}
+
+	step, ok := auth.ValidateTOTP(secret.String, req.Code, time.Now())
+	if !ok {
+		http.Error(w, "Invalid two-factor code", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE users SET totp_enabled = TRUE, totp_last_step = $3
+		WHERE id = $1 AND totp_secret = $2 AND totp_enabled = FALSE
+	`, userID, secret.String, step)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		// Setup was restarted or finished in the meantime
+		http.Error(w, "Two-factor setup changed, please try again", http.StatusConflict)
+		return
+	}
+
+	codes, err := auth.ReplaceRecoveryCodes(r.Context(), tx, userID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if sessionID, _ := r.Context().Value(middleware.SessionIDKey).(string); sessionID != "" {
+		if _, err := tx.Exec(`UPDATE sessions SET mfa = TRUE WHERE id = $1 AND user_id = $2`, sessionID, userID); err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// DisableTwoFactor turns off 2FA after checking the password and a code.
+// Admins have to keep it on.
+func (h *AuthHandler) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req DisableTwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var hashedPassword, role string
+	err := h.db.QueryRow(`SELECT password, role FROM users WHERE id = $1`, userID).Scan(&hashedPassword, &role)
+	if err == sql.ErrNoRows {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if auth.RoleRequiresMFA(role) {
+		http.Error(w, "Moderators and admins must keep two-factor authentication enabled", http.StatusForbidden)
+		return
+	}
+	if err := auth.ComparePasswords(hashedPassword, req.Password); err != nil {
+		http.Error(w, "Invalid password", http.StatusUnauthorized)
+		return
+	}
+
+	if err := auth.VerifySecondFactor(r.Context(), h.db, userID, req.Code); errors.Is(err, auth.ErrInvalidMFACode) {
+		http.Error(w, "Invalid two-factor code", http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := h.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		UPDATE users SET totp_enabled = FALSE, totp_secret = NULL, totp_last_step = NULL WHERE id = $1
+	`, userID); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if _, err := tx.Exec(`DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	// Sessions that passed the second factor must not keep the MFA claim
+	// when their tokens are refreshed
+	if _, err := tx.Exec(`UPDATE sessions SET mfa = FALSE WHERE user_id = $1`, userID); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RegenerateRecoveryCodes replaces the authenticated user's recovery codes
+// after checking a code from their authenticator app
+func (h *AuthHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := auth.VerifySecondFactor(r.Context(), h.db, userID, req.Code); errors.Is(err, auth.ErrInvalidMFACode) {
+		http.Error(w, "Invalid two-factor code", http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := h.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	codes, err := auth.ReplaceRecoveryCodes(r.Context(), tx, userID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RecoveryCodesResponse{RecoveryCodes: codes})
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/aung-arata/youtube-clone/services/user-service/internal/models"
 	"github.com/gorilla/mux"
+	"github.com/lib/pq"
 )
 
 type UserHandler struct {
@@ -132,3 +133,62 @@ func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(u)
 }
+
+// maxLookupUsernames caps how many users a single lookup can resolve
+const maxLookupUsernames = 50
+
+// LookupUsers resolves usernames (case-insensitively) and user IDs to users.
+// It backs @mention resolution in the notification service and the video
+// service's channel teams. Unknown usernames and IDs are left out of the
+// result.
+func (h *UserHandler) LookupUsers(w http.ResponseWriter, r *http.Request) {
+	usernames := []string{}
+	for _, username := range r.URL.Query()["username"] {
+		if username = strings.ToLower(strings.TrimSpace(username)); username != "" {
+			usernames = append(usernames, username)
+		}
+	}
+
+	ids := []int64{}
+	for _, v := range r.URL.Query()["id"] {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	if len(usernames)+len(ids) > maxLookupUsernames {
+		http.Error(w, "Too many users", http.StatusBadRequest)
+		return
+	}
+
+	users := []models.UserSummary{}
+	if len(usernames)+len(ids) > 0 {
+		query := `
+			SELECT id, username, COALESCE(avatar, '')
+			FROM users
+			WHERE LOWER(username) = ANY($1) OR id = ANY($2)
+		`
+
+		rows, err := h.db.Query(query, pq.Array(usernames), pq.Array(ids))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var u models.UserSummary
+			if err := rows.Scan(&u.ID, &u.Username, &u.Avatar); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			users = append(users, u)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/aung-arata/youtube-clone/services/user-service/internal/models"
+	"github.com/lib/pq"
+)
+
+func TestLookupUsers(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewUserHandler(db)
+
+	// Usernames are matched case-insensitively; blank ones are skipped
+	mock.ExpectQuery("SELECT id, username, COALESCE\\(avatar, ''\\) FROM users WHERE LOWER\\(username\\) = ANY\\(\\$1\\) OR id = ANY\\(\\$2\\)").
+		WithArgs(pq.Array([]string{"alice", "nobody"}), pq.Array([]int64{})).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "avatar"}).AddRow(7, "Alice", ""))
+
+	req := httptest.NewRequest("GET", "/users/lookup?username=Alice&username=nobody&username=+", nil)
+	w := httptest.NewRecorder()
+
+	handler.LookupUsers(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var users []models.UserSummary
+	if err := json.NewDecoder(w.Body).Decode(&users); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(users) != 1 || users[0].ID != 7 || users[0].Username != "Alice" {
+		t.Errorf("Unexpected users %+v", users)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestLookupUsers_ByID(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT id, username, COALESCE\\(avatar, ''\\) FROM users").
+		WithArgs(pq.Array([]string{}), pq.Array([]int64{7, 8})).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "avatar"}).AddRow(7, "Alice", "").AddRow(8, "Bob", "b.png"))
+
+	req := httptest.NewRequest("GET", "/users/lookup?id=7&id=8", nil)
+	w := httptest.NewRecorder()
+
+	NewUserHandler(db).LookupUsers(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var users []models.UserSummary
+	if err := json.NewDecoder(w.Body).Decode(&users); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(users) != 2 {
+		t.Errorf("Expected 2 users, got %+v", users)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestLookupUsers_InvalidID(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	req := httptest.NewRequest("GET", "/users/lookup?id=abc", nil)
+	w := httptest.NewRecorder()
+
+	NewUserHandler(db).LookupUsers(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+}
+
+func TestLookupUsers_TooMany(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	target := "/users/lookup?"
+	for i := 0; i <= maxLookupUsernames; i++ {
+		target += "username=u&"
+	}
+	req := httptest.NewRequest("GET", target, nil)
+	w := httptest.NewRecorder()
+
+	NewUserHandler(db).LookupUsers(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
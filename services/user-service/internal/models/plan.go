@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type Plan struct {
 	ID                 int       `json:"id"`
@@ -14,8 +17,41 @@ type Plan struct {
 }
 
 type Subscription struct {
-	ID          int       `json:"id"`
-	UserID      int       `json:"user_id"`
-	ChannelName string    `json:"channel_name"`
-	CreatedAt   time.Time `json:"created_at"`
+	ID                int       `json:"id"`
+	UserID            int       `json:"user_id"`
+	ChannelID         *int      `json:"channel_id,omitempty"` // nil for subscriptions made by channel name
+	ChannelName       string    `json:"channel_name"`
+	NotificationLevel string    `json:"notification_level"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// Notification levels a subscriber can pick with the channel's bell
+const (
+	NotificationLevelAll          = "all"
+	NotificationLevelPersonalized = "personalized"
+	NotificationLevelNone         = "none"
+)
+
+// ValidNotificationLevel reports whether level is one of the bell settings
+func ValidNotificationLevel(level string) bool {
+	switch level {
+	case NotificationLevelAll, NotificationLevelPersonalized, NotificationLevelNone:
+		return true
+	}
+	return false
+}
+
+// SubscriptionFeed is a page of videos from a user's subscribed channels. The
+// videos are passed through from the video service as they are.
+type SubscriptionFeed struct {
+	Videos     []json.RawMessage `json:"videos"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
+
+// ChannelUnseenCount is the number of uploads a subscriber hasn't seen yet
+type ChannelUnseenCount struct {
+	ChannelID     int       `json:"channel_id"`
+	ChannelName   string    `json:"channel_name"`
+	LastVisitedAt time.Time `json:"last_visited_at"`
+	UnseenCount   int       `json:"unseen_count"`
 }
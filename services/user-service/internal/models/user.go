@@ -8,8 +8,15 @@ type User struct {
 	Email     string    `json:"email"`
 	Password  string    `json:"-"` // Never send password in JSON responses
 	Avatar    string    `json:"avatar"`
-	Role      string    `json:"role"` // "user" or "admin"
+	Role      string    `json:"role"` // "viewer", "creator", "moderator" or "admin"
 	PlanID    *int      `json:"plan_id,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
+
+// UserSummary is the public part of a user, as returned by lookups
+type UserSummary struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+	Avatar   string `json:"avatar"`
+}
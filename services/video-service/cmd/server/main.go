@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"os"
 
+	"github.com/aung-arata/youtube-clone/services/video-service/internal/auth"
 	"github.com/aung-arata/youtube-clone/services/video-service/internal/database"
 	"github.com/aung-arata/youtube-clone/services/video-service/internal/handlers"
 	"github.com/aung-arata/youtube-clone/services/video-service/internal/middleware"
@@ -21,6 +22,20 @@ func main() {
 	}
 	defer db.Close()
 
+	// Services present SERVICE_TOKEN to each other's internal endpoints
+	auth.UseServiceToken(os.Getenv("SERVICE_TOKEN"))
+
+	// Reject access tokens whose session was revoked on the user service
+	if url := os.Getenv("SESSION_CHECK_URL"); url != "" {
+		auth.UseSessionChecker(auth.NewSessionCache(auth.NewRemoteSessionChecker(url), auth.SessionCheckTTL))
+	}
+
+	// Accept API keys on routes that name a scope, checking them with the
+	// user service
+	if url := os.Getenv("API_KEY_CHECK_URL"); url != "" {
+		auth.UseAPIKeyVerifier(auth.NewRemoteAPIKeyVerifier(url))
+	}
+
 	// Initialize file storage
 	fileStorage, err := storage.NewFileStorage("")
 	if err != nil {
@@ -37,8 +52,8 @@ func main() {
 	uploadHandler := handlers.NewUploadHandler(db, fileStorage)
 	protectedUpload := r.PathPrefix("/upload").Subrouter()
 	protectedUpload.Use(middleware.AuthMiddleware)
-	protectedUpload.HandleFunc("/video", uploadHandler.UploadVideo).Methods("POST")
-	protectedUpload.HandleFunc("/video/delete", uploadHandler.DeleteVideo).Methods("DELETE")
+	protectedUpload.Handle("/video", middleware.RequireScope(auth.ScopeVideosWrite, middleware.RequirePermission(auth.PermUploadVideos, middleware.RequireVerifiedEmail(http.HandlerFunc(uploadHandler.UploadVideo))))).Methods("POST")
+	protectedUpload.Handle("/video/delete", middleware.RequireScope(auth.ScopeVideosWrite, middleware.RequirePermission(auth.PermUploadVideos, http.HandlerFunc(uploadHandler.DeleteVideo)))).Methods("DELETE")
 
 	// Video routes
 	videoHandler := handlers.NewVideoHandler(db)
@@ -48,21 +63,58 @@ func main() {
 	r.HandleFunc("/videos/popular", videoHandler.GetPopularVideos).Methods("GET")
 	r.HandleFunc("/videos/{id}", videoHandler.GetVideo).Methods("GET")
 	r.HandleFunc("/videos/{id}/recommendations", videoHandler.GetRecommendations).Methods("GET")
-	r.HandleFunc("/videos/{id}/analytics", videoHandler.GetVideoAnalytics).Methods("GET")
-	r.HandleFunc("/videos", videoHandler.CreateVideo).Methods("POST")
+	r.Handle("/videos/{id}/analytics", middleware.Protect(auth.PermInteract, http.HandlerFunc(videoHandler.GetVideoAnalytics))).Methods("GET")
+	r.Handle("/videos", middleware.RequireScope(auth.ScopeVideosWrite, middleware.Protect(auth.PermUploadVideos, middleware.RequireVerifiedEmail(http.HandlerFunc(videoHandler.CreateVideo))))).Methods("POST")
+	// Views are counted for everyone, logged in or not
 	r.HandleFunc("/videos/{id}/views", videoHandler.IncrementViews).Methods("POST")
-	r.HandleFunc("/videos/{id}/like", videoHandler.LikeVideo).Methods("POST")
-	r.HandleFunc("/videos/{id}/dislike", videoHandler.DislikeVideo).Methods("POST")
+	r.Handle("/videos/{id}/like", middleware.Protect(auth.PermInteract, http.HandlerFunc(videoHandler.LikeVideo))).Methods("POST")
+	r.Handle("/videos/{id}/dislike", middleware.Protect(auth.PermInteract, http.HandlerFunc(videoHandler.DislikeVideo))).Methods("POST")
+
+	// Channel routes; uploads go to a channel the uploader owns
+	channelHandler := handlers.NewChannelHandler(db)
+	r.HandleFunc("/channels/{id:[0-9]+}", channelHandler.GetChannel).Methods("GET")
+	r.HandleFunc("/channels/{id:[0-9]+}/page", channelHandler.GetChannelPage).Methods("GET")
+	r.HandleFunc("/channels/handle/{handle}", channelHandler.GetChannelByHandle).Methods("GET")
+	r.HandleFunc("/users/{userId}/channels", channelHandler.GetUserChannels).Methods("GET")
+	r.Handle("/channels", middleware.Protect(auth.PermManageChannels, http.HandlerFunc(channelHandler.CreateChannel))).Methods("POST")
+	// The user service builds subscription feeds and unseen counts from these
+	// and keeps subscriber counts up to date
+	r.Handle("/internal/channels/uploads", middleware.RequireServiceToken(http.HandlerFunc(channelHandler.GetUploads))).Methods("POST")
+	r.Handle("/internal/channels/upload-counts", middleware.RequireServiceToken(http.HandlerFunc(channelHandler.GetUploadCounts))).Methods("POST")
+	r.Handle("/internal/channels/{id:[0-9]+}/subscriber-count", middleware.RequireServiceToken(http.HandlerFunc(channelHandler.SetSubscriberCount))).Methods("PUT")
+	r.Handle("/channels/{id:[0-9]+}", middleware.Protect(auth.PermManageChannels, http.HandlerFunc(channelHandler.UpdateChannel))).Methods("PUT")
+	r.Handle("/channels/{id:[0-9]+}", middleware.Protect(auth.PermManageChannels, http.HandlerFunc(channelHandler.DeleteChannel))).Methods("DELETE")
+	r.Handle("/channels/{id:[0-9]+}/blocked-words", middleware.AuthMiddleware(http.HandlerFunc(channelHandler.GetBlockedWords))).Methods("GET")
+	r.Handle("/channels/{id:[0-9]+}/blocked-words", middleware.Protect(auth.PermManageChannels, http.HandlerFunc(channelHandler.UpdateBlockedWords))).Methods("PUT")
+	// The comment service holds comments by these and checks who may moderate
+	r.Handle("/internal/videos/{id:[0-9]+}/comment-policy", middleware.RequireServiceToken(http.HandlerFunc(channelHandler.GetVideoCommentPolicy))).Methods("GET")
+	r.Handle("/internal/channels/{id:[0-9]+}/comment-policy", middleware.RequireServiceToken(http.HandlerFunc(channelHandler.GetChannelCommentPolicy))).Methods("GET")
 
-	// Playlist routes
+	// Channel team routes; members and invitees are users of the user service
+	teamHandler := handlers.NewChannelTeamHandler(db)
+	r.Handle("/channels/{id:[0-9]+}/members", middleware.AuthMiddleware(http.HandlerFunc(teamHandler.ListMembers))).Methods("GET")
+	r.Handle("/channels/{id:[0-9]+}/members/{userId:[0-9]+}", middleware.Protect(auth.PermManageChannels, http.HandlerFunc(teamHandler.UpdateMemberRole))).Methods("PUT")
+	r.Handle("/channels/{id:[0-9]+}/members/{userId:[0-9]+}", middleware.Protect(auth.PermInteract, http.HandlerFunc(teamHandler.RemoveMember))).Methods("DELETE")
+	r.Handle("/channels/{id:[0-9]+}/invitations", middleware.Protect(auth.PermManageChannels, http.HandlerFunc(teamHandler.InviteMember))).Methods("POST")
+	r.Handle("/channels/{id:[0-9]+}/invitations", middleware.AuthMiddleware(http.HandlerFunc(teamHandler.ListChannelInvitations))).Methods("GET")
+	r.Handle("/channels/{id:[0-9]+}/invitations/{invitationId:[0-9]+}", middleware.Protect(auth.PermManageChannels, http.HandlerFunc(teamHandler.CancelInvitation))).Methods("DELETE")
+	r.Handle("/channel-invitations", middleware.AuthMiddleware(http.HandlerFunc(teamHandler.GetMyInvitations))).Methods("GET")
+	r.Handle("/channel-invitations/{id:[0-9]+}/accept", middleware.Protect(auth.PermInteract, http.HandlerFunc(teamHandler.AcceptInvitation))).Methods("POST")
+	r.Handle("/channel-invitations/{id:[0-9]+}", middleware.Protect(auth.PermInteract, http.HandlerFunc(teamHandler.DeclineInvitation))).Methods("DELETE")
+
+	// Playlist routes; changes need their owner's token or a playlists:write
+	// API key
 	playlistHandler := handlers.NewPlaylistHandler(db)
+	playlistWrite := func(h http.HandlerFunc) http.Handler {
+		return middleware.RequireScope(auth.ScopePlaylistsWrite, middleware.Protect(auth.PermInteract, h))
+	}
 	r.HandleFunc("/users/{userId}/playlists", playlistHandler.GetUserPlaylists).Methods("GET")
-	r.HandleFunc("/users/{userId}/playlists", playlistHandler.CreatePlaylist).Methods("POST")
+	r.Handle("/users/{userId}/playlists", playlistWrite(playlistHandler.CreatePlaylist)).Methods("POST")
 	r.HandleFunc("/playlists/{id}", playlistHandler.GetPlaylist).Methods("GET")
-	r.HandleFunc("/playlists/{id}", playlistHandler.UpdatePlaylist).Methods("PUT")
-	r.HandleFunc("/playlists/{id}", playlistHandler.DeletePlaylist).Methods("DELETE")
-	r.HandleFunc("/playlists/{id}/videos", playlistHandler.AddVideoToPlaylist).Methods("POST")
-	r.HandleFunc("/playlists/{id}/videos/{videoId}", playlistHandler.RemoveVideoFromPlaylist).Methods("DELETE")
+	r.Handle("/playlists/{id}", playlistWrite(playlistHandler.UpdatePlaylist)).Methods("PUT")
+	r.Handle("/playlists/{id}", playlistWrite(playlistHandler.DeletePlaylist)).Methods("DELETE")
+	r.Handle("/playlists/{id}/videos", playlistWrite(playlistHandler.AddVideoToPlaylist)).Methods("POST")
+	r.Handle("/playlists/{id}/videos/{videoId}", playlistWrite(playlistHandler.RemoveVideoFromPlaylist)).Methods("DELETE")
 	
 	// Health check
 	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
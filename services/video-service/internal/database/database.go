@@ -103,6 +103,72 @@ func runMigrations(db *sql.DB) error {
 	CREATE INDEX IF NOT EXISTS idx_playlists_user_id ON playlists (user_id);
 	CREATE INDEX IF NOT EXISTS idx_playlist_videos_playlist_id ON playlist_videos (playlist_id);
 	CREATE INDEX IF NOT EXISTS idx_playlist_videos_position ON playlist_videos (position);
+
+	CREATE TABLE IF NOT EXISTS channels (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER,
+		handle VARCHAR(30) UNIQUE NOT NULL,
+		display_name VARCHAR(100) NOT NULL,
+		avatar VARCHAR(500) NOT NULL DEFAULT '',
+		banner VARCHAR(500) NOT NULL DEFAULT '',
+		description TEXT NOT NULL DEFAULT '',
+		links JSONB NOT NULL DEFAULT '[]',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_channels_user_id ON channels (user_id);
+
+	ALTER TABLE videos ADD COLUMN IF NOT EXISTS channel_id INTEGER REFERENCES channels(id) ON DELETE CASCADE;
+
+	-- Backfill: every legacy free-text channel name becomes an unowned channel
+	INSERT INTO channels (handle, display_name, avatar)
+	SELECT
+		COALESCE(NULLIF(left(trim(both '-' from regexp_replace(lower(n.name), '[^a-z0-9]+', '-', 'g')), 23), ''), 'channel')
+			|| '-' || substr(md5(n.name), 1, 6),
+		n.name,
+		COALESCE(n.avatar, '')
+	FROM (
+		SELECT channel_name AS name, MAX(channel_avatar) AS avatar FROM videos WHERE channel_id IS NULL GROUP BY channel_name
+	) n
+	WHERE NOT EXISTS (SELECT 1 FROM channels c WHERE c.user_id IS NULL AND c.display_name = n.name)
+	ON CONFLICT (handle) DO NOTHING;
+
+	UPDATE videos v SET channel_id = c.id
+	FROM channels c
+	WHERE v.channel_id IS NULL AND c.user_id IS NULL AND c.display_name = v.channel_name;
+
+	CREATE INDEX IF NOT EXISTS idx_videos_channel_id_uploaded ON videos (channel_id, uploaded_at DESC, id DESC);
+
+	CREATE TABLE IF NOT EXISTS channel_members (
+		channel_id INTEGER NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL,
+		role VARCHAR(20) NOT NULL CHECK (role IN ('manager', 'editor', 'viewer-analytics')),
+		added_by INTEGER,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (channel_id, user_id)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_channel_members_user ON channel_members (user_id);
+
+	-- Kept in step with the user service's subscriptions
+	ALTER TABLE channels ADD COLUMN IF NOT EXISTS subscriber_count INTEGER NOT NULL DEFAULT 0;
+
+	CREATE TABLE IF NOT EXISTS channel_invitations (
+		id SERIAL PRIMARY KEY,
+		channel_id INTEGER NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL,
+		role VARCHAR(20) NOT NULL CHECK (role IN ('manager', 'editor', 'viewer-analytics')),
+		invited_by INTEGER,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		expires_at TIMESTAMP NOT NULL,
+		UNIQUE (channel_id, user_id)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_channel_invitations_user ON channel_invitations (user_id);
+
+	-- Comments containing one of these are held for the channel's team to review
+	ALTER TABLE channels ADD COLUMN IF NOT EXISTS blocked_words JSONB NOT NULL DEFAULT '[]';
 	`
 
 	_, err := db.Exec(query)
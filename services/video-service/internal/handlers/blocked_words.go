@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+const (
+	maxBlockedWords      = 500
+	maxBlockedWordLength = 100
+)
+
+// normalizeBlockedWords trims, lowercases and de-duplicates a blocked-words list
+func normalizeBlockedWords(words []string) ([]string, error) {
+	seen := make(map[string]bool)
+	normalized := []string{}
+
+	for _, word := range words {
+		word = strings.ToLower(strings.TrimSpace(word))
+		if word == "" || seen[word] {
+			continue
+		}
+		if len(word) > maxBlockedWordLength {
+			return nil, errors.New("Blocked words must be at most 100 characters")
+		}
+		seen[word] = true
+		normalized = append(normalized, word)
+	}
+
+	if len(normalized) > maxBlockedWords {
+		return nil, errors.New("At most 500 words can be blocked")
+	}
+	return normalized, nil
+}
+
+// decodeBlockedWords parses a JSONB list of blocked words
+func decodeBlockedWords(raw []byte) ([]string, error) {
+	words := []string{}
+	if len(raw) == 0 {
+		return words, nil
+	}
+	err := json.Unmarshal(raw, &words)
+	return words, err
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"database/sql"
+
+	"github.com/aung-arata/youtube-clone/services/video-service/internal/models"
+)
+
+// channelAction is something a channel's team may be allowed to do
+type channelAction int
+
+const (
+	channelViewAnalytics channelAction = iota
+	channelManageVideos
+	channelModerateComments
+	channelEditProfile
+	channelManageTeam
+)
+
+// channelRoleActions lists what each role on a channel's team may do. Only
+// the owner may delete the channel or manage its managers.
+var channelRoleActions = map[string][]channelAction{
+	models.ChannelRoleOwner:           {channelViewAnalytics, channelManageVideos, channelModerateComments, channelEditProfile, channelManageTeam},
+	models.ChannelRoleManager:         {channelViewAnalytics, channelManageVideos, channelModerateComments, channelEditProfile, channelManageTeam},
+	models.ChannelRoleEditor:          {channelViewAnalytics, channelManageVideos, channelModerateComments},
+	models.ChannelRoleViewerAnalytics: {channelViewAnalytics},
+}
+
+// channelRoleAllows reports whether role may perform action
+func channelRoleAllows(role string, action channelAction) bool {
+	for _, a := range channelRoleActions[role] {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// assignableChannelRoles returns the roles a team member with role may
+// invite, change or remove. Managers handle everyone below them.
+func assignableChannelRoles(role string) []string {
+	switch role {
+	case models.ChannelRoleOwner:
+		return []string{models.ChannelRoleManager, models.ChannelRoleEditor, models.ChannelRoleViewerAnalytics}
+	case models.ChannelRoleManager:
+		return []string{models.ChannelRoleEditor, models.ChannelRoleViewerAnalytics}
+	}
+	return nil
+}
+
+// canAssignChannelRole reports whether a team member with role may invite,
+// change or remove members with target role
+func canAssignChannelRole(role, target string) bool {
+	for _, r := range assignableChannelRoles(role) {
+		if r == target {
+			return true
+		}
+	}
+	return false
+}
+
+// channelMemberRole returns userID's role on a channel's team, or "" if they
+// aren't a member. The owner isn't stored as a member.
+func channelMemberRole(db *sql.DB, channelID, userID int) (string, error) {
+	var role string
+	err := db.QueryRow(`SELECT role FROM channel_members WHERE channel_id = $1 AND user_id = $2`, channelID, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return role, err
+}
+
+// checkVideoChannelAccess verifies that userID's role on the team of the
+// video's channel allows action
+func checkVideoChannelAccess(db *sql.DB, videoID, userID int, action channelAction) error {
+	query := `
+		SELECT CASE WHEN ch.user_id = $2 THEN 'owner' ELSE COALESCE(m.role, '') END
+		FROM videos v
+		LEFT JOIN channels ch ON ch.id = v.channel_id
+		LEFT JOIN channel_members m ON m.channel_id = v.channel_id AND m.user_id = $2
+		WHERE v.id = $1
+	`
+
+	var role string
+	err := db.QueryRow(query, videoID, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return errVideoNotFound
+	} else if err != nil {
+		return err
+	}
+
+	if role == "" {
+		return errNoChannelAccess
+	}
+	if !channelRoleAllows(role, action) {
+		return errChannelRoleForbidden
+	}
+	return nil
+}
@@ -0,0 +1,600 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aung-arata/youtube-clone/services/video-service/internal/middleware"
+	"github.com/aung-arata/youtube-clone/services/video-service/internal/models"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+const (
+	// channelInvitationTTL is how long an invitation to a channel's team can
+	// be accepted
+	channelInvitationTTL = 7 * 24 * time.Hour
+	// maxUserLookup is how many users the user service resolves per request
+	maxUserLookup = 50
+)
+
+// userSummary is a user as the user service's lookup returns them
+type userSummary struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+	Avatar   string `json:"avatar"`
+}
+
+// ChannelTeamHandler manages the members of channels' teams and the
+// invitations to join them. Users live in the user service, which resolves
+// usernames and avatars.
+type ChannelTeamHandler struct {
+	db             *sql.DB
+	events         *eventPublisher
+	httpClient     *http.Client
+	userServiceURL string
+}
+
+func NewChannelTeamHandler(db *sql.DB) *ChannelTeamHandler {
+	userServiceURL := os.Getenv("USER_SERVICE_URL")
+	if userServiceURL == "" {
+		userServiceURL = "http://user-service:8082" // default for docker-compose
+	}
+
+	return &ChannelTeamHandler{
+		db:             db,
+		events:         newEventPublisher(),
+		userServiceURL: userServiceURL,
+		httpClient: &http.Client{
+			Timeout: 5 * time.Second, // 5 second timeout for user service calls
+		},
+	}
+}
+
+// lookupUsers resolves user IDs to users through the user service. Users
+// that no longer exist are left out.
+func (h *ChannelTeamHandler) lookupUsers(ids []int) (map[int]userSummary, error) {
+	users := make(map[int]userSummary, len(ids))
+	for start := 0; start < len(ids); start += maxUserLookup {
+		end := start + maxUserLookup
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		query := url.Values{}
+		for _, id := range ids[start:end] {
+			query.Add("id", strconv.Itoa(id))
+		}
+		found, err := h.queryUsers(query)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range found {
+			users[u.ID] = u
+		}
+	}
+	return users, nil
+}
+
+// lookupUsername resolves a username (case-insensitively) through the user
+// service
+func (h *ChannelTeamHandler) lookupUsername(username string) (userSummary, bool, error) {
+	found, err := h.queryUsers(url.Values{"username": {username}})
+	if err != nil || len(found) == 0 {
+		return userSummary{}, false, err
+	}
+	return found[0], true, nil
+}
+
+// queryUsers calls the user service's lookup
+func (h *ChannelTeamHandler) queryUsers(query url.Values) ([]userSummary, error) {
+	resp, err := h.httpClient.Get(h.userServiceURL + "/users/lookup?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("user service returned %d", resp.StatusCode)
+	}
+
+	var users []userSummary
+	if err := json.NewDecoder(resp.Body).Decode(&users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// ListMembers returns a channel's team, owner first. Anyone on the team can
+// see it.
+func (h *ChannelTeamHandler) ListMembers(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	channelID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := loadChannelFor(h.db, channelID, userID, channelViewAnalytics); err != nil {
+		writeChannelAccessError(w, err)
+		return
+	}
+
+	query := `
+		SELECT channel_id, user_id, role, created_at FROM (
+			SELECT id AS channel_id, user_id, 'owner' AS role, created_at
+			FROM channels
+			WHERE id = $1 AND user_id IS NOT NULL
+			UNION ALL
+			SELECT channel_id, user_id, role, created_at
+			FROM channel_members
+			WHERE channel_id = $1
+		) team
+		ORDER BY role <> 'owner', created_at ASC
+	`
+
+	rows, err := h.db.Query(query, channelID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	members := []models.ChannelMember{}
+	var ids []int
+	for rows.Next() {
+		var m models.ChannelMember
+		if err := rows.Scan(&m.ChannelID, &m.UserID, &m.Role, &m.CreatedAt); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		members = append(members, m)
+		ids = append(ids, m.UserID)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	users, err := h.lookupUsers(ids)
+	if err != nil {
+		http.Error(w, "Error looking up users: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	for i := range members {
+		members[i].Username = users[members[i].UserID].Username
+		members[i].Avatar = users[members[i].UserID].Avatar
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(members)
+}
+
+// InviteMember invites a user to join a channel's team. Inviting someone
+// again replaces their pending invitation.
+func (h *ChannelTeamHandler) InviteMember(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	channelID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Username string `json:"username"`
+		Role     string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Username = strings.TrimSpace(strings.TrimPrefix(req.Username, "@"))
+	if req.Username == "" {
+		http.Error(w, "Username is required", http.StatusBadRequest)
+		return
+	}
+	if req.Role != models.ChannelRoleManager && req.Role != models.ChannelRoleEditor && req.Role != models.ChannelRoleViewerAnalytics {
+		http.Error(w, "Role must be manager, editor or viewer-analytics", http.StatusBadRequest)
+		return
+	}
+
+	channel, role, err := loadChannelRole(h.db, channelID, userID)
+	if err != nil {
+		writeChannelAccessError(w, err)
+		return
+	}
+	if !channelRoleAllows(role, channelManageTeam) || !canAssignChannelRole(role, req.Role) {
+		http.Error(w, "Your role on this channel doesn't allow inviting a "+req.Role, http.StatusForbidden)
+		return
+	}
+
+	invitee, found, err := h.lookupUsername(req.Username)
+	if err != nil {
+		http.Error(w, "Error looking up user: "+err.Error(), http.StatusBadGateway)
+		return
+	} else if !found {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	inv := models.ChannelInvitation{
+		ChannelID:   channel.ID,
+		ChannelName: channel.DisplayName,
+		UserID:      invitee.ID,
+		Username:    invitee.Username,
+		Role:        req.Role,
+		InvitedBy:   &userID,
+	}
+
+	memberRole := models.ChannelRoleOwner
+	if channel.UserID == nil || *channel.UserID != inv.UserID {
+		if memberRole, err = channelMemberRole(h.db, channel.ID, inv.UserID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if memberRole != "" {
+		http.Error(w, "User is already on this channel's team", http.StatusConflict)
+		return
+	}
+
+	query := `
+		INSERT INTO channel_invitations (channel_id, user_id, role, invited_by, expires_at)
+		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP + $5 * INTERVAL '1 second')
+		ON CONFLICT (channel_id, user_id) DO UPDATE
+		SET role = EXCLUDED.role, invited_by = EXCLUDED.invited_by,
+		    created_at = CURRENT_TIMESTAMP, expires_at = EXCLUDED.expires_at
+		RETURNING id, created_at, expires_at
+	`
+	err = h.db.QueryRow(query, channel.ID, inv.UserID, inv.Role, userID, channelInvitationTTL.Seconds()).
+		Scan(&inv.ID, &inv.CreatedAt, &inv.ExpiresAt)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The invitation stands even if the invitee isn't told about it
+	h.events.publishInvitation(inv)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(inv)
+}
+
+// ListChannelInvitations returns a channel's pending invitations to team
+// members who manage the team
+func (h *ChannelTeamHandler) ListChannelInvitations(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	channelID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := loadChannelFor(h.db, channelID, userID, channelManageTeam); err != nil {
+		writeChannelAccessError(w, err)
+		return
+	}
+
+	h.writeInvitations(w, `i.channel_id = $1`, channelID)
+}
+
+// CancelInvitation withdraws a pending invitation to a channel's team
+func (h *ChannelTeamHandler) CancelInvitation(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	channelID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+	invitationID, err := strconv.Atoi(vars["invitationId"])
+	if err != nil {
+		http.Error(w, "Invalid invitation ID", http.StatusBadRequest)
+		return
+	}
+
+	_, role, err := loadChannelRole(h.db, channelID, userID)
+	if err != nil {
+		writeChannelAccessError(w, err)
+		return
+	}
+	if !channelRoleAllows(role, channelManageTeam) {
+		writeChannelAccessError(w, errChannelRoleForbidden)
+		return
+	}
+
+	// Managers can't withdraw the owner's invitations to other managers
+	result, err := h.db.Exec(`DELETE FROM channel_invitations WHERE id = $1 AND channel_id = $2 AND role = ANY($3)`,
+		invitationID, channelID, pq.Array(assignableChannelRoles(role)))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Invitation not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetMyInvitations returns the authenticated user's pending invitations
+func (h *ChannelTeamHandler) GetMyInvitations(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	h.writeInvitations(w, `i.user_id = $1`, userID)
+}
+
+// writeInvitations responds with the unexpired invitations matching where,
+// newest first
+func (h *ChannelTeamHandler) writeInvitations(w http.ResponseWriter, where string, arg int) {
+	query := `
+		SELECT i.id, i.channel_id, ch.display_name, i.user_id, i.role, i.invited_by, i.created_at, i.expires_at
+		FROM channel_invitations i
+		INNER JOIN channels ch ON ch.id = i.channel_id
+		WHERE ` + where + ` AND i.expires_at > CURRENT_TIMESTAMP
+		ORDER BY i.created_at DESC
+	`
+
+	rows, err := h.db.Query(query, arg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	invitations := []models.ChannelInvitation{}
+	var ids []int
+	for rows.Next() {
+		var inv models.ChannelInvitation
+		if err := rows.Scan(&inv.ID, &inv.ChannelID, &inv.ChannelName, &inv.UserID,
+			&inv.Role, &inv.InvitedBy, &inv.CreatedAt, &inv.ExpiresAt); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		invitations = append(invitations, inv)
+		ids = append(ids, inv.UserID)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	users, err := h.lookupUsers(ids)
+	if err != nil {
+		http.Error(w, "Error looking up users: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	for i := range invitations {
+		invitations[i].Username = users[invitations[i].UserID].Username
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invitations)
+}
+
+// AcceptInvitation adds the authenticated user to the team that invited them
+func (h *ChannelTeamHandler) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	invitationID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid invitation ID", http.StatusBadRequest)
+		return
+	}
+
+	// Claiming the invitation and joining happen together, so an invitation
+	// is used at most once
+	query := `
+		WITH accepted AS (
+			DELETE FROM channel_invitations
+			WHERE id = $1 AND user_id = $2 AND expires_at > CURRENT_TIMESTAMP
+			RETURNING channel_id, user_id, role, invited_by
+		)
+		INSERT INTO channel_members (channel_id, user_id, role, added_by)
+		SELECT channel_id, user_id, role, invited_by FROM accepted
+		ON CONFLICT (channel_id, user_id) DO UPDATE SET role = EXCLUDED.role
+		RETURNING channel_id, user_id, role, created_at
+	`
+
+	var m models.ChannelMember
+	err = h.db.QueryRow(query, invitationID, userID).Scan(&m.ChannelID, &m.UserID, &m.Role, &m.CreatedAt)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Invitation not found or expired", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if username, ok := r.Context().Value(middleware.UsernameKey).(string); ok {
+		m.Username = username
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m)
+}
+
+// DeclineInvitation turns down one of the authenticated user's invitations
+func (h *ChannelTeamHandler) DeclineInvitation(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	invitationID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid invitation ID", http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.db.Exec(`DELETE FROM channel_invitations WHERE id = $1 AND user_id = $2`, invitationID, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Invitation not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// teamChange is a requested change to a member of a channel's team
+type teamChange struct {
+	channelID  int
+	memberID   int
+	callerRole string // the authenticated user's role on the team
+	memberRole string
+}
+
+// loadTeamChange checks that the authenticated user may change or remove a
+// member of a channel's team. It writes the error response and returns false
+// otherwise.
+func (h *ChannelTeamHandler) loadTeamChange(w http.ResponseWriter, r *http.Request) (teamChange, bool) {
+	var c teamChange
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return c, false
+	}
+
+	vars := mux.Vars(r)
+	var err error
+	if c.channelID, err = strconv.Atoi(vars["id"]); err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return c, false
+	}
+	if c.memberID, err = strconv.Atoi(vars["userId"]); err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return c, false
+	}
+
+	if _, c.callerRole, err = loadChannelRole(h.db, c.channelID, userID); err != nil {
+		writeChannelAccessError(w, err)
+		return c, false
+	}
+	if c.callerRole == "" {
+		writeChannelAccessError(w, errNoChannelAccess)
+		return c, false
+	}
+
+	if c.memberRole, err = channelMemberRole(h.db, c.channelID, c.memberID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return c, false
+	}
+	if c.memberRole == "" {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return c, false
+	}
+
+	// Anyone may leave a team
+	if c.memberID != userID && (!channelRoleAllows(c.callerRole, channelManageTeam) || !canAssignChannelRole(c.callerRole, c.memberRole)) {
+		writeChannelAccessError(w, errChannelRoleForbidden)
+		return c, false
+	}
+	return c, true
+}
+
+// UpdateMemberRole changes a team member's role
+func (h *ChannelTeamHandler) UpdateMemberRole(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	c, ok := h.loadTeamChange(w, r)
+	if !ok {
+		return
+	}
+
+	// Members can't change their own role, and managers can't make managers
+	if !canAssignChannelRole(c.callerRole, c.memberRole) || !canAssignChannelRole(c.callerRole, req.Role) {
+		http.Error(w, "Your role on this channel doesn't allow making someone a "+req.Role, http.StatusForbidden)
+		return
+	}
+
+	query := `
+		UPDATE channel_members SET role = $1
+		WHERE channel_id = $2 AND user_id = $3
+		RETURNING channel_id, user_id, role, created_at
+	`
+
+	var m models.ChannelMember
+	err := h.db.QueryRow(query, req.Role, c.channelID, c.memberID).Scan(&m.ChannelID, &m.UserID, &m.Role, &m.CreatedAt)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The role has changed either way; the username is only for display
+	if users, err := h.lookupUsers([]int{m.UserID}); err != nil {
+		log.Printf("Failed to look up user %d: %v", m.UserID, err)
+	} else {
+		m.Username = users[m.UserID].Username
+		m.Avatar = users[m.UserID].Avatar
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m)
+}
+
+// RemoveMember removes a member from a channel's team. Members may remove
+// themselves to leave it.
+func (h *ChannelTeamHandler) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	c, ok := h.loadTeamChange(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := h.db.Exec(`DELETE FROM channel_members WHERE channel_id = $1 AND user_id = $2`, c.channelID, c.memberID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// encodeCursor builds an opaque keyset pagination cursor from the sort
+// timestamp and ID of the last row on a page
+func encodeCursor(t time.Time, id int) string {
+	raw := t.UTC().Format(time.RFC3339Nano) + "|" + strconv.Itoa(id)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeCursor reverses encodeCursor
+func decodeCursor(cursor string) (time.Time, int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, errInvalidCursor
+	}
+
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 {
+		return time.Time{}, 0, errInvalidCursor
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return time.Time{}, 0, errInvalidCursor
+	}
+
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return time.Time{}, 0, errInvalidCursor
+	}
+
+	return t, id, nil
+}
+
+// encodeRankCursor builds a cursor for lists ordered by an integer score,
+// such as like counts, rather than by time
+func encodeRankCursor(rank, id int) string {
+	raw := strconv.Itoa(rank) + "|" + strconv.Itoa(id)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeRankCursor reverses encodeRankCursor
+func decodeRankCursor(cursor string) (int, int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, 0, errInvalidCursor
+	}
+
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 {
+		return 0, 0, errInvalidCursor
+	}
+
+	rank, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, errInvalidCursor
+	}
+
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, errInvalidCursor
+	}
+
+	return rank, id, nil
+}
+
+// parseLimit reads the "limit" query value, falling back to def when it is
+// missing or outside 1..max
+func parseLimit(value string, def, max int) int {
+	if value == "" {
+		return def
+	}
+	if l, err := strconv.Atoi(value); err == nil && l > 0 && l <= max {
+		return l
+	}
+	return def
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aung-arata/youtube-clone/services/video-service/internal/auth"
+	"github.com/aung-arata/youtube-clone/services/video-service/internal/models"
+)
+
+// videoEvent is what the notification service needs to tell a channel's
+// subscribers about a new upload
+type videoEvent struct {
+	VideoID     int    `json:"video_id"`
+	ChannelID   int    `json:"channel_id"`
+	ChannelName string `json:"channel_name"`
+	Title       string `json:"title"`
+}
+
+// invitationEvent is what the notification service needs to tell a user
+// they were invited to a channel's team
+type invitationEvent struct {
+	InvitationID int    `json:"invitation_id"`
+	ChannelID    int    `json:"channel_id"`
+	ChannelName  string `json:"channel_name"`
+	UserID       int    `json:"user_id"`
+	Role         string `json:"role"`
+}
+
+// eventPublisher hands new uploads and channel invitations to the
+// notification service
+type eventPublisher struct {
+	httpClient             *http.Client
+	notificationServiceURL string
+}
+
+func newEventPublisher() *eventPublisher {
+	notificationServiceURL := os.Getenv("NOTIFICATION_SERVICE_URL")
+	if notificationServiceURL == "" {
+		notificationServiceURL = "http://notification-service:8086" // default for docker-compose
+	}
+
+	return &eventPublisher{
+		notificationServiceURL: notificationServiceURL,
+		httpClient: &http.Client{
+			Timeout: 5 * time.Second, // 5 second timeout for notification service calls
+		},
+	}
+}
+
+// publish posts an event to one of the notification service's event
+// endpoints
+func (p *eventPublisher) publish(path string, event interface{}) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, p.notificationServiceURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	auth.SetServiceToken(req)
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("notification service returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// publishInBackground publishes an event without holding up the request.
+// Notifications are best effort, so failures are only logged.
+func (p *eventPublisher) publishInBackground(path string, event interface{}, what string) {
+	go func() {
+		if err := p.publish(path, event); err != nil {
+			log.Printf("Failed to publish %s to the notification service: %v", what, err)
+		}
+	}()
+}
+
+// publishVideo tells the notification service about a new upload
+func (p *eventPublisher) publishVideo(v models.Video) {
+	event := videoEvent{VideoID: v.ID, ChannelID: *v.ChannelID, ChannelName: v.ChannelName, Title: v.Title}
+	p.publishInBackground("/events/videos", event, fmt.Sprintf("video %d", v.ID))
+}
+
+// publishInvitation tells the notification service about an invitation to a
+// channel's team
+func (p *eventPublisher) publishInvitation(inv models.ChannelInvitation) {
+	event := invitationEvent{
+		InvitationID: inv.ID,
+		ChannelID:    inv.ChannelID,
+		ChannelName:  inv.ChannelName,
+		UserID:       inv.UserID,
+		Role:         inv.Role,
+	}
+	p.publishInBackground("/events/channel-invitations", event, fmt.Sprintf("invitation %d", inv.ID))
+}
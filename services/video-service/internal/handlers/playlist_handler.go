@@ -7,6 +7,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/aung-arata/youtube-clone/services/video-service/internal/middleware"
 	"github.com/aung-arata/youtube-clone/services/video-service/internal/models"
 	"github.com/gorilla/mux"
 )
@@ -19,7 +20,7 @@ func NewPlaylistHandler(db *sql.DB) *PlaylistHandler {
 	return &PlaylistHandler{db: db}
 }
 
-// CreatePlaylist creates a new playlist for a user
+// CreatePlaylist creates a new playlist for the authenticated user
 func (h *PlaylistHandler) CreatePlaylist(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	userID, err := strconv.Atoi(vars["userId"])
@@ -28,6 +29,11 @@ func (h *PlaylistHandler) CreatePlaylist(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if currentUserID, ok := r.Context().Value(middleware.UserIDKey).(int); !ok || currentUserID != userID {
+		http.Error(w, "You can only create playlists for yourself", http.StatusForbidden)
+		return
+	}
+
 	var req struct {
 		Name        string `json:"name"`
 		Description string `json:"description"`
@@ -131,7 +137,7 @@ func (h *PlaylistHandler) GetPlaylist(w http.ResponseWriter, r *http.Request) {
 
 	// Get videos in playlist
 	videoQuery := `
-		SELECT v.id, v.title, v.description, v.url, v.thumbnail, v.channel_name, v.channel_avatar, 
+		SELECT v.id, v.title, v.description, v.url, v.thumbnail, v.channel_id, v.channel_name, v.channel_avatar, 
 		       v.views, v.likes, v.dislikes, v.category, v.duration, v.uploaded_at, v.created_at, v.updated_at
 		FROM videos v
 		INNER JOIN playlist_videos pv ON pv.video_id = v.id
@@ -149,7 +155,7 @@ func (h *PlaylistHandler) GetPlaylist(w http.ResponseWriter, r *http.Request) {
 	var videos []models.Video
 	for rows.Next() {
 		var v models.Video
-		if err := rows.Scan(&v.ID, &v.Title, &v.Description, &v.URL, &v.Thumbnail, &v.ChannelName,
+		if err := rows.Scan(&v.ID, &v.Title, &v.Description, &v.URL, &v.Thumbnail, &v.ChannelID, &v.ChannelName,
 			&v.ChannelAvatar, &v.Views, &v.Likes, &v.Dislikes, &v.Category, &v.Duration,
 			&v.UploadedAt, &v.CreatedAt, &v.UpdatedAt); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -178,6 +184,10 @@ func (h *PlaylistHandler) UpdatePlaylist(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if !h.ownsPlaylist(w, r, playlistID) {
+		return
+	}
+
 	var req struct {
 		Name        string `json:"name"`
 		Description string `json:"description"`
@@ -223,6 +233,10 @@ func (h *PlaylistHandler) DeletePlaylist(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if !h.ownsPlaylist(w, r, playlistID) {
+		return
+	}
+
 	query := `DELETE FROM playlists WHERE id = $1`
 	result, err := h.db.Exec(query, playlistID)
 	if err != nil {
@@ -253,6 +267,10 @@ func (h *PlaylistHandler) AddVideoToPlaylist(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	if !h.ownsPlaylist(w, r, playlistID) {
+		return
+	}
+
 	var req struct {
 		VideoID int `json:"video_id"`
 	}
@@ -302,6 +320,10 @@ func (h *PlaylistHandler) RemoveVideoFromPlaylist(w http.ResponseWriter, r *http
 		return
 	}
 
+	if !h.ownsPlaylist(w, r, playlistID) {
+		return
+	}
+
 	videoID, err := strconv.Atoi(vars["videoId"])
 	if err != nil {
 		http.Error(w, "Invalid video ID", http.StatusBadRequest)
@@ -328,3 +350,29 @@ func (h *PlaylistHandler) RemoveVideoFromPlaylist(w http.ResponseWriter, r *http
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// ownsPlaylist checks that the authenticated user owns a playlist. It writes
+// the error response itself.
+func (h *PlaylistHandler) ownsPlaylist(w http.ResponseWriter, r *http.Request, playlistID int) bool {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+
+	var ownerID int
+	err := h.db.QueryRow("SELECT user_id FROM playlists WHERE id = $1", playlistID).Scan(&ownerID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Playlist not found", http.StatusNotFound)
+		return false
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+
+	if ownerID != userID {
+		http.Error(w, "You can only change your own playlists", http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -9,16 +9,18 @@ import (
 	"strings"
 	"time"
 
+	"github.com/aung-arata/youtube-clone/services/video-service/internal/middleware"
 	"github.com/aung-arata/youtube-clone/services/video-service/internal/models"
 	"github.com/gorilla/mux"
 )
 
 type VideoHandler struct {
-	db *sql.DB
+	db     *sql.DB
+	events *eventPublisher
 }
 
 func NewVideoHandler(db *sql.DB) *VideoHandler {
-	return &VideoHandler{db: db}
+	return &VideoHandler{db: db, events: newEventPublisher()}
 }
 
 // GetVideos returns all videos with optional search, category filter and pagination
@@ -54,7 +56,7 @@ func (h *VideoHandler) GetVideos(w http.ResponseWriter, r *http.Request) {
 
 	// Build query with optional search and category filter
 	query := `
-		SELECT id, title, description, url, thumbnail, channel_name, 
+		SELECT id, title, description, url, thumbnail, channel_id, channel_name, 
 		       channel_avatar, views, likes, dislikes, category, duration, uploaded_at, created_at, updated_at
 		FROM videos
 	`
@@ -158,7 +160,7 @@ func (h *VideoHandler) GetVideos(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var v models.Video
 		err := rows.Scan(&v.ID, &v.Title, &v.Description, &v.URL, &v.Thumbnail,
-			&v.ChannelName, &v.ChannelAvatar, &v.Views, &v.Likes, &v.Dislikes, &v.Category, &v.Duration,
+			&v.ChannelID, &v.ChannelName, &v.ChannelAvatar, &v.Views, &v.Likes, &v.Dislikes, &v.Category, &v.Duration,
 			&v.UploadedAt, &v.CreatedAt, &v.UpdatedAt)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -185,7 +187,7 @@ func (h *VideoHandler) GetVideo(w http.ResponseWriter, r *http.Request) {
 	}
 
 	query := `
-		SELECT id, title, description, url, thumbnail, channel_name, 
+		SELECT id, title, description, url, thumbnail, channel_id, channel_name, 
 		       channel_avatar, views, likes, dislikes, category, duration, uploaded_at, created_at, updated_at
 		FROM videos
 		WHERE id = $1
@@ -193,7 +195,7 @@ func (h *VideoHandler) GetVideo(w http.ResponseWriter, r *http.Request) {
 
 	var v models.Video
 	err = h.db.QueryRow(query, id).Scan(&v.ID, &v.Title, &v.Description, &v.URL,
-		&v.Thumbnail, &v.ChannelName, &v.ChannelAvatar, &v.Views, &v.Likes, &v.Dislikes, &v.Category, &v.Duration,
+		&v.Thumbnail, &v.ChannelID, &v.ChannelName, &v.ChannelAvatar, &v.Views, &v.Likes, &v.Dislikes, &v.Category, &v.Duration,
 		&v.UploadedAt, &v.CreatedAt, &v.UpdatedAt)
 
 	if err == sql.ErrNoRows {
@@ -208,8 +210,15 @@ func (h *VideoHandler) GetVideo(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(v)
 }
 
-// CreateVideo creates a new video
+// CreateVideo creates a new video on a channel whose videos the authenticated
+// user manages
 func (h *VideoHandler) CreateVideo(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
 	var v models.Video
 	if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -225,25 +234,36 @@ func (h *VideoHandler) CreateVideo(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "URL is required", http.StatusBadRequest)
 		return
 	}
-	if strings.TrimSpace(v.ChannelName) == "" {
-		http.Error(w, "Channel name is required", http.StatusBadRequest)
+	if v.ChannelID == nil || *v.ChannelID <= 0 {
+		http.Error(w, "Channel ID is required", http.StatusBadRequest)
+		return
+	}
+
+	channel, err := loadChannelFor(h.db, *v.ChannelID, userID, channelManageVideos)
+	if err != nil {
+		writeChannelAccessError(w, err)
 		return
 	}
+	v.ChannelName = channel.DisplayName
+	v.ChannelAvatar = channel.Avatar
 
 	query := `
-		INSERT INTO videos (title, description, url, thumbnail, channel_name, channel_avatar, duration)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO videos (title, description, url, thumbnail, channel_id, channel_name, channel_avatar, duration)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 		RETURNING id, uploaded_at, created_at, updated_at
 	`
 
-	err := h.db.QueryRow(query, v.Title, v.Description, v.URL, v.Thumbnail,
-		v.ChannelName, v.ChannelAvatar, v.Duration).Scan(&v.ID, &v.UploadedAt, &v.CreatedAt, &v.UpdatedAt)
+	err = h.db.QueryRow(query, v.Title, v.Description, v.URL, v.Thumbnail,
+		channel.ID, v.ChannelName, v.ChannelAvatar, v.Duration).Scan(&v.ID, &v.UploadedAt, &v.CreatedAt, &v.UpdatedAt)
 
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	// Subscribers hear about the upload from the notification service
+	h.events.publishVideo(v)
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(v)
@@ -405,7 +425,7 @@ func (h *VideoHandler) GetRecommendations(w http.ResponseWriter, r *http.Request
 
 	// Get recommended videos from the same category, sorted by views
 	query := `
-		SELECT id, title, description, url, thumbnail, channel_name, channel_avatar,
+		SELECT id, title, description, url, thumbnail, channel_id, channel_name, channel_avatar,
 		       views, likes, dislikes, category, duration, uploaded_at, created_at, updated_at
 		FROM videos
 		WHERE category = $1 AND id != $2
@@ -424,7 +444,7 @@ func (h *VideoHandler) GetRecommendations(w http.ResponseWriter, r *http.Request
 	for rows.Next() {
 		var v models.Video
 		err := rows.Scan(&v.ID, &v.Title, &v.Description, &v.URL, &v.Thumbnail,
-			&v.ChannelName, &v.ChannelAvatar, &v.Views, &v.Likes, &v.Dislikes,
+			&v.ChannelID, &v.ChannelName, &v.ChannelAvatar, &v.Views, &v.Likes, &v.Dislikes,
 			&v.Category, &v.Duration, &v.UploadedAt, &v.CreatedAt, &v.UpdatedAt)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -458,7 +478,7 @@ func (h *VideoHandler) GetTrendingVideos(w http.ResponseWriter, r *http.Request)
 
 	// Get videos uploaded or viewed recently, sorted by views
 	query := `
-		SELECT id, title, description, url, thumbnail, channel_name, 
+		SELECT id, title, description, url, thumbnail, channel_id, channel_name, 
 		       channel_avatar, views, likes, dislikes, category, duration, uploaded_at, created_at, updated_at
 		FROM videos
 		WHERE uploaded_at >= NOW() - INTERVAL '7 days'
@@ -477,7 +497,7 @@ func (h *VideoHandler) GetTrendingVideos(w http.ResponseWriter, r *http.Request)
 	for rows.Next() {
 		var v models.Video
 		err := rows.Scan(&v.ID, &v.Title, &v.Description, &v.URL, &v.Thumbnail,
-			&v.ChannelName, &v.ChannelAvatar, &v.Views, &v.Likes, &v.Dislikes,
+			&v.ChannelID, &v.ChannelName, &v.ChannelAvatar, &v.Views, &v.Likes, &v.Dislikes,
 			&v.Category, &v.Duration, &v.UploadedAt, &v.CreatedAt, &v.UpdatedAt)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -510,7 +530,7 @@ func (h *VideoHandler) GetPopularVideos(w http.ResponseWriter, r *http.Request)
 	}
 
 	query := `
-		SELECT id, title, description, url, thumbnail, channel_name, 
+		SELECT id, title, description, url, thumbnail, channel_id, channel_name, 
 		       channel_avatar, views, likes, dislikes, category, duration, uploaded_at, created_at, updated_at
 		FROM videos
 		ORDER BY views DESC, likes DESC
@@ -528,7 +548,7 @@ func (h *VideoHandler) GetPopularVideos(w http.ResponseWriter, r *http.Request)
 	for rows.Next() {
 		var v models.Video
 		err := rows.Scan(&v.ID, &v.Title, &v.Description, &v.URL, &v.Thumbnail,
-			&v.ChannelName, &v.ChannelAvatar, &v.Views, &v.Likes, &v.Dislikes,
+			&v.ChannelID, &v.ChannelName, &v.ChannelAvatar, &v.Views, &v.Likes, &v.Dislikes,
 			&v.Category, &v.Duration, &v.UploadedAt, &v.CreatedAt, &v.UpdatedAt)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -550,8 +570,15 @@ func (h *VideoHandler) GetPopularVideos(w http.ResponseWriter, r *http.Request)
 	json.NewEncoder(w).Encode(videos)
 }
 
-// GetVideoAnalytics returns detailed analytics for a specific video
+// GetVideoAnalytics returns detailed analytics for a specific video. Anyone on
+// the team of the video's channel can see them.
 func (h *VideoHandler) GetVideoAnalytics(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
@@ -559,6 +586,11 @@ func (h *VideoHandler) GetVideoAnalytics(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if err := checkVideoChannelAccess(h.db, id, userID, channelViewAnalytics); err != nil {
+		writeChannelAccessError(w, err)
+		return
+	}
+
 	query := `
 		SELECT id, title, views, likes, dislikes, category, uploaded_at
 		FROM videos
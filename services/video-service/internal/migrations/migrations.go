@@ -141,5 +141,136 @@ func GetAllMigrations() []Migration {
 				return err
 			},
 		},
+		{
+			Version:     5,
+			Name:        "create_channels_table",
+			Description: "Creates channels, links videos to them and backfills legacy channel names",
+			Up: func(db *sql.DB) error {
+				query := `
+				CREATE TABLE IF NOT EXISTS channels (
+					id SERIAL PRIMARY KEY,
+					user_id INTEGER,
+					handle VARCHAR(30) UNIQUE NOT NULL,
+					display_name VARCHAR(100) NOT NULL,
+					avatar VARCHAR(500) NOT NULL DEFAULT '',
+					banner VARCHAR(500) NOT NULL DEFAULT '',
+					description TEXT NOT NULL DEFAULT '',
+					links JSONB NOT NULL DEFAULT '[]',
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_channels_user_id ON channels (user_id);
+
+				ALTER TABLE videos ADD COLUMN IF NOT EXISTS channel_id INTEGER REFERENCES channels(id) ON DELETE CASCADE;
+
+				-- Backfill: every legacy free-text channel name becomes an unowned channel
+				INSERT INTO channels (handle, display_name, avatar)
+				SELECT
+					COALESCE(NULLIF(left(trim(both '-' from regexp_replace(lower(n.name), '[^a-z0-9]+', '-', 'g')), 23), ''), 'channel')
+						|| '-' || substr(md5(n.name), 1, 6),
+					n.name,
+					COALESCE(n.avatar, '')
+				FROM (
+					SELECT channel_name AS name, MAX(channel_avatar) AS avatar FROM videos WHERE channel_id IS NULL GROUP BY channel_name
+				) n
+				WHERE NOT EXISTS (SELECT 1 FROM channels c WHERE c.user_id IS NULL AND c.display_name = n.name)
+				ON CONFLICT (handle) DO NOTHING;
+
+				UPDATE videos v SET channel_id = c.id
+				FROM channels c
+				WHERE v.channel_id IS NULL AND c.user_id IS NULL AND c.display_name = v.channel_name;
+
+				CREATE INDEX IF NOT EXISTS idx_videos_channel_id_uploaded ON videos (channel_id, uploaded_at DESC, id DESC);
+				`
+				_, err := db.Exec(query)
+				return err
+			},
+			Down: func(db *sql.DB) error {
+				_, err := db.Exec(`
+				ALTER TABLE videos DROP COLUMN IF EXISTS channel_id;
+				DROP TABLE IF EXISTS channels CASCADE;
+				`)
+				return err
+			},
+		},
+		{
+			Version:     6,
+			Name:        "create_channel_members_table",
+			Description: "Creates the members of channels' teams",
+			Up: func(db *sql.DB) error {
+				query := `
+				CREATE TABLE IF NOT EXISTS channel_members (
+					channel_id INTEGER NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+					user_id INTEGER NOT NULL,
+					role VARCHAR(20) NOT NULL CHECK (role IN ('manager', 'editor', 'viewer-analytics')),
+					added_by INTEGER,
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					PRIMARY KEY (channel_id, user_id)
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_channel_members_user ON channel_members (user_id);
+				`
+				_, err := db.Exec(query)
+				return err
+			},
+			Down: func(db *sql.DB) error {
+				_, err := db.Exec("DROP TABLE IF EXISTS channel_members")
+				return err
+			},
+		},
+		{
+			Version:     7,
+			Name:        "add_channel_subscriber_count",
+			Description: "Adds the subscriber count the user service keeps up to date",
+			Up: func(db *sql.DB) error {
+				_, err := db.Exec("ALTER TABLE channels ADD COLUMN IF NOT EXISTS subscriber_count INTEGER NOT NULL DEFAULT 0")
+				return err
+			},
+			Down: func(db *sql.DB) error {
+				_, err := db.Exec("ALTER TABLE channels DROP COLUMN IF EXISTS subscriber_count")
+				return err
+			},
+		},
+		{
+			Version:     8,
+			Name:        "create_channel_invitations_table",
+			Description: "Creates the pending invitations to channels' teams",
+			Up: func(db *sql.DB) error {
+				query := `
+				CREATE TABLE IF NOT EXISTS channel_invitations (
+					id SERIAL PRIMARY KEY,
+					channel_id INTEGER NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+					user_id INTEGER NOT NULL,
+					role VARCHAR(20) NOT NULL CHECK (role IN ('manager', 'editor', 'viewer-analytics')),
+					invited_by INTEGER,
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					expires_at TIMESTAMP NOT NULL,
+					UNIQUE (channel_id, user_id)
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_channel_invitations_user ON channel_invitations (user_id);
+				`
+				_, err := db.Exec(query)
+				return err
+			},
+			Down: func(db *sql.DB) error {
+				_, err := db.Exec("DROP TABLE IF EXISTS channel_invitations")
+				return err
+			},
+		},
+		{
+			Version:     9,
+			Name:        "add_channel_blocked_words",
+			Description: "Adds the per-channel list of words that hold comments for review",
+			Up: func(db *sql.DB) error {
+				_, err := db.Exec("ALTER TABLE channels ADD COLUMN IF NOT EXISTS blocked_words JSONB NOT NULL DEFAULT '[]'")
+				return err
+			},
+			Down: func(db *sql.DB) error {
+				_, err := db.Exec("ALTER TABLE channels DROP COLUMN IF EXISTS blocked_words")
+				return err
+			},
+		},
 	}
 }
@@ -8,6 +8,7 @@ type Video struct {
 	Description   string    `json:"description"`
 	URL           string    `json:"url"`
 	Thumbnail     string    `json:"thumbnail"`
+	ChannelID     *int      `json:"channel_id,omitempty"`
 	ChannelName   string    `json:"channel_name"`
 	ChannelAvatar string    `json:"channel_avatar"`
 	Views         int       `json:"views"`
@@ -20,6 +21,88 @@ type Video struct {
 	UpdatedAt     time.Time `json:"updated_at"`
 }
 
+// Channel is a user-owned publishing identity that videos are uploaded to
+type Channel struct {
+	ID              int           `json:"id"`
+	UserID          *int          `json:"user_id,omitempty"` // nil for channels backfilled from legacy channel names
+	Handle          string        `json:"handle"`
+	DisplayName     string        `json:"display_name"`
+	Avatar          string        `json:"avatar"`
+	Banner          string        `json:"banner"`
+	Description     string        `json:"description"`
+	Links           []ChannelLink `json:"links"`
+	SubscriberCount int           `json:"subscriber_count"`
+	CreatedAt       time.Time     `json:"created_at"`
+	UpdatedAt       time.Time     `json:"updated_at"`
+}
+
+// Roles on a channel's team. The owner is the user who created the channel;
+// everyone else is a member.
+const (
+	ChannelRoleOwner           = "owner"
+	ChannelRoleManager         = "manager"
+	ChannelRoleEditor          = "editor"
+	ChannelRoleViewerAnalytics = "viewer-analytics"
+)
+
+// ChannelMember is a user on a channel's team. Usernames and avatars come
+// from the user service.
+type ChannelMember struct {
+	ChannelID int       `json:"channel_id"`
+	UserID    int       `json:"user_id"`
+	Username  string    `json:"username"`
+	Avatar    string    `json:"avatar"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CommentPolicy is what the comment service needs to know about the channel
+// a comment is posted on: its blocked words, and whether a given user is on
+// the team that moderates its comments
+type CommentPolicy struct {
+	ChannelID    *int     `json:"channel_id,omitempty"` // nil for videos without a channel
+	BlockedWords []string `json:"blocked_words"`
+	CanModerate  bool     `json:"can_moderate"`
+}
+
+// ChannelInvitation is a pending invitation to join a channel's team
+type ChannelInvitation struct {
+	ID          int       `json:"id"`
+	ChannelID   int       `json:"channel_id"`
+	ChannelName string    `json:"channel_name"`
+	UserID      int       `json:"user_id"`
+	Username    string    `json:"username"`
+	Role        string    `json:"role"`
+	InvitedBy   *int      `json:"invited_by,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+type ChannelLink struct {
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+// ChannelPage is the public view of a channel with its uploads and playlists
+type ChannelPage struct {
+	Channel
+	Videos     []Video    `json:"videos"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+	Playlists  []Playlist `json:"playlists"`
+}
+
+// VideoPage is a keyset-paginated page of videos
+type VideoPage struct {
+	Videos     []Video `json:"videos"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+}
+
+// ChannelUploadCount is how many videos a channel uploaded after some time
+type ChannelUploadCount struct {
+	ChannelID int `json:"channel_id"`
+	Count     int `json:"count"`
+}
+
 type Playlist struct {
 	ID          int       `json:"id"`
 	UserID      int       `json:"user_id"`